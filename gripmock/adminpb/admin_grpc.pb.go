@@ -0,0 +1,347 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: admin.proto
+
+// gRPC version of the stub admin API, served by gripmock on -admin-grpc-port
+// alongside the HTTP admin API. Stub inputs and outputs use the same JSON
+// stub format as the HTTP API, as Structs.
+
+package adminpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	StubAdmin_AddStub_FullMethodName    = "/gripmock.admin.v1.StubAdmin/AddStub"
+	StubAdmin_ListStubs_FullMethodName  = "/gripmock.admin.v1.StubAdmin/ListStubs"
+	StubAdmin_GetStub_FullMethodName    = "/gripmock.admin.v1.StubAdmin/GetStub"
+	StubAdmin_DeleteStub_FullMethodName = "/gripmock.admin.v1.StubAdmin/DeleteStub"
+	StubAdmin_ClearStubs_FullMethodName = "/gripmock.admin.v1.StubAdmin/ClearStubs"
+	StubAdmin_FindStub_FullMethodName   = "/gripmock.admin.v1.StubAdmin/FindStub"
+	StubAdmin_Verify_FullMethodName     = "/gripmock.admin.v1.StubAdmin/Verify"
+)
+
+// StubAdminClient is the client API for StubAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type StubAdminClient interface {
+	// Add a stub, returning its ID
+	AddStub(ctx context.Context, in *AddStubRequest, opts ...grpc.CallOption) (*AddStubResponse, error)
+	// List stubs, optionally only those for one service or method
+	ListStubs(ctx context.Context, in *ListStubsRequest, opts ...grpc.CallOption) (*ListStubsResponse, error)
+	GetStub(ctx context.Context, in *GetStubRequest, opts ...grpc.CallOption) (*Stub, error)
+	DeleteStub(ctx context.Context, in *DeleteStubRequest, opts ...grpc.CallOption) (*DeleteStubResponse, error)
+	// Remove every stub
+	ClearStubs(ctx context.Context, in *ClearStubsRequest, opts ...grpc.CallOption) (*ClearStubsResponse, error)
+	// Find the stub matching a call and return its output, like the gRPC
+	// server does; the lookup counts as a call
+	FindStub(ctx context.Context, in *FindStubRequest, opts ...grpc.CallOption) (*FindStubResponse, error)
+	// Check expected numbers of calls
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+}
+
+type stubAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStubAdminClient(cc grpc.ClientConnInterface) StubAdminClient {
+	return &stubAdminClient{cc}
+}
+
+func (c *stubAdminClient) AddStub(ctx context.Context, in *AddStubRequest, opts ...grpc.CallOption) (*AddStubResponse, error) {
+	out := new(AddStubResponse)
+	err := c.cc.Invoke(ctx, StubAdmin_AddStub_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stubAdminClient) ListStubs(ctx context.Context, in *ListStubsRequest, opts ...grpc.CallOption) (*ListStubsResponse, error) {
+	out := new(ListStubsResponse)
+	err := c.cc.Invoke(ctx, StubAdmin_ListStubs_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stubAdminClient) GetStub(ctx context.Context, in *GetStubRequest, opts ...grpc.CallOption) (*Stub, error) {
+	out := new(Stub)
+	err := c.cc.Invoke(ctx, StubAdmin_GetStub_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stubAdminClient) DeleteStub(ctx context.Context, in *DeleteStubRequest, opts ...grpc.CallOption) (*DeleteStubResponse, error) {
+	out := new(DeleteStubResponse)
+	err := c.cc.Invoke(ctx, StubAdmin_DeleteStub_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stubAdminClient) ClearStubs(ctx context.Context, in *ClearStubsRequest, opts ...grpc.CallOption) (*ClearStubsResponse, error) {
+	out := new(ClearStubsResponse)
+	err := c.cc.Invoke(ctx, StubAdmin_ClearStubs_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stubAdminClient) FindStub(ctx context.Context, in *FindStubRequest, opts ...grpc.CallOption) (*FindStubResponse, error) {
+	out := new(FindStubResponse)
+	err := c.cc.Invoke(ctx, StubAdmin_FindStub_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stubAdminClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, StubAdmin_Verify_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StubAdminServer is the server API for StubAdmin service.
+// All implementations must embed UnimplementedStubAdminServer
+// for forward compatibility
+type StubAdminServer interface {
+	// Add a stub, returning its ID
+	AddStub(context.Context, *AddStubRequest) (*AddStubResponse, error)
+	// List stubs, optionally only those for one service or method
+	ListStubs(context.Context, *ListStubsRequest) (*ListStubsResponse, error)
+	GetStub(context.Context, *GetStubRequest) (*Stub, error)
+	DeleteStub(context.Context, *DeleteStubRequest) (*DeleteStubResponse, error)
+	// Remove every stub
+	ClearStubs(context.Context, *ClearStubsRequest) (*ClearStubsResponse, error)
+	// Find the stub matching a call and return its output, like the gRPC
+	// server does; the lookup counts as a call
+	FindStub(context.Context, *FindStubRequest) (*FindStubResponse, error)
+	// Check expected numbers of calls
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	mustEmbedUnimplementedStubAdminServer()
+}
+
+// UnimplementedStubAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedStubAdminServer struct {
+}
+
+func (UnimplementedStubAdminServer) AddStub(context.Context, *AddStubRequest) (*AddStubResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddStub not implemented")
+}
+func (UnimplementedStubAdminServer) ListStubs(context.Context, *ListStubsRequest) (*ListStubsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListStubs not implemented")
+}
+func (UnimplementedStubAdminServer) GetStub(context.Context, *GetStubRequest) (*Stub, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStub not implemented")
+}
+func (UnimplementedStubAdminServer) DeleteStub(context.Context, *DeleteStubRequest) (*DeleteStubResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteStub not implemented")
+}
+func (UnimplementedStubAdminServer) ClearStubs(context.Context, *ClearStubsRequest) (*ClearStubsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearStubs not implemented")
+}
+func (UnimplementedStubAdminServer) FindStub(context.Context, *FindStubRequest) (*FindStubResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindStub not implemented")
+}
+func (UnimplementedStubAdminServer) Verify(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedStubAdminServer) mustEmbedUnimplementedStubAdminServer() {}
+
+// UnsafeStubAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StubAdminServer will
+// result in compilation errors.
+type UnsafeStubAdminServer interface {
+	mustEmbedUnimplementedStubAdminServer()
+}
+
+func RegisterStubAdminServer(s grpc.ServiceRegistrar, srv StubAdminServer) {
+	s.RegisterService(&StubAdmin_ServiceDesc, srv)
+}
+
+func _StubAdmin_AddStub_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddStubRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StubAdminServer).AddStub(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StubAdmin_AddStub_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StubAdminServer).AddStub(ctx, req.(*AddStubRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StubAdmin_ListStubs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListStubsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StubAdminServer).ListStubs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StubAdmin_ListStubs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StubAdminServer).ListStubs(ctx, req.(*ListStubsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StubAdmin_GetStub_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStubRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StubAdminServer).GetStub(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StubAdmin_GetStub_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StubAdminServer).GetStub(ctx, req.(*GetStubRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StubAdmin_DeleteStub_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteStubRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StubAdminServer).DeleteStub(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StubAdmin_DeleteStub_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StubAdminServer).DeleteStub(ctx, req.(*DeleteStubRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StubAdmin_ClearStubs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearStubsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StubAdminServer).ClearStubs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StubAdmin_ClearStubs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StubAdminServer).ClearStubs(ctx, req.(*ClearStubsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StubAdmin_FindStub_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindStubRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StubAdminServer).FindStub(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StubAdmin_FindStub_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StubAdminServer).FindStub(ctx, req.(*FindStubRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StubAdmin_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StubAdminServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StubAdmin_Verify_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StubAdminServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// StubAdmin_ServiceDesc is the grpc.ServiceDesc for StubAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var StubAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gripmock.admin.v1.StubAdmin",
+	HandlerType: (*StubAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddStub",
+			Handler:    _StubAdmin_AddStub_Handler,
+		},
+		{
+			MethodName: "ListStubs",
+			Handler:    _StubAdmin_ListStubs_Handler,
+		},
+		{
+			MethodName: "GetStub",
+			Handler:    _StubAdmin_GetStub_Handler,
+		},
+		{
+			MethodName: "DeleteStub",
+			Handler:    _StubAdmin_DeleteStub_Handler,
+		},
+		{
+			MethodName: "ClearStubs",
+			Handler:    _StubAdmin_ClearStubs_Handler,
+		},
+		{
+			MethodName: "FindStub",
+			Handler:    _StubAdmin_FindStub_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _StubAdmin_Verify_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
@@ -0,0 +1,64 @@
+package main
+
+/*
+ * Bind addresses for the gRPC and admin servers.
+ *
+ * -grpc-listen and -admin-listen take a comma separated list of hosts to
+ * bind to, each an IPv4 or IPv6 literal, with or without brackets, or a
+ * host name, e.g. "127.0.0.1,::1" for loopback over both IPv4 and IPv6. An
+ * empty host, "0.0.0.0" or "::" binds every interface, dual-stack where the
+ * OS allows, so one listener accepts both IPv4 and IPv6 connections.
+ *
+ * Hosts are joined to ports with brackets round IPv6 literals, as in
+ * "[::1]:4770"; a port in the host list is a mistake for -grpc-port or
+ * -admin-port.
+ */
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Parse a -grpc-listen or -admin-listen list, with "" for every interface
+func parseBindHosts(spec string) ([]string, error) {
+	hosts := []string{}
+	seen := map[string]bool{}
+	for _, host := range strings.Split(spec, ",") {
+		host = strings.TrimSpace(host)
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+			if _, err := netip.ParseAddr(host); err != nil {
+				return nil, fmt.Errorf("\"[%s]\" isn't an IPv6 address", host)
+			}
+		}
+		if _, err := netip.ParseAddr(host); err != nil && strings.Contains(host, ":") {
+			return nil, fmt.Errorf("\"%s\" must be a host or IP address without a port, which is set separately", host)
+		}
+		if strings.ContainsAny(host, " \t/[]") {
+			return nil, fmt.Errorf("\"%s\" isn't a host or IP address", host)
+		}
+		if seen[host] {
+			return nil, fmt.Errorf("\"%s\" is listed twice", host)
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	if len(hosts) > 1 && seen[""] {
+		return nil, fmt.Errorf("\"%s\" lists an empty host, for every interface, with others", spec)
+	}
+	return hosts, nil
+}
+
+// The host:port addresses to listen on
+func bindAddresses(hosts []string, port string) []string {
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	addrs := []string{}
+	for _, host := range hosts {
+		addrs = append(addrs, net.JoinHostPort(host, port))
+	}
+	return addrs
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseBindHosts(t *testing.T) {
+	for spec, want := range map[string][]string{
+		"":                {""},
+		"0.0.0.0":         {"0.0.0.0"},
+		"127.0.0.1, ::1":  {"127.0.0.1", "::1"},
+		"[::1],localhost": {"::1", "localhost"},
+		"fe80::1%eth0":    {"fe80::1%eth0"},
+		"::":              {"::"},
+	} {
+		hosts, err := parseBindHosts(spec)
+		require.NoError(t, err, spec)
+		assert.Equal(t, want, hosts, spec)
+	}
+	for _, bad := range []string{"localhost:4770", "[localhost]", "::1,[::1]", ",127.0.0.1", "a host"} {
+		_, err := parseBindHosts(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func Test_bindAddresses(t *testing.T) {
+	assert.Equal(t, []string{":4770"}, bindAddresses(nil, "4770"))
+	assert.Equal(t, []string{":4770"}, bindAddresses([]string{""}, "4770"))
+	assert.Equal(t, []string{"127.0.0.1:4770", "[::1]:4770"}, bindAddresses([]string{"127.0.0.1", "::1"}, "4770"))
+}
@@ -0,0 +1,69 @@
+package main
+
+/*
+ * buf inputs.
+ *
+ * A proto argument can be a buf module instead of a .proto file: a
+ * directory with a buf.yaml (or a buf.work.yaml workspace), or a Buf Schema
+ * Registry reference such as buf.build/acme/orders or
+ * buf.build/acme/orders:v1.2.0. "buf build" resolves its dependencies, so
+ * there's no need for -imports, and its image is served as a -descriptor
+ * set would be. Any other proto arguments then name the files within the
+ * images to serve.
+ *
+ * This needs the buf CLI on the PATH, and for registry references, any buf
+ * login they need.
+ */
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+)
+
+// buf image built from the nth buf input, in the output dir
+const BUF_IMAGE_FILE = "gripmock-buf-%d.bin"
+
+// host/owner/module, with an optional :ref
+var bufModuleRefPattern = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)+/[^/:]+/[^/:]+(:[^/:]+)?$`)
+
+// Whether a proto argument is a buf module directory or registry reference
+func isBufInput(arg string) bool {
+	info, err := os.Stat(arg)
+	if err != nil {
+		return bufModuleRefPattern.MatchString(arg)
+	}
+	if !info.IsDir() {
+		return false
+	}
+	for _, config := range []string{"buf.yaml", "buf.work.yaml"} {
+		if _, err := os.Stat(filepath.Join(arg, config)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Build the buf inputs among the proto arguments to images in the output
+// dir. Returns the other proto arguments and the images.
+func buildBufInputs(protoPaths []string, output string) ([]string, []string, error) {
+	var protos, images []string
+	for _, arg := range protoPaths {
+		if !isBufInput(arg) {
+			protos = append(protos, arg)
+			continue
+		}
+		image := filepath.Join(output, fmt.Sprintf(BUF_IMAGE_FILE, len(images)))
+		buf := exec.Command("buf", "build", arg, "-o", image)
+		buf.Stdout = os.Stdout
+		buf.Stderr = os.Stderr
+		log.V(LOG_VERBOSE).Info("invoking \"buf\"", "cmd", buf.String())
+		if err := buf.Run(); err != nil {
+			return nil, nil, fmt.Errorf("building buf input %s: %w", arg, err)
+		}
+		images = append(images, image)
+	}
+	return protos, images, nil
+}
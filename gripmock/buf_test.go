@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func Test_isBufInput(t *testing.T) {
+	dir := t.TempDir()
+	module := filepath.Join(dir, "module")
+	require.NoError(t, os.MkdirAll(module, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(module, "buf.yaml"), []byte("version: v1\n"), 0644))
+	protoFile := filepath.Join(dir, "api.proto")
+	require.NoError(t, os.WriteFile(protoFile, []byte(`syntax = "proto3";`), 0644))
+
+	assert.True(t, isBufInput(module))
+	assert.True(t, isBufInput("buf.build/acme/orders"))
+	assert.True(t, isBufInput("buf.build/acme/orders:v1.2.0"))
+	assert.False(t, isBufInput(dir), "a directory without buf.yaml")
+	assert.False(t, isBufInput(protoFile))
+	assert.False(t, isBufInput("api/v1/orders.proto"), "a name within a descriptor set")
+}
+
+func Test_buildBufInputs(t *testing.T) {
+	initLogging(LOG_ERROR)
+	dir := t.TempDir()
+	image, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{Name: proto.String("api/v1/orders.proto")}}})
+	require.NoError(t, err)
+	imagePath := filepath.Join(dir, "image.bin")
+	require.NoError(t, os.WriteFile(imagePath, image, 0644))
+	// "buf build <input> -o <image>" copies the image
+	bin := filepath.Join(dir, "bin")
+	require.NoError(t, os.MkdirAll(bin, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "buf"), []byte("#!/bin/sh\ncp "+imagePath+" \"$4\"\n"), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	output := t.TempDir()
+	protos, images, err := buildBufInputs([]string{"buf.build/acme/orders", "api/v1/orders.proto"}, output)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api/v1/orders.proto"}, protos)
+	require.Equal(t, []string{filepath.Join(output, "gripmock-buf-0.bin")}, images)
+	_, serve, err := readDescriptorSets(images, protos)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api/v1/orders.proto"}, serve)
+
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "buf"), []byte("#!/bin/sh\nexit 1\n"), 0755))
+	_, _, err = buildBufInputs([]string{"buf.build/acme/missing"}, output)
+	assert.ErrorContains(t, err, "building buf input buf.build/acme/missing")
+}
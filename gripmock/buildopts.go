@@ -0,0 +1,81 @@
+package main
+
+/*
+ * Build options for the generated server.
+ *
+ * The server is built with go build's defaults, which keep the build paths,
+ * symbol table and DWARF debug info in the binary. For embedding it into a
+ * minimal image, -go-trimpath leaves out the build paths and -go-strip the
+ * symbol table and debug info, which takes about a third off its size.
+ * -go-gcflags passes compiler flags, e.g. "all=-l" to skip inlining for a
+ * faster build, and -go-compiler=gccgo builds with gccgo instead of gc.
+ *
+ * -tracing=false leaves OpenTelemetry tracing, and with it the OpenTelemetry
+ * SDK and exporters, out of the server, which is then a good deal smaller
+ * and quicker to build. OTEL_TRACES_EXPORTER is then ignored.
+ */
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+const (
+	GO_COMPILER_GC    = "gc"
+	GO_COMPILER_GCCGO = "gccgo"
+)
+
+type goBuildOptions struct {
+	// leave the build paths out of the binary
+	trimpath bool
+	// leave the symbol table and debug info out of the binary
+	strip bool
+	// extra flags for the gc compiler
+	gcflags string
+	// GO_COMPILER_GC or GO_COMPILER_GCCGO
+	compiler string
+	// build OpenTelemetry tracing into the server
+	tracing bool
+}
+
+// Check the -go-trimpath, -go-strip, -go-gcflags, -go-compiler and -tracing
+// flags
+func newGoBuildOptions(trimpath, strip bool, gcflags, compiler string, tracing bool) (goBuildOptions, error) {
+	opts := goBuildOptions{trimpath: trimpath, strip: strip, gcflags: gcflags, compiler: compiler, tracing: tracing}
+	switch compiler {
+	case "", GO_COMPILER_GC:
+		opts.compiler = GO_COMPILER_GC
+	case GO_COMPILER_GCCGO:
+		if gcflags != "" {
+			return goBuildOptions{}, fmt.Errorf("-go-gcflags are for the gc compiler, not gccgo")
+		}
+		if _, err := exec.LookPath("gccgo"); err != nil {
+			return goBuildOptions{}, fmt.Errorf("-go-compiler=gccgo needs gccgo: %w", err)
+		}
+	default:
+		return goBuildOptions{}, fmt.Errorf("unknown compiler %q, must be %s or %s", compiler, GO_COMPILER_GC, GO_COMPILER_GCCGO)
+	}
+	return opts, nil
+}
+
+// Extra go build arguments
+func (o goBuildOptions) buildArgs() []string {
+	args := []string{}
+	if o.trimpath {
+		args = append(args, "-trimpath")
+	}
+	if o.compiler == GO_COMPILER_GCCGO {
+		args = append(args, "-compiler="+GO_COMPILER_GCCGO)
+		if o.strip {
+			args = append(args, "-gccgoflags=-g0", "-ldflags=-s")
+		}
+		return args
+	}
+	if o.strip {
+		args = append(args, "-ldflags=-s -w")
+	}
+	if o.gcflags != "" {
+		args = append(args, "-gcflags="+o.gcflags)
+	}
+	return args
+}
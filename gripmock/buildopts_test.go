@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoBuildOptions(t *testing.T) {
+	o, err := newGoBuildOptions(false, false, "", "", true)
+	require.NoError(t, err)
+	assert.Equal(t, GO_COMPILER_GC, o.compiler)
+	assert.Empty(t, o.buildArgs())
+
+	o, err = newGoBuildOptions(true, true, "all=-l", GO_COMPILER_GC, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-trimpath", "-ldflags=-s -w", "-gcflags=all=-l"}, o.buildArgs())
+	assert.False(t, o.tracing)
+
+	_, err = newGoBuildOptions(false, false, "", "tinygo", true)
+	assert.ErrorContains(t, err, "unknown compiler")
+	_, err = newGoBuildOptions(false, false, "-N", GO_COMPILER_GCCGO, true)
+	assert.ErrorContains(t, err, "not gccgo")
+
+	gccgo := goBuildOptions{trimpath: true, strip: true, compiler: GO_COMPILER_GCCGO}
+	assert.Equal(t, []string{"-trimpath", "-compiler=gccgo", "-gccgoflags=-g0", "-ldflags=-s"}, gccgo.buildArgs())
+}
+
+func TestBuildHashBuildOptions(t *testing.T) {
+	dir := t.TempDir()
+	param := protocParam{imports: []string{dir}}
+	hash := func(o goBuildOptions) string {
+		param.goBuild = o
+		h, err := buildHash(param, nil, nil)
+		require.NoError(t, err)
+		return h
+	}
+	plain := hash(goBuildOptions{compiler: GO_COMPILER_GC, tracing: true})
+	assert.NotEqual(t, plain, hash(goBuildOptions{compiler: GO_COMPILER_GC, tracing: true, strip: true}))
+	assert.NotEqual(t, plain, hash(goBuildOptions{compiler: GO_COMPILER_GC}))
+	assert.Equal(t, plain, hash(goBuildOptions{compiler: GO_COMPILER_GC, tracing: true}))
+}
@@ -0,0 +1,213 @@
+package main
+
+/*
+ * Build cache.
+ *
+ * Generating and building the server takes tens of seconds, mostly in go
+ * build, though repeated test-suite starts usually serve the same protos.
+ * Before generating, gripmock hashes everything the server binary depends
+ * on: the generation options, the protos and the files they import, any
+ * descriptor sets, the template dir, local -go-replace modules, the GO*
+ * environment and the tools that generate and build the server. The last
+ * successful build leaves the hash in BUILD_HASH_FILE in the output dir; if
+ * it matches and the server binary is still there, protoc and go build are
+ * skipped and the binary reused.
+ *
+ * Imports are found by scanning the protos' import statements, so an
+ * import that can't be found on the import path, such as a well-known type
+ * bundled with protoc, counts by name only. -build-cache=false always
+ * rebuilds, as does -pause-after.
+ */
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// hash of the inputs of the server binary in the output dir
+const BUILD_HASH_FILE = ".gripmock-build-hash"
+
+// the tools that generate and build the server
+var buildTools = []string{"protoc-gen-go", "protoc-gen-go-grpc", "protoc-gen-gripmock", "go"}
+
+var protoImportPattern = regexp.MustCompile(`^\s*import\s+(?:public\s+|weak\s+)?"([^"]+)"\s*;`)
+
+// Hash the inputs of the server that would be built with these parameters
+func buildHash(param protocParam, modReplacements []string, environ []string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "protos %q\nimports %q\ndescriptors %q\ncodecs %q\nserve imports %t\ngoogleapis %t\nonly services %q\nexclude methods %q\nprotoc args %q\nlayout %q %q %q\nxds %t\n", param.protoPath, param.imports, param.descriptors, param.codecs, param.serveImports, param.googleapis, param.onlyServices, param.excludeMethods, param.protocArgs, param.layout.moduleName(), param.layout.serverPackageDir(), param.layout.protoDir, param.xds)
+	fmt.Fprintf(h, "grpc %q:%s\nadmin %s\ntemplate %s\nreplace %q\n", param.grpcHosts, param.grpcPort, param.adminPort, param.templateDir, modReplacements)
+	fmt.Fprintf(h, "vendor %t %s\n", param.vendor.enabled, param.vendor.from)
+	fmt.Fprintf(h, "build %q tracing %t\n", param.goBuild.buildArgs(), param.goBuild.tracing)
+	if param.vendor.from != "" {
+		if err := hashFile(h, filepath.Join(param.vendor.from, "vendor", "modules.txt")); err != nil {
+			return "", err
+		}
+	}
+
+	env := []string{}
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, "GO") && !isGoCacheEnv(kv) {
+			env = append(env, kv)
+		}
+	}
+	sort.Strings(env)
+	fmt.Fprintf(h, "env %q\n", env)
+
+	if self, err := os.Executable(); err == nil {
+		hashFileStat(h, self)
+	}
+	tools := buildTools
+	if param.goBuild.compiler == GO_COMPILER_GCCGO {
+		tools = append(tools[:len(tools):len(tools)], GO_COMPILER_GCCGO)
+	}
+	for _, tool := range tools {
+		if found, err := exec.LookPath(tool); err == nil {
+			hashFileStat(h, found)
+		}
+	}
+	hashProtoc(h, param.protoc)
+
+	if len(param.descriptors) > 0 {
+		for _, d := range param.descriptors {
+			if err := hashFile(h, d); err != nil {
+				return "", err
+			}
+		}
+	} else {
+		seen := map[string]bool{}
+		for _, proto := range param.protoPath {
+			importDir, rel, err := findProtoInImports(param.imports, proto)
+			if err != nil {
+				return "", err
+			}
+			dirs := append([]string{importDir}, param.imports...)
+			if err := hashProto(h, protoName(rel, proto), dirs, seen); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if param.templateDir != "" {
+		if err := hashDir(h, param.templateDir, nil); err != nil {
+			return "", err
+		}
+	}
+	for _, file := range param.serverFiles {
+		if err := hashFile(h, file); err != nil {
+			return "", err
+		}
+	}
+	for _, r := range modReplacements {
+		// only local replacements can change under the same name
+		_, target, _ := strings.Cut(r, "=")
+		if strings.HasPrefix(target, ".") || filepath.IsAbs(target) {
+			goFiles := func(name string) bool {
+				return strings.HasSuffix(name, ".go") || name == "go.mod" || name == "go.sum"
+			}
+			if err := hashDir(h, target, goFiles); err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Hash a proto found on the import dirs and, once each, everything it
+// imports
+func hashProto(h hash.Hash, name string, dirs []string, seen map[string]bool) error {
+	if seen[name] {
+		return nil
+	}
+	seen[name] = true
+	fmt.Fprintf(h, "proto %s\n", name)
+	for _, dir := range dirs {
+		file := filepath.Join(dir, name)
+		byt, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		h.Write(byt)
+		s := bufio.NewScanner(strings.NewReader(string(byt)))
+		for s.Scan() {
+			if m := protoImportPattern.FindStringSubmatch(s.Text()); m != nil {
+				if err := hashProto(h, m[1], dirs, seen); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+func hashFile(h hash.Hash, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintf(h, "file %s\n", file)
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// Hash a file by its path, size and modification time, for binaries too big
+// to read on every start
+func hashFileStat(h hash.Hash, file string) {
+	if info, err := os.Stat(file); err == nil {
+		fmt.Fprintf(h, "stat %s %d %d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+}
+
+// Hash the files in a dir, or those with names include accepts
+func hashDir(h hash.Hash, dir string, include func(name string) bool) error {
+	return filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if include != nil && !include(d.Name()) {
+			return nil
+		}
+		return hashFile(h, file)
+	})
+}
+
+// Whether the output dir has a server built from inputs with this hash
+func cachedBuild(output, hash string) bool {
+	byt, err := os.ReadFile(filepath.Join(output, BUILD_HASH_FILE))
+	if err != nil || strings.TrimSpace(string(byt)) != hash {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(output, SERVER_BINARY))
+	return err == nil
+}
+
+// Record the hash of the inputs the server in the output dir was built
+// from, or with an empty hash, that it's being rebuilt
+func saveBuildHash(output, hash string) {
+	file := filepath.Join(output, BUILD_HASH_FILE)
+	var err error
+	if hash == "" {
+		err = os.Remove(file)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		err = os.WriteFile(file, []byte(hash+"\n"), 0644)
+	}
+	if err != nil {
+		log.Error(err, "updating build cache hash", "file", file)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_buildHash(t *testing.T) {
+	initLogging(LOG_ERROR)
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	write("api/orders.proto", "syntax = \"proto3\";\nimport \"api/types.proto\";\nimport \"google/protobuf/empty.proto\";\n")
+	write("api/types.proto", "syntax = \"proto3\";\nmessage Order {}\n")
+	param := protocParam{
+		protoPath: []string{filepath.Join(dir, "api/orders.proto")},
+		imports:   []string{dir},
+		grpcPort:  "4770",
+		adminPort: "4771",
+	}
+	hash := func(param protocParam, replacements ...string) string {
+		h, err := buildHash(param, replacements, []string{"GOFLAGS=-mod=mod", "HOME=/home/test"})
+		require.NoError(t, err)
+		return h
+	}
+	first := hash(param)
+	assert.Equal(t, first, hash(param), "the same inputs")
+
+	changed := param
+	changed.grpcPort = "5770"
+	assert.NotEqual(t, first, hash(changed), "a different port is compiled in")
+	changed = param
+	changed.protocArgs = []string{"--experimental_allow_proto3_optional"}
+	assert.NotEqual(t, first, hash(changed), "extra protoc arguments")
+	changed = param
+	changed.xds = true
+	assert.NotEqual(t, first, hash(changed), "xDS serving is compiled in")
+	changed = param
+	changed.vendor = vendorConfig{enabled: true}
+	assert.NotEqual(t, first, hash(changed), "built from vendored dependencies")
+
+	write("auth.go", "package main\n")
+	changed = param
+	changed.serverFiles = []string{filepath.Join(dir, "auth.go")}
+	withFile := hash(changed)
+	assert.NotEqual(t, first, withFile)
+	write("auth.go", "package main\n\nfunc init() {}\n")
+	assert.NotEqual(t, withFile, hash(changed), "a server file changed")
+	assert.NotEqual(t, first, hash(param, "example.com/x=example.com/y@v1.0.0"))
+	h, err := buildHash(param, nil, []string{"GOFLAGS=-mod=vendor"})
+	require.NoError(t, err)
+	assert.NotEqual(t, first, h, "GO* environment")
+	h, err = buildHash(param, nil, []string{"GOFLAGS=-mod=mod", "HOME=/elsewhere"})
+	require.NoError(t, err)
+	assert.Equal(t, first, h, "other environment")
+	h, err = buildHash(param, nil, []string{"GOFLAGS=-mod=mod", "GOCACHE=/cache/build", "GOMODCACHE=/cache/mod"})
+	require.NoError(t, err)
+	assert.Equal(t, first, h, "Go cache locations")
+
+	write("api/types.proto", "syntax = \"proto3\";\nmessage Order { string id = 1; }\n")
+	assert.NotEqual(t, first, hash(param), "an imported proto changed")
+
+	// local replacements are hashed by content
+	write("local/go.mod", "module example.com/local\n")
+	write("local/local.go", "package local\n")
+	replaced := hash(param, "example.com/local="+filepath.Join(dir, "local"))
+	write("local/local.go", "package local\n\nconst X = 1\n")
+	assert.NotEqual(t, replaced, hash(param, "example.com/local="+filepath.Join(dir, "local")))
+
+	_, err = buildHash(protocParam{protoPath: []string{"missing.proto"}, imports: []string{dir}}, nil, nil)
+	assert.Error(t, err)
+}
+
+func Test_cachedBuild(t *testing.T) {
+	initLogging(LOG_ERROR)
+	output := t.TempDir()
+	assert.False(t, cachedBuild(output, "abc"), "never built")
+
+	saveBuildHash(output, "abc")
+	assert.False(t, cachedBuild(output, "abc"), "no server binary")
+	require.NoError(t, os.WriteFile(filepath.Join(output, "server"), []byte{}, 0755))
+	assert.True(t, cachedBuild(output, "abc"))
+	assert.False(t, cachedBuild(output, "def"), "inputs changed")
+
+	saveBuildHash(output, "")
+	assert.False(t, cachedBuild(output, "abc"), "being rebuilt")
+	saveBuildHash(output, "")
+}
@@ -0,0 +1,179 @@
+package main
+
+/*
+ * Checking protos and stubs without serving them.
+ *
+ * "gripmock check" goes through everything gripmock does before it serves,
+ * and stops there, without binding any port: it resolves and compiles the
+ * protos, generates the server into a temp dir (unless -dynamic, which
+ * doesn't generate one), then reads every stub file of -stub and checks its
+ * syntax and schema, its includes and its output, see stub/check.go, and
+ * that it fits the protos: its service and method exist, the top-level keys
+ * of its input matchers are fields of the request, and its output data,
+ * stream and push messages convert to the response message. Problems are
+ * reported one per line, by file, line and field, and gripmock exits
+ * non-zero, so it can gate CI for a repository of fixtures.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ringerc/gripmock/stub"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Check the protos and stub files of param, writing the problems found to
+// w, and return the exit code. The output dir is a temp dir, removed once
+// checked.
+func runCheck(param exportParam, dynamic, stubTemplates bool, w io.Writer) int {
+	defer os.RemoveAll(param.protoc.output)
+	if len(param.protoc.protoPath) == 0 && len(param.protoc.descriptors) == 0 {
+		log.V(LOG_ERROR).Info("Need at least one proto file or -descriptor")
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+
+	if !dynamic {
+		if err := generateProtoc(param.protoc); err != nil {
+			fmt.Fprintf(w, "generating the server: %v\n", err)
+			return EXITCODE_BUILD_ERROR
+		}
+	}
+	_, services, err := loadDynamicServices(param.protoc)
+	if err != nil {
+		fmt.Fprintf(w, "loading the services: %v\n", err)
+		return EXITCODE_BUILD_ERROR
+	}
+	if param.stubPath == "" {
+		fmt.Fprintf(w, "%d services OK, no stubs to check\n", len(services))
+		return 0
+	}
+
+	problems, count, err := stub.CheckStubFiles(param.stubPath, stubTemplates, stubChecker(services))
+	if err != nil {
+		fmt.Fprintf(w, "finding stub files: %v\n", err)
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+	for _, p := range problems {
+		fmt.Fprintln(w, p.Error())
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(w, "%d problems in %d stubs for %d services\n", len(problems), count, len(services))
+		return EXITCODE_OTHER_ERROR
+	}
+	fmt.Fprintf(w, "%d stubs for %d services OK\n", count, len(services))
+	return 0
+}
+
+// Check a stub against the services it could be for, which stubs name
+// without their package
+func stubChecker(services []protoreflect.ServiceDescriptor) func(*stub.Stub) stub.SchemaErrors {
+	byName := map[string][]protoreflect.ServiceDescriptor{}
+	for _, sd := range services {
+		byName[string(sd.Name())] = append(byName[string(sd.Name())], sd)
+	}
+	return func(s *stub.Stub) stub.SchemaErrors {
+		sds := byName[s.Service]
+		if len(sds) == 0 {
+			return stub.SchemaErrors{{Field: "service", Message: fmt.Sprintf("no service %s in the protos", s.Service)}}
+		}
+		var md protoreflect.MethodDescriptor
+		for _, sd := range sds {
+			if md = sd.Methods().ByName(protoreflect.Name(s.Method)); md != nil {
+				break
+			}
+		}
+		if md == nil {
+			return stub.SchemaErrors{{Field: "method", Message: fmt.Sprintf("service %s has no method %s", s.Service, s.Method)}}
+		}
+
+		errs := checkInputFields("input", s.Input, md.Input())
+		if st := s.Input.Stream; st != nil {
+			for i, in := range st.Messages {
+				errs = append(errs, checkInputFields(fmt.Sprintf("input.stream.messages[%d]", i), in, md.Input())...)
+			}
+			if st.Any != nil {
+				errs = append(errs, checkInputFields("input.stream.any", *st.Any, md.Input())...)
+			}
+		}
+
+		// scripts and transforms make their own output, and raw output
+		// isn't JSON
+		out := s.Output
+		if out.Script != "" || out.Transform != nil || out.Raw != "" {
+			return errs
+		}
+		if out.Data != nil {
+			errs = append(errs, checkOutputMessage("output.data", out.Data, md.Output())...)
+		}
+		for i, msg := range out.Stream {
+			errs = append(errs, checkOutputMessage(fmt.Sprintf("output.stream[%d]", i), msg, md.Output())...)
+		}
+		if out.Push != nil {
+			for i, msg := range out.Push.Messages {
+				errs = append(errs, checkOutputMessage(fmt.Sprintf("output.push.messages[%d]", i), msg, md.Output())...)
+			}
+		}
+		return errs
+	}
+}
+
+// Check the keys of an input's matchers are fields of the request message,
+// by their proto or JSON names or their oneof's name
+func checkInputFields(field string, in stub.Input, msg protoreflect.MessageDescriptor) stub.SchemaErrors {
+	errs := stub.SchemaErrors{}
+	for _, m := range []struct {
+		name   string
+		fields map[string]interface{}
+	}{{"equals", in.Equals}, {"contains", in.Contains}, {"matches", in.Matches}} {
+		keys := []string{}
+		for key := range m.fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !hasField(msg, key) {
+				errs = append(errs, stub.SchemaError{
+					Field:   field + "." + m.name + "." + key,
+					Message: fmt.Sprintf("%s has no field %s", msg.FullName(), key),
+				})
+			}
+		}
+	}
+	return errs
+}
+
+func hasField(msg protoreflect.MessageDescriptor, key string) bool {
+	if msg.Fields().ByName(protoreflect.Name(key)) != nil || msg.Fields().ByJSONName(key) != nil {
+		return true
+	}
+	// the generated server's requests have a oneof's fields under its Go
+	// name
+	goName := strings.ReplaceAll(key, "_", "")
+	oneofs := msg.Oneofs()
+	for i := 0; i < oneofs.Len(); i++ {
+		if strings.EqualFold(strings.ReplaceAll(string(oneofs.Get(i).Name()), "_", ""), goName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check a stub's message converts to the response message, as the server
+// converts it
+func checkOutputMessage(field string, data map[string]interface{}, msg protoreflect.MessageDescriptor) stub.SchemaErrors {
+	byt, err := json.Marshal(data)
+	if err == nil {
+		err = protojson.Unmarshal(byt, dynamicpb.NewMessage(msg))
+	}
+	if err != nil {
+		return stub.SchemaErrors{{Field: field, Message: fmt.Sprintf("not a valid %s: %v", msg.FullName(), err)}}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runCheck(t *testing.T) {
+	initLogging(LOG_ERROR)
+	setPath := writeGreeterSet(t)
+	stubs := t.TempDir()
+	check := func(content string) (int, string) {
+		require.NoError(t, os.WriteFile(filepath.Join(stubs, "greeter.json"), []byte(content), 0644))
+		var out bytes.Buffer
+		code := runCheck(exportParam{
+			protoc:   protocParam{descriptors: []string{setPath}, output: t.TempDir()},
+			stubPath: stubs,
+		}, true, false, &out)
+		return code, out.String()
+	}
+
+	code, out := check(`{"service": "Greeter", "method": "SayHello", "input": {"equals": {"user_name": "a"}}, "output": {"data": {"message": "hi", "count": 2}}}`)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "1 stubs for 1 services OK\n", out)
+
+	code, out = check(`[
+  {"service": "Greeter", "method": "SayHello", "input": {"contains": {"username": "a"}}, "output": {"data": {"message": 1}}},
+  {"service": "Greeter", "method": "SayGoodbye", "input": {"equals": {}}, "output": {"data": {}}},
+  {"service": "Farewell", "method": "SayHello", "input": {"equals": {}}, "output": {"data": {}}},
+  {"service": "Greeter", "method": "Chat", "input": {"stream": {"any": {"matches": {"user_name": "a", "name": "b"}}}}, "output": {"stream": [{"message": "hi"}, {"count": "x"}]}}
+]`)
+	assert.Equal(t, EXITCODE_OTHER_ERROR, code)
+	file := filepath.Join(stubs, "greeter.json")
+	lines := []string{
+		file + ":2:3: [0].input.contains.username: test.Request has no field username",
+		file + ":2:3: [0].output.data: not a valid test.Reply: ",
+		file + ":3:3: [1].method: service Greeter has no method SayGoodbye",
+		file + ":4:3: [2].service: no service Farewell in the protos",
+		file + ":5:3: [3].input.stream.any.matches.name: test.Request has no field name",
+		file + ":5:3: [3].output.stream[1]: not a valid test.Reply: ",
+		"6 problems in 4 stubs for 1 services",
+	}
+	for _, line := range lines {
+		assert.Contains(t, out, line)
+	}
+
+	// never gets as far as the stubs without the services
+	var buf bytes.Buffer
+	code = runCheck(exportParam{protoc: protocParam{descriptors: []string{filepath.Join(stubs, "missing.pb")}, output: t.TempDir()}, stubPath: stubs}, true, false, &buf)
+	assert.Equal(t, EXITCODE_BUILD_ERROR, code)
+	assert.Contains(t, buf.String(), "loading the services")
+}
@@ -0,0 +1,296 @@
+package main
+
+/*
+ * "gripmock completion" prints a shell completion script, built from the
+ * flags themselves so it never falls behind them:
+ *
+ *   source <(gripmock completion bash)
+ *   gripmock completion zsh > "${fpath[1]}/_gripmock"
+ *   gripmock completion fish > ~/.config/fish/completions/gripmock.fish
+ *
+ * Commands, flags and the values of flags in valueCompletions complete, as
+ * do .proto files for the arguments.
+ */
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ringerc/gripmock/stub"
+)
+
+const (
+	COMPLETE_DIR  = "dir"
+	COMPLETE_FILE = "file"
+	// one of the choices
+	COMPLETE_CHOICE = "choice"
+)
+
+type valueCompletion struct {
+	kind    string
+	choices []string
+}
+
+// How to complete flag values; other flags that take a value complete
+// nothing
+var valueCompletions = map[string]valueCompletion{
+	"o":                  {kind: COMPLETE_DIR},
+	"template-dir":       {kind: COMPLETE_DIR},
+	"imports":            {kind: COMPLETE_DIR},
+	"wasm-dir":           {kind: COMPLETE_DIR},
+	"go-build-cache":     {kind: COMPLETE_DIR},
+	"go-mod-cache":       {kind: COMPLETE_DIR},
+	"vendor-from":        {kind: COMPLETE_DIR},
+	"stub":               {kind: COMPLETE_FILE},
+	"descriptor":         {kind: COMPLETE_FILE},
+	"server-files":       {kind: COMPLETE_FILE},
+	"ports-file":         {kind: COMPLETE_FILE},
+	"ready-file":         {kind: COMPLETE_FILE},
+	"access-log":         {kind: COMPLETE_FILE},
+	"config":             {kind: COMPLETE_FILE},
+	"tls-cert":           {kind: COMPLETE_FILE},
+	"tls-key":            {kind: COMPLETE_FILE},
+	"admin-tls-cert":     {kind: COMPLETE_FILE},
+	"admin-tls-key":      {kind: COMPLETE_FILE},
+	"xds-bootstrap":      {kind: COMPLETE_FILE},
+	"from-reflection-ca": {kind: COMPLETE_FILE},
+	"export-file":        {kind: COMPLETE_FILE},
+	"scaffold-dir":       {kind: COMPLETE_DIR},
+	"stub-overlap":       {COMPLETE_CHOICE, []string{stub.OVERLAP_OFF, stub.OVERLAP_WARN, stub.OVERLAP_REJECT}},
+	"stub-validation":    {COMPLETE_CHOICE, []string{stub.VALIDATION_OFF, stub.VALIDATION_WARN, stub.VALIDATION_REJECT}},
+	"pause-after":        {COMPLETE_CHOICE, []string{PAUSE_AFTER_GENERATE, PAUSE_AFTER_BUILD}},
+	"format":             {COMPLETE_CHOICE, []string{EXPORT_FORMAT_TAR_GZ, EXPORT_FORMAT_TAR}},
+	"go-compiler":        {COMPLETE_CHOICE, []string{GO_COMPILER_GC, GO_COMPILER_GCCGO}},
+	"scaffold-data":      {COMPLETE_CHOICE, []string{SCAFFOLD_DATA_DEFAULTS, SCAFFOLD_DATA_FAKE}},
+	"verbosity":          {COMPLETE_CHOICE, []string{"0", "1", "2", "3", "4"}},
+}
+
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// Run "gripmock completion" with the arguments after it, and return the
+// exit code
+func runCompletion(args []string, flags *flag.FlagSet, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "Usage: gripmock completion bash|zsh|fish")
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+	var script string
+	switch args[0] {
+	case "bash":
+		script = bashCompletion(flags)
+	case "zsh":
+		script = zshCompletion(flags)
+	case "fish":
+		script = fishCompletion(flags)
+	default:
+		fmt.Fprintf(stderr, "no completion for %q, only bash, zsh and fish\n", args[0])
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+	fmt.Fprint(stdout, script)
+	return 0
+}
+
+type completionFlag struct {
+	name string
+	// short description
+	desc string
+	// takes a value as the next argument
+	takesValue bool
+	value      valueCompletion
+}
+
+func completionFlags(flags *flag.FlagSet) []completionFlag {
+	out := []completionFlag{}
+	flags.VisitAll(func(f *flag.Flag) {
+		_, usage := flag.UnquoteUsage(f)
+		boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+		out = append(out, completionFlag{
+			name:       f.Name,
+			desc:       shortUsage(usage),
+			takesValue: !ok || !boolFlag.IsBoolFlag(),
+			value:      valueCompletions[f.Name],
+		})
+	})
+	return out
+}
+
+// First clause of a flag's usage, short enough for a completion menu
+func shortUsage(usage string) string {
+	usage = strings.TrimSuffix(usage, " (Optional)")
+	for _, sep := range []string{"; ", ", e.g.", " e.g.", ", see ", ". "} {
+		if i := strings.Index(usage, sep); i > 0 {
+			usage = usage[:i]
+		}
+	}
+	return strings.TrimSuffix(usage, ".")
+}
+
+func helpTopics(flags *flag.FlagSet) []string {
+	topics := []string{}
+	for _, g := range flagGroups(flags) {
+		topics = append(topics, g.name)
+	}
+	return topics
+}
+
+func subcommandNames() []string {
+	names := []string{}
+	for _, c := range subcommands {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func stubCommandNames() []string {
+	names := []string{}
+	for _, c := range stubCommands {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func bashCompletion(flags *flag.FlagSet) string {
+	cflags := completionFlags(flags)
+	// flags that take a value, by how it completes
+	byValue := map[string][]string{}
+	names := []string{}
+	for _, f := range cflags {
+		names = append(names, "-"+f.name)
+		if !f.takesValue {
+			continue
+		}
+		key := f.value.kind
+		if key == COMPLETE_CHOICE {
+			key += " " + strings.Join(f.value.choices, " ")
+		}
+		byValue[key] = append(byValue[key], "-"+f.name, "--"+f.name)
+	}
+	keys := []string{}
+	for k := range byValue {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("# bash completion for gripmock, from \"gripmock completion bash\"\n")
+	b.WriteString("_gripmock() {\n")
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("    if [ \"$COMP_CWORD\" -eq 2 ]; then\n")
+	b.WriteString("        case \"$prev\" in\n")
+	fmt.Fprintf(&b, "        completion) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", strings.Join(completionShells, " "))
+	fmt.Fprintf(&b, "        help) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", strings.Join(helpTopics(flags), " "))
+	fmt.Fprintf(&b, "        stub) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", strings.Join(stubCommandNames(), " "))
+	b.WriteString("        esac\n")
+	b.WriteString("    fi\n")
+	b.WriteString("    case \"$prev\" in\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "    %s)\n", strings.Join(byValue[k], "|"))
+		switch {
+		case k == COMPLETE_DIR:
+			b.WriteString("        COMPREPLY=($(compgen -d -- \"$cur\")); return ;;\n")
+		case k == COMPLETE_FILE:
+			b.WriteString("        COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n")
+		case strings.HasPrefix(k, COMPLETE_CHOICE+" "):
+			fmt.Fprintf(&b, "        COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", strings.TrimPrefix(k, COMPLETE_CHOICE+" "))
+		default:
+			b.WriteString("        return ;;\n")
+		}
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("    case \"$cur\" in\n")
+	fmt.Fprintf(&b, "    -*) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", strings.Join(names, " "))
+	b.WriteString("    esac\n")
+	b.WriteString("    if [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(&b, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(subcommandNames(), " "))
+	b.WriteString("    fi\n")
+	b.WriteString("    COMPREPLY+=($(compgen -f -X '!*.proto' -- \"$cur\") $(compgen -d -- \"$cur\"))\n")
+	b.WriteString("}\n")
+	b.WriteString("complete -o filenames -F _gripmock gripmock\n")
+	return b.String()
+}
+
+// Quote for a single-quoted zsh word
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func zshCompletion(flags *flag.FlagSet) string {
+	var b strings.Builder
+	b.WriteString("#compdef gripmock\n")
+	b.WriteString("# zsh completion for gripmock, from \"gripmock completion zsh\"\n")
+	b.WriteString("_gripmock() {\n")
+	b.WriteString("    local -a commands\n")
+	b.WriteString("    commands=(\n")
+	for _, c := range subcommands {
+		fmt.Fprintf(&b, "        %s\n", zshQuote(c.name+":"+c.summary))
+	}
+	b.WriteString("    )\n")
+	b.WriteString("    if (( CURRENT == 3 )); then\n")
+	b.WriteString("        case $words[2] in\n")
+	fmt.Fprintf(&b, "        completion) compadd %s; return ;;\n", strings.Join(completionShells, " "))
+	fmt.Fprintf(&b, "        help) compadd %s; return ;;\n", strings.Join(helpTopics(flags), " "))
+	fmt.Fprintf(&b, "        stub) compadd %s; return ;;\n", strings.Join(stubCommandNames(), " "))
+	b.WriteString("        esac\n")
+	b.WriteString("    fi\n")
+	b.WriteString("    _arguments \\\n")
+	for _, f := range completionFlags(flags) {
+		desc := strings.NewReplacer("[", `\[`, "]", `\]`).Replace(f.desc)
+		spec := "-" + f.name + "[" + desc + "]"
+		if f.takesValue {
+			switch f.value.kind {
+			case COMPLETE_DIR:
+				spec += ":dir:_files -/"
+			case COMPLETE_FILE:
+				spec += ":file:_files"
+			case COMPLETE_CHOICE:
+				spec += ":value:(" + strings.Join(f.value.choices, " ") + ")"
+			default:
+				spec += ":value: "
+			}
+		}
+		fmt.Fprintf(&b, "        %s \\\n", zshQuote(spec))
+	}
+	b.WriteString("        '1:command or proto:{_describe -t commands command commands; _files -g \"*.proto\"}' \\\n")
+	b.WriteString("        '*:proto:_files -g \"*.proto\"'\n")
+	b.WriteString("}\n")
+	b.WriteString("_gripmock \"$@\"\n")
+	return b.String()
+}
+
+// Quote for a single-quoted fish word
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func fishCompletion(flags *flag.FlagSet) string {
+	var b strings.Builder
+	b.WriteString("# fish completion for gripmock, from \"gripmock completion fish\"\n")
+	b.WriteString("complete -c gripmock -f\n")
+	for _, c := range subcommands {
+		fmt.Fprintf(&b, "complete -c gripmock -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.summary))
+	}
+	fmt.Fprintf(&b, "complete -c gripmock -n '__fish_seen_subcommand_from completion' -a %s\n", fishQuote(strings.Join(completionShells, " ")))
+	fmt.Fprintf(&b, "complete -c gripmock -n '__fish_seen_subcommand_from help' -a %s\n", fishQuote(strings.Join(helpTopics(flags), " ")))
+	fmt.Fprintf(&b, "complete -c gripmock -n '__fish_seen_subcommand_from stub' -a %s\n", fishQuote(strings.Join(stubCommandNames(), " ")))
+	for _, f := range completionFlags(flags) {
+		line := "complete -c gripmock -o " + f.name + " -d " + fishQuote(f.desc)
+		if f.takesValue {
+			switch f.value.kind {
+			case COMPLETE_DIR:
+				line += " -x -a '(__fish_complete_directories)'"
+			case COMPLETE_FILE:
+				line += " -r -F"
+			case COMPLETE_CHOICE:
+				line += " -x -a " + fishQuote(strings.Join(f.value.choices, " "))
+			default:
+				line += " -x"
+			}
+		}
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("complete -c gripmock -a '(__fish_complete_suffix .proto)'\n")
+	return b.String()
+}
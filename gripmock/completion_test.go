@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_shortUsage(t *testing.T) {
+	assert.Equal(t, "stub files to load", shortUsage("stub files to load (Optional)"))
+	assert.Equal(t, "print the ports", shortUsage("print the ports; see -ports-file"))
+	assert.Equal(t, "Port of gRPC tcp server", shortUsage("Port of gRPC tcp server, e.g. 0 for a free one"))
+}
+
+func Test_bashCompletion(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("no bash")
+	}
+	var script bytes.Buffer
+	require.Equal(t, 0, runCompletion([]string{"bash"}, testFlags(), &script, os.Stderr))
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "gripmock.bash"), script.Bytes(), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "protos"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "api.proto"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644))
+
+	complete := func(words ...string) string {
+		run := exec.Command(bash, "-c", `source gripmock.bash
+COMP_WORDS=("$@"); COMP_CWORD=$(( $# - 1 )); _gripmock; echo "${COMPREPLY[*]}"`, "bash")
+		run.Args = append(run.Args, words...)
+		run.Dir = dir
+		out, err := run.Output()
+		require.NoError(t, err)
+		return strings.TrimSpace(string(out))
+	}
+	assert.Equal(t, "demo check export generate scaffold-stubs wiremock stub completion help api.proto protos", complete("gripmock", ""))
+	assert.Equal(t, "-stub -stub-overlap", complete("gripmock", "-stub"))
+	assert.Equal(t, "warn", complete("gripmock", "--stub-overlap", "w"))
+	assert.Equal(t, "0 1 2 3 4", complete("gripmock", "-verbosity", ""))
+	assert.Equal(t, "protos", complete("gripmock", "-o", ""))
+	assert.Equal(t, "", complete("gripmock", "-grpc-port", ""))
+	assert.Equal(t, "api.proto protos", complete("gripmock", "-print-ports", ""))
+	assert.Equal(t, "zsh", complete("gripmock", "completion", "z"))
+	assert.Equal(t, "list", complete("gripmock", "stub", "l"))
+	assert.Equal(t, "stubs serving startup", complete("gripmock", "help", "s"))
+}
+
+func Test_zshCompletion(t *testing.T) {
+	script := zshCompletion(testFlags())
+	assert.True(t, strings.HasPrefix(script, "#compdef gripmock\n"))
+	for _, line := range []string{
+		`        'check:check the protos and stub files, without serving'`,
+		`        '-stub[stub files to load]:file:_files' \`,
+		`        '-stub-overlap[check stubs for overlap: off, warn or reject]:value:(off warn reject)' \`,
+		`        '-verbosity[log verbosity \[0..4\], default 1]:value:(0 1 2 3 4)' \`,
+		`        '-o[directory to output generated files]:dir:_files -/' \`,
+		`        '-grpc-port[Port of gRPC tcp server]:value: ' \`,
+		`        '-print-ports[print the ports]' \`,
+	} {
+		assert.Contains(t, script, line+"\n")
+	}
+}
+
+func Test_fishCompletion(t *testing.T) {
+	script := fishCompletion(testFlags())
+	for _, line := range []string{
+		`complete -c gripmock -n __fish_use_subcommand -a check -d 'check the protos and stub files, without serving'`,
+		`complete -c gripmock -o stub -d 'stub files to load' -r -F`,
+		`complete -c gripmock -o stub-overlap -d 'check stubs for overlap: off, warn or reject' -x -a 'off warn reject'`,
+		`complete -c gripmock -o o -d 'directory to output generated files' -x -a '(__fish_complete_directories)'`,
+		`complete -c gripmock -o grpc-port -d 'Port of gRPC tcp server' -x`,
+		`complete -c gripmock -o print-ports -d 'print the ports'`,
+	} {
+		assert.Contains(t, script, line+"\n")
+	}
+
+	var stderr bytes.Buffer
+	assert.Equal(t, EXITCODE_ARGUMENTS_ERROR, runCompletion([]string{"ksh"}, testFlags(), &bytes.Buffer{}, &stderr))
+	assert.Contains(t, stderr.String(), "only bash, zsh and fish")
+}
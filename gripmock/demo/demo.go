@@ -0,0 +1,88 @@
+// Package demo bundles the example service, stubs and walkthrough page
+// served by "gripmock demo".
+package demo
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// proto file of the demo service, relative to the extracted dir
+	PROTO_FILE = "library.proto"
+	// stub dir, relative to the extracted dir
+	STUB_DIR = "stub"
+)
+
+//go:embed library.proto stub
+var files embed.FS
+
+//go:embed walkthrough.html
+var Walkthrough []byte
+
+// A call to try on the demo service, and what the stubs answer it with
+type Call struct {
+	Method string
+	// request message, as JSON
+	Data   string
+	Answer string
+}
+
+// The calls printed by "gripmock demo", and listed in the walkthrough
+var Calls = []Call{
+	{"library.Library/GetBook", `{"isbn":"9780441013593"}`, "Dune, matched exactly"},
+	{"library.Library/GetBook", `{"isbn":"9780140449136"}`, "any Penguin Classic, matched by a regex"},
+	{"library.Library/GetBook", `{"isbn":"0000000000"}`, "NOT_FOUND, from the catch-all stub"},
+	{"library.Library/GetBook", `{"isbn":"busy"}`, "RESOURCE_EXHAUSTED, rate limited"},
+	{"library.Library/ListBooks", `{"author":"Frank Herbert"}`, "a stream of books"},
+	{"library.Library/ListBooks", `{"author":"Someone Else"}`, "a book, then an error ending the stream"},
+}
+
+// grpcurl commands making the Calls to the demo served on address, with a
+// comment saying what each is answered with. tls is whether the server
+// serves TLS, with a certificate grpcurl may not trust.
+func GrpcurlCommands(address string, tls bool) []string {
+	transport := "-plaintext"
+	if tls {
+		transport = "-insecure"
+	}
+	commands := make([]string, len(Calls))
+	for i, c := range Calls {
+		commands[i] = fmt.Sprintf("grpcurl %s -d '%s' %s %s  # %s", transport, c.Data, address, c.Method, c.Answer)
+	}
+	return commands
+}
+
+// The Calls to try, as printed by "gripmock demo"
+func Usage(address string, tls bool) string {
+	return "Try the demo with grpcurl (https://github.com/fullstorydev/grpcurl):\n\n  " +
+		strings.Join(GrpcurlCommands(address, tls), "\n  ") + "\n"
+}
+
+// Write the demo proto and stub files under dir, which must exist, so they
+// can be served like any user-supplied proto and stubs. Returns the paths
+// of the proto file and stub dir.
+func Extract(dir string) (protoPath string, stubPath string, err error) {
+	err = fs.WalkDir(files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, path)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		content, err := files.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, 0644)
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(dir, PROTO_FILE), filepath.Join(dir, STUB_DIR), nil
+}
@@ -0,0 +1,32 @@
+package demo
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+	protoPath, stubPath, err := Extract(dir)
+	require.NoError(t, err)
+
+	_, err = os.Stat(protoPath)
+	require.NoError(t, err)
+
+	byt, err := os.ReadFile(stubPath + "/library.json")
+	require.NoError(t, err)
+	var stubs []map[string]interface{}
+	require.NoError(t, json.Unmarshal(byt, &stubs))
+	require.NotEmpty(t, stubs)
+}
+
+func TestCallsInWalkthrough(t *testing.T) {
+	for _, c := range Calls {
+		require.Contains(t, string(Walkthrough), "grpcurl -plaintext -d '"+c.Data+"' localhost:<span class=\"grpc-port\">4770</span> "+c.Method)
+	}
+	require.Equal(t, `grpcurl -insecure -d '{"isbn":"busy"}' localhost:5000 library.Library/GetBook  # RESOURCE_EXHAUSTED, rate limited`,
+		GrpcurlCommands("localhost:5000", true)[3])
+}
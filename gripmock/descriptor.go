@@ -0,0 +1,132 @@
+package main
+
+/*
+ * FileDescriptorSet input.
+ *
+ * With -descriptor, gripmock serves services from compiled FileDescriptorSet
+ * files (.pb, .protoset, as from "buf build -o" or "protoc
+ * --descriptor_set_out --include_imports") instead of .proto sources, so
+ * buf or bazel pipelines don't need to ship raw protos or juggle import
+ * paths. The sets must include the files they import.
+ *
+ * The proto arguments then name files within the sets to serve, e.g.
+ * "api/v1/orders.proto"; with none, every file in the sets that defines a
+ * service and isn't imported by another file in them is served. As
+ * fixGoPackages does for sources, the served files get a go_package in the
+ * generated module, and the rewritten set is handed to protoc with
+ * --descriptor_set_in.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// rewritten descriptor set in the output dir
+const DESCRIPTOR_SET_FILE = "gripmock-descriptors.pb"
+
+// Read the descriptor sets, give the files to serve go_packages in the
+// generated module, and write the result to the output dir. With
+// serveImports, the files they import that define services are served too.
+// Returns the written set and the names of the files to serve.
+func prepareDescriptorSet(descriptors []string, serve []string, serveImports bool, layout generatedLayout, output string) (string, []string, error) {
+	set, serve, err := readDescriptorSets(descriptors, serve)
+	if err != nil {
+		return "", nil, err
+	}
+	if serveImports {
+		serve = withImportedServiceFiles(set, serve)
+	}
+	byName := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, f := range set.GetFile() {
+		byName[f.GetName()] = f
+	}
+	for _, name := range serve {
+		f := byName[name]
+		if f.Options == nil {
+			f.Options = &descriptorpb.FileOptions{}
+		}
+		newPackage := layout.goPackage(path.Dir(f.GetName()))
+		f.Options.GoPackage = proto.String(newPackage)
+		log.V(LOG_TRACE).Info("descriptor go package", "file", f.GetName(), "package", newPackage)
+	}
+
+	byt, err := proto.Marshal(set)
+	if err != nil {
+		return "", nil, err
+	}
+	out := filepath.Join(output, DESCRIPTOR_SET_FILE)
+	if err := os.WriteFile(out, byt, 0644); err != nil {
+		return "", nil, err
+	}
+	return out, serve, nil
+}
+
+// Read and merge the descriptor sets, and check the names of the files to
+// serve, or find them if there are none. Returns the merged set and the file
+// names.
+func readDescriptorSets(descriptors []string, serve []string) (*descriptorpb.FileDescriptorSet, []string, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	byName := map[string]bool{}
+	for _, d := range descriptors {
+		byt, err := os.ReadFile(d)
+		if err != nil {
+			return nil, nil, err
+		}
+		in := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(byt, in); err != nil {
+			return nil, nil, fmt.Errorf("%s isn't a FileDescriptorSet: %w", d, err)
+		}
+		for _, f := range in.GetFile() {
+			// the same file from several sets, e.g. a shared import
+			if byName[f.GetName()] {
+				continue
+			}
+			byName[f.GetName()] = true
+			set.File = append(set.File, f)
+		}
+	}
+	if len(set.File) == 0 {
+		return nil, nil, fmt.Errorf("no files in descriptor sets %v", descriptors)
+	}
+
+	if len(serve) == 0 {
+		serve = topLevelServices(set)
+		if len(serve) == 0 {
+			return nil, nil, fmt.Errorf("no services in descriptor sets %v to serve", descriptors)
+		}
+	}
+	names := make([]string, len(serve))
+	for i, name := range serve {
+		names[i] = filepath.ToSlash(name)
+		if !byName[names[i]] {
+			return nil, nil, fmt.Errorf("\"%s\" isn't in descriptor sets %v", name, descriptors)
+		}
+	}
+	return set, names, nil
+}
+
+// The files that define services and aren't imported by another file: the
+// set's own API rather than its dependencies
+func topLevelServices(set *descriptorpb.FileDescriptorSet) []string {
+	imported := map[string]bool{}
+	for _, f := range set.GetFile() {
+		for _, dep := range f.GetDependency() {
+			imported[dep] = true
+		}
+	}
+	names := []string{}
+	for _, f := range set.GetFile() {
+		if len(f.GetService()) > 0 && !imported[f.GetName()] {
+			names = append(names, f.GetName())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
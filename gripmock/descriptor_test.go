@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func Test_prepareDescriptorSet(t *testing.T) {
+	initLogging(LOG_ERROR)
+	dir := t.TempDir()
+	service := func(name string) []*descriptorpb.ServiceDescriptorProto {
+		return []*descriptorpb.ServiceDescriptorProto{{Name: proto.String(name)}}
+	}
+	write := func(name string, files ...*descriptorpb.FileDescriptorProto) string {
+		byt, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: files})
+		require.NoError(t, err)
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, byt, 0644))
+		return path
+	}
+	common := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("common/v1/ops.proto"),
+		Service: service("Operations"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/common/v1;commonv1")},
+	}
+	orders := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("api/v1/orders.proto"),
+		Dependency: []string{"common/v1/ops.proto"},
+		Service:    service("Orders"),
+	}
+	users := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("users.proto"),
+		Service: service("Users"),
+	}
+	first := write("first.pb", common, orders)
+	second := write("second.protoset", common, users)
+
+	output := t.TempDir()
+	set, serve, err := prepareDescriptorSet([]string{first, second}, nil, false, generatedLayout{}, output)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(output, DESCRIPTOR_SET_FILE), set)
+	assert.Equal(t, []string{"api/v1/orders.proto", "users.proto"}, serve, "services that aren't imports")
+
+	byt, err := os.ReadFile(set)
+	require.NoError(t, err)
+	written := &descriptorpb.FileDescriptorSet{}
+	require.NoError(t, proto.Unmarshal(byt, written))
+	packages := map[string]string{}
+	for _, f := range written.GetFile() {
+		packages[f.GetName()] = f.GetOptions().GetGoPackage()
+	}
+	assert.Equal(t, map[string]string{
+		"common/v1/ops.proto": "example.com/common/v1;commonv1",
+		"api/v1/orders.proto": GENERATED_MODULE_NAME + "/api/v1",
+		"users.proto":         GENERATED_MODULE_NAME,
+	}, packages)
+
+	// naming the files to serve
+	_, serve, err = prepareDescriptorSet([]string{first}, []string{"common/v1/ops.proto"}, false, generatedLayout{}, output)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"common/v1/ops.proto"}, serve)
+
+	_, _, err = prepareDescriptorSet([]string{first}, []string{"missing.proto"}, false, generatedLayout{}, output)
+	assert.ErrorContains(t, err, `"missing.proto" isn't in descriptor sets`)
+	_, _, err = prepareDescriptorSet([]string{write("none.pb", &descriptorpb.FileDescriptorProto{Name: proto.String("types.proto")})}, nil, false, generatedLayout{}, output)
+	assert.ErrorContains(t, err, "no services in descriptor sets")
+	notASet := filepath.Join(dir, "garbage.pb")
+	require.NoError(t, os.WriteFile(notASet, []byte("not protobuf"), 0644))
+	_, _, err = prepareDescriptorSet([]string{notASet}, nil, false, generatedLayout{}, output)
+	assert.ErrorContains(t, err, "isn't a FileDescriptorSet")
+}
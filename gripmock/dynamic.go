@@ -0,0 +1,1158 @@
+package main
+
+/*
+ * Dynamic serving.
+ *
+ * With -dynamic, gripmock serves the services itself instead of generating,
+ * building and running a server for them. The service descriptors come from
+ * the -descriptor sets, or from compiling .proto sources to a descriptor set
+ * with the embedded protoc, see protoc.go, and a grpc.UnknownServiceHandler
+ * answers every call with dynamicpb messages. There's no protoc-gen-go, go
+ * build or child process, so the server starts in moments and neither the
+ * Go toolchain nor protoc is needed at runtime, unless -protoc names a
+ * protoc binary to compile with.
+ *
+ * Calls look up their stubs on the admin server's /find, and are reported
+ * on /inflight and /events, as the generated server's are, so stubs, the
+ * request journal and the lifecycle state work the same. Messages are
+ * converted with protojson, using the proto field names and enum numbers
+ * the generated server's messages have in JSON. 64-bit integers and the
+ * well-known types take their protojson forms, though, e.g. strings for
+ * int64 and RFC 3339 strings for Timestamp.
+ *
+ * It's also the in-process alternative to the generated server's child
+ * process: the admin server's /server controls and /reload act on the
+ * grpc.Server directly, reload compiling the protos again and swapping in
+ * the new services. Pausing holds calls to the mocked services, though the
+ * health and reflection services still answer, unlike a stopped process.
+ *
+ * Not supported yet: the push, send_rate, repeat and half_close stub
+ * options, which are ignored with a warning; -codecs; and tracing.
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	// registers the v1 reflection descriptors
+	_ "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/ringerc/gripmock/stub"
+)
+
+const (
+	// descriptor set protoc compiles the proto sources to, in the output dir
+	DYNAMIC_DESCRIPTOR_FILE = "gripmock-dynamic.pb"
+
+	// how long to wait for in-flight calls to finish when stopping
+	DYNAMIC_STOP_TIMEOUT = 5 * time.Second
+)
+
+// the JSON form of messages sent to the admin server
+var dynamicMarshal = protojson.MarshalOptions{UseProtoNames: true, UseEnumNumbers: true}
+
+// Load the services and serve them until a signal stops the server,
+// carrying out the admin server's control actions meanwhile. Returns the
+// exit code.
+func runDynamic(param protocParam, keepalive keepaliveConfig, limits limitsConfig, tlsConf tlsConfig, drainPeriod time.Duration, controls <-chan serverControl) int {
+	loading := time.Now()
+	d, err := loadDynamicServer(param)
+	if err != nil {
+		log.Error(err, "loading service descriptors")
+		return EXITCODE_BUILD_ERROR
+	}
+	timePhase(stub.STARTUP_LOAD, loading)
+	stub.SetState(stub.STATE_STARTING)
+
+	listeners := []grpcListener{}
+	for _, address := range bindAddresses(param.grpcHosts, param.grpcPort) {
+		listeners = append(listeners, grpcListener{address: address})
+	}
+	listeners = append(listeners, param.listeners...)
+	tlsOpts, err := tlsConf.serverOptions()
+	if err != nil {
+		log.Error(err, "loading TLS certificate and key")
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+	if tlsConf.enabled() {
+		log.V(LOG_INFO).Info("Serving gRPC with TLS", "cert", tlsConf.cert)
+	}
+	opts := append(keepalive.serverOptions(), limits.serverOptions()...)
+	opts = append(opts, tlsOpts...)
+	run, err := d.serve(listeners, limits, opts)
+	if err != nil {
+		log.Error(err, "starting gRPC server")
+		return EXITCODE_RUNTIME_ERROR
+	}
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigchan)
+	// As with the child process: actions run one at a time, and a reload
+	// loads the services while the old server keeps serving, then swaps
+	// them in like a restart.
+	type reload struct {
+		server *dynamicServer
+		err    error
+	}
+	var pending chan error
+	var next *dynamicServer
+	reloaded := make(chan reload)
+	stopping, running, paused, swapping, halting := false, true, false, false, false
+	unpause := func() {
+		if paused {
+			d.pause(false)
+			paused = false
+			stub.ServerPaused(false)
+		}
+	}
+	for {
+		select {
+		case ctl := <-controls:
+			if pending != nil {
+				ctl.done <- stub.ErrServerBusy
+				continue
+			}
+			if err := checkControl(ctl.action, stopping, running, paused); err != nil {
+				ctl.done <- err
+				continue
+			}
+			switch ctl.action {
+			case stub.SERVER_RELOAD:
+				log.V(LOG_INFO).Info("Reloading service descriptors")
+				pending = ctl.done
+				go func() {
+					server, err := loadDynamicServer(param)
+					reloaded <- reload{server, err}
+				}()
+			case stub.SERVER_STOP, stub.SERVER_RESTART:
+				log.V(LOG_INFO).Info("Stopping gRPC server", "action", ctl.action, "drainPeriod", drainPeriod)
+				pending = ctl.done
+				swapping = ctl.action == stub.SERVER_RESTART
+				halting = !swapping
+				unpause()
+				go run.drain(drainPeriod)
+			case stub.SERVER_START:
+				log.V(LOG_INFO).Info("Starting gRPC server")
+				stub.Restarting()
+				if run, err = d.serve(listeners, limits, opts); err != nil {
+					stub.SetState(stub.STATE_STOPPED)
+					ctl.done <- err
+					continue
+				}
+				running = true
+				ctl.done <- nil
+			case stub.SERVER_PAUSE:
+				log.V(LOG_INFO).Info("Paused gRPC server")
+				d.pause(true)
+				paused = true
+				stub.ServerPaused(true)
+				ctl.done <- nil
+			case stub.SERVER_RESUME:
+				log.V(LOG_INFO).Info("Resumed gRPC server")
+				unpause()
+				ctl.done <- nil
+			}
+		case r := <-reloaded:
+			err := r.err
+			if err == nil && (stopping || !running) {
+				err = fmt.Errorf("the gRPC server stopped during the reload")
+			}
+			if err != nil {
+				log.Error(err, "reloading, the old services carry on")
+				pending <- err
+				pending = nil
+				continue
+			}
+			log.V(LOG_DEBUG).Info("Reloaded, stopping old gRPC server", "drainPeriod", drainPeriod)
+			next = r.server
+			swapping = true
+			unpause()
+			go run.drain(drainPeriod)
+		case err := <-run.done:
+			running = false
+			unpause()
+			if swapping && !stopping {
+				log.V(LOG_INFO).Info("Old gRPC server stopped, starting the new one", "error", err)
+				swapping = false
+				if next != nil {
+					d, next = next, nil
+				}
+				stub.Restarting()
+				run, err = d.serve(listeners, limits, opts)
+				if err != nil {
+					stub.SetState(stub.STATE_STOPPED)
+				} else {
+					running = true
+				}
+				pending <- err
+				pending = nil
+				continue
+			}
+			stub.SetState(stub.STATE_STOPPED)
+			if halting && !stopping {
+				log.V(LOG_INFO).Info("gRPC server stopped, the admin server carries on", "error", err)
+				halting = false
+				pending <- nil
+				pending = nil
+				continue
+			}
+			if err != nil {
+				log.Error(err, "serving gRPC")
+				return EXITCODE_RUNTIME_ERROR
+			}
+			log.V(LOG_INFO).Info("gRPC server exited")
+			return 0
+		case <-sigchan:
+			if !running {
+				log.V(LOG_INFO).Info("Caught signal with the gRPC server stopped, exiting")
+				return 0
+			}
+			if stopping {
+				log.V(LOG_DEBUG).Info("Caught second signal, stopping gRPC server")
+				run.stop()
+				continue
+			}
+			log.V(LOG_DEBUG).Info("Caught signal, stopping gRPC server", "drainPeriod", drainPeriod)
+			stopping = true
+			stub.SetState(stub.STATE_DRAINING)
+			unpause()
+			go run.drain(drainPeriod)
+		}
+	}
+}
+
+// Server options for the keepalive settings; zero values are replaced by
+// the grpc-go defaults
+func (k keepaliveConfig) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:                  k.time,
+			Timeout:               k.timeout,
+			MaxConnectionIdle:     k.maxConnectionIdle,
+			MaxConnectionAge:      k.maxConnectionAge,
+			MaxConnectionAgeGrace: k.maxConnectionAgeGrace,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             k.minTime,
+			PermitWithoutStream: k.permitWithoutStream,
+		}),
+	}
+}
+
+// Load the services to serve, and a server for them reporting to the admin
+// server
+func loadDynamicServer(param protocParam) (*dynamicServer, error) {
+	log.V(LOG_VERBOSE).Info("Loading services for dynamic serving", "input", param.protoPath, "descriptors", param.descriptors)
+	files, services, err := loadDynamicServices(param)
+	if err != nil {
+		return nil, err
+	}
+	d := newDynamicServer(files, services, adminURL(param.adminPort, param.adminTLS))
+	d.correlationKey = param.correlationKey
+	if param.adminTLS {
+		d.adminClient = loopbackTLSClient()
+	}
+	for name, md := range d.methods {
+		if methodExcluded(param.excludeMethods, string(md.Parent().FullName()), string(md.Name())) {
+			log.V(LOG_VERBOSE).Info("Excluding method", "method", name)
+			delete(d.methods, name)
+		}
+	}
+	return d, nil
+}
+
+// Build the file registry from the descriptor sets or proto sources, and
+// find the services of the files to serve
+func loadDynamicServices(param protocParam) (*protoregistry.Files, []protoreflect.ServiceDescriptor, error) {
+	var set *descriptorpb.FileDescriptorSet
+	var serve []string
+	var err error
+	if len(param.descriptors) > 0 {
+		set, serve, err = readDescriptorSets(param.descriptors, param.protoPath)
+	} else {
+		set, serve, err = compileDescriptorSet(param)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if param.serveImports {
+		serve = withImportedServiceFiles(set, serve)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolving descriptors: %w", err)
+	}
+	services := []protoreflect.ServiceDescriptor{}
+	for _, name := range serve {
+		fd, err := files.FindFileByPath(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := 0; i < fd.Services().Len(); i++ {
+			sd := fd.Services().Get(i)
+			if !serviceSelected(param.onlyServices, string(sd.FullName())) {
+				log.V(LOG_VERBOSE).Info("Not serving service, it isn't in -only-services", "service", sd.FullName())
+				continue
+			}
+			services = append(services, sd)
+		}
+	}
+	if len(services) == 0 && len(param.onlyServices) > 0 {
+		return nil, nil, fmt.Errorf("none of the services %v are in %v", param.onlyServices, serve)
+	}
+	if len(services) == 0 {
+		return nil, nil, fmt.Errorf("no services in %v", serve)
+	}
+	return files, services, nil
+}
+
+// Compile the proto sources, with everything they import, to a descriptor
+// set in the output dir. Returns the set and the protos' names within it.
+func compileDescriptorSet(param protocParam) (*descriptorpb.FileDescriptorSet, []string, error) {
+	out := filepath.Join(param.output, DYNAMIC_DESCRIPTOR_FILE)
+	args := []string{"--include_imports", "--descriptor_set_out=" + out}
+	// each proto's import dir comes first, as for the generated server, so
+	// protoc names the file relative to it
+	var protos, names, importDirs []string
+	for _, proto := range param.protoPath {
+		importDir, rel, err := findProtoInImports(param.imports, proto)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, "-I", importDir)
+		importDirs = append(importDirs, importDir)
+		name := protoName(rel, proto)
+		protos = append(protos, filepath.Join(importDir, filepath.FromSlash(name)))
+		names = append(names, name)
+	}
+	for _, imp := range param.imports {
+		args = append(args, "-I", imp)
+	}
+	if param.googleapis {
+		set, err := writeGoogleapisSet(param.output)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, "--descriptor_set_in="+set)
+	}
+	args = append(args, protos...)
+	// the protos are compiled where they are, so are found on the imports
+	if err := runProtoc(param.protoc, args, protoSources{imports: append(importDirs, param.imports...)}); err != nil {
+		return nil, nil, err
+	}
+
+	byt, err := os.ReadFile(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(byt, set); err != nil {
+		return nil, nil, err
+	}
+	return set, names, nil
+}
+
+// Answers calls to the methods of services loaded from descriptors, with
+// the stubs the admin server finds for them
+type dynamicServer struct {
+	files    *protoregistry.Files
+	services []protoreflect.ServiceDescriptor
+	// by full method name, "/package.Service/Method"
+	methods map[string]protoreflect.MethodDescriptor
+	// base URL of the admin server, and the client to call it with
+	adminURL    string
+	adminClient *http.Client
+	// metadata key of each call's correlation ID, for logging
+	correlationKey string
+	// warned about unsupported stub options once
+	warned int32
+	// closed on resuming while paused, see pause
+	mx      sync.Mutex
+	resumed chan struct{}
+}
+
+func newDynamicServer(files *protoregistry.Files, services []protoreflect.ServiceDescriptor, adminURL string) *dynamicServer {
+	d := &dynamicServer{
+		files:       files,
+		services:    services,
+		methods:     map[string]protoreflect.MethodDescriptor{},
+		adminURL:    adminURL,
+		adminClient: http.DefaultClient,
+	}
+	for _, sd := range services {
+		for i := 0; i < sd.Methods().Len(); i++ {
+			md := sd.Methods().Get(i)
+			d.methods[fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())] = md
+		}
+	}
+	return d
+}
+
+// A gRPC server for the services serves is true for, with health and
+// reflection services
+func (d *dynamicServer) grpcServer(serves func(string) bool, opts ...grpc.ServerOption) (*grpc.Server, healthTarget) {
+	s := grpc.NewServer(append(opts, grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		return d.handle(serves, stream)
+	}))...)
+
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthServices := []string{""}
+	services := []protoreflect.ServiceDescriptor{}
+	for _, sd := range d.services {
+		if !serves(string(sd.FullName())) {
+			continue
+		}
+		log.V(LOG_INFO).Info("Registering dynamic server", "service", sd.FullName())
+		healthSrv.SetServingStatus(string(sd.FullName()), healthpb.HealthCheckResponse_SERVING)
+		healthServices = append(healthServices, string(sd.FullName()))
+		services = append(services, sd)
+	}
+	healthpb.RegisterHealthServer(s, healthSrv)
+
+	// v1 reflection has v1alpha's messages under a new name, and grpc-go
+	// only implements v1alpha
+	reflectionSrv := reflection.NewServer(reflection.ServerOptions{
+		Services:           dynamicServiceInfo{s, services},
+		DescriptorResolver: dynamicResolver{d.files},
+	})
+	reflectionpb.RegisterServerReflectionServer(s, reflectionSrv)
+	v1 := reflectionpb.ServerReflection_ServiceDesc
+	v1.ServiceName = "grpc.reflection.v1.ServerReflection"
+	v1.Metadata = "grpc/reflection/v1/reflection.proto"
+	s.RegisterService(&v1, reflectionSrv)
+	return s, healthTarget{healthSrv, healthServices}
+}
+
+// Start a gRPC server for the services on each listener; the main listeners,
+// one per bind address, have no services of their own, see listeners.go
+func (d *dynamicServer) serve(listeners []grpcListener, limits limitsConfig, opts []grpc.ServerOption) (*dynamicRun, error) {
+	lises := []net.Listener{}
+	for _, l := range listeners {
+		lis, err := net.Listen("tcp", l.address)
+		if err != nil {
+			for _, lis := range lises {
+				lis.Close()
+			}
+			return nil, fmt.Errorf("listening for gRPC on %s: %w", l.address, err)
+		}
+		lises = append(lises, limits.listener(lis))
+	}
+	run := &dynamicRun{
+		server:   d,
+		done:     make(chan error, 1),
+		finished: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	extra := []grpcListener{}
+	for _, l := range listeners {
+		if len(l.services) > 0 {
+			extra = append(extra, l)
+		}
+	}
+	for _, l := range listeners {
+		serves := l.serves
+		if len(l.services) == 0 {
+			serves = func(name string) bool { return mainListenerServes(extra, name) }
+		}
+		s, healthSrv := d.grpcServer(serves, opts...)
+		run.grpc = append(run.grpc, s)
+		run.health = append(run.health, healthSrv)
+	}
+	// as bound, with the ports picked for any port of 0
+	bound := []string{}
+	for _, lis := range lises {
+		bound = append(bound, lis.Addr().String())
+	}
+	for i, l := range listeners {
+		if len(l.services) > 0 {
+			fmt.Println("Serving gRPC on tcp://"+bound[i], "for", strings.Join(l.services, ","))
+		}
+	}
+	for i, l := range listeners {
+		if len(l.services) == 0 {
+			fmt.Println("Serving gRPC on tcp://" + bound[i])
+		}
+	}
+	d.reportEvent(stub.EVENT_LISTENING, map[string]string{"addresses": strings.Join(bound, ",")})
+	d.reportEvent("health", map[string]string{"service": "", "status": "SERVING", "reason": "started"})
+	go watchHealth(d.adminClient, d.adminURL, run.health, run.finished)
+	errs := make(chan error, len(lises))
+	for i, lis := range lises {
+		go func(s *grpc.Server, lis net.Listener) {
+			errs <- s.Serve(lis)
+		}(run.grpc[i], lis)
+	}
+	// done once every server has stopped; one failing stops the others
+	go func() {
+		var first error
+		for range lises {
+			if err := <-errs; err != nil && first == nil {
+				first = err
+				for _, s := range run.grpc {
+					s.Stop()
+				}
+			}
+		}
+		close(run.finished)
+		run.done <- first
+	}()
+	return run, nil
+}
+
+// gRPC servers serving, one per listener, from starting until they stop
+type dynamicRun struct {
+	server *dynamicServer
+	grpc   []*grpc.Server
+	health []healthTarget
+	// the error Serve returned, once it has
+	done chan error
+	// closed once every server has stopped
+	finished chan struct{}
+	// closed by stop
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// Report NOT_SERVING health status for drainPeriod, then stop once
+// in-flight calls finish, or at once on stop
+func (r *dynamicRun) drain(drainPeriod time.Duration) {
+	for _, h := range r.health {
+		h.srv.Shutdown()
+	}
+	r.server.reportEvent("health", map[string]string{"service": "", "status": "NOT_SERVING", "reason": "draining"})
+	if drainPeriod > 0 {
+		log.V(LOG_INFO).Info("Draining before stopping", "drainPeriod", drainPeriod)
+		select {
+		case <-r.stopped:
+			r.stopAll()
+			return
+		case <-time.After(drainPeriod):
+		}
+	}
+
+	// health watches and other long-lived streams never finish by
+	// themselves, so only wait for in-flight calls for a while
+	graceful := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, s := range r.grpc {
+			wg.Add(1)
+			go func(s *grpc.Server) {
+				defer wg.Done()
+				s.GracefulStop()
+			}(s)
+		}
+		wg.Wait()
+		close(graceful)
+	}()
+	select {
+	case <-graceful:
+	case <-r.stopped:
+		r.stopAll()
+	case <-time.After(DYNAMIC_STOP_TIMEOUT):
+		r.stopAll()
+	}
+}
+
+func (r *dynamicRun) stopAll() {
+	for _, s := range r.grpc {
+		s.Stop()
+	}
+}
+
+// Stop without waiting for in-flight calls
+func (r *dynamicRun) stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopped)
+	})
+	r.stopAll()
+}
+
+// Hold calls to the services until resumed, or let them go ahead. The
+// health and reflection services still answer.
+func (d *dynamicServer) pause(paused bool) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	switch {
+	case paused && d.resumed == nil:
+		d.resumed = make(chan struct{})
+	case !paused && d.resumed != nil:
+		close(d.resumed)
+		d.resumed = nil
+	}
+}
+
+// Wait while the server is paused
+func (d *dynamicServer) waitResumed(ctx context.Context) error {
+	d.mx.Lock()
+	resumed := d.resumed
+	d.mx.Unlock()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// Handle a call to any method on a listener serving the services serves
+// is true for, see grpc.UnknownServiceHandler
+func (d *dynamicServer) handle(serves func(string) bool, stream grpc.ServerStream) error {
+	if err := d.waitResumed(stream.Context()); err != nil {
+		return err
+	}
+	name, _ := grpc.MethodFromServerStream(stream)
+	md, ok := d.methods[name]
+	if !ok || !serves(string(md.Parent().FullName())) {
+		id, finish := d.startCall(stream.Context(), name, "unknown")
+		err := d.answerUnknown(stream, name, id)
+		finish(err)
+		return err
+	}
+	callType := "unary"
+	switch {
+	case md.IsStreamingClient() && md.IsStreamingServer():
+		callType = "bidi_stream"
+	case md.IsStreamingClient():
+		callType = "client_stream"
+	case md.IsStreamingServer():
+		callType = "server_stream"
+	}
+	id, finish := d.startCall(stream.Context(), name, callType)
+	call := &dynamicCall{server: d, ctx: stream.Context(), stream: stream, method: md, callID: id}
+	var err error
+	switch callType {
+	case "unary":
+		err = call.unary()
+	case "server_stream":
+		err = call.serverStream()
+	case "client_stream":
+		err = call.clientStream()
+	default:
+		err = call.bidiStream()
+	}
+	finish(err)
+	return err
+}
+
+// Answer a call to a method that isn't in the descriptors with a stub for it
+// or a catch-all stub, as the generated server does. The request is only
+// raw bytes, and the response is the stub's raw output.
+func (d *dynamicServer) answerUnknown(stream grpc.ServerStream, fullMethod, callID string) error {
+	// the unknown fields of an empty message are the message's bytes
+	in := &emptypb.Empty{}
+	if err := stream.RecvMsg(in); err != nil && err != io.EOF {
+		return err
+	}
+	service, method := "", fullMethod
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		service, method = strings.TrimPrefix(fullMethod[:i], "/"), fullMethod[i+1:]
+	}
+	if i := strings.LastIndex(service, "."); i >= 0 {
+		service = service[i+1:]
+	}
+	call := &dynamicCall{server: d, ctx: stream.Context(), stream: stream, callID: callID}
+	resp, err := call.find(dynamicQuery{
+		Service: service,
+		Method:  method,
+		Data:    map[string][]byte{"raw": in.ProtoReflect().GetUnknown()},
+		Unknown: true,
+	})
+	if err != nil {
+		return status.Errorf(codes.Unimplemented, "unknown method %s: %v", fullMethod, err)
+	}
+	if err := resp.err(); err != nil {
+		return err
+	}
+	if resp.TrailersOnly {
+		return nil
+	}
+	out := &emptypb.Empty{}
+	out.ProtoReflect().SetUnknown(resp.Raw)
+	return stream.SendMsg(out)
+}
+
+// Report a call starting, and return its ID and a function to report it
+// finishing with the handler's error
+func (d *dynamicServer) startCall(ctx context.Context, method, callType string) (string, func(error)) {
+	start := time.Now()
+	id := fmt.Sprintf("%d-%d", os.Getpid(), atomic.AddUint64(&lastDynamicCallID, 1))
+	d.post("/inflight", dynamicCallReport{Method: method, Type: callType, Delta: 1, CallID: id, Headers: incomingHeaders(ctx)})
+	return id, func(err error) {
+		d.post("/inflight", dynamicCallReport{
+			Method:  method,
+			Type:    callType,
+			Delta:   -1,
+			CallID:  id,
+			Code:    int(status.Code(err)),
+			Latency: time.Since(start).String(),
+			Peer:    peerAddress(ctx),
+		})
+	}
+}
+
+// The client's address, if known
+func peerAddress(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+var lastDynamicCallID uint64
+
+// as the generated server's callReport
+type dynamicCallReport struct {
+	Method  string            `json:"method"`
+	Type    string            `json:"type"`
+	Delta   int               `json:"delta"`
+	CallID  string            `json:"call_id"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Code    int               `json:"code,omitempty"`
+	Latency string            `json:"latency,omitempty"`
+	Peer    string            `json:"peer,omitempty"`
+}
+
+// Tell the admin server about a lifecycle event, for its /events log
+func (d *dynamicServer) reportEvent(typ string, detail map[string]string) {
+	d.post("/events", map[string]interface{}{"type": typ, "detail": detail})
+}
+
+// Post a report to the admin server, logging any failure
+func (d *dynamicServer) post(path string, report interface{}) {
+	byt, err := json.Marshal(report)
+	if err != nil {
+		log.Error(err, "encoding report", "path", path)
+		return
+	}
+	resp, err := d.adminClient.Post(d.adminURL+path, "application/json", bytes.NewReader(byt))
+	if err != nil {
+		log.Error(err, "reporting to admin server", "path", path)
+		return
+	}
+	resp.Body.Close()
+}
+
+// One call to a dynamic method
+type dynamicCall struct {
+	server *dynamicServer
+	ctx    context.Context
+	stream grpc.ServerStream
+	// nil for an unknown method
+	method protoreflect.MethodDescriptor
+	// ID the call was reported with, for the request journal
+	callID string
+}
+
+func (c *dynamicCall) unary() error {
+	in, err := c.recv()
+	if err != nil {
+		return err
+	}
+	resp, err := c.find(dynamicQuery{Data: in})
+	if err != nil {
+		return err
+	}
+	if err := resp.err(); err != nil {
+		return err
+	}
+	if resp.TrailersOnly {
+		return status.Error(codes.Internal, "trailers_only stub without an error for a method that must send a message")
+	}
+	return c.send(resp.Data)
+}
+
+func (c *dynamicCall) serverStream() error {
+	in, err := c.recv()
+	if err != nil {
+		return err
+	}
+	resp, err := c.find(dynamicQuery{Data: in})
+	if err != nil {
+		return err
+	}
+	for _, msg := range resp.messages() {
+		if err := c.send(msg); err != nil {
+			return err
+		}
+	}
+	// a stub error ends the stream after any messages were sent
+	return resp.err()
+}
+
+// The stub is chosen once the client is done, so it can match on the whole
+// message sequence
+func (c *dynamicCall) clientStream() error {
+	msgs := []dynamicMessage{}
+	for {
+		in, err := c.recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, in)
+	}
+	resp, err := c.findStream(msgs, false)
+	if err != nil {
+		return err
+	}
+	if err := resp.err(); err != nil {
+		return err
+	}
+	if resp.TrailersOnly {
+		return status.Error(codes.Internal, "trailers_only stub without an error for a method that must send a message")
+	}
+	return c.send(resp.Data)
+}
+
+// Each message gets the replies of the stub matching the stream so far. A
+// stub matching the stream before any message arrives can greet the
+// client.
+func (c *dynamicCall) bidiStream() error {
+	msgs := []dynamicMessage{}
+	resp, err := c.findStream(msgs, true)
+	if err != nil {
+		return err
+	}
+	if resp != nil {
+		if err := c.reply(resp); err != nil || resp.TrailersOnly {
+			return err
+		}
+	}
+	for {
+		in, err := c.recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, in)
+		resp, err := c.findStream(msgs, false)
+		if err != nil {
+			return err
+		}
+		// a trailers-only reply ends the stream, even with an OK status
+		if err := c.reply(resp); err != nil || resp.TrailersOnly {
+			return err
+		}
+	}
+}
+
+// Send a stub's messages and return its error, if any
+func (c *dynamicCall) reply(resp *dynamicResponse) error {
+	for _, msg := range resp.messages() {
+		if err := c.send(msg); err != nil {
+			return err
+		}
+	}
+	return resp.err()
+}
+
+func (c *dynamicCall) recv() (dynamicMessage, error) {
+	in := dynamicpb.NewMessage(c.method.Input())
+	if err := c.stream.RecvMsg(in); err != nil {
+		return dynamicMessage{}, err
+	}
+	return dynamicMessage{in}, nil
+}
+
+// Convert a stub's json message into the method's output message, and send
+// it
+func (c *dynamicCall) send(data interface{}) error {
+	byt, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	out := dynamicpb.NewMessage(c.method.Output())
+	if err := protojson.Unmarshal(byt, out); err != nil {
+		return err
+	}
+	return c.stream.SendMsg(out)
+}
+
+// Like find, matching on every message the stream has received so far. The
+// last message is sent as the call's data for stubs that only match one. If
+// optional, a nil response means no stub matched.
+func (c *dynamicCall) findStream(msgs []dynamicMessage, optional bool) (*dynamicResponse, error) {
+	query := dynamicQuery{Stream: msgs, Optional: optional}
+	if len(msgs) > 0 {
+		query.Data = msgs[len(msgs)-1]
+	}
+	return c.find(query)
+}
+
+// The logger for lines about the call, with its correlation ID if it has one
+func (c *dynamicCall) log() logr.Logger {
+	if c.server.correlationKey == "" {
+		return log
+	}
+	md, _ := metadata.FromIncomingContext(c.ctx)
+	if ids := md.Get(c.server.correlationKey); len(ids) > 0 {
+		return log.WithValues("correlationID", ids[0])
+	}
+	return log
+}
+
+// Ask the admin server for the response to the call, and apply the response
+// options that aren't specific to any one message
+func (c *dynamicCall) find(query dynamicQuery) (*dynamicResponse, error) {
+	if c.method != nil {
+		query.Service = string(c.method.Parent().Name())
+		query.Method = string(c.method.Name())
+	}
+	query.Headers = incomingHeaders(c.ctx)
+	query.CallID = c.callID
+	if deadline, ok := c.ctx.Deadline(); ok {
+		query.Deadline = time.Until(deadline).String()
+	}
+	byt, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	httpResp, err := c.server.adminClient.Post(c.server.adminURL+"/find", "application/json", bytes.NewReader(byt))
+	if err != nil {
+		return nil, fmt.Errorf("Error request to stub server %v", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode == http.StatusNotFound && query.Optional {
+		return nil, nil
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(httpResp.Body)
+		return nil, errors.New(string(body))
+	}
+	resp := &dynamicResponse{}
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		return nil, fmt.Errorf("decoding json response %v", err)
+	}
+	if resp.unsupported() && atomic.CompareAndSwapInt32(&c.server.warned, 0, 1) {
+		c.log().V(LOG_INFO).Info("WARNING: -dynamic ignores the push, send_rate, repeat and half_close stub options", "service", query.Service, "method", query.Method)
+	}
+
+	if resp.Compression != "" {
+		// fails if the client didn't advertise support for the compressor
+		if err := grpc.SetSendCompressor(c.ctx, resp.Compression); err != nil {
+			c.log().V(LOG_VERBOSE).Info("setting compressor", "method", query.Method, "error", err.Error())
+		}
+	}
+	if len(resp.Trailers) > 0 {
+		c.stream.SetTrailer(metadata.New(resp.Trailers))
+	}
+	// headers can only be sent once per call, so later replies on a
+	// bidirectional stream can't change them
+	if len(resp.Headers) > 0 {
+		if err := c.stream.SetHeader(metadata.New(resp.Headers)); err != nil {
+			c.log().V(LOG_VERBOSE).Info("setting headers", "method", query.Method, "error", err.Error())
+		}
+	}
+	if resp.EarlyHeaders {
+		if err := c.stream.SendHeader(metadata.MD{}); err != nil {
+			c.log().V(LOG_VERBOSE).Info("sending headers", "method", query.Method, "error", err.Error())
+		}
+	}
+	if resp.Delay != "" {
+		// the stub server validated the delay
+		delay, _ := time.ParseDuration(resp.Delay)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-c.ctx.Done():
+			return nil, status.FromContextError(c.ctx.Err()).Err()
+		case <-timer.C:
+		}
+	}
+	if resp.ExceedDeadline {
+		// respond anyway once the deadline has passed; calls without one
+		// wait until the client cancels
+		<-c.ctx.Done()
+	}
+	return resp, nil
+}
+
+// A received message, in the JSON the admin server matches stubs on
+type dynamicMessage struct {
+	proto.Message
+}
+
+func (m dynamicMessage) MarshalJSON() ([]byte, error) {
+	return dynamicMarshal.Marshal(m.Message)
+}
+
+// The admin server's /find request, as the generated server sends it
+type dynamicQuery struct {
+	Service  string            `json:"service"`
+	Method   string            `json:"method"`
+	Data     interface{}       `json:"data"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Stream   []dynamicMessage  `json:"stream,omitempty"`
+	Deadline string            `json:"deadline,omitempty"`
+	CallID   string            `json:"call_id,omitempty"`
+	Optional bool              `json:"optional,omitempty"`
+	Unknown  bool              `json:"unknown,omitempty"`
+}
+
+// The admin server's /find response
+type dynamicResponse struct {
+	Data           interface{}       `json:"data"`
+	Error          string            `json:"error"`
+	Compression    string            `json:"compression"`
+	Code           int               `json:"code"`
+	RetryDelay     string            `json:"retry_delay"`
+	Trailers       map[string]string `json:"trailers"`
+	Headers        map[string]string `json:"headers"`
+	Delay          string            `json:"delay"`
+	ExceedDeadline bool              `json:"exceed_deadline"`
+	EarlyHeaders   bool              `json:"early_headers"`
+	TrailersOnly   bool              `json:"trailers_only"`
+	Stream         []interface{}     `json:"stream"`
+	// response message bytes for unknown methods
+	Raw []byte `json:"raw"`
+	// not supported, see unsupported
+	Push      json.RawMessage `json:"push"`
+	SendRate  json.RawMessage `json:"send_rate"`
+	HalfClose json.RawMessage `json:"half_close"`
+	Repeat    json.RawMessage `json:"repeat"`
+}
+
+// Whether the stub asks for options -dynamic doesn't support
+func (resp *dynamicResponse) unsupported() bool {
+	for _, opt := range []json.RawMessage{resp.Push, resp.SendRate, resp.HalfClose, resp.Repeat} {
+		if len(opt) > 0 && string(opt) != "null" {
+			return true
+		}
+	}
+	return false
+}
+
+// The messages a streaming response sends: the stub's stream list, or its
+// single data message if it doesn't have a list and isn't an error
+func (resp *dynamicResponse) messages() []interface{} {
+	if resp.TrailersOnly {
+		return nil
+	}
+	if len(resp.Stream) > 0 {
+		return resp.Stream
+	}
+	if resp.Error == "" && resp.Code == 0 {
+		return []interface{}{resp.Data}
+	}
+	return nil
+}
+
+// The gRPC status error the stub asked for, with any error details. Nil if
+// the stub doesn't return an error.
+func (resp *dynamicResponse) err() error {
+	if resp.Error == "" && resp.Code == 0 {
+		return nil
+	}
+	code := codes.Unknown
+	if resp.Code != 0 {
+		code = codes.Code(resp.Code)
+	}
+	st := status.New(code, resp.Error)
+	if resp.RetryDelay != "" {
+		delay, err := time.ParseDuration(resp.RetryDelay)
+		if err != nil {
+			return fmt.Errorf("invalid stub retry_delay: %v", err)
+		}
+		withDetails, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)})
+		if err != nil {
+			return fmt.Errorf("adding RetryInfo to stub error: %v", err)
+		}
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// Flatten the incoming call metadata so the stub server can match and route
+// on it. Multiple values for a key are joined with ", ".
+func incomingHeaders(ctx context.Context) map[string]string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	headers := make(map[string]string, len(md))
+	for k, v := range md {
+		headers[k] = strings.Join(v, ", ")
+	}
+	return headers
+}
+
+// The services reflection lists: the dynamic ones, and those registered on
+// the server, health and reflection itself
+type dynamicServiceInfo struct {
+	server   *grpc.Server
+	services []protoreflect.ServiceDescriptor
+}
+
+func (i dynamicServiceInfo) GetServiceInfo() map[string]grpc.ServiceInfo {
+	info := i.server.GetServiceInfo()
+	for _, sd := range i.services {
+		info[string(sd.FullName())] = grpc.ServiceInfo{}
+	}
+	return info
+}
+
+// Finds descriptors for reflection in the loaded files, then in those
+// compiled into gripmock, such as the health service's
+type dynamicResolver struct {
+	files *protoregistry.Files
+}
+
+func (r dynamicResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if fd, err := r.files.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r dynamicResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if desc, err := r.files.FindDescriptorByName(name); err == nil {
+		return desc, nil
+	}
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
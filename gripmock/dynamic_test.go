@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/ringerc/gripmock/stub"
+)
+
+// Write a descriptor set with a test.Greeter service
+func writeGreeterSet(t *testing.T) string {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+	}
+	method := func(name string, streaming bool) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(".test.Request"),
+			OutputType:      proto.String(".test.Reply"),
+			ClientStreaming: proto.Bool(streaming),
+			ServerStreaming: proto.Bool(streaming),
+		}
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/greeter.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Request"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("user_name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			}},
+			{Name: proto.String("Reply"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("message", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name:   proto.String("Greeter"),
+			Method: []*descriptorpb.MethodDescriptorProto{method("SayHello", false), method("Chat", true)},
+		}},
+	}
+	byt, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	require.NoError(t, err)
+	setPath := filepath.Join(t.TempDir(), "greeter.pb")
+	require.NoError(t, os.WriteFile(setPath, byt, 0644))
+	return setPath
+}
+
+func Test_loadDynamicServer_filters(t *testing.T) {
+	initLogging(LOG_ERROR)
+	setPath := writeGreeterSet(t)
+	d, err := loadDynamicServer(protocParam{
+		descriptors:    []string{setPath},
+		onlyServices:   []string{"test.Greeter"},
+		excludeMethods: []string{"Greeter/Chat"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, d.services, 1)
+	assert.Contains(t, d.methods, "/test.Greeter/SayHello")
+	assert.NotContains(t, d.methods, "/test.Greeter/Chat", "answered as an unknown method")
+
+	_, err = loadDynamicServer(protocParam{descriptors: []string{setPath}, onlyServices: []string{"Farewell"}})
+	assert.ErrorContains(t, err, "none of the services [Farewell]")
+}
+
+func Test_dynamicServer(t *testing.T) {
+	initLogging(LOG_ERROR)
+	setPath := writeGreeterSet(t)
+	files, services, err := loadDynamicServices(protocParam{descriptors: []string{setPath}})
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+	assert.Equal(t, protoreflect.FullName("test.Greeter"), services[0].FullName())
+
+	// an admin server with a stub per call
+	var mx sync.Mutex
+	queries := []dynamicQuery{}
+	inflight := 0
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+		switch r.URL.Path {
+		case "/inflight":
+			inflight++
+		case "/find":
+			query := dynamicQuery{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+			queries = append(queries, query)
+			data, _ := query.Data.(map[string]interface{})
+			switch {
+			case query.Unknown:
+				// echo the request
+				w.Write([]byte(`{"raw":"` + data["raw"].(string) + `"}`))
+			case query.Method == "Chat" && query.Optional:
+				w.WriteHeader(http.StatusNotFound)
+			case query.Method == "Chat":
+				w.Write([]byte(`{"stream":[{"message":"one"},{"message":"two"}]}`))
+			case data["user_name"] == "bob":
+				w.Write([]byte(`{"data":{"message":"Hello bob","count":2},"headers":{"x-stub":"hello"}}`))
+			default:
+				w.Write([]byte(`{"error":"no such user","code":5}`))
+			}
+		}
+	}))
+	defer admin.Close()
+
+	d := newDynamicServer(files, services, admin.URL)
+	s, _ := d.grpcServer(func(string) bool { return true })
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(lis)
+	defer s.Stop()
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sd := services[0]
+	request := func(name string) *dynamicpb.Message {
+		in := dynamicpb.NewMessage(sd.Methods().ByName("SayHello").Input())
+		in.Set(in.Descriptor().Fields().ByName("user_name"), protoreflect.ValueOfString(name))
+		return in
+	}
+	replyField := func(msg *dynamicpb.Message, name protoreflect.Name) interface{} {
+		return msg.Get(msg.Descriptor().Fields().ByName(name)).Interface()
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-test", "yes")
+	out := dynamicpb.NewMessage(sd.Methods().ByName("SayHello").Output())
+	var header metadata.MD
+	require.NoError(t, conn.Invoke(ctx, "/test.Greeter/SayHello", request("bob"), out, grpc.Header(&header)))
+	assert.Equal(t, "Hello bob", replyField(out, "message"))
+	assert.Equal(t, int32(2), replyField(out, "count"))
+	assert.Equal(t, []string{"hello"}, header.Get("x-stub"))
+	mx.Lock()
+	assert.Equal(t, "Greeter", queries[0].Service)
+	assert.Equal(t, "SayHello", queries[0].Method)
+	assert.Equal(t, "yes", queries[0].Headers["x-test"])
+	assert.NotEmpty(t, queries[0].CallID)
+	assert.Equal(t, 2, inflight, "the call starting and finishing")
+	mx.Unlock()
+
+	err = conn.Invoke(ctx, "/test.Greeter/SayHello", request("alice"), out)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "no such user", status.Convert(err).Message())
+
+	// methods that aren't in the descriptors get raw stubs
+	raw := &emptypb.Empty{}
+	require.NoError(t, conn.Invoke(ctx, "/test.Greeter/Missing", request("bob"), raw))
+	echoed, err := proto.Marshal(request("bob"))
+	require.NoError(t, err)
+	assert.Equal(t, echoed, []byte(raw.ProtoReflect().GetUnknown()))
+	mx.Lock()
+	assert.Equal(t, "Missing", queries[len(queries)-1].Method)
+	mx.Unlock()
+
+	// reflection v1 lists the services, and serves their descriptors
+	v1, err := conn.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, reflectionMethods[0])
+	require.NoError(t, err)
+	listed, err := reflectionRequest(v1, &reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	require.NoError(t, err)
+	names := []string{}
+	for _, svc := range listed.GetListServicesResponse().GetService() {
+		names = append(names, svc.GetName())
+	}
+	assert.Subset(t, names, []string{"test.Greeter", "grpc.health.v1.Health", "grpc.reflection.v1.ServerReflection", "grpc.reflection.v1alpha.ServerReflection"})
+	v1.CloseSend()
+	set, err := reflectDescriptors(ctx, conn)
+	require.NoError(t, err)
+	require.Len(t, set.GetFile(), 1)
+	assert.Equal(t, "test/greeter.proto", set.GetFile()[0].GetName())
+
+	// each message on a bidirectional stream gets the stub's replies
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/test.Greeter/Chat")
+	require.NoError(t, err)
+	require.NoError(t, stream.SendMsg(request("bob")))
+	require.NoError(t, stream.CloseSend())
+	replies := []interface{}{}
+	for {
+		reply := dynamicpb.NewMessage(sd.Methods().ByName("Chat").Output())
+		err := stream.RecvMsg(reply)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		replies = append(replies, replyField(reply, "message"))
+	}
+	assert.Equal(t, []interface{}{"one", "two"}, replies)
+	mx.Lock()
+	last := queries[len(queries)-1]
+	mx.Unlock()
+	assert.Len(t, last.Stream, 1, "matched on the stream so far")
+}
+
+func Test_runDynamic_controls(t *testing.T) {
+	initLogging(LOG_ERROR)
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/find" {
+			w.Write([]byte(`{"data":{"message":"Hello"}}`))
+		}
+	}))
+	defer admin.Close()
+	_, adminPort, err := net.SplitHostPort(admin.Listener.Addr().String())
+	require.NoError(t, err)
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, grpcPort, err := net.SplitHostPort(free.Addr().String())
+	require.NoError(t, err)
+	free.Close()
+
+	param := protocParam{
+		descriptors: []string{writeGreeterSet(t)},
+		grpcHosts:   []string{"127.0.0.1"},
+		grpcPort:    grpcPort,
+		adminPort:   adminPort,
+	}
+	controls := make(chan serverControl)
+	exited := make(chan int, 1)
+	go func() {
+		exited <- runDynamic(param, keepaliveConfig{}, limitsConfig{}, tlsConfig{}, 0, controls)
+	}()
+	control := func(action string) error {
+		done := make(chan error, 1)
+		controls <- serverControl{action, done}
+		return <-done
+	}
+
+	conn, err := grpc.Dial("127.0.0.1:"+grpcPort, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	call := func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return conn.Invoke(ctx, "/test.Greeter/SayHello", &emptypb.Empty{}, &emptypb.Empty{}, grpc.WaitForReady(true))
+	}
+	require.NoError(t, call(5*time.Second))
+
+	// calls hang while paused
+	require.NoError(t, control(stub.SERVER_PAUSE))
+	assert.ErrorIs(t, control(stub.SERVER_PAUSE), stub.ErrServerState)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(call(100*time.Millisecond)))
+	require.NoError(t, control(stub.SERVER_RESUME))
+	require.NoError(t, call(5*time.Second))
+
+	require.NoError(t, control(stub.SERVER_STOP))
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(call(100*time.Millisecond)), "nothing listening")
+	assert.ErrorIs(t, control(stub.SERVER_RESTART), stub.ErrServerState)
+	require.NoError(t, control(stub.SERVER_START))
+	require.NoError(t, call(5*time.Second))
+
+	require.NoError(t, control(stub.SERVER_RELOAD))
+	require.NoError(t, call(5*time.Second))
+	require.NoError(t, os.WriteFile(param.descriptors[0], []byte("not a descriptor set"), 0644))
+	assert.Error(t, control(stub.SERVER_RELOAD))
+	require.NoError(t, call(5*time.Second), "the old services carry on")
+
+	self, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, self.Signal(syscall.SIGTERM))
+	select {
+	case code := <-exited:
+		assert.Equal(t, 0, code)
+	case <-time.After(10 * time.Second):
+		t.Fatal("runDynamic didn't exit")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+/*
+ * "gripmock export" writes the generated server module to an archive instead
+ * of building and running it, so it can be audited or committed.
+ *
+ * Archives are reproducible: entries are in path order, and every entry has
+ * the same timestamp, owner and normalized permissions, so exporting the
+ * same inputs twice gives byte-identical archives.
+ */
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ringerc/gripmock/stub"
+)
+
+const (
+	EXPORT_FORMAT_TAR_GZ = "tar.gz"
+	EXPORT_FORMAT_TAR    = "tar"
+
+	// directory in the archive holding the stub files
+	EXPORT_STUB_DIR = "stubs"
+)
+
+// files in the output dir that are build products, not sources
+var exportExcludes = map[string]bool{
+	// built on any platform
+	"server":     true,
+	"server.exe": true,
+}
+
+// Timestamp for every archive entry: $SOURCE_DATE_EPOCH if set, per
+// https://reproducible-builds.org/specs/source-date-epoch/, otherwise the
+// Unix epoch.
+func exportTimestamp(environ func(string) string) (time.Time, error) {
+	epoch := environ("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Unix(0, 0).UTC(), nil
+	}
+	secs, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH \"%s\": %w", epoch, err)
+	}
+	return time.Unix(secs, 0).UTC(), nil
+}
+
+type exportEntry struct {
+	// path in the archive, slash separated
+	name string
+	// file on disk, empty for directories
+	source string
+}
+
+// List the files under dir as archive entries below prefix, in path order
+func exportEntries(dir, prefix string, exclude map[string]bool) ([]exportEntry, error) {
+	entries := []exportEntry{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if exclude[filepath.ToSlash(rel)] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := filepath.ToSlash(filepath.Join(prefix, rel))
+		if info.IsDir() {
+			entries = append(entries, exportEntry{name: name + "/"})
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("can't export %s, not a regular file", p)
+		}
+		entries = append(entries, exportEntry{name: name, source: p})
+		return nil
+	})
+	return entries, err
+}
+
+// Entries for the stub files of a -stub list, under EXPORT_STUB_DIR by
+// their path below the directory or pattern base they were found in
+func stubExportEntries(stubPath string) ([]exportEntry, error) {
+	files, err := stub.FindStubFiles(stubPath)
+	if err != nil {
+		return nil, err
+	}
+	entries := []exportEntry{{name: EXPORT_STUB_DIR + "/"}}
+	dirs := map[string]bool{}
+	sources := map[string]string{}
+	for _, f := range files {
+		name := path.Join(EXPORT_STUB_DIR, f.Rel)
+		if other, ok := sources[name]; ok {
+			return nil, fmt.Errorf("stub files %s and %s would both be exported as %s", other, f.Path, name)
+		}
+		sources[name] = f.Path
+		for dir := path.Dir(f.Rel); dir != "."; dir = path.Dir(dir) {
+			if !dirs[dir] {
+				dirs[dir] = true
+				entries = append(entries, exportEntry{name: path.Join(EXPORT_STUB_DIR, dir) + "/"})
+			}
+		}
+		entries = append(entries, exportEntry{name: name, source: f.Path})
+	}
+	return entries, nil
+}
+
+// Write a reproducible archive of the generated module in output, and the
+// stub files of stubPath, a -stub list, if it's set.
+func writeExport(w io.Writer, format, output, stubPath string, modTime time.Time) error {
+	entries, err := exportEntries(output, "", exportExcludes)
+	if err != nil {
+		return err
+	}
+	if stubPath != "" {
+		stubs, err := stubExportEntries(stubPath)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, stubs...)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	switch format {
+	case EXPORT_FORMAT_TAR_GZ:
+		gz := gzip.NewWriter(w)
+		// leave the gzip name and timestamp unset, so they're stable
+		if err := writeTar(gz, entries, modTime); err != nil {
+			return err
+		}
+		return gz.Close()
+	case EXPORT_FORMAT_TAR:
+		return writeTar(w, entries, modTime)
+	}
+	return fmt.Errorf("unsupported export format \"%s\", must be \"%s\" or \"%s\"", format, EXPORT_FORMAT_TAR_GZ, EXPORT_FORMAT_TAR)
+}
+
+func writeTar(w io.Writer, entries []exportEntry, modTime time.Time) error {
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:    e.name,
+			ModTime: modTime,
+			Mode:    0644,
+			Format:  tar.FormatPAX,
+		}
+		if e.source == "" {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		byt, err := os.ReadFile(e.source)
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(len(byt))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(byt); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Write the export archive to path
+func exportArchive(path, format, output, stubPath string) error {
+	modTime, err := exportTimestamp(os.Getenv)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeExport(f, format, output, stubPath, modTime); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
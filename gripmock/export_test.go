@@ -0,0 +1,106 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeExport(t *testing.T) {
+	output := t.TempDir()
+	stubs := t.TempDir()
+	files := map[string]string{
+		filepath.Join(output, "go.mod"):            "module gripmock/generated\n",
+		filepath.Join(output, "go.sum"):            "",
+		filepath.Join(output, "cmd", "server.go"):  "package main\n",
+		filepath.Join(output, "api", "api.proto"):  "syntax = \"proto3\";\n",
+		filepath.Join(output, "server"):            "binary",
+		filepath.Join(stubs, "one.json"):           "{}",
+		filepath.Join(stubs, "nested", "two.json"): "[]",
+	}
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+
+	modTime := time.Unix(1700000000, 0).UTC()
+	first := &bytes.Buffer{}
+	require.NoError(t, writeExport(first, EXPORT_FORMAT_TAR_GZ, output, stubs, modTime))
+
+	// touching the inputs doesn't change the archive
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(output, "go.mod"), later, later))
+	second := &bytes.Buffer{}
+	require.NoError(t, writeExport(second, EXPORT_FORMAT_TAR_GZ, output, stubs, modTime))
+	assert.Equal(t, first.Bytes(), second.Bytes())
+
+	gz, err := gzip.NewReader(first)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	names := []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, modTime, hdr.ModTime.UTC(), hdr.Name)
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{
+		"api/",
+		"api/api.proto",
+		"cmd/",
+		"cmd/server.go",
+		"go.mod",
+		"go.sum",
+		"stubs/",
+		"stubs/nested/",
+		"stubs/nested/two.json",
+		"stubs/one.json",
+	}, names)
+
+	// only the files a pattern matches are exported, below its base
+	archive := &bytes.Buffer{}
+	require.NoError(t, writeExport(archive, EXPORT_FORMAT_TAR, output, filepath.Join(stubs, "**", "two.json"), modTime))
+	tr = tar.NewReader(archive)
+	names = []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{"stubs/", "stubs/nested/", "stubs/nested/two.json"}, names[len(names)-3:])
+
+	assert.EqualError(t, writeExport(io.Discard, "zip", output, "", modTime),
+		`unsupported export format "zip", must be "tar.gz" or "tar"`)
+}
+
+func Test_exportTimestamp(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+
+	ts, err := exportTimestamp(getenv)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), ts.Unix())
+
+	env["SOURCE_DATE_EPOCH"] = "1700000000"
+	ts, err = exportTimestamp(getenv)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1700000000), ts.Unix())
+
+	env["SOURCE_DATE_EPOCH"] = "yesterday"
+	_, err = exportTimestamp(getenv)
+	assert.Error(t, err)
+}
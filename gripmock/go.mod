@@ -3,21 +3,30 @@ module github.com/ringerc/gripmock
 go 1.19
 
 require (
+	github.com/bufbuild/protocompile v0.6.0
 	github.com/go-chi/chi v4.1.2+incompatible
+	github.com/go-logr/logr v1.2.4
+	github.com/go-logr/stdr v1.2.2
+	github.com/lithammer/dedent v1.1.0
 	github.com/lithammer/fuzzysearch v1.1.1
-	github.com/stretchr/testify v1.8.2
+	github.com/stretchr/testify v1.8.4
+	github.com/tetratelabs/wazero v1.1.0
+	go.starlark.net v0.0.0-20230302034142-4b1e35fe2254
+	google.golang.org/genproto v0.0.0-20230306155012-7f2fa6fef1f4
+	google.golang.org/grpc v1.55.0
+	google.golang.org/protobuf v1.31.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/go-logr/logr v1.2.4 // indirect
-	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/kr/pretty v0.3.0 // indirect
-	github.com/lithammer/dedent v1.1.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.9.0 // indirect
-	golang.org/x/exp v0.0.0-20230425010034-47ecfdc1ba53 // indirect
-	golang.org/x/text v0.3.8 // indirect
+	golang.org/x/net v0.8.0 // indirect
+	golang.org/x/sync v0.3.0 // indirect
+	golang.org/x/sys v0.6.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
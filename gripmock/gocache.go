@@ -0,0 +1,92 @@
+package main
+
+/*
+ * Go build and module caches for building the server.
+ *
+ * Building the generated server compiles grpc-go, protobuf and the rest of
+ * its dependencies, after go mod tidy downloads any that are missing. Go
+ * caches both, in GOCACHE and GOMODCACHE, but in a container these are
+ * thrown away with it unless they're on a volume, so every start downloads
+ * and compiles everything again. -go-build-cache and -go-mod-cache point the
+ * go commands gripmock runs at directories that outlive the container, such
+ * as a mounted volume, creating them if need be.
+ *
+ * The go commands are given their environment deliberately: gripmock's own,
+ * with GOCACHE and GOMODCACHE replaced where the flags are set. The cache
+ * locations don't change what's built, so they don't count towards the
+ * build hash, see cache.go.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Where the go commands building the server keep their caches; empty
+// leaves the environment's, or go's defaults
+type goCaches struct {
+	// GOCACHE, compiled packages
+	build string
+	// GOMODCACHE, downloaded modules
+	mod string
+}
+
+// Check the cache directories, creating any that don't exist, and make
+// them absolute since the go commands run in the output dir
+func newGoCaches(build string, mod string) (goCaches, error) {
+	caches := goCaches{}
+	for _, dir := range []struct {
+		flag string
+		path string
+		set  *string
+	}{
+		{"-go-build-cache", build, &caches.build},
+		{"-go-mod-cache", mod, &caches.mod},
+	} {
+		if dir.path == "" {
+			continue
+		}
+		abs, err := filepath.Abs(dir.path)
+		if err != nil {
+			return goCaches{}, err
+		}
+		if err := os.MkdirAll(abs, 0755); err != nil {
+			return goCaches{}, fmt.Errorf("%s: %w", dir.flag, err)
+		}
+		*dir.set = abs
+	}
+	return caches, nil
+}
+
+// The environment for the go commands, environ with the cache locations
+// replaced
+func (c goCaches) env(environ []string) []string {
+	set := map[string]string{}
+	if c.build != "" {
+		set["GOCACHE"] = c.build
+	}
+	if c.mod != "" {
+		set["GOMODCACHE"] = c.mod
+	}
+	env := []string{}
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if _, ok := set[name]; !ok {
+			env = append(env, kv)
+		}
+	}
+	for _, name := range []string{"GOCACHE", "GOMODCACHE"} {
+		if dir, ok := set[name]; ok {
+			env = append(env, name+"="+dir)
+		}
+	}
+	return env
+}
+
+// Whether an environment variable is a cache location, rather than
+// something that changes the build
+func isGoCacheEnv(kv string) bool {
+	return strings.HasPrefix(kv, "GOCACHE=") || strings.HasPrefix(kv, "GOMODCACHE=")
+}
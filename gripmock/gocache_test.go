@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoCaches(t *testing.T) {
+	dir := t.TempDir()
+	caches, err := newGoCaches(filepath.Join(dir, "build"), "")
+	require.NoError(t, err)
+	assert.Equal(t, goCaches{build: filepath.Join(dir, "build")}, caches)
+	info, err := os.Stat(caches.build)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir(), "created")
+
+	environ := []string{"HOME=/home/test", "GOCACHE=/tmp/old", "GOMODCACHE=/go/pkg/mod", "GOFLAGS=-mod=mod"}
+	assert.Equal(t, environ, goCaches{}.env(environ), "the environment's caches by default")
+	assert.Equal(t,
+		[]string{"HOME=/home/test", "GOMODCACHE=/go/pkg/mod", "GOFLAGS=-mod=mod", "GOCACHE=" + caches.build},
+		caches.env(environ))
+	assert.Equal(t,
+		[]string{"HOME=/home/test", "GOFLAGS=-mod=mod", "GOCACHE=/b", "GOMODCACHE=/m"},
+		goCaches{build: "/b", mod: "/m"}.env(environ))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), nil, 0644))
+	_, err = newGoCaches("", filepath.Join(dir, "file"))
+	assert.ErrorContains(t, err, "-go-mod-cache")
+}
@@ -0,0 +1,148 @@
+package main
+
+/*
+ * Bundled googleapis protos.
+ *
+ * Protos often import the well-known types (google/protobuf), HTTP
+ * annotations (google/api), rpc.Status and error details (google/rpc) or
+ * the common types such as Money and Date (google/type), which users would
+ * otherwise have to mount a googleapis checkout into -imports for. gripmock
+ * bundles them: the descriptors linked into gripmock from the Go packages
+ * the generated code uses are written to GOOGLEAPIS_SET_FILE in the output
+ * dir, and handed to protoc with --descriptor_set_in as well as the -I
+ * import dirs. protoc only looks there for imports it doesn't find on the
+ * import dirs, so protos on -imports take precedence.
+ *
+ * The bundle is the genproto version in gripmock's go.mod, which is also
+ * the version the generated server's go.mod resolves the Go packages to.
+ * -googleapis=false leaves it out.
+ */
+
+import (
+	"os"
+	"path/filepath"
+
+	"google.golang.org/genproto/googleapis/api"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/genproto/googleapis/type/calendarperiod"
+	"google.golang.org/genproto/googleapis/type/color"
+	"google.golang.org/genproto/googleapis/type/date"
+	"google.golang.org/genproto/googleapis/type/datetime"
+	"google.golang.org/genproto/googleapis/type/dayofweek"
+	"google.golang.org/genproto/googleapis/type/decimal"
+	"google.golang.org/genproto/googleapis/type/expr"
+	"google.golang.org/genproto/googleapis/type/fraction"
+	"google.golang.org/genproto/googleapis/type/interval"
+	"google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/genproto/googleapis/type/localized_text"
+	"google.golang.org/genproto/googleapis/type/money"
+	"google.golang.org/genproto/googleapis/type/month"
+	"google.golang.org/genproto/googleapis/type/phone_number"
+	"google.golang.org/genproto/googleapis/type/postaladdress"
+	"google.golang.org/genproto/googleapis/type/quaternion"
+	"google.golang.org/genproto/googleapis/type/timeofday"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/apipb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/sourcecontextpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/typepb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// bundled googleapis descriptor set, in the output dir
+const GOOGLEAPIS_SET_FILE = "gripmock-googleapis.pb"
+
+// the bundled files; the files they import come too
+var googleapisFiles = []protoreflect.FileDescriptor{
+	// google/protobuf
+	anypb.File_google_protobuf_any_proto,
+	apipb.File_google_protobuf_api_proto,
+	descriptorpb.File_google_protobuf_descriptor_proto,
+	durationpb.File_google_protobuf_duration_proto,
+	emptypb.File_google_protobuf_empty_proto,
+	fieldmaskpb.File_google_protobuf_field_mask_proto,
+	pluginpb.File_google_protobuf_compiler_plugin_proto,
+	sourcecontextpb.File_google_protobuf_source_context_proto,
+	structpb.File_google_protobuf_struct_proto,
+	timestamppb.File_google_protobuf_timestamp_proto,
+	typepb.File_google_protobuf_type_proto,
+	wrapperspb.File_google_protobuf_wrappers_proto,
+	// google/api
+	annotations.File_google_api_annotations_proto,
+	annotations.File_google_api_client_proto,
+	annotations.File_google_api_field_behavior_proto,
+	annotations.File_google_api_http_proto,
+	annotations.File_google_api_resource_proto,
+	annotations.File_google_api_routing_proto,
+	api.File_google_api_launch_stage_proto,
+	httpbody.File_google_api_httpbody_proto,
+	// google/rpc
+	code.File_google_rpc_code_proto,
+	errdetails.File_google_rpc_error_details_proto,
+	status.File_google_rpc_status_proto,
+	// google/type
+	calendarperiod.File_google_type_calendar_period_proto,
+	color.File_google_type_color_proto,
+	date.File_google_type_date_proto,
+	datetime.File_google_type_datetime_proto,
+	dayofweek.File_google_type_dayofweek_proto,
+	decimal.File_google_type_decimal_proto,
+	expr.File_google_type_expr_proto,
+	fraction.File_google_type_fraction_proto,
+	interval.File_google_type_interval_proto,
+	latlng.File_google_type_latlng_proto,
+	localized_text.File_google_type_localized_text_proto,
+	money.File_google_type_money_proto,
+	month.File_google_type_month_proto,
+	phone_number.File_google_type_phone_number_proto,
+	postaladdress.File_google_type_postal_address_proto,
+	quaternion.File_google_type_quaternion_proto,
+	timeofday.File_google_type_timeofday_proto,
+}
+
+// The bundled files as a descriptor set, each after the files it imports
+func googleapisSet() *descriptorpb.FileDescriptorSet {
+	set := &descriptorpb.FileDescriptorSet{}
+	added := map[string]bool{}
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if added[fd.Path()] {
+			return
+		}
+		added[fd.Path()] = true
+		for i := 0; i < fd.Imports().Len(); i++ {
+			add(fd.Imports().Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	for _, fd := range googleapisFiles {
+		add(fd)
+	}
+	return set
+}
+
+// Write the bundled descriptor set to the output dir, returning its path
+func writeGoogleapisSet(output string) (string, error) {
+	byt, err := proto.Marshal(googleapisSet())
+	if err != nil {
+		return "", err
+	}
+	out := filepath.Join(output, GOOGLEAPIS_SET_FILE)
+	if err := os.WriteFile(out, byt, 0644); err != nil {
+		return "", err
+	}
+	return out, nil
+}
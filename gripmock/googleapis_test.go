@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func Test_writeGoogleapisSet(t *testing.T) {
+	out, err := writeGoogleapisSet(t.TempDir())
+	require.NoError(t, err)
+	byt, err := os.ReadFile(out)
+	require.NoError(t, err)
+	set := &descriptorpb.FileDescriptorSet{}
+	require.NoError(t, proto.Unmarshal(byt, set))
+
+	names := map[string]int{}
+	for i, f := range set.GetFile() {
+		for _, dep := range f.GetDependency() {
+			assert.Contains(t, names, dep, "%s comes after its imports", f.GetName())
+		}
+		names[f.GetName()] = i
+	}
+	for _, name := range []string{
+		"google/protobuf/descriptor.proto",
+		"google/protobuf/timestamp.proto",
+		"google/api/annotations.proto",
+		"google/api/field_behavior.proto",
+		"google/rpc/status.proto",
+		"google/rpc/error_details.proto",
+		"google/type/money.proto",
+	} {
+		assert.Contains(t, names, name)
+	}
+
+	// everything the bundled files import is bundled
+	_, err = protodesc.NewFiles(set)
+	assert.NoError(t, err)
+}
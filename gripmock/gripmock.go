@@ -19,22 +19,31 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"os"
 	"io"
+	"net"
 	"os/exec"
 	"os/signal"
 	stdlog "log"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/bufbuild/protocompile/ast"
+	"github.com/bufbuild/protocompile/parser"
+	"github.com/bufbuild/protocompile/reporter"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/stdr"
 
+	"github.com/ringerc/gripmock/demo"
 	"github.com/ringerc/gripmock/stub"
 )
 
@@ -53,6 +62,10 @@ const (
 	LOG_VERBOSE = 2
 	LOG_DEBUG = 3
 	LOG_TRACE = 4
+
+	// phases -pause-after can pause after
+	PAUSE_AFTER_GENERATE = "generate"
+	PAUSE_AFTER_BUILD = "build"
 )
 
 var log logr.Logger
@@ -60,107 +73,1051 @@ var log logr.Logger
 func main() {
 	outputPointer := flag.String("o", "generated", "directory to output generated files and binaries. Default is \"generated\"")
 	templateDir := flag.String("template-dir", "", "path to directory containing server.tmpl and its go.mod, uses compiled-in template by default")
-	grpcPort := flag.String("grpc-port", "4770", "Port of gRPC tcp server")
-	grpcBindAddr := flag.String("grpc-listen", "", "Adress the gRPC server will bind to. Default to localhost, set to 0.0.0.0 to use from another machine")
-	adminport := flag.String("admin-port", "4771", "Port of stub admin server")
-	adminBindAddr := flag.String("admin-listen", "", "Adress the admin server will bind to. Default to localhost, set to 0.0.0.0 to use from another machine")
-	stubPath := flag.String("stub", "", "Path where the stub files are (Optional)")
+	grpcPort := flag.String("grpc-port", "4770", "Port of gRPC tcp server, 0 for a free one, see -ports-file")
+	grpcBindAddr := flag.String("grpc-listen", "", "Comma separated hosts or IPv4 or IPv6 addresses the gRPC server will bind to, e.g. \"127.0.0.1,::1\". Default to every interface, dual-stack")
+	adminport := flag.String("admin-port", "4771", "Port of stub admin server, 0 for a free one")
+	adminBindAddr := flag.String("admin-listen", "", "Comma separated hosts or IPv4 or IPv6 addresses the admin server will bind to, e.g. \"127.0.0.1,::1\". Default to every interface, dual-stack")
+	portsFile := flag.String("ports-file", "", "write the ports gripmock serves on, as JSON, to this file each time the gRPC server starts, for a port of 0 to be found")
+	printPorts := flag.Bool("print-ports", false, "print the ports gripmock serves on, as a line of JSON on stdout, each time the gRPC server starts")
+	readyFile := flag.String("ready-file", "", "create this file, holding gripmock's pid, while the gRPC server is serving, removing it when it drains")
+	printReady := flag.Bool("print-ready", false, "print READY on a line of its own on stdout each time the gRPC server starts serving")
+	configFile := flag.String("config", "", "JSON file of named profiles, each the protos and flags of a mock, to run all at once, see -profile (Optional)")
+	profileNames := flag.String("profile", "", "comma separated profiles of the -config file to run, default all of them")
+	adminGrpcPort := flag.String("admin-grpc-port", "", "Port to serve the gRPC stub admin service on, alongside the HTTP admin API. Disabled if empty")
+	stubPath := flag.String("stub", "", "Stub files to load: comma separated directories, files, glob patterns, where ** matches any number of directories, http(s) URLs, or - for stdin (Optional)")
+	stubOverlap := flag.String("stub-overlap", stub.OVERLAP_OFF, "check stubs added via the admin API for overlap with existing stubs that make them unreachable: off, warn or reject")
+	stubValidation := flag.String("stub-validation", stub.VALIDATION_REJECT, "check stub files and stubs added via the admin API against the stub schema, reporting unknown keys and wrong types: off, warn or reject")
+	stubTemplates := flag.Bool("stub-templates", false, "render stub files as Go templates, with ${ENV_VAR} substitution, when loading them")
+	persistStubs := flag.Bool("persist-stubs", false, "save stubs added on the admin API to runtime-stubs.json in the -stub directory whenever they change, so they're loaded again on restart")
+	tenantKey := flag.String("tenant-key", "", "gRPC metadata key (e.g. x-tenant-id) whose value selects the stub namespace for each call (Optional)")
+	wireLog := flag.Bool("wire-log", false, "log each call's decoded request and response messages, masking -wire-log-redact fields")
+	wireLogRedact := flag.String("wire-log-redact", "password,token,secret,authorization", "comma separated message fields and metadata keys whose values -wire-log masks, at any depth")
+	accessLog := flag.String("access-log", "", "file to write a JSON line to for each finished call, with its method, client address, status, duration and matched stubs (Optional)")
+	accessLogMaxSize := flag.Int("access-log-max-size", 100, "rotate the -access-log file when it would grow past this many megabytes, 0 for no limit")
+	accessLogRotate := flag.Duration("access-log-rotate", 0, "rotate the -access-log file after it's been open this long, e.g. \"24h\", 0 for never")
+	accessLogBackups := flag.Int("access-log-backups", 5, "rotated -access-log files to keep, 0 to keep them all")
+	logSample := flag.Int("log-sample", 0, "log at most this many lines of each kind, e.g. no stub matched, about each method per -log-sample-interval, then a summary of how many were dropped, 0 to log them all")
+	logSampleInterval := flag.Duration("log-sample-interval", stub.DEFAULT_LOG_SAMPLE_INTERVAL, "interval -log-sample counts log lines over, e.g. \"30s\"")
+	correlationKey := flag.String("correlation-key", "", "gRPC metadata key (e.g. x-request-id) whose value is recorded as each call's correlation ID in the journal and access and wire logs, and prefixed to log lines about the call (Optional)")
+	importMaxSize := flag.Int("import-max-size", stub.DEFAULT_IMPORT_MAX_SIZE>>20, "largest a tar.gz or zip archive of stubs, from /import, a -stub URL or stdin, may decompress to, in megabytes")
+	lazyStubs := flag.Bool("lazy-stubs", false, "only scan the -stub files at startup, loading each service's stubs when it's first called, to save memory with large fixture sets")
+	pprof := flag.Bool("pprof", false, "serve pprof profiles of gripmock on the admin port under /debug/pprof/")
+	sessionKey := flag.String("session-key", "", "gRPC metadata key and admin HTTP header (e.g. x-gripmock-session) whose value isolates the stubs and calls of each test session (Optional)")
+	wasmDir := flag.String("wasm-dir", "", "directory of .wasm modules stubs can use as custom matchers and transformers (Optional)")
 	imports := flag.String("imports", "", "comma separated imports path to search for dependency .proto files")
+	codecs := flag.String("codecs", "", "comma separated extra gRPC codecs for the server to accept, as content-subtype=kind where kind is json or proto, e.g. \"json,x-protobuf=proto\" (Optional)")
+	goBuildCache := flag.String("go-build-cache", "", "directory for the Go build cache (GOCACHE) used to build the server, e.g. on a volume so it outlives the container. Default the environment's")
+	goModCache := flag.String("go-mod-cache", "", "directory for the Go module cache (GOMODCACHE) used to build the server. Default the environment's")
+	goTrimpath := flag.Bool("go-trimpath", false, "build the server with go build -trimpath, leaving the build paths out of the binary")
+	goStrip := flag.Bool("go-strip", false, "leave the symbol table and debug info out of the server binary, to make it smaller")
+	goGcflags := flag.String("go-gcflags", "", "go build -gcflags for building the server, e.g. \"all=-l\" (Optional)")
+	goCompiler := flag.String("go-compiler", GO_COMPILER_GC, "compiler to build the server with: gc or gccgo")
+	tracing := flag.Bool("tracing", true, "build OpenTelemetry tracing into the server; false leaves it and its dependencies out, for a smaller server that builds faster")
+	vendorDeps := flag.Bool("vendor", false, "vendor the server's dependencies into the generated module with go mod vendor, and build from them, so an exported module builds offline")
+	vendorFrom := flag.String("vendor-from", "", "build the server offline with the go.mod, go.sum and vendor dir of a module generated with -vendor, instead of resolving dependencies (Optional)")
 	goReplaces := flag.String("go-replace", "", "comma separated list of \"replace\" directives for finding local paths to pre-generated go protocol files")
 	logVerbosity := flag.Int("verbosity", LOG_INFO, "log verbosity [0..4], default 1")
+	drainPeriod := flag.Duration("drain-period", 0, "on shutdown, report NOT_SERVING gRPC health status for this long before the gRPC server stops, e.g. \"5s\"")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate (chain) file for the gRPC server to serve TLS with, with -tls-key (Optional)")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for -tls-cert (Optional)")
+	adminTLSCert := flag.String("admin-tls-cert", "", "PEM certificate (chain) file for the admin APIs to serve HTTPS and gRPC TLS with, with -admin-tls-key (Optional)")
+	adminTLSKey := flag.String("admin-tls-key", "", "PEM private key file for -admin-tls-cert (Optional)")
+	adminTLS := flag.Bool("admin-tls", false, "serve the admin APIs with TLS, with -tls-cert and -tls-key unless -admin-tls-cert and -admin-tls-key are given")
+	xds := flag.Bool("xds", false, "build the gRPC server with xDS serving and credentials, for proxyless service mesh clients, see README")
+	xdsBootstrap := flag.String("xds-bootstrap", "", "xDS bootstrap file for -xds, default the GRPC_XDS_BOOTSTRAP or GRPC_XDS_BOOTSTRAP_CONFIG environment variable (Optional)")
+	listenSpecs := stringList{}
+	flag.Var(&listenSpecs, "listen", "extra gRPC listener serving some services, as <address>=<service>[,<service>...], e.g. \":4780=Greeter\"; the main listener serves the rest; may be repeated (Optional)")
+	keepalive := keepaliveConfig{}
+	flag.DurationVar(&keepalive.time, "keepalive-time", 0, "gRPC server pings a client after its connection is idle this long, default 2h")
+	flag.DurationVar(&keepalive.timeout, "keepalive-timeout", 0, "gRPC server closes a connection if a ping isn't acked within this long, default 20s")
+	flag.DurationVar(&keepalive.maxConnectionIdle, "max-connection-idle", 0, "gRPC server sends GOAWAY on connections with no calls for this long, default unlimited")
+	flag.DurationVar(&keepalive.maxConnectionAge, "max-connection-age", 0, "gRPC server sends GOAWAY on connections this old, default unlimited")
+	flag.DurationVar(&keepalive.maxConnectionAgeGrace, "max-connection-age-grace", 0, "time allowed for calls to finish after -max-connection-age before the connection is closed, default unlimited")
+	flag.DurationVar(&keepalive.minTime, "keepalive-min-time", 0, "gRPC server closes connections with too_many_pings if a client pings more often than this, default 5m")
+	flag.BoolVar(&keepalive.permitWithoutStream, "keepalive-permit-without-stream", false, "allow client pings when there are no active calls, instead of closing the connection with too_many_pings")
+	limits := limitsConfig{}
+	flag.IntVar(&limits.maxRecvMsgSize, "max-recv-msg-size", 0, "largest message in bytes the gRPC server receives, default 4MiB")
+	flag.IntVar(&limits.maxSendMsgSize, "max-send-msg-size", 0, "largest message in bytes the gRPC server sends, default 2GiB")
+	flag.UintVar(&limits.maxConcurrentStreams, "max-concurrent-streams", 0, "most calls in flight on a gRPC connection, default unlimited")
+	flag.IntVar(&limits.maxConnections, "max-connections", 0, "most connections each gRPC listener accepts at once, closing any more, default unlimited")
+	exportFormat := flag.String("format", EXPORT_FORMAT_TAR_GZ, "archive format for \"gripmock export\": tar.gz or tar")
+	exportFile := flag.String("export-file", "", "archive path for \"gripmock export\", default gripmock-export.<format>")
+	scaffoldDir := flag.String("scaffold-dir", "stubs", "dir \"gripmock scaffold-stubs\" writes stub files to")
+	scaffoldData := flag.String("scaffold-data", SCAFFOLD_DATA_DEFAULTS, "values in scaffolded stubs: defaults, the zero values, or fake, made up from each field's type and name")
+	scaffoldOverwrite := flag.Bool("scaffold-overwrite", false, "replace stub files \"gripmock scaffold-stubs\" finds already exist, instead of leaving them alone")
+	descriptors := flag.String("descriptor", "", "comma separated FileDescriptorSet files (.pb, .protoset) to serve instead of .proto sources; proto arguments then name files within them (Optional)")
+	fromReflection := flag.String("from-reflection", "", "host:port of a running server to fetch the services to mock from, with gRPC server reflection (Optional)")
+	fromReflectionTLS := flag.Bool("from-reflection-tls", false, "connect to the -from-reflection server with TLS")
+	fromReflectionCA := flag.String("from-reflection-ca", "", "PEM file of the CAs to verify the -from-reflection server's certificate with, instead of the system's; implies -from-reflection-tls (Optional)")
+	fromReflectionInsecure := flag.Bool("from-reflection-insecure", false, "connect to the -from-reflection server with TLS without verifying its certificate")
+	googleapis := flag.Bool("googleapis", true, "resolve imports of the well-known types and the google/api, google/rpc and google/type protos that aren't on -imports from a bundled copy")
+	serveImports := flag.Bool("serve-imports", false, "also serve the services of the protos the served protos import, directly or indirectly")
+	onlyServices := flag.String("only-services", "", "comma separated services to serve, e.g. \"Greeter,helloworld.Farewell\"; the other services of the protos aren't (Optional)")
+	excludeMethods := flag.String("exclude-methods", "", "comma separated methods not to serve, as <service>/<method>, e.g. \"Greeter/SayGoodbye\" (Optional)")
+	protocArgs := stringList{}
+	protocBinary := flag.String("protoc", PROTOC_EMBEDDED, "protoc to compile the protos with: embedded, built into gripmock, or the name or path of a protoc binary")
+	flag.Var(&protocArgs, "protoc-arg", "extra argument for protoc when generating the server, e.g. \"--go_opt=Mfoo.proto=example.com/foo\"; may be repeated (Optional)")
+	moduleName := flag.String("module", GENERATED_MODULE_NAME, "module path of the generated server, for vendoring it where its packages must be importable")
+	serverDir := flag.String("server-dir", DEFAULT_SERVER_DIR, "dir of the generated server's main package, within its module")
+	protoDir := flag.String("proto-dir", "", "dir to generate the protobuf packages in, within the generated module, e.g. \"internal/pb\"; default the module root (Optional)")
+	serverFiles := flag.String("server-files", "", "comma separated Go files of package main to add to the generated server, e.g. to register interceptors, see README (Optional)")
+	watch := flag.Bool("watch", false, "regenerate, rebuild and restart the gRPC server when the protos, what they import or the template dir change")
+	dynamic := flag.Bool("dynamic", false, "serve the services from their descriptors at runtime, without generating and building a server; needs no Go toolchain, but doesn't support every stub option, see README")
+	buildCache := flag.Bool("build-cache", true, "reuse the server in the output dir when its protos, options and tools haven't changed since it was built, instead of generating and building it again")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "most protos to rewrite or protoc runs to generate Go packages with at once; 1 to run them in turn")
+	pauseAfter := flag.String("pause-after", "", "pause after a phase, \"generate\" or \"build\", until POST /state/resume to the admin server (Optional)")
+
+	flag.Usage = func() {
+		printUsage(flag.CommandLine, flag.CommandLine.Output())
+	}
 
 	// for backwards compatibility
 	if len(os.Args) >= 2 && os.Args[1] == "gripmock" {
 		os.Args = append(os.Args[:1], os.Args[2:]...)
 	}
 
+	// "gripmock help" and "gripmock completion" describe the flags, and
+	// take none themselves
+	if len(os.Args) >= 2 && os.Args[1] == "help" {
+		os.Exit(runHelp(os.Args[2:], flag.CommandLine, os.Stdout, os.Stderr))
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "completion" {
+		os.Exit(runCompletion(os.Args[2:], flag.CommandLine, os.Stdout, os.Stderr))
+	}
+	// "gripmock stub" talks to a running gripmock's admin API
+	if len(os.Args) >= 2 && os.Args[1] == "stub" {
+		os.Exit(runStubCommand(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
+	// "gripmock wiremock" converts WireMock mappings to stubs, and needs
+	// none of the other flags
+	if len(os.Args) >= 2 && os.Args[1] == "wiremock" {
+		os.Exit(runWireMock(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	// "gripmock demo" serves a bundled example service, stubs and
+	// walkthrough instead of user-supplied protos
+	demoMode := false
+	if len(os.Args) >= 2 && os.Args[1] == "demo" {
+		demoMode = true
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	// "gripmock export" writes the generated server module to an archive
+	// instead of building and running it
+	exportMode := false
+	if len(os.Args) >= 2 && os.Args[1] == "export" {
+		exportMode = true
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	// "gripmock generate" writes a standalone server module to the output
+	// dir, to build and run without gripmock
+	generateMode := false
+	if len(os.Args) >= 2 && os.Args[1] == "generate" {
+		generateMode = true
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	// "gripmock check" checks the protos and stub files, reporting problems
+	// without serving
+	checkMode := false
+	if len(os.Args) >= 2 && os.Args[1] == "check" {
+		checkMode = true
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	// "gripmock scaffold-stubs" writes an example stub file for each method
+	scaffoldMode := false
+	if len(os.Args) >= 2 && os.Args[1] == "scaffold-stubs" {
+		scaffoldMode = true
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	flag.Parse()
 
+	// the container image entrypoint puts flags before "demo", "export",
+	// "generate", "check" or "scaffold-stubs"
+	if !demoMode && !exportMode && !generateMode && !checkMode && !scaffoldMode {
+		switch flag.Arg(0) {
+		case "demo":
+			demoMode = true
+			flag.CommandLine.Parse(flag.Args()[1:])
+		case "export":
+			exportMode = true
+			flag.CommandLine.Parse(flag.Args()[1:])
+		case "generate":
+			generateMode = true
+			flag.CommandLine.Parse(flag.Args()[1:])
+		case "check":
+			checkMode = true
+			flag.CommandLine.Parse(flag.Args()[1:])
+		case "scaffold-stubs":
+			scaffoldMode = true
+			flag.CommandLine.Parse(flag.Args()[1:])
+		}
+	}
+
 	initLogging(*logVerbosity)
 
 	log.V(LOG_VERBOSE).Info("Starting GripMock")
 
+	// run a gripmock for each profile instead
+	if *configFile != "" {
+		if demoMode || exportMode || generateMode || checkMode || scaffoldMode || len(flag.Args()) > 0 {
+			log.V(LOG_ERROR).Info("-config runs the protos of its profiles, and doesn't take proto files or a command", "args", flag.Args())
+			os.Exit(EXITCODE_ARGUMENTS_ERROR)
+		}
+		profiles, err := loadProfiles(*configFile, *profileNames, flag.CommandLine)
+		if err != nil {
+			log.V(LOG_ERROR).Info("invalid -config", "error", err.Error())
+			os.Exit(EXITCODE_ARGUMENTS_ERROR)
+		}
+		os.Exit(runProfiles(profiles, filepath.Dir(*configFile)))
+	}
+	if *profileNames != "" {
+		log.V(LOG_ERROR).Info("-profile needs a -config file of profiles")
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+
+	config := resolveConfig(flag.CommandLine, *templateDir, *imports, os.Environ())
+	log.V(LOG_INFO).Info("effective configuration", "config", config)
+
+	switch *stubOverlap {
+	case stub.OVERLAP_OFF, stub.OVERLAP_WARN, stub.OVERLAP_REJECT:
+	default:
+		log.V(LOG_ERROR).Info("-stub-overlap must be one of off, warn, reject", "value", *stubOverlap)
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+
+	switch *stubValidation {
+	case stub.VALIDATION_OFF, stub.VALIDATION_WARN, stub.VALIDATION_REJECT:
+	default:
+		log.V(LOG_ERROR).Info("-stub-validation must be one of off, warn, reject", "value", *stubValidation)
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+
+	switch *pauseAfter {
+	case "", PAUSE_AFTER_GENERATE, PAUSE_AFTER_BUILD:
+	default:
+		log.V(LOG_ERROR).Info("-pause-after must be one of generate, build", "value", *pauseAfter)
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+
+	if *dynamic && *pauseAfter != "" {
+		log.V(LOG_ERROR).Info("-pause-after doesn't apply with -dynamic, which neither generates nor builds a server")
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+
+	codecSpecs, err := parseCodecs(*codecs)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid -codecs", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	onlyServiceNames, err := parseRPCList(*onlyServices, false)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid -only-services", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	excludedMethods, err := parseRPCList(*excludeMethods, true)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid -exclude-methods", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	serverGoFiles, err := parseServerFiles(*serverFiles)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid -server-files", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	tlsConf, err := newTLSConfig(*tlsCert, *tlsKey)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid TLS options", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	adminTLSConf, err := newTLSConfig(*adminTLSCert, *adminTLSKey)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid admin TLS options", "error", strings.ReplaceAll(err.Error(), "-tls-", "-admin-tls-"))
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	if *adminTLS && !adminTLSConf.enabled() {
+		if !tlsConf.enabled() {
+			log.V(LOG_ERROR).Info("-admin-tls needs -admin-tls-cert and -admin-tls-key, or -tls-cert and -tls-key to share")
+			os.Exit(EXITCODE_ARGUMENTS_ERROR)
+		}
+		adminTLSConf = tlsConf
+	}
+	if err := limits.check(); err != nil {
+		log.V(LOG_ERROR).Info("invalid gRPC server limits", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	listeners, err := parseListeners(listenSpecs)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid -listen", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	grpcHosts, err := parseBindHosts(*grpcBindAddr)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid -grpc-listen", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	adminHosts, err := parseBindHosts(*adminBindAddr)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid -admin-listen", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	layout, err := newGeneratedLayout(*moduleName, *serverDir, *protoDir)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid generated module layout", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	caches, err := newGoCaches(*goBuildCache, *goModCache)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid Go cache directory", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	vendor, err := newVendorConfig(*vendorDeps, *vendorFrom, *goReplaces)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid vendoring options", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+
+	goBuild, err := newGoBuildOptions(*goTrimpath, *goStrip, *goGcflags, *goCompiler, *tracing)
+	if err != nil {
+		log.V(LOG_ERROR).Info("invalid build options", "error", err.Error())
+		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+
 	output := *outputPointer
 	if output == "" {
 		log.V(LOG_ERROR).Info("output dir may not be empty")
 		os.Exit(EXITCODE_ARGUMENTS_ERROR)
 	}
+	// check and scaffold-stubs work in a temp dir, leaving -o alone
+	if checkMode || scaffoldMode {
+		pattern := "gripmock-check"
+		if scaffoldMode {
+			pattern = "gripmock-scaffold"
+		}
+		dir, err := os.MkdirTemp("", pattern)
+		if err != nil {
+			log.Error(err, "creating temp dir")
+			os.Exit(EXITCODE_OTHER_ERROR)
+		}
+		output = dir
+	}
 	if _, err := os.Stat(output); os.IsNotExist(err) {
-		if err := os.Mkdir(output, os.ModePerm); err != nil {
+		if err := os.MkdirAll(output, os.ModePerm); err != nil {
 			log.Error(err, "creating output directory", "dir", output)
 			os.Exit(EXITCODE_OTHER_ERROR)
 		}
 	}
 
-	// run admin stub server
-	stub.RunStubServer(stub.Options{
-		StubPath: *stubPath,
-		Port:     *adminport,
-		BindAddr: *adminBindAddr,
-	})
-
 	// parse proto files
 	protoPaths := flag.Args()
+	var descriptorSets []string
+	if *descriptors != "" {
+		descriptorSets = strings.Split(*descriptors, ",")
+	}
+	protoPaths, bufImages, err := buildBufInputs(protoPaths, output)
+	if err != nil {
+		log.Error(err, "building buf inputs")
+		os.Exit(EXITCODE_BUILD_ERROR)
+	}
+	descriptorSets = append(descriptorSets, bufImages...)
+	if *fromReflection != "" {
+		set, err := fetchReflectionSet(*fromReflection, output, newReflectionTLS(*fromReflectionTLS, *fromReflectionCA, *fromReflectionInsecure))
+		if err != nil {
+			log.Error(err, "fetching services to mock", "target", *fromReflection)
+			os.Exit(EXITCODE_BUILD_ERROR)
+		}
+		descriptorSets = append(descriptorSets, set)
+	}
 
-	if len(protoPaths) == 0 {
-		log.V(LOG_ERROR).Info("Need at least one proto file")
-		os.Exit(EXITCODE_ARGUMENTS_ERROR)
+	var demoPage []byte
+	if demoMode {
+		if len(protoPaths) != 0 {
+			log.V(LOG_ERROR).Info("gripmock demo serves its own proto, and does not accept proto files", "protos", protoPaths)
+			os.Exit(EXITCODE_ARGUMENTS_ERROR)
+		}
+		demoProto, demoStubs, err := extractDemo()
+		if err != nil {
+			log.Error(err, "extracting demo files")
+			exit(EXITCODE_OTHER_ERROR)
+		}
+		protoPaths = []string{demoProto}
+		config.Protos = protoPaths
+		demoDir := filepath.Dir(demoProto)
+		if *imports == "" {
+			*imports = demoDir
+		} else {
+			*imports += "," + demoDir
+		}
+		config.ImportDirs = append(config.ImportDirs, demoDir)
+		if *stubPath == "" {
+			*stubPath = demoStubs
+		}
+		demoPage = demo.Walkthrough
+	}
+
+	if exportMode || generateMode || checkMode || scaffoldMode {
+		param := exportParam{
+			protoc: protocParam{
+				protoPath:      protoPaths,
+				adminPort:      *adminport,
+				grpcHosts:      grpcHosts,
+				grpcPort:       *grpcPort,
+				output:         output,
+				imports:        strings.Split(*imports, ","),
+				templateDir:    *templateDir,
+				codecs:         codecSpecs,
+				descriptors:    descriptorSets,
+				serveImports:   *serveImports,
+				googleapis:     *googleapis,
+				onlyServices:   onlyServiceNames,
+				excludeMethods: excludedMethods,
+				protoc:         *protocBinary,
+				protocArgs:     protocArgs,
+				layout:         layout,
+				serverFiles:    serverGoFiles,
+				xds:            *xds,
+				goCaches:       caches,
+				vendor:         vendor,
+				goBuild:        goBuild,
+				jobs:           *jobs,
+			},
+			goReplaces: *goReplaces,
+			stubPath:   *stubPath,
+			format:     *exportFormat,
+			file:       *exportFile,
+		}
+		switch {
+		case checkMode:
+			exit(runCheck(param, *dynamic, *stubTemplates, os.Stdout))
+		case scaffoldMode:
+			exit(runScaffold(param.protoc, scaffoldOptions{*scaffoldDir, *scaffoldData, *scaffoldOverwrite}, os.Stdout))
+		case generateMode:
+			runGenerate(param)
+		default:
+			runExport(param)
+		}
+		return
+	}
+
+	// the exported server is run elsewhere, with its own bootstrap
+	if err := setupXDS(*xds, *xdsBootstrap); err != nil {
+		log.V(LOG_ERROR).Info("invalid xDS options", "error", err.Error())
+		exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+
+	// gRPC server actions requested on the admin server
+	controls := make(chan serverControl)
+	control := func(action string) error {
+		done := make(chan error, 1)
+		controls <- serverControl{action, done}
+		return <-done
+	}
+
+	// the gRPC server's ports are reported as it starts listening
+	ports := &portReporter{file: *portsFile}
+	if *printPorts {
+		ports.stdout = os.Stdout
+	}
+	var adminPorts stub.ServerPorts
+	onListening := func(addresses []string) {
+		report, err := newPortReport(addresses, listeners, adminPorts)
+		if err == nil {
+			err = ports.write(report)
+		}
+		if err != nil {
+			log.Error(err, "reporting ports", "file", *portsFile)
+		}
+	}
+	if !ports.enabled() {
+		onListening = nil
+	}
+	// the demo prints the calls to try once the gRPC server's port is known
+	if demoMode {
+		var printed sync.Once
+		reportPorts := onListening
+		onListening = func(addresses []string) {
+			if reportPorts != nil {
+				reportPorts(addresses)
+			}
+			printed.Do(func() {
+				printDemoCalls(addresses[0], grpcHosts[0], tlsConf.enabled())
+			})
+		}
+	}
+	// and readiness once it's serving
+	ready := &readiness{file: *readyFile, notifySocket: os.Getenv("NOTIFY_SOCKET")}
+	if *printReady {
+		ready.stdout = os.Stdout
+	}
+	if err := ready.reset(); err != nil {
+		log.Error(err, "removing the ready file of an earlier run", "file", *readyFile)
+	}
+
+	// run admin stub server
+	adminPorts = stub.RunStubServer(stub.Options{
+		StubPath:          *stubPath,
+		Port:              *adminport,
+		BindAddrs:         adminHosts,
+		TenantKey:         *tenantKey,
+		SessionKey:        *sessionKey,
+		CorrelationKey:    *correlationKey,
+		Config:            config,
+		OverlapCheck:      *stubOverlap,
+		StubValidation:    *stubValidation,
+		StubTemplates:     *stubTemplates,
+		PersistStubs:      *persistStubs,
+		DemoPage:          demoPage,
+		WasmDir:           *wasmDir,
+		GrpcPort:          *adminGrpcPort,
+		TLSCert:           adminTLSConf.cert,
+		TLSKey:            adminTLSConf.key,
+		WireLog:           *wireLog,
+		Redact:            strings.Split(*wireLogRedact, ","),
+		AccessLog:         *accessLog,
+		AccessLogMaxSize:  int64(*accessLogMaxSize) * 1024 * 1024,
+		AccessLogRotate:   *accessLogRotate,
+		AccessLogBackups:  *accessLogBackups,
+		LogSample:         *logSample,
+		LogSampleInterval: *logSampleInterval,
+		ImportMaxSize:     int64(*importMaxSize) * 1024 * 1024,
+		Pprof:             *pprof,
+		LazyStubs:         *lazyStubs,
+		Control:           control,
+		GrpcTLS:           tlsConf.enabled(),
+		OnListening:       onListening,
+		OnServing: func(serving bool) {
+			if err := ready.serving(serving); err != nil {
+				log.Error(err, "signalling readiness", "serving", serving)
+			}
+		},
+	})
+	if demoMode {
+		adminHost := adminHosts[0]
+		if adminHost == "" || net.ParseIP(adminHost).IsUnspecified() {
+			adminHost = "localhost"
+		}
+		scheme := "http://"
+		if adminTLSConf.enabled() {
+			scheme = "https://"
+		}
+		log.V(LOG_INFO).Info("serving demo, open the walkthrough in a browser",
+			"walkthrough", scheme+net.JoinHostPort(adminHost, adminPorts.Admin)+"/demo",
+			"proto", protoPaths[0], "stubs", *stubPath)
+	}
+
+	if len(protoPaths) == 0 && len(descriptorSets) == 0 {
+		log.V(LOG_ERROR).Info("Need at least one proto file or -descriptor")
+		exit(EXITCODE_ARGUMENTS_ERROR)
 	}
 
 	importDirs := strings.Split(*imports, ",")
 
 	// generate pb.go and grpc server based on proto
-	if err := generateProtoc(protocParam{
-		protoPath:   protoPaths,
-		adminPort:   *adminport,
-		grpcAddress: *grpcBindAddr,
-		grpcPort:    *grpcPort,
-		output:      output,
-		imports:     importDirs,
+	protoc := protocParam{
+		protoPath:      protoPaths,
+		adminPort:      *adminport,
+		grpcHosts:      grpcHosts,
+		grpcPort:       *grpcPort,
+		output:         output,
+		imports:        importDirs,
 		templateDir:    *templateDir,
-	}); err != nil {
-		log.Error(err, "when generating protocol and server")
-		os.Exit(EXITCODE_BUILD_ERROR)
+		codecs:         codecSpecs,
+		descriptors:    descriptorSets,
+		serveImports:   *serveImports,
+		googleapis:     *googleapis,
+		onlyServices:   onlyServiceNames,
+		excludeMethods: excludedMethods,
+		protoc:         *protocBinary,
+		protocArgs:     protocArgs,
+		layout:         layout,
+		serverFiles:    serverGoFiles,
+		adminTLS:       adminTLSConf.enabled(),
+		listeners:      listeners,
+		xds:            *xds,
+		correlationKey: strings.ToLower(*correlationKey),
+		goCaches:       caches,
+		vendor:         vendor,
+		goBuild:        goBuild,
+		incremental:    *buildCache,
+		jobs:           *jobs,
 	}
-
 	var modReplacements []string
 	if *goReplaces != "" {
 		modReplacements = strings.Split(*goReplaces, ",")
 	}
+	if *watch {
+		// reloads wait until the server is running
+		go watchInputs(func() (string, error) {
+			return buildHash(protoc, modReplacements, os.Environ())
+		}, WATCH_INTERVAL, func() error {
+			return control(stub.SERVER_RELOAD)
+		}, nil)
+	}
+	if *dynamic {
+		if *xds {
+			log.V(LOG_ERROR).Info("-dynamic can't serve xDS, it needs the generated server")
+			exit(EXITCODE_ARGUMENTS_ERROR)
+		}
+		if len(codecSpecs) > 0 {
+			log.V(LOG_INFO).Info("WARNING: -dynamic ignores -codecs", "codecs", codecSpecs)
+		}
+		if len(protocArgs) > 0 {
+			log.V(LOG_INFO).Info("WARNING: -dynamic ignores -protoc-arg", "args", []string(protocArgs))
+		}
+		if len(serverGoFiles) > 0 {
+			log.V(LOG_INFO).Info("WARNING: -dynamic ignores -server-files, there's no generated server to add them to", "files", serverGoFiles)
+		}
+		if args := goBuild.buildArgs(); len(args) > 0 {
+			log.V(LOG_INFO).Info("WARNING: -dynamic ignores the server build options, there's no server to build", "args", args)
+		}
+		// the server looks up stubs on the admin port gripmock got
+		dynamicParam := protoc
+		dynamicParam.adminPort = adminPorts.Admin
+		exit(runDynamic(dynamicParam, keepalive, limits, tlsConf, *drainPeriod, controls))
+	}
 
-	// Build the server binary
-	if err := buildServer(output, modReplacements); err != nil {
-		log.Error(err, "building gRPC server")
-		os.Exit(EXITCODE_BUILD_ERROR)
+	// hash the inputs before generating, so they can't change unnoticed
+	// during the build
+	inputHash := func() string {
+		if !*buildCache || *pauseAfter != "" {
+			return ""
+		}
+		hash, err := buildHash(protoc, modReplacements, os.Environ())
+		if err != nil {
+			log.Error(err, "hashing build inputs, not caching the build")
+			return ""
+		}
+		return hash
+	}
+	hash := inputHash()
+	if hash != "" && cachedBuild(output, hash) {
+		log.V(LOG_INFO).Info("Inputs unchanged since the last build, reusing the gRPC server", "server", filepath.Join(output, SERVER_BINARY))
+	} else {
+		saveBuildHash(output, "")
+		if err := generateProtoc(protoc); err != nil {
+			log.Error(err, "when generating protocol and server")
+			exit(EXITCODE_BUILD_ERROR)
+		}
+		if *pauseAfter == PAUSE_AFTER_GENERATE {
+			pause(*pauseAfter, output, adminURL(adminPorts.Admin, adminTLSConf.enabled()))
+		}
+		stub.SetState(stub.STATE_BUILDING)
+
+		// Build the server binary
+		if err := buildServer(protoc, modReplacements); err != nil {
+			log.Error(err, "building gRPC server")
+			exit(EXITCODE_BUILD_ERROR)
+		}
+		if *pauseAfter == PAUSE_AFTER_BUILD {
+			pause(*pauseAfter, output, adminURL(adminPorts.Admin, adminTLSConf.enabled()))
+		}
+		saveBuildHash(output, hash)
 	}
+	stub.SetState(stub.STATE_STARTING)
 
 	// and run
-	run, runerrchan := runGrpcServer(output)
+	serverArgs := append([]string{"-drain-period=" + drainPeriod.String()}, keepalive.serverArgs()...)
+	serverArgs = append(serverArgs, limits.serverArgs()...)
+	serverArgs = append(serverArgs, tlsConf.serverArgs()...)
+	serverArgs = append(serverArgs, listenerArgs(listeners)...)
+	if adminTLSConf.enabled() {
+		serverArgs = append(serverArgs, "-admin-tls")
+	}
+	// the server was built with the port as given, so the cache holds for
+	// a port of 0
+	if adminPorts.Admin != *adminport {
+		serverArgs = append(serverArgs, "-admin-port="+adminPorts.Admin)
+	}
+	if protoc.correlationKey != "" {
+		serverArgs = append(serverArgs, "-correlation-key="+protoc.correlationKey)
+	}
+	run, runerrchan := runGrpcServer(output, serverArgs)
 
-	var sigchan = make(chan os.Signal)
+	var sigchan = make(chan os.Signal, 1)
 	signal.Notify(sigchan, syscall.SIGTERM, syscall.SIGINT)
+	stopping := false
+	// Actions from the admin server run one at a time; pending answers the
+	// one running. A reload rebuilds the server while the old one keeps
+	// serving, then swaps it like a restart: the old server is stopped and
+	// the new one started once it has exited. A stop leaves the server
+	// stopped, halted, until it's started again.
+	var pending chan error
+	rebuilt := make(chan error)
+	running, paused, swapping, halting := true, false, false, false
+	unpause := func() {
+		if paused {
+			continueProcess(run.Process)
+			paused = false
+			stub.ServerPaused(false)
+		}
+	}
 	for {
 		select {
+		case ctl := <-controls:
+			if pending != nil {
+				ctl.done <- stub.ErrServerBusy
+				continue
+			}
+			if err := checkControl(ctl.action, stopping, running, paused); err != nil {
+				ctl.done <- err
+				continue
+			}
+			switch ctl.action {
+			case stub.SERVER_RELOAD:
+				log.V(LOG_INFO).Info("Reloading protos and rebuilding gRPC server")
+				pending = ctl.done
+				go func() {
+					// always rebuilt, as a reload is asked for when
+					// something changed
+					hash := inputHash()
+					saveBuildHash(output, "")
+					if err := generateProtoc(protoc); err != nil {
+						rebuilt <- fmt.Errorf("generating protocol and server: %w", err)
+						return
+					}
+					if err := buildServer(protoc, modReplacements); err != nil {
+						rebuilt <- err
+						return
+					}
+					saveBuildHash(output, hash)
+					rebuilt <- nil
+				}()
+			case stub.SERVER_STOP, stub.SERVER_RESTART:
+				log.V(LOG_INFO).Info("Stopping gRPC server", "action", ctl.action, "drainPeriod", *drainPeriod)
+				pending = ctl.done
+				swapping = ctl.action == stub.SERVER_RESTART
+				halting = !swapping
+				unpause()
+				stopProcess(run.Process)
+			case stub.SERVER_START:
+				log.V(LOG_INFO).Info("Starting gRPC server")
+				stub.Restarting()
+				run, runerrchan = runGrpcServer(output, serverArgs)
+				running = true
+				ctl.done <- nil
+			case stub.SERVER_PAUSE:
+				err := suspendProcess(run.Process)
+				if err == nil {
+					log.V(LOG_INFO).Info("Paused gRPC server")
+					paused = true
+					stub.ServerPaused(true)
+				}
+				ctl.done <- err
+			case stub.SERVER_RESUME:
+				err := continueProcess(run.Process)
+				if err == nil {
+					log.V(LOG_INFO).Info("Resumed gRPC server")
+					paused = false
+					stub.ServerPaused(false)
+				}
+				ctl.done <- err
+			}
+		case err := <-rebuilt:
+			if err == nil && (stopping || !running) {
+				err = fmt.Errorf("the gRPC server stopped during the rebuild")
+			}
+			if err != nil {
+				log.Error(err, "reloading, the old gRPC server carries on")
+				pending <- err
+				pending = nil
+				continue
+			}
+			log.V(LOG_DEBUG).Info("Rebuilt, stopping old gRPC server", "drainPeriod", *drainPeriod)
+			swapping = true
+			unpause()
+			stopProcess(run.Process)
 		case err := <-runerrchan:
+			running, paused = false, false
+			stub.ServerPaused(false)
+			if swapping && !stopping {
+				log.V(LOG_INFO).Info("Old gRPC server exited, starting the new one", "error", err)
+				swapping = false
+				stub.Restarting()
+				run, runerrchan = runGrpcServer(output, serverArgs)
+				running = true
+				pending <- nil
+				pending = nil
+				continue
+			}
+			stub.SetState(stub.STATE_STOPPED)
+			if halting && !stopping {
+				log.V(LOG_INFO).Info("gRPC server stopped, the admin server carries on", "error", err)
+				halting = false
+				pending <- nil
+				pending = nil
+				continue
+			}
 			switch e := err.(type) {
+			case nil:
+				log.V(LOG_INFO).Info("gRPC server exited")
+				exit(0)
 			case *exec.ExitError:
 				log.V(LOG_INFO).Info("gRPC server exited", "exitcode", e.ExitCode())
 				if e.Success() {
-					os.Exit(0)
+					exit(0)
 				} else {
-					os.Exit(EXITCODE_RUNTIME_ERROR)
+					exit(EXITCODE_RUNTIME_ERROR)
 				}
 			default:
 				log.V(LOG_INFO).Error(e, "gRPC server exited", "error")
 			}
 		case <-sigchan:
-			log.V(LOG_DEBUG).Info("Caught signal, stopping gRPC Server")
-			run.Process.Kill()
+			if !running {
+				log.V(LOG_INFO).Info("Caught signal with the gRPC server stopped, exiting")
+				exit(0)
+			}
+			if stopping {
+				log.V(LOG_DEBUG).Info("Caught second signal, killing gRPC Server")
+				run.Process.Kill()
+				continue
+			}
+			// The server drains, then exits; a second signal kills it
+			// without waiting.
+			log.V(LOG_DEBUG).Info("Caught signal, stopping gRPC Server", "drainPeriod", *drainPeriod)
+			stopping = true
+			stub.SetState(stub.STATE_DRAINING)
+			unpause()
+			stopProcess(run.Process)
 			// Now wait for child exit
 		}
 	}
+}
+
+// The fully resolved configuration gripmock runs with. It's logged at startup
+// and served by the admin server on /config so it's easy to tell which
+// setting actually took effect.
+type effectiveConfig struct {
+	// value of every flag, whether defaulted or not
+	Flags map[string]string `json:"flags"`
+	// flags explicitly set on the command line
+	SetFlags []string `json:"setFlags"`
+	// environment variables that influence code generation, the go build
+	// and the generated server
+	Env map[string]string `json:"env"`
+	// proto files to serve, as given on the command line
+	Protos []string `json:"protos"`
+	// import dirs resolved to absolute paths
+	ImportDirs []string `json:"importDirs"`
+	// server template source, "embedded" or the template dir
+	Template string `json:"template"`
+}
+
+// How an environment variable's value is reported in the effective
+// configuration, which is logged and served without authentication
+const (
+	ENV_PLAIN = iota
+	// URLs, with any user:password@ stripped
+	ENV_URL
+	// replaced by stub.REDACTED
+	ENV_SECRET
+)
+
+// environment variables reported in the effective configuration
+var configEnv = map[string]int{
+	"GOFLAGS":                            ENV_PLAIN,
+	"GOOS":                               ENV_PLAIN,
+	"GOARCH":                             ENV_PLAIN,
+	"GOTOOLCHAIN":                        ENV_PLAIN,
+	"GO111MODULE":                        ENV_PLAIN,
+	"GOCACHE":                            ENV_PLAIN,
+	"GOMODCACHE":                         ENV_PLAIN,
+	"GOPATH":                             ENV_PLAIN,
+	"GOPRIVATE":                          ENV_PLAIN,
+	"GONOPROXY":                          ENV_PLAIN,
+	"GONOSUMDB":                          ENV_PLAIN,
+	"GOPROXY":                            ENV_URL,
+	"GOSUMDB":                            ENV_URL,
+	"OTEL_SERVICE_NAME":                  ENV_PLAIN,
+	"OTEL_TRACES_EXPORTER":               ENV_PLAIN,
+	"OTEL_EXPORTER_OTLP_PROTOCOL":        ENV_PLAIN,
+	"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL": ENV_PLAIN,
+	"OTEL_EXPORTER_OTLP_INSECURE":        ENV_PLAIN,
+	"OTEL_EXPORTER_OTLP_ENDPOINT":        ENV_URL,
+	"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": ENV_URL,
+	"OTEL_EXPORTER_OTLP_HEADERS":         ENV_SECRET,
+	"OTEL_EXPORTER_OTLP_TRACES_HEADERS":  ENV_SECRET,
+}
+
+// the user:password@ of each URL in a value
+var urlUserinfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/@\s]*@`)
+
+func resolveConfig(flags *flag.FlagSet, templateDir string, imports string, environ []string) effectiveConfig {
+	config := effectiveConfig{
+		Flags:      map[string]string{},
+		SetFlags:   []string{},
+		Env:        map[string]string{},
+		Protos:     flags.Args(),
+		ImportDirs: []string{},
+		Template:   "embedded",
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		config.Flags[f.Name] = f.Value.String()
+	})
+	flags.Visit(func(f *flag.Flag) {
+		config.SetFlags = append(config.SetFlags, f.Name)
+	})
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		kind, ok := configEnv[k]
+		if !ok {
+			continue
+		}
+		switch kind {
+		case ENV_URL:
+			v = urlUserinfo.ReplaceAllString(v, "$1")
+		case ENV_SECRET:
+			v = stub.REDACTED
+		}
+		config.Env[k] = v
+	}
+	for _, imp := range strings.Split(imports, ",") {
+		if imp == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(imp); err == nil {
+			imp = abs
+		}
+		config.ImportDirs = append(config.ImportDirs, imp)
+	}
+	if templateDir != "" {
+		config.Template = templateDir
+		if abs, err := filepath.Abs(templateDir); err == nil {
+			config.Template = abs
+		}
+	}
+	return config
+}
 
-	panic("unreachable")
+type exportParam struct {
+	protoc     protocParam
+	goReplaces string
+	stubPath   string
+	format     string
+	file       string
+}
+
+// Generate the server module and write it to an archive, without building or
+// running it
+func runExport(param exportParam) {
+	switch param.format {
+	case EXPORT_FORMAT_TAR_GZ, EXPORT_FORMAT_TAR:
+	default:
+		log.V(LOG_ERROR).Info("-format must be one of tar.gz, tar", "value", param.format)
+		exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	if len(param.protoc.protoPath) == 0 && len(param.protoc.descriptors) == 0 {
+		log.V(LOG_ERROR).Info("Need at least one proto file or -descriptor")
+		exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	file := param.file
+	if file == "" {
+		file = "gripmock-export." + param.format
+	}
+
+	if err := generateProtoc(param.protoc); err != nil {
+		log.Error(err, "when generating protocol and server")
+		exit(EXITCODE_BUILD_ERROR)
+	}
+	var modReplacements []string
+	if param.goReplaces != "" {
+		modReplacements = strings.Split(param.goReplaces, ",")
+	}
+	if err := prepareModule(param.protoc, modReplacements); err != nil {
+		log.Error(err, "preparing generated module")
+		exit(EXITCODE_BUILD_ERROR)
+	}
+
+	if err := exportArchive(file, param.format, param.protoc.output, param.stubPath); err != nil {
+		log.Error(err, "writing export archive", "file", file)
+		exit(EXITCODE_OTHER_ERROR)
+	}
+	log.V(LOG_INFO).Info("Exported generated server", "file", file)
+}
+
+// Generate a standalone server module in the output dir, with copies of the
+// stub files, to build and run without gripmock
+func runGenerate(param exportParam) {
+	if len(param.protoc.protoPath) == 0 && len(param.protoc.descriptors) == 0 {
+		log.V(LOG_ERROR).Info("Need at least one proto file or -descriptor")
+		exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	if param.protoc.vendor.from != "" {
+		log.V(LOG_ERROR).Info("\"gripmock generate\" can't use -vendor-from, since the prepared module doesn't have the stub package's dependencies; use -vendor")
+		exit(EXITCODE_ARGUMENTS_ERROR)
+	}
+	param.protoc.standalone = true
+
+	if err := generateProtoc(param.protoc); err != nil {
+		log.Error(err, "when generating protocol and server")
+		exit(EXITCODE_BUILD_ERROR)
+	}
+	var modReplacements []string
+	if param.goReplaces != "" {
+		modReplacements = strings.Split(param.goReplaces, ",")
+	}
+	if err := prepareModule(param.protoc, modReplacements); err != nil {
+		log.Error(err, "preparing generated module")
+		exit(EXITCODE_BUILD_ERROR)
+	}
+	if param.stubPath != "" {
+		if err := copyStandaloneStubs(param.stubPath, param.protoc.output); err != nil {
+			log.Error(err, "copying stub files")
+			exit(EXITCODE_OTHER_ERROR)
+		}
+	}
+	log.V(LOG_INFO).Info("Generated standalone server module", "dir", param.protoc.output,
+		"build", "go build -o "+SERVER_BINARY+" ./"+param.protoc.layout.serverPackageDir())
+}
+
+// Wait for the admin server to be told to resume, so the generated output
+// can be inspected before gripmock carries on.
+func pause(phase, output, adminURL string) {
+	log.V(LOG_INFO).Info("paused, POST to /state/resume on the admin server to continue",
+		"after", phase, "output", output,
+		"resume", "curl -k -X POST "+adminURL+"/state/resume")
+	stub.Pause()
+	log.V(LOG_INFO).Info("resumed", "after", phase)
+}
+
+// Print grpcurl commands calling the demo on the gRPC server's address, as
+// bound, on host, the first -grpc-listen host
+func printDemoCalls(address string, host string, tls bool) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		log.Error(err, "printing the demo calls", "address", address)
+		return
+	}
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
+	}
+	fmt.Print("\n" + demo.Usage(net.JoinHostPort(host, port), tls) + "\n")
+}
+
+// Write the bundled demo proto and stubs to a new temp dir and return the
+// proto file and stub dir paths.
+// Extract the demo files to a temp dir, which is removed on exit
+func extractDemo() (string, string, error) {
+	dir, err := os.MkdirTemp("", "gripmock-demo")
+	if err != nil {
+		return "", "", err
+	}
+	exitHooks = append(exitHooks, func() { os.RemoveAll(dir) })
+	return demo.Extract(dir)
+}
+
+// run before gripmock exits, e.g. to remove temp dirs
+var exitHooks []func()
+
+// Run the exit hooks, then exit with code
+func exit(code int) {
+	for _, hook := range exitHooks {
+		hook()
+	}
+	os.Exit(code)
 }
 
 func initLogging(level int) {
@@ -168,64 +1125,294 @@ func initLogging(level int) {
 	stdr.SetVerbosity(level)
 }
 
+// codec kinds the generated server can register under another name
+var codecKinds = map[string]bool{"json": true, "proto": true}
+
+var codecNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._+-]*$`)
+
+// Parse the -server-files flag into absolute paths of .go files, which are
+// added to the server by name so the names must differ, and mustn't be the
+// generated server.go
+func parseServerFiles(spec string) ([]string, error) {
+	files := []string{}
+	names := map[string]bool{"server.go": true}
+	for _, file := range strings.Split(spec, ",") {
+		if file == "" {
+			continue
+		}
+		if !strings.HasSuffix(file, ".go") || strings.HasSuffix(file, "_test.go") {
+			return nil, fmt.Errorf("server file \"%s\" must be a .go file, and not a test", file)
+		}
+		if names[filepath.Base(file)] {
+			return nil, fmt.Errorf("server file \"%s\" has the same name as another file of the server", file)
+		}
+		names[filepath.Base(file)] = true
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return nil, err
+		}
+		files = append(files, abs)
+	}
+	return files, nil
+}
+
+// A flag that may be repeated, collecting each value
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Parse the -codecs flag into "content-subtype:kind" specs for the server
+// generator. A bare name is a codec of the kind it names.
+func parseCodecs(spec string) ([]string, error) {
+	codecs := []string{}
+	for _, c := range strings.Split(spec, ",") {
+		if c == "" {
+			continue
+		}
+		name, kind, found := strings.Cut(c, "=")
+		if !found {
+			kind = name
+		}
+		if !codecKinds[kind] {
+			return nil, fmt.Errorf("codec \"%s\" must be json, proto or <content-subtype>=<json|proto>", c)
+		}
+		if !codecNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("codec content-subtype \"%s\" must be lower case letters, digits and .+-_", name)
+		}
+		if name == "proto" {
+			return nil, fmt.Errorf("codec \"proto\" is always registered and can't be replaced")
+		}
+		codecs = append(codecs, name+":"+kind)
+	}
+	return codecs, nil
+}
+
 type protocParam struct {
 	protoPath   []string
 	adminPort   string
-	grpcAddress string
+	// hosts to bind to, see bindaddr.go
+	grpcHosts   []string
 	grpcPort    string
 	output      string
 	imports     []string
 	templateDir string
+	// extra codecs, as "content-subtype:kind"
+	codecs []string
+	// FileDescriptorSet files to read instead of proto sources, when
+	// protoPath names files within them, see descriptor.go
+	descriptors []string
+	// also serve the services of the protos the served ones import, see
+	// imported.go
+	serveImports bool
+	// resolve imports missing from the import dirs from the bundled
+	// googleapis protos, see googleapis.go
+	googleapis bool
+	// services to serve, if not all, and methods of them not to, see
+	// rpcfilter.go
+	onlyServices   []string
+	excludeMethods []string
+	// protoc to run, PROTOC_EMBEDDED or a binary, see protoc.go
+	protoc string
+	// extra protoc arguments, after gripmock's own
+	protocArgs []string
+	// module name and layout of the generated server, see layout.go
+	layout generatedLayout
+	// Go files to add to the server's main package, by absolute path
+	serverFiles []string
+	// the admin server serves HTTPS, see tls.go
+	adminTLS bool
+	// extra gRPC listeners, see listeners.go; the generated server takes
+	// them as flags
+	listeners []grpcListener
+	// build the server with xDS serving, see xds.go
+	xds bool
+	// gRPC metadata key of each call's correlation ID, for log lines about
+	// the call; the generated server takes it as a flag
+	correlationKey string
+	// where go keeps its caches while building the server, see gocache.go
+	goCaches goCaches
+	// vendor the server's dependencies, see vendor.go
+	vendor vendorConfig
+	// how to build the server, see buildopts.go
+	goBuild goBuildOptions
+	// generate a standalone server module, see standalone.go
+	standalone bool
+	// only generate the Go code of protos that changed since the last
+	// generation in the output dir, see regen.go
+	incremental bool
+	// most protos munged or protoc runs at once, see parallel.go
+	jobs int
 }
 
 func generateProtoc(param protocParam) error {
-	log.V(LOG_VERBOSE).Info("Generating server protocol", "input", param.protoPath, "output", param.output)
+	log.V(LOG_VERBOSE).Info("Generating server protocol", "input", param.protoPath, "descriptors", param.descriptors, "output", param.output)
 
-	// Generate new .proto files under param.output and update param.protoPath
-	// and param.imports to point to them instead of the original user inputs
-	if err := fixGoPackages(&param); err != nil {
-		return fmt.Errorf("Munging proto files: %w", err)
-	}
+	// protoc arguments to find the protos, and the protos to generate
+	var inputs, files []string
+	// hashes for incremental regeneration, see regen.go
+	var hashes *protoHashes
+	// to report protoc's errors against, see protocerrors.go
+	var sources protoSources
+	if len(param.descriptors) > 0 {
+		// The descriptor sets carry everything protoc needs, once the files
+		// to serve have their go packages rewritten
+		munging := time.Now()
+		set, serve, err := prepareDescriptorSet(param.descriptors, param.protoPath, param.serveImports, param.layout, param.output)
+		if err != nil {
+			return fmt.Errorf("Reading descriptor sets: %w", err)
+		}
+		timePhase(stub.STARTUP_MUNGE, munging)
+		inputs = append(inputs, "--descriptor_set_in="+set)
+		files = serve
+	} else {
+		if param.serveImports {
+			served, err := withImportedServices(param.protoPath, param.imports)
+			if err != nil {
+				return fmt.Errorf("Finding imported services: %w", err)
+			}
+			param.protoPath = served
+		}
+		originals := param.protoPath
+		// Generate new .proto files under param.output and update
+		// param.protoPath and param.imports to point to them instead of the
+		// original user inputs
+		munging := time.Now()
+		if err := fixGoPackages(&param); err != nil {
+			return fmt.Errorf("Munging proto files: %w", err)
+		}
+		timePhase(stub.STARTUP_MUNGE, munging)
+		sources = newProtoSources(param.output, param.imports, originals, param.protoPath)
+		if param.incremental {
+			h, err := hashProtos(param, originals, param.protoPath)
+			if err != nil {
+				return fmt.Errorf("Hashing proto files: %w", err)
+			}
+			hashes = &h
+		}
 
-	// Always search the generated protos dir first, since that will ensure
-	// any proto files we rewrote with new package names will appear before
-	// any of the well-known types and other protos our proto files may
-	// have imported but do not serve.
-	args := []string{"-I", param.output}
-	for _, imp := range param.imports {
-		args = append(args, "-I", imp)
+		// Always search the generated protos dir first, since that will
+		// ensure any proto files we rewrote with new package names will
+		// appear before any of the well-known types and other protos our
+		// proto files may have imported but do not serve.
+		inputs = append(inputs, "-I", param.output)
+		for _, imp := range param.imports {
+			inputs = append(inputs, "-I", imp)
+		}
+		if param.googleapis {
+			set, err := writeGoogleapisSet(param.output)
+			if err != nil {
+				return fmt.Errorf("Writing googleapis protos: %w", err)
+			}
+			inputs = append(inputs, "--descriptor_set_in="+set)
+		}
+		files = param.protoPath
 	}
-	args = append(args, param.protoPath...)
-	args = append(args,
+	goOut := []string{
 		"--go_out="+param.output,
-		"--go_opt=module="+GENERATED_MODULE_NAME,
+		"--go_opt=module="+param.layout.moduleName(),
 		"--go-grpc_out="+param.output,
-		"--go-grpc_opt=module="+GENERATED_MODULE_NAME,
-	)
-	args = append(args,
+		"--go-grpc_opt=module="+param.layout.moduleName(),
+	}
+	gripmockOut := []string{
 		"--gripmock_out="+param.output,
 		"--gripmock_opt=paths=source_relative",
 		"--gripmock_opt=admin-port="+param.adminPort,
-		"--gripmock_opt=grpc-address="+param.grpcAddress,
 		"--gripmock_opt=grpc-port="+param.grpcPort,
 		"--gripmock_opt=template-dir="+param.templateDir,
-	)
-	protoc := exec.Command("protoc", args...)
-	protoc.Stdout = os.Stdout
-	protoc.Stderr = os.Stderr
-	log.V(LOG_VERBOSE).Info("invoking \"protoc\"", "cmd", protoc.String())
-	if err := protoc.Run(); err != nil {
-		return fmt.Errorf("running protoc: %w", err)
+		"--gripmock_opt=server-dir="+param.layout.serverPackageDir(),
+	}
+	for _, host := range param.grpcHosts {
+		gripmockOut = append(gripmockOut, "--gripmock_opt=grpc-address="+host)
+	}
+	for _, file := range param.serverFiles {
+		gripmockOut = append(gripmockOut, "--gripmock_opt=server-file="+file)
+	}
+	if param.xds {
+		gripmockOut = append(gripmockOut, "--gripmock_opt=xds=true")
+	}
+	if !param.goBuild.tracing {
+		gripmockOut = append(gripmockOut, "--gripmock_opt=tracing=false")
+	}
+	if param.standalone {
+		gripmockOut = append(gripmockOut, "--gripmock_opt=stub-package="+standaloneStubPackage(param.layout))
+	}
+	for _, codec := range param.codecs {
+		gripmockOut = append(gripmockOut, "--gripmock_opt=codec="+codec)
+	}
+	for _, service := range param.onlyServices {
+		gripmockOut = append(gripmockOut, "--gripmock_opt=only-service="+service)
+	}
+	for _, method := range param.excludeMethods {
+		gripmockOut = append(gripmockOut, "--gripmock_opt=exclude-method="+method)
 	}
 
-	log.V(LOG_VERBOSE).Info("Generated protocol and server")
+	// the protos whose Go code to generate: all of them, unless only the
+	// changed ones are regenerated, see regen.go
+	goFiles := files
+	if hashes != nil {
+		changed := hashes.changed(loadProtoHashes(param.output))
+		log.V(LOG_VERBOSE).Info("Regenerating changed protos", "changed", len(changed), "protos", len(files))
+		goFiles = []string{}
+		for _, proto := range changed {
+			goFiles = append(goFiles, filepath.Join(param.output, proto))
+		}
+	}
+	// until it's done, the next generation has to do every proto
+	if err := saveProtoHashes(param.output, nil); err != nil {
+		return err
+	}
 
-	return nil
+	// the protoc runs, run in parallel, see parallel.go
+	var runs [][]string
+	if hashes == nil && param.jobs <= 1 {
+		run := append(append(append([]string{}, inputs...), files...), goOut...)
+		runs = append(runs, append(run, gripmockOut...))
+	} else {
+		runs = append(runs, append(append(append([]string{}, inputs...), files...), gripmockOut...))
+		groups := [][]string{goFiles}
+		if param.jobs > 1 {
+			groups = groupByPackage(goFiles)
+		}
+		for _, group := range groups {
+			if len(group) > 0 {
+				runs = append(runs, append(append(append([]string{}, inputs...), group...), goOut...))
+			}
+		}
+	}
+	generating := time.Now()
+	err := forEachParallel(len(runs), param.jobs, func(i int) error {
+		return runProtoc(param.protoc, append(runs[i], param.protocArgs...), sources)
+	})
+	timePhase(stub.STARTUP_PROTOC, generating)
+	if err != nil {
+		return err
+	}
+	log.V(LOG_VERBOSE).Info("Generated protocol and server", "protocRuns", len(runs))
+	if hashes == nil {
+		return nil
+	}
+	return saveProtoHashes(param.output, hashes)
+}
+
+// Record how long a startup phase that began at start took, see
+// stub/startup.go
+func timePhase(phase string, start time.Time) {
+	stub.RecordStartupPhase(phase, time.Since(start))
 }
 
 // Generate a go package name for input proto file 'protoPath';
-// return the package name relative to the GENERATED_MODULE_NAME
-// prefix.
+// return the package name relative to the generated module's protobuf
+// package dir, see layout.go.
 //
 // Returns: import path protocol matched on, protocol path relative to import dir
 //
@@ -270,13 +1457,13 @@ func findProtoInImports(importPaths []string, protoPath string) (string, string,
 			relPath, err := filepath.Rel(absImp, protoPath)
 			// We have to exclude relative paths that descend because filepath.Rel
 			// will generate a descending relative path if given two absolute paths
-			if err == nil && relPath != "" && !strings.HasPrefix(relPath, "../") {
+			if err == nil && relPath != "" && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
 				log.V(LOG_TRACE).Info("matched absolute path prefix", "proto", protoPath, "dir", imp, "absdir", absImp, "rel", relPath)
 
-				rel := path.Dir(relPath)
+				rel := filepath.Dir(relPath)
 				// Sanity check that the proto file is actually on the matched
 				// import path, since filepath.Rel is just a lexical check.
-				derivedPath := path.Join(imp, rel, path.Base(protoPath))
+				derivedPath := filepath.Join(imp, rel, filepath.Base(protoPath))
 				if _, err := os.Stat(derivedPath); err != nil {
 					log.V(LOG_TRACE).Info("protocol file appears to be within import path, but could not stat() file",
 										 "derived proto path to stat", derivedPath, "error", err)
@@ -301,11 +1488,11 @@ func findProtoInImports(importPaths []string, protoPath string) (string, string,
 			// irrelevant whether the import path is relative or absolute for
 			// this. We don't recurse inside the directories, we only care about
 			// whether the proto path can be found within the top level importdir.
-			importProtoPath := path.Join(imp, protoPath)
+			importProtoPath := filepath.Join(imp, protoPath)
 			log.V(LOG_TRACE).Info("testing path existence", "path", importProtoPath)
 			if _, err := os.Stat(importProtoPath); !os.IsNotExist(err) {
 				matchedImp = imp
-				matchedRel = path.Dir(protoPath)
+				matchedRel = filepath.Dir(protoPath)
 				log.V(LOG_TRACE).Info("matched relative path", "proto", protoPath, "dir", imp, "fullPath", importProtoPath)
 				break
 			}
@@ -324,7 +1511,7 @@ func findProtoInImports(importPaths []string, protoPath string) (string, string,
 				wd, _ := os.Getwd()
 				log.V(LOG_VERBOSE).Info(fmt.Sprintf("Protocol file \"%s\" not found on any import path, but WAS found relative to the gripmock working directory \"%s\".", protoPath, wd))
 			}
-			matchedImp = path.Dir(protoPath)
+			matchedImp = filepath.Dir(protoPath)
 			matchedRel = "."
 			log.V(LOG_INFO).Info("WARNING: adding proto file's containing dir as implicit import path. You should specify an appropriate path on the -imports list instead.", "importpath", matchedImp)
 		} else {
@@ -335,7 +1522,7 @@ func findProtoInImports(importPaths []string, protoPath string) (string, string,
 	}
 
 	// Sanity check that the proto file is actually on the matched import path
-	derivedPath := path.Join(matchedImp, matchedRel, path.Base(protoPath))
+	derivedPath := filepath.Join(matchedImp, matchedRel, filepath.Base(protoPath))
 	fileinfo, err := os.Stat(derivedPath)
 	if err != nil {
 		return "", "", fmt.Errorf("cannot stat proto file at path \"%s\": %w", derivedPath, err)
@@ -344,72 +1531,72 @@ func findProtoInImports(importPaths []string, protoPath string) (string, string,
 		return "", "", fmt.Errorf("path \"%s\" is a directory", derivedPath)
 	}
 
-	return matchedImp, matchedRel, nil
+	// the relative dir names a Go package and a proto, so is always
+	// slash-separated
+	return matchedImp, filepath.ToSlash(matchedRel), nil
+}
+
+// Name of a proto found by findProtoInImports, as protoc and the descriptors
+// know it: its dir relative to the import dir, slash-separated, and its file
+// name
+func protoName(rel, proto string) string {
+	return path.Join(rel, filepath.Base(proto))
 }
 
 // Stream transformation that rewrites a .proto file's go_package directive
-// to point to new_package
-func fixGoPackageProtoStream(in io.Reader, newPackage string, out io.Writer) error {
+// to point to new_package. The file is parsed, so options split over lines,
+// comments and unusual spacing don't matter; only the go_package option's
+// own text is replaced, or a go_package option added after the syntax
+// statement, and the rest is copied as it is.
+func fixGoPackageProtoStream(name string, in io.Reader, newPackage string, out io.Writer) error {
 	if newPackage == "" {
 		return fmt.Errorf("empty package name")
 	}
-
-	s := bufio.NewScanner(in)
-	s.Split(bufio.ScanLines)
-
-	ow := bufio.NewWriter(out)
-
-	var err error
-	foundSyntaxLine := false
-	var matched bool
-	for s.Scan() {
-		l := s.Text()
-
-		// Any go_package line must be omitted, since we'll be writing a
-		// replacement for it.
-		if matched, err = regexp.MatchString("^option[ \\t]+go_package[ \\t]+=", l); err != nil {
-			return err
-		}
-		if matched {
-			continue
-		}
-
-		if matched, err = regexp.MatchString("^syntax[ \\t]", l); err != nil {
-			return err
-		}
-		if matched {
-			if foundSyntaxLine {
-				return fmt.Errorf("Found more than one \"syntax\" statement")
-			}
-			foundSyntaxLine = true;
-			// Immediately after the "syntax" line, add our own option
-			// go_package line to override the protocol's real package
-			// with one we will generate
-			l = l + fmt.Sprintf("\noption go_package = \"%s\";\n", newPackage)
-		}
-
-		// Write (possibly modified) line(s) to the new proto file
-		if _, err := ow.WriteString(l + "\n"); err != nil {
-			return err
-		}
-	}
-
-	if err := ow.Flush(); err != nil {
+	src, err := io.ReadAll(in)
+	if err != nil {
 		return err
 	}
-	if err := s.Err(); err != nil {
+	file, err := parser.Parse(name, bytes.NewReader(src), reporter.NewHandler(nil))
+	if err != nil {
 		return err
 	}
 
-	if ! foundSyntaxLine {
-		return fmt.Errorf("no \"syntax\" line found when scanning proto file")
+	option := fmt.Sprintf("option go_package = %q;", newPackage)
+	// offsets in src of the text to replace with the option
+	start, end := 0, 0
+	found := false
+	for _, decl := range file.Decls {
+		opt, ok := decl.(*ast.OptionNode)
+		if !ok || len(opt.Name.Parts) != 1 || opt.Name.Parts[0].IsExtension() ||
+			opt.Name.Parts[0].Name.AsIdentifier() != "go_package" {
+			continue
+		}
+		if found {
+			return fmt.Errorf("%v: more than one go_package option", file.NodeInfo(opt).Start())
+		}
+		found = true
+		info := file.NodeInfo(opt)
+		start, end = info.Start().Offset, info.End().Offset+1
+	}
+	if !found {
+		if file.Syntax != nil {
+			start = file.NodeInfo(file.Syntax).End().Offset + 1
+			end = start
+			option = "\n" + option
+		} else {
+			option += "\n"
+		}
 	}
 
-	return nil
+	ow := bufio.NewWriter(out)
+	ow.Write(src[:start])
+	ow.WriteString(option)
+	ow.Write(src[end:])
+	return ow.Flush()
 }
 
 // Rewrite the .proto file to replace any go_package directive with one based
-// on our local package path for generated servers, GENERATED_MODULE_NAME .
+// on our local package path for generated servers, in the generated module.
 // Write the new file to the provided path.
 //
 func fixGoPackage(protoPath string, newPackage string, outPath string) error {
@@ -425,7 +1612,7 @@ func fixGoPackage(protoPath string, newPackage string, outPath string) error {
 	}
 	defer of.Close()
 
-	if err := fixGoPackageProtoStream(in, newPackage, of); err != nil {
+	if err := fixGoPackageProtoStream(protoPath, in, newPackage, of); err != nil {
 		return fmt.Errorf("failed to munge proto file \"%s\": %w", protoPath, err)
 	}
 	log.V(LOG_DEBUG).Info("wrote modified proto file",
@@ -449,18 +1636,21 @@ func fixGoPackage(protoPath string, newPackage string, outPath string) error {
 //
 func fixGoPackages(param *protocParam) error {
 	outProtos := make([]string, len(param.protoPath))
-	for i, proto := range param.protoPath {
+	// a proto given twice is only written once
+	written := sync.Map{}
+	err := forEachParallel(len(param.protoPath), param.jobs, func(i int) error {
+		proto := param.protoPath[i]
 		importDir, newPackageSuffix, err := findProtoInImports(param.imports, proto)
 		if err != nil {
 			return err
 		}
-		protoPath := path.Join(importDir, newPackageSuffix, path.Base(proto))
-		outProtoDir := path.Join(param.output, newPackageSuffix)
-		outProto := path.Join(outProtoDir, path.Base(proto))
+		protoPath := filepath.Join(importDir, filepath.FromSlash(protoName(newPackageSuffix, proto)))
+		outProtoDir := filepath.Join(param.output, filepath.FromSlash(newPackageSuffix))
+		outProto := filepath.Join(outProtoDir, filepath.Base(proto))
 		// Write a copy of the .proto file in outProto with the go_package
 		// directive rewritten to point to the full package path, and the file
 		// placed in in newPackageSuffix/{filename}.proto
-		newPackage := path.Join(GENERATED_MODULE_NAME, newPackageSuffix)
+		newPackage := param.layout.goPackage(newPackageSuffix)
 		log.V(LOG_TRACE).Info("path resolution",
 							  "input proto arg", proto,
 							  "resolved input proto path", protoPath,
@@ -469,16 +1659,20 @@ func fixGoPackages(param *protocParam) error {
 							  "output proto dir", outProtoDir,
 							  "output proto file", outProto,
 							  "full proto package", newPackage)
+		outProtos[i] = outProto
+		if _, dup := written.LoadOrStore(outProto, true); dup {
+			return nil
+		}
 		if err := os.MkdirAll(outProtoDir, os.ModePerm); err != nil {
 			return err
 		}
 		// Write a copy of the original proto in the output dir, preserving the
 		// same path-prefix. Change the go_package directive to the new one for
 		// locally generated proto files.
-		if err := fixGoPackage(protoPath, newPackage, outProto); err != nil {
-			return err
-		}
-		outProtos[i] = outProto
+		return fixGoPackage(protoPath, newPackage, outProto)
+	})
+	if err != nil {
+		return err
 	}
 	// Modify the protoc inputs to use our munged protocol files,
 	// all of which are within the outputdir
@@ -486,40 +1680,144 @@ func fixGoPackages(param *protocParam) error {
 	return nil
 }
 
-func runGrpcServer(output string) (*exec.Cmd, <-chan error) {
-	run := exec.Command(path.Join(output,"server"))
-	run.Stdout = os.Stdout
-	run.Stderr = os.Stderr
+// gRPC server keepalive parameters and enforcement policy. Zero values leave
+// the grpc-go defaults in place.
+type keepaliveConfig struct {
+	time                  time.Duration
+	timeout               time.Duration
+	maxConnectionIdle     time.Duration
+	maxConnectionAge      time.Duration
+	maxConnectionAgeGrace time.Duration
+	minTime               time.Duration
+	permitWithoutStream   bool
+}
+
+// Flags for the generated server, for the settings that aren't defaults
+func (k keepaliveConfig) serverArgs() []string {
+	args := []string{}
+	for _, d := range []struct {
+		flag  string
+		value time.Duration
+	}{
+		{"keepalive-time", k.time},
+		{"keepalive-timeout", k.timeout},
+		{"max-connection-idle", k.maxConnectionIdle},
+		{"max-connection-age", k.maxConnectionAge},
+		{"max-connection-age-grace", k.maxConnectionAgeGrace},
+		{"keepalive-min-time", k.minTime},
+	} {
+		if d.value != 0 {
+			args = append(args, "-"+d.flag+"="+d.value.String())
+		}
+	}
+	if k.permitWithoutStream {
+		args = append(args, "-keepalive-permit-without-stream")
+	}
+	return args
+}
+
+func runGrpcServer(output string, args []string) (*exec.Cmd, <-chan error) {
+	run := exec.Command(filepath.Join(output, SERVER_BINARY), args...)
+	setServerProcAttr(run)
+	// logged through our logger, see serverlog.go
+	stdout, stderr := newServerLogWriter("stdout"), newServerLogWriter("stderr")
+	run.Stdout = stdout
+	run.Stderr = stderr
 	err := run.Start()
 	if err != nil {
 		log.Error(err, "starting grpc server")
-		os.Exit(EXITCODE_RUNTIME_ERROR)
+		exit(EXITCODE_RUNTIME_ERROR)
 	}
 	log.V(LOG_VERBOSE).Info("grpc server started", "pid", run.Process.Pid)
 	runerr := make(chan error)
 	go func() {
-		runerr <- run.Wait()
+		err := run.Wait()
+		stdout.flush()
+		stderr.flush()
+		runerr <- err
 	}()
 	return run, runerr
 }
 
-func buildServer(output string, modReplacements []string) error {
+// An action on the gRPC server requested on the admin server, see
+// stub.Options.Control
+type serverControl struct {
+	action string
+	done   chan error
+}
+
+// Check a server action applies in the server's current state
+func checkControl(action string, stopping, running, paused bool) error {
+	switch {
+	case stopping:
+		return fmt.Errorf("%w, gripmock is stopping", stub.ErrServerState)
+	case action == stub.SERVER_START && running:
+		return fmt.Errorf("%w, the gRPC server is already running", stub.ErrServerState)
+	case action != stub.SERVER_START && !running:
+		return fmt.Errorf("%w, the gRPC server is stopped", stub.ErrServerState)
+	case action == stub.SERVER_PAUSE && paused:
+		return fmt.Errorf("%w, the gRPC server is already paused", stub.ErrServerState)
+	case action == stub.SERVER_RESUME && !paused:
+		return fmt.Errorf("%w, the gRPC server isn't paused", stub.ErrServerState)
+	}
+	return nil
+}
+
+// Build the server in the output dir. It doesn't change directory, since
+// the admin server is running by the time a reload rebuilds it.
+func buildServer(param protocParam, modReplacements []string) error {
 	log.V(LOG_VERBOSE).Info("Building server")
-	oldCwd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("getcwd(): %w", err)
+	output := param.output
+	preparing := time.Now()
+	if err := prepareModule(param, modReplacements); err != nil {
+		return err
 	}
-	if err := os.Chdir(output); err != nil {
-		return fmt.Errorf("changing directory to %s: %w", output, err)
+	timePhase(stub.STARTUP_MODULE, preparing)
+
+	args := append([]string{"build"}, param.vendor.buildArgs()...)
+	args = append(args, param.goBuild.buildArgs()...)
+	args = append(args, "-o", SERVER_BINARY, "./"+param.layout.serverPackageDir()+"/...")
+	run := exec.Command("go", args...)
+	run.Dir = output
+	run.Env = param.goCaches.env(os.Environ())
+	run.Stdout = os.Stdout
+	run.Stderr = os.Stderr
+	log.V(LOG_DEBUG).Info("building gRPC server from module", "cmd", run.String())
+	building := time.Now()
+	if err := run.Run(); err != nil {
+		return fmt.Errorf("building server: %w", err)
 	}
+	timePhase(stub.STARTUP_BUILD, building)
+	log.Info("Built server", "path", filepath.Join(output, SERVER_BINARY))
+
+	return nil
+}
 
-	run := exec.Command("go", "mod", "edit", "-module", GENERATED_MODULE_NAME)
+// Name the generated module, add any replacements and resolve its
+// dependencies into go.mod and go.sum, and vendor them if asked, ready to
+// build. With -vendor-from, the dependencies are taken from the prepared
+// module instead.
+func prepareModule(param protocParam, modReplacements []string) error {
+	dir := param.output
+	env := param.goCaches.env(os.Environ())
+	if param.vendor.from != "" {
+		log.V(LOG_DEBUG).Info("copying prepared module dependencies", "from", param.vendor.from)
+		if err := param.vendor.copyPrepared(dir); err != nil {
+			return fmt.Errorf("copying -vendor-from module: %w", err)
+		}
+	}
+	run := exec.Command("go", "mod", "edit", "-module", param.layout.moduleName())
+	run.Dir = dir
+	run.Env = env
 	run.Stdout = os.Stdout
 	run.Stderr = os.Stderr
 	log.V(LOG_DEBUG).Info("setting go.mod module name", "cmd", run.String())
 	if err := run.Run(); err != nil {
 		return fmt.Errorf("setting go.mod name: %w", err)
 	}
+	if param.vendor.from != "" {
+		return nil
+	}
 
 	if len(modReplacements) > 0 {
 		cmd := []string{"mod", "edit"}
@@ -527,6 +1825,8 @@ func buildServer(output string, modReplacements []string) error {
 			cmd = append(cmd, "-replace=" + r)
 		}
 		run := exec.Command("go", cmd...)
+		run.Dir = dir
+		run.Env = env
 		run.Stdout = os.Stdout
 		run.Stderr = os.Stderr
 		log.V(LOG_DEBUG).Info("adding module replacement directives", "cmd", run.String())
@@ -535,7 +1835,15 @@ func buildServer(output string, modReplacements []string) error {
 		}
 	}
 
+	if param.standalone {
+		if err := makeStandalone(dir, param.layout, env); err != nil {
+			return err
+		}
+	}
+
 	run = exec.Command("go", "mod", "tidy")
+	run.Dir = dir
+	run.Env = env
 	run.Stdout = os.Stdout
 	run.Stderr = os.Stderr
 	log.V(LOG_DEBUG).Info("tidying go.mod", "cmd", run.String())
@@ -543,19 +1851,18 @@ func buildServer(output string, modReplacements []string) error {
 		return fmt.Errorf("tidying go.mod: %w", err)
 	}
 
-	run = exec.Command("go", "build", "-o", "server", "./cmd/...")
+	if !param.vendor.enabled {
+		return removeStaleVendor(dir)
+	}
+	run = exec.Command("go", "mod", "vendor")
+	run.Dir = dir
+	run.Env = env
 	run.Stdout = os.Stdout
 	run.Stderr = os.Stderr
-	log.V(LOG_DEBUG).Info("building gRPC server from module", "cmd", run.String())
+	log.V(LOG_DEBUG).Info("vendoring dependencies", "cmd", run.String())
 	if err := run.Run(); err != nil {
-		return fmt.Errorf("building server: %w", err)
+		return fmt.Errorf("vendoring dependencies: %w", err)
 	}
-
-	if err := os.Chdir(oldCwd); err != nil {
-		return fmt.Errorf("returning to old working directory: %w", err)
-	}
-	log.Info("Built server", "path", path.Join(output,"server"))
-
 	return nil
 }
 
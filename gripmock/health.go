@@ -0,0 +1,97 @@
+package main
+
+/*
+ * Health status overrides for -dynamic.
+ *
+ * Statuses set on the admin server's /health/services, see
+ * stub/health.go, are applied to the health service of each gRPC server,
+ * for the services it serves, as the generated server does. A service
+ * without a status set is SERVING. Once a server starts draining its
+ * health service reports NOT_SERVING whatever is set.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ringerc/gripmock/stub"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Time between attempts to fetch the statuses after one fails
+const HEALTH_RETRY_INTERVAL = time.Second
+
+// A gRPC server's health service, and the services it reports on
+type healthTarget struct {
+	srv *health.Server
+	// "" and the fully qualified name of each service served
+	services []string
+}
+
+// Set each service's status to the one in statuses, or SERVING
+func (t healthTarget) apply(statuses map[string]string) {
+	for _, name := range t.services {
+		status := healthpb.HealthCheckResponse_SERVING
+		if s, ok := statuses[name]; ok {
+			status = healthpb.HealthCheckResponse_ServingStatus(healthpb.HealthCheckResponse_ServingStatus_value[s])
+		}
+		t.srv.SetServingStatus(name, status)
+	}
+}
+
+// Long-poll the admin server's health statuses, applying each version to
+// the targets, until stop is closed
+func watchHealth(client *http.Client, adminURL string, targets []healthTarget, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	version := -1
+	for ctx.Err() == nil {
+		statuses, err := fetchHealth(ctx, client, adminURL, version)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.V(LOG_DEBUG).Info("fetching health statuses", "error", err.Error())
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(HEALTH_RETRY_INTERVAL):
+			}
+			continue
+		}
+		if statuses.Version == version {
+			continue
+		}
+		version = statuses.Version
+		for _, t := range targets {
+			t.apply(statuses.Services)
+		}
+	}
+}
+
+// The statuses set, once they differ from version
+func fetchHealth(ctx context.Context, client *http.Client, adminURL string, version int) (stub.HealthStatuses, error) {
+	statuses := stub.HealthStatuses{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, adminURL+"/health/services?wait="+strconv.Itoa(version), nil)
+	if err != nil {
+		return statuses, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return statuses, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statuses, fmt.Errorf("GET /health/services: %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&statuses)
+	return statuses, err
+}
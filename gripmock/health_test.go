@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func Test_watchHealth(t *testing.T) {
+	initLogging(LOG_ERROR)
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/health/services", r.URL.Path)
+		if r.URL.Query().Get("wait") == "-1" {
+			w.Write([]byte(`{"version":1,"services":{"test.Greeter":"NOT_SERVING","other.Service":"NOT_SERVING"}}`))
+			return
+		}
+		// no change until the watcher gives up
+		<-r.Context().Done()
+	}))
+	defer admin.Close()
+
+	srv := health.NewServer()
+	target := healthTarget{srv, []string{"", "test.Greeter", "test.Other"}}
+	target.apply(nil)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		watchHealth(http.DefaultClient, admin.URL, []healthTarget{target}, stop)
+		close(stopped)
+	}()
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := srv.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		return resp.Status
+	}
+	assert.Eventually(t, func() bool {
+		return check("test.Greeter") == healthpb.HealthCheckResponse_NOT_SERVING
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check("test.Other"))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVICE_UNKNOWN, check("other.Service"), "not served here")
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchHealth didn't stop")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+/*
+ * Grouped help.
+ *
+ * gripmock has grown too many flags for one alphabetical list, so -help
+ * prints them in groups, with the subcommands and some examples, and
+ * "gripmock help <topic>" prints a single group, where the topic is a
+ * group's name or one of its flags:
+ *
+ *   gripmock help tls
+ *   gripmock help stub-overlap
+ *
+ * A flag missing from helpGroups is listed under "other", so a new flag
+ * always shows up, if not where it belongs.
+ */
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type helpGroup struct {
+	// topic for "gripmock help <topic>"
+	name  string
+	title string
+	flags []string
+}
+
+var helpGroups = []helpGroup{
+	{"protos", "Protos and descriptors", []string{
+		"imports", "descriptor", "from-reflection", "from-reflection-tls",
+		"from-reflection-ca", "from-reflection-insecure", "googleapis", "serve-imports",
+		"only-services", "exclude-methods", "protoc", "protoc-arg",
+	}},
+	{"stubs", "Stubs", []string{
+		"stub", "stub-validation", "stub-overlap", "stub-templates", "persist-stubs",
+		"lazy-stubs", "import-max-size", "tenant-key", "session-key", "wasm-dir",
+	}},
+	{"serving", "Serving", []string{
+		"grpc-port", "grpc-listen", "listen", "admin-port", "admin-listen",
+		"admin-grpc-port", "codecs", "dynamic", "watch", "drain-period", "xds",
+		"xds-bootstrap", "config", "profile",
+	}},
+	{"startup", "Waiting for startup", []string{
+		"ports-file", "print-ports", "ready-file", "print-ready", "pause-after",
+	}},
+	{"tls", "TLS", []string{
+		"tls-cert", "tls-key", "admin-tls", "admin-tls-cert", "admin-tls-key",
+	}},
+	{"connections", "Connections and limits", []string{
+		"keepalive-time", "keepalive-timeout", "keepalive-min-time",
+		"keepalive-permit-without-stream", "max-connection-idle", "max-connection-age",
+		"max-connection-age-grace", "max-recv-msg-size", "max-send-msg-size",
+		"max-concurrent-streams", "max-connections",
+	}},
+	{"logging", "Logging and debugging", []string{
+		"verbosity", "wire-log", "wire-log-redact", "access-log", "access-log-max-size",
+		"access-log-rotate", "access-log-backups", "log-sample", "log-sample-interval",
+		"correlation-key", "pprof",
+	}},
+	{"build", "Generating and building the server", []string{
+		"o", "template-dir", "module", "server-dir", "proto-dir", "server-files",
+		"go-replace", "build-cache", "jobs", "go-build-cache", "go-mod-cache",
+		"go-trimpath", "go-strip", "go-gcflags", "go-compiler", "tracing", "vendor",
+		"vendor-from",
+	}},
+	{"export", "Exporting", []string{"format", "export-file"}},
+	{"scaffold", "Scaffolding stubs", []string{"scaffold-dir", "scaffold-data", "scaffold-overwrite"}},
+}
+
+// topic of the group of flags missing from helpGroups
+const HELP_OTHER_GROUP = "other"
+
+var subcommands = []struct {
+	name    string
+	summary string
+}{
+	{"demo", "serve a bundled example service, with stubs and a walkthrough"},
+	{"check", "check the protos and stub files, without serving"},
+	{"export", "write the generated server module and stubs to an archive"},
+	{"generate", "write a standalone server module to the -o dir"},
+	{"scaffold-stubs", "write an example stub file for each method of the protos"},
+	{"wiremock", "convert WireMock mappings to a stub file"},
+	{"stub", "add, list, delete or verify the stubs of a running gripmock"},
+	{"completion", "print a bash, zsh or fish completion script"},
+	{"help", "print this help, or one group of it: gripmock help <topic>"},
+}
+
+var helpExamples = []string{
+	"gripmock -stub stubs -imports protos greeter.proto",
+	"gripmock -dynamic -descriptor api.protoset -stub stubs api.proto",
+	"gripmock -grpc-port 0 -admin-port 0 -ports-file ports.json -ready-file ready -stub stubs api.proto",
+	"gripmock check -stub stubs api.proto",
+	"gripmock scaffold-stubs -scaffold-data fake api.proto",
+	"gripmock demo",
+}
+
+// The groups in order, with "other" last if any flag isn't in a group
+func flagGroups(flags *flag.FlagSet) []helpGroup {
+	grouped := map[string]bool{}
+	groups := []helpGroup{}
+	for _, g := range helpGroups {
+		present := helpGroup{name: g.name, title: g.title}
+		for _, name := range g.flags {
+			if flags.Lookup(name) != nil {
+				present.flags = append(present.flags, name)
+				grouped[name] = true
+			}
+		}
+		if len(present.flags) > 0 {
+			groups = append(groups, present)
+		}
+	}
+	other := helpGroup{name: HELP_OTHER_GROUP, title: "Other"}
+	flags.VisitAll(func(f *flag.Flag) {
+		if !grouped[f.Name] {
+			other.flags = append(other.flags, f.Name)
+		}
+	})
+	if len(other.flags) > 0 {
+		groups = append(groups, other)
+	}
+	return groups
+}
+
+// Print the full help for -help and "gripmock help"
+func printUsage(flags *flag.FlagSet, w io.Writer) {
+	fmt.Fprintln(w, "Usage: gripmock [flags] <proto>...")
+	fmt.Fprintln(w, "       gripmock <command> [flags] [<proto>...]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	width := 0
+	for _, c := range subcommands {
+		if len(c.name) > width {
+			width = len(c.name)
+		}
+	}
+	for _, c := range subcommands {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, c.name, c.summary)
+	}
+	for _, g := range flagGroups(flags) {
+		fmt.Fprintln(w)
+		printGroup(flags, g, w)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Examples:")
+	for _, e := range helpExamples {
+		fmt.Fprintln(w, "  "+e)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Flags take one dash or two. See the README for more on each.")
+}
+
+func printGroup(flags *flag.FlagSet, g helpGroup, w io.Writer) {
+	fmt.Fprintf(w, "%s (gripmock help %s):\n", g.title, g.name)
+	for _, name := range g.flags {
+		printFlag(flags.Lookup(name), w)
+	}
+}
+
+// One flag, laid out as flag.PrintDefaults does
+func printFlag(f *flag.Flag, w io.Writer) {
+	kind, usage := flag.UnquoteUsage(f)
+	line := "  -" + f.Name
+	if kind != "" {
+		line += " " + kind
+	}
+	line += "\n    \t" + strings.ReplaceAll(usage, "\n", "\n    \t")
+	if !isZeroDefault(f) {
+		if kind == "string" {
+			line += fmt.Sprintf(" (default %q)", f.DefValue)
+		} else {
+			line += fmt.Sprintf(" (default %v)", f.DefValue)
+		}
+	}
+	fmt.Fprintln(w, line)
+}
+
+// flag.PrintDefaults leaves out zero defaults, so follows suit
+func isZeroDefault(f *flag.Flag) bool {
+	switch f.DefValue {
+	case "", "0", "0s", "false", "[]":
+		return true
+	}
+	return false
+}
+
+// Print the group a topic names, for "gripmock help <topic>"; a flag's name
+// picks its group
+func printHelpTopic(flags *flag.FlagSet, topic string, w io.Writer) error {
+	topic = strings.TrimLeft(topic, "-")
+	for _, g := range flagGroups(flags) {
+		if g.name == topic {
+			printGroup(flags, g, w)
+			return nil
+		}
+	}
+	for _, g := range flagGroups(flags) {
+		for _, name := range g.flags {
+			if name == topic {
+				printGroup(flags, g, w)
+				return nil
+			}
+		}
+	}
+	topics := []string{}
+	for _, g := range flagGroups(flags) {
+		topics = append(topics, g.name)
+	}
+	return fmt.Errorf("no help topic or flag %q, try one of %s", topic, strings.Join(topics, ", "))
+}
+
+// Run "gripmock help" with the arguments after it, and return the exit code
+func runHelp(args []string, flags *flag.FlagSet, stdout, stderr io.Writer) int {
+	switch len(args) {
+	case 0:
+		printUsage(flags, stdout)
+		return 0
+	case 1:
+		if err := printHelpTopic(flags, args[0], stdout); err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXITCODE_ARGUMENTS_ERROR
+		}
+		return 0
+	}
+	fmt.Fprintln(stderr, "Usage: gripmock help [<topic or flag>]")
+	return EXITCODE_ARGUMENTS_ERROR
+}
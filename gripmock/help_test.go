@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFlags() *flag.FlagSet {
+	flags := flag.NewFlagSet("gripmock", flag.ContinueOnError)
+	flags.String("stub", "", "stub files to load (Optional)")
+	flags.String("stub-overlap", "off", "check stubs for overlap: off, warn or reject")
+	flags.Bool("print-ports", false, "print the ports; see -ports-file")
+	flags.Int("verbosity", 1, "log verbosity [0..4], default 1")
+	flags.String("o", "generated", "directory to output generated files")
+	flags.String("grpc-port", "4770", "Port of gRPC tcp server, e.g. 0 for a free one")
+	flags.Bool("shiny-new", false, "not in a group yet")
+	return flags
+}
+
+func Test_printUsage(t *testing.T) {
+	var out bytes.Buffer
+	printUsage(testFlags(), &out)
+	usage := out.String()
+	assert.Contains(t, usage, "  completion      print a bash, zsh or fish completion script\n")
+	// every command's summary starts in the same column, past the longest name
+	column := len("  scaffold-stubs  ")
+	for _, c := range subcommands {
+		line := "  " + c.name + strings.Repeat(" ", column-2-len(c.name)) + c.summary + "\n"
+		assert.Contains(t, usage, line)
+	}
+	assert.Contains(t, usage, `Stubs (gripmock help stubs):
+  -stub string
+    	stub files to load (Optional)
+  -stub-overlap string
+    	check stubs for overlap: off, warn or reject (default "off")
+`)
+	assert.Contains(t, usage, "Serving (gripmock help serving):\n  -grpc-port string\n")
+	assert.Contains(t, usage, "Waiting for startup (gripmock help startup):\n  -print-ports\n    \tprint the ports; see -ports-file\n")
+	assert.Contains(t, usage, "  -verbosity int\n    \tlog verbosity [0..4], default 1 (default 1)\n")
+	// ungrouped flags come last, before the examples
+	assert.Regexp(t, `(?s)Other \(gripmock help other\):\n  -shiny-new\n.*Examples:`, usage)
+	assert.NotContains(t, usage, "TLS", "groups without flags are left out")
+}
+
+func Test_runHelp(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, runHelp([]string{"stubs"}, testFlags(), &stdout, &stderr))
+	assert.Equal(t, `Stubs (gripmock help stubs):
+  -stub string
+    	stub files to load (Optional)
+  -stub-overlap string
+    	check stubs for overlap: off, warn or reject (default "off")
+`, stdout.String())
+
+	// a flag picks its group
+	stdout.Reset()
+	assert.Equal(t, 0, runHelp([]string{"-print-ports"}, testFlags(), &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "Waiting for startup")
+
+	assert.Equal(t, EXITCODE_ARGUMENTS_ERROR, runHelp([]string{"tls"}, testFlags(), &stdout, &stderr))
+	assert.Equal(t, "no help topic or flag \"tls\", try one of stubs, serving, startup, logging, build, other\n", stderr.String())
+}
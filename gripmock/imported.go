@@ -0,0 +1,135 @@
+package main
+
+/*
+ * Services from imported protos.
+ *
+ * Only the services of the protos named on the command line are served,
+ * though an API's top-level file often just imports the files that define
+ * them. With -serve-imports, the services of the protos those import,
+ * directly or indirectly, are served too: the imported protos that define
+ * services are added to the protos to serve, and generated in the gripmock
+ * module as the named ones are.
+ *
+ * For .proto sources, imports are found by scanning the protos' import
+ * statements and looking for the files on -imports; those that aren't
+ * there, such as the well-known types bundled with protoc, aren't served.
+ * For descriptor sets, the files' dependencies are followed within the
+ * sets.
+ */
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var protoServicePattern = regexp.MustCompile(`^\s*service\s+[A-Za-z_]`)
+
+// The proto arguments, followed by the protos on the import dirs they
+// import, directly or indirectly, that define services
+func withImportedServices(protos []string, imports []string) ([]string, error) {
+	seen := map[string]bool{}
+	var queue []string
+	for _, proto := range protos {
+		importDir, rel, err := findProtoInImports(imports, proto)
+		if err != nil {
+			return nil, err
+		}
+		name := protoName(rel, proto)
+		seen[name] = true
+		queue = append(queue, filepath.Join(importDir, filepath.FromSlash(name)))
+	}
+	served := append([]string{}, protos...)
+	for len(queue) > 0 {
+		deps, _, err := scanProto(queue[0])
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		for _, dep := range deps {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			file := findImport(dep, imports)
+			if file == "" {
+				log.V(LOG_DEBUG).Info("imported proto isn't on the import path, not serving it", "import", dep)
+				continue
+			}
+			if _, services, err := scanProto(file); err != nil {
+				return nil, err
+			} else if services {
+				log.V(LOG_VERBOSE).Info("Serving the services of imported proto", "import", dep)
+				served = append(served, dep)
+			}
+			queue = append(queue, file)
+		}
+	}
+	return served, nil
+}
+
+// Find an imported proto on the import dirs, as protoc would. Returns ""
+// if it isn't on any.
+func findImport(name string, imports []string) string {
+	for _, imp := range imports {
+		file := filepath.Join(imp, name)
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return file
+		}
+	}
+	return ""
+}
+
+// A proto's imports, and whether it defines any services
+func scanProto(file string) ([]string, bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	var imports []string
+	services := false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if m := protoImportPattern.FindStringSubmatch(s.Text()); m != nil {
+			imports = append(imports, m[1])
+		} else if protoServicePattern.MatchString(s.Text()) {
+			services = true
+		}
+	}
+	return imports, services, s.Err()
+}
+
+// The names of the files to serve in the set, followed by the files they
+// depend on, directly or indirectly, that define services
+func withImportedServiceFiles(set *descriptorpb.FileDescriptorSet, serve []string) []string {
+	byName := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, f := range set.GetFile() {
+		byName[f.GetName()] = f
+	}
+	seen := map[string]bool{}
+	for _, name := range serve {
+		seen[name] = true
+	}
+	served := append([]string{}, serve...)
+	queue := append([]string{}, serve...)
+	for len(queue) > 0 {
+		f := byName[queue[0]]
+		queue = queue[1:]
+		for _, dep := range f.GetDependency() {
+			if seen[dep] || byName[dep] == nil {
+				continue
+			}
+			seen[dep] = true
+			if len(byName[dep].GetService()) > 0 {
+				log.V(LOG_VERBOSE).Info("Serving the services of imported file", "import", dep)
+				served = append(served, dep)
+			}
+			queue = append(queue, dep)
+		}
+	}
+	return served
+}
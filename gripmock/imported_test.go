@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func Test_withImportedServices(t *testing.T) {
+	initLogging(LOG_ERROR)
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	write("api/api.proto", `syntax = "proto3";
+import "api/orders.proto";
+import public "api/types.proto";
+import "google/protobuf/empty.proto";
+service Api {}
+`)
+	write("api/orders.proto", `syntax = "proto3";
+import "api/types.proto";
+import "api/users.proto";
+service Orders {}
+`)
+	write("api/types.proto", "syntax = \"proto3\";\nmessage Order {}\n")
+	write("api/users.proto", "syntax = \"proto3\";\nservice Users\n{\n}\n")
+
+	served, err := withImportedServices([]string{"api/api.proto"}, []string{dir})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api/api.proto", "api/orders.proto", "api/users.proto"}, served)
+
+	served, err = withImportedServices([]string{"api/api.proto", "api/users.proto"}, []string{dir})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api/api.proto", "api/users.proto", "api/orders.proto"}, served, "served once")
+
+	_, err = withImportedServices([]string{"api/missing.proto"}, []string{dir})
+	assert.Error(t, err)
+}
+
+func Test_withImportedServiceFiles(t *testing.T) {
+	file := func(name string, services int, deps ...string) *descriptorpb.FileDescriptorProto {
+		f := &descriptorpb.FileDescriptorProto{Name: proto.String(name), Dependency: deps}
+		for i := 0; i < services; i++ {
+			f.Service = append(f.Service, &descriptorpb.ServiceDescriptorProto{Name: proto.String("S")})
+		}
+		return f
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		file("google/protobuf/empty.proto", 0),
+		file("api/types.proto", 0),
+		file("api/users.proto", 1, "api/types.proto"),
+		file("api/orders.proto", 1, "api/types.proto", "api/users.proto"),
+		file("api/api.proto", 1, "api/orders.proto", "google/protobuf/empty.proto"),
+		file("other/other.proto", 1),
+	}}
+	assert.Equal(t, []string{"api/api.proto", "api/orders.proto", "api/users.proto"}, withImportedServiceFiles(set, []string{"api/api.proto"}))
+	assert.Equal(t, []string{"other/other.proto"}, withImportedServiceFiles(set, []string{"other/other.proto"}))
+}
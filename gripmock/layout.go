@@ -0,0 +1,87 @@
+package main
+
+/*
+ * Generated module name and layout.
+ *
+ * The generated server is a Go module named GENERATED_MODULE_NAME, with
+ * the server's main package in cmd/ and the protobuf packages at the paths
+ * of their protos. Users who vendor the generated server into their own
+ * repos (see "gripmock export") can name the module with -module so its
+ * packages are importable, put the main package elsewhere with -server-dir,
+ * and put the protobuf packages under a dir with -proto-dir, e.g.
+ * "internal/pb".
+ *
+ * The proto copies with rewritten go_packages stay at the paths protoc
+ * imports them by; only the generated Go files move.
+ */
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// default dir of the generated server's main package
+const DEFAULT_SERVER_DIR = "cmd"
+
+// The zero value is the default layout
+type generatedLayout struct {
+	// module path of the generated go.mod, if not GENERATED_MODULE_NAME
+	module string
+	// dir of the server's main package, relative to the module root, if
+	// not DEFAULT_SERVER_DIR
+	serverDir string
+	// dir the protobuf packages are generated in, relative to the module
+	// root; empty for the root
+	protoDir string
+}
+
+// Check and normalise the -module, -server-dir and -proto-dir flags
+func newGeneratedLayout(module, serverDir, protoDir string) (generatedLayout, error) {
+	l := generatedLayout{module: strings.TrimSuffix(module, "/")}
+	if l.module == "" || strings.HasPrefix(l.module, "/") || strings.ContainsAny(l.module, " \t\\") {
+		return l, fmt.Errorf("module path \"%s\" must be a Go module path, e.g. example.com/mocks", module)
+	}
+	var err error
+	if l.serverDir, err = moduleDir(serverDir); err != nil {
+		return l, fmt.Errorf("server dir %w", err)
+	}
+	if l.protoDir, err = moduleDir(protoDir); err != nil {
+		return l, fmt.Errorf("proto dir %w", err)
+	}
+	if l.serverDir == "" || l.serverDir == l.protoDir {
+		return l, fmt.Errorf("server dir \"%s\" must be a subdir of the module, apart from the proto dir", serverDir)
+	}
+	return l, nil
+}
+
+// A dir within the module, cleaned, with "" for the module root
+func moduleDir(dir string) (string, error) {
+	clean := path.Clean(dir)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(dir, "\\") {
+		return "", fmt.Errorf("\"%s\" must be a relative path within the module", dir)
+	}
+	if clean == "." {
+		return "", nil
+	}
+	return clean, nil
+}
+
+func (l generatedLayout) moduleName() string {
+	if l.module == "" {
+		return GENERATED_MODULE_NAME
+	}
+	return l.module
+}
+
+func (l generatedLayout) serverPackageDir() string {
+	if l.serverDir == "" {
+		return DEFAULT_SERVER_DIR
+	}
+	return l.serverDir
+}
+
+// Go package for the protos in the dir, relative to their import dir
+func (l generatedLayout) goPackage(protoDir string) string {
+	return path.Join(l.moduleName(), l.protoDir, protoDir)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newGeneratedLayout(t *testing.T) {
+	l, err := newGeneratedLayout("example.com/mocks/", "./tools//server", "internal/pb/")
+	require.NoError(t, err)
+	assert.Equal(t, generatedLayout{module: "example.com/mocks", serverDir: "tools/server", protoDir: "internal/pb"}, l)
+	assert.Equal(t, "example.com/mocks/internal/pb/api/v1", l.goPackage("api/v1"))
+	assert.Equal(t, "example.com/mocks/internal/pb", l.goPackage(""))
+
+	l, err = newGeneratedLayout(GENERATED_MODULE_NAME, DEFAULT_SERVER_DIR, "")
+	require.NoError(t, err)
+	assert.Equal(t, generatedLayout{}.goPackage("api"), l.goPackage("api"), "the defaults")
+	assert.Equal(t, GENERATED_MODULE_NAME+"/api", l.goPackage("api"))
+
+	for _, bad := range [][3]string{
+		{"", "cmd", ""},
+		{"/abs", "cmd", ""},
+		{"example.com/mocks", "", ""},
+		{"example.com/mocks", ".", ""},
+		{"example.com/mocks", "../cmd", ""},
+		{"example.com/mocks", "/cmd", ""},
+		{"example.com/mocks", "cmd", "a/../../pb"},
+		{"example.com/mocks", "pb", "pb"},
+	} {
+		_, err := newGeneratedLayout(bad[0], bad[1], bad[2])
+		assert.Error(t, err, "%q", bad)
+	}
+}
+
+func Test_generatedLayout_defaults(t *testing.T) {
+	assert.Equal(t, GENERATED_MODULE_NAME, generatedLayout{}.moduleName())
+	assert.Equal(t, DEFAULT_SERVER_DIR, generatedLayout{}.serverPackageDir())
+}
@@ -0,0 +1,119 @@
+package main
+
+/*
+ * Message size, concurrency and connection limits for the gRPC server.
+ *
+ * grpc-go rejects received messages over 4MiB with RESOURCE_EXHAUSTED,
+ * which surprises tests with large payloads, while real servers often set
+ * other limits clients must cope with. -max-recv-msg-size and
+ * -max-send-msg-size set the message size limits, in bytes,
+ * -max-concurrent-streams the calls a connection may have in flight, and
+ * -max-connections the connections each listener accepts at once; the
+ * server closes connections over the limit as soon as it accepts them.
+ *
+ * Like the keepalive settings they're handed to the generated server as
+ * flags of the same names, so changing them doesn't rebuild it, and zero
+ * values leave the grpc-go defaults.
+ */
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+type limitsConfig struct {
+	maxRecvMsgSize       int
+	maxSendMsgSize       int
+	maxConcurrentStreams uint
+	maxConnections       int
+}
+
+func (l limitsConfig) check() error {
+	if l.maxRecvMsgSize < 0 || l.maxSendMsgSize < 0 || l.maxConnections < 0 {
+		return fmt.Errorf("message sizes and -max-connections must not be negative")
+	}
+	if uint64(l.maxConcurrentStreams) > uint64(^uint32(0)) {
+		return fmt.Errorf("-max-concurrent-streams must fit in 32 bits")
+	}
+	return nil
+}
+
+// Flags for the generated server
+func (l limitsConfig) serverArgs() []string {
+	args := []string{}
+	for _, v := range []struct {
+		flag  string
+		value uint64
+	}{
+		{"max-recv-msg-size", uint64(l.maxRecvMsgSize)},
+		{"max-send-msg-size", uint64(l.maxSendMsgSize)},
+		{"max-concurrent-streams", uint64(l.maxConcurrentStreams)},
+		{"max-connections", uint64(l.maxConnections)},
+	} {
+		if v.value != 0 {
+			args = append(args, "-"+v.flag+"="+strconv.FormatUint(v.value, 10))
+		}
+	}
+	return args
+}
+
+// Options for the in-process server
+func (l limitsConfig) serverOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{}
+	if l.maxRecvMsgSize != 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(l.maxRecvMsgSize))
+	}
+	if l.maxSendMsgSize != 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(l.maxSendMsgSize))
+	}
+	if l.maxConcurrentStreams != 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(uint32(l.maxConcurrentStreams)))
+	}
+	return opts
+}
+
+// The listener, closing connections over -max-connections
+func (l limitsConfig) listener(lis net.Listener) net.Listener {
+	if l.maxConnections == 0 {
+		return lis
+	}
+	return &limitListener{Listener: lis, conns: make(chan struct{}, l.maxConnections)}
+}
+
+type limitListener struct {
+	net.Listener
+	// holds a token per open connection
+	conns chan struct{}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case l.conns <- struct{}{}:
+			return &limitConn{Conn: c, release: func() { <-l.conns }}, nil
+		default:
+			log.V(LOG_VERBOSE).Info("Closing connection over -max-connections", "remote", c.RemoteAddr().String())
+			c.Close()
+		}
+	}
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_limitsConfig(t *testing.T) {
+	assert.Empty(t, limitsConfig{}.serverArgs())
+	assert.Empty(t, limitsConfig{}.serverOptions())
+	limits := limitsConfig{maxRecvMsgSize: 16 << 20, maxConcurrentStreams: 10}
+	require.NoError(t, limits.check())
+	assert.Equal(t, []string{"-max-recv-msg-size=16777216", "-max-concurrent-streams=10"}, limits.serverArgs())
+	assert.Len(t, limits.serverOptions(), 2)
+
+	assert.Error(t, limitsConfig{maxSendMsgSize: -1}.check())
+	assert.Error(t, limitsConfig{maxConcurrentStreams: 1 << 33}.check())
+}
+
+func Test_limitsConfig_listener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Equal(t, inner, limitsConfig{}.listener(inner))
+	lis := limitsConfig{maxConnections: 1}.listener(inner)
+	defer lis.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			c, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	first, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	conn := <-accepted
+
+	// closed by the server, over the limit
+	second, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = second.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	// accepted once the first closes
+	conn.Close()
+	third, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	defer third.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection under the limit not accepted")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+/*
+ * Extra gRPC listeners with per-service routing.
+ *
+ * A client may expect the services gripmock mocks at different addresses,
+ * e.g. a public API on one port and an internal one on another. Each
+ * -listen flag, "<address>=<service>[,<service>...]", adds a listener that
+ * serves the services it names, with or without their package. The main
+ * listener, -grpc-listen and -grpc-port, serves the services no other
+ * listener does. Every listener has health and reflection services for its
+ * own services; calls to services served elsewhere are handled as unknown
+ * methods.
+ *
+ * The generated server takes the listeners as -listen flags of the same
+ * form, so they can change without rebuilding it.
+ */
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+type grpcListener struct {
+	address  string
+	services []string
+}
+
+// Parse the -listen flags
+func parseListeners(specs []string) ([]grpcListener, error) {
+	listeners := []grpcListener{}
+	for _, spec := range specs {
+		address, services, found := strings.Cut(spec, "=")
+		l := grpcListener{address: address}
+		for _, s := range strings.Split(services, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				l.services = append(l.services, s)
+			}
+		}
+		if _, _, err := net.SplitHostPort(address); !found || err != nil || len(l.services) == 0 {
+			return nil, fmt.Errorf("listener \"%s\" must be <address>=<service>[,<service>...], e.g. \":5000=Greeter\" or \"[::1]:5000=Greeter\"", spec)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Whether the listener serves the service with the full name
+func (l grpcListener) serves(fullName string) bool {
+	for _, name := range l.services {
+		if isServiceName(name, fullName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the main listener serves the service with the full name, given
+// the extra listeners
+func mainListenerServes(listeners []grpcListener, fullName string) bool {
+	for _, l := range listeners {
+		if l.serves(fullName) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flags for the generated server
+func listenerArgs(listeners []grpcListener) []string {
+	args := []string{}
+	for _, l := range listeners {
+		args = append(args, "-listen="+l.address+"="+strings.Join(l.services, ","))
+	}
+	return args
+}
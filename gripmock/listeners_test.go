@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseListeners(t *testing.T) {
+	listeners, err := parseListeners([]string{":4780=Greeter, helloworld.Farewell", "127.0.0.1:4781=Admin"})
+	require.NoError(t, err)
+	assert.Equal(t, []grpcListener{
+		{address: ":4780", services: []string{"Greeter", "helloworld.Farewell"}},
+		{address: "127.0.0.1:4781", services: []string{"Admin"}},
+	}, listeners)
+	assert.Equal(t, []string{"-listen=:4780=Greeter,helloworld.Farewell", "-listen=127.0.0.1:4781=Admin"}, listenerArgs(listeners))
+
+	for _, bad := range []string{":4780", ":4780=", "4780=Greeter", ":4780= ,"} {
+		_, err = parseListeners([]string{bad})
+		assert.Error(t, err, bad)
+	}
+
+	none, err := parseListeners(nil)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}
+
+func Test_mainListenerServes(t *testing.T) {
+	listeners := []grpcListener{{address: ":4780", services: []string{"Greeter"}}}
+	assert.True(t, listeners[0].serves("helloworld.Greeter"))
+	assert.False(t, listeners[0].serves("helloworld.Farewell"))
+	assert.False(t, mainListenerServes(listeners, "helloworld.Greeter"))
+	assert.True(t, mainListenerServes(listeners, "helloworld.Farewell"))
+	assert.True(t, mainListenerServes(nil, "helloworld.Greeter"))
+}
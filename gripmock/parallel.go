@@ -0,0 +1,98 @@
+package main
+
+/*
+ * Parallel generation.
+ *
+ * With dozens of protos, generating the server is dominated by work done a
+ * proto or a package at a time, one after another. Instead, the go_package
+ * rewriting of each proto, and the protoc runs that generate each go
+ * package's code, run in a pool of -jobs workers, along with the run of
+ * protoc-gen-gripmock, which sees every proto to generate the one server.
+ *
+ * Protos are generated a go package at a time, as protoc-gen-go expects the
+ * files of a package to be generated together. Each run's protoc output is
+ * written out whole once it exits, so the output of concurrent runs isn't
+ * interleaved. With -jobs=1 everything runs in turn, and a full generation
+ * runs protoc once, as before.
+ */
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Run fn(i) for i in [0, n) on at most jobs goroutines at once. The error
+// returned is that of the lowest i that failed, so it doesn't depend on
+// scheduling; the rest still run.
+func forEachParallel(n, jobs int, fn func(i int) error) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	errs := make([]error, n)
+	sem := make(chan struct{}, jobs)
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Group protos by the go package they're generated into, which is their
+// dir, keeping the order they were given in
+func groupByPackage(protos []string) [][]string {
+	groups := [][]string{}
+	index := map[string]int{}
+	for _, proto := range protos {
+		dir := filepath.Dir(proto)
+		i, ok := index[dir]
+		if !ok {
+			i = len(groups)
+			index[dir] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], proto)
+	}
+	return groups
+}
+
+// Serializes the output of protoc runs written out after they exit
+var protocOutputMx sync.Mutex
+
+// Collects the output of a protoc run, to write out whole
+type protocOutput struct {
+	stdout, stderr bytes.Buffer
+}
+
+// Report errors against the user's files, see protocerrors.go
+func (o *protocOutput) annotate(sources protoSources) {
+	if o.stderr.Len() == 0 {
+		return
+	}
+	annotated := sources.annotate(o.stderr.String())
+	o.stderr.Reset()
+	o.stderr.WriteString(annotated)
+}
+
+func (o *protocOutput) flush() {
+	protocOutputMx.Lock()
+	defer protocOutputMx.Unlock()
+	io.Copy(os.Stdout, &o.stdout)
+	io.Copy(os.Stderr, &o.stderr)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachParallel(t *testing.T) {
+	var running, most atomic.Int32
+	ran := make([]bool, 20)
+	err := forEachParallel(len(ran), 3, func(i int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+		}
+		time.Sleep(time.Millisecond)
+		ran[i] = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.LessOrEqual(t, most.Load(), int32(3))
+	assert.NotContains(t, ran, false)
+
+	// the first failure by index, not by time
+	err = forEachParallel(10, 4, func(i int) error {
+		if i == 2 || i == 7 {
+			time.Sleep(time.Duration(10-i) * time.Millisecond)
+			return fmt.Errorf("failed %d", i)
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "failed 2")
+	assert.NoError(t, forEachParallel(0, 0, nil))
+}
+
+func TestGroupByPackage(t *testing.T) {
+	assert.Equal(t, [][]string{
+		{"out/users/users.proto", "out/users/roles.proto"},
+		{"out/orders/orders.proto"},
+		{"out/top.proto"},
+	}, groupByPackage([]string{"out/users/users.proto", "out/orders/orders.proto", "out/users/roles.proto", "out/top.proto"}))
+	assert.Empty(t, groupByPackage(nil))
+}
+
+func TestFixGoPackagesParallel(t *testing.T) {
+	dir := t.TempDir()
+	output := t.TempDir()
+	protos := []string{}
+	for i := 0; i < 12; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("pkg%d", i%4), fmt.Sprintf("p%d.proto", i))
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		require.NoError(t, os.WriteFile(name, []byte(fmt.Sprintf("syntax = \"proto3\";\nmessage M%d {}\n", i)), 0644))
+		protos = append(protos, name)
+	}
+	// given twice
+	protos = append(protos, protos[0])
+
+	param := protocParam{protoPath: protos, imports: []string{dir}, output: output, jobs: 4}
+	require.NoError(t, fixGoPackages(&param))
+	require.Len(t, param.protoPath, len(protos))
+	for i, proto := range param.protoPath {
+		rel, err := filepath.Rel(dir, protos[i])
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(output, rel), proto, "in the order given")
+		byt, err := os.ReadFile(proto)
+		require.NoError(t, err)
+		assert.Contains(t, string(byt), fmt.Sprintf("option go_package = %q;", param.layout.goPackage(filepath.Dir(rel))))
+	}
+
+	param = protocParam{protoPath: []string{protos[0], "missing.proto"}, imports: []string{dir}, output: output, jobs: 4}
+	assert.Error(t, fixGoPackages(&param))
+}
@@ -0,0 +1,138 @@
+package main
+
+/*
+ * Ephemeral ports and the port report.
+ *
+ * Parallel test jobs on one machine collide on fixed ports. -grpc-port 0,
+ * -admin-port 0 and -admin-grpc-port 0, and a port of 0 in a -listen
+ * address, have the system pick a free port instead. The admin ports are
+ * bound by gripmock itself, and the generated server is told the admin
+ * port it got with its -admin-port flag, so the port it was built with
+ * stays 0 and the build cache still applies. The gRPC listeners are bound
+ * by the server, which reports the addresses it got as a "listening"
+ * event.
+ *
+ * Every time the gRPC server starts, the ports are reported as a JSON
+ * object: on a line of its own on stdout with -print-ports, and written
+ * to -ports-file, which is replaced as a whole so it can be polled for.
+ * A restart or reload binds a port of 0 afresh, so it may move, and the
+ * report is written again.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/ringerc/gripmock/stub"
+)
+
+type portReport struct {
+	// port of the first main gRPC address
+	GrpcPort int `json:"grpc_port"`
+	// main gRPC addresses, as bound
+	GrpcAddresses []string `json:"grpc_addresses"`
+	// the -listen listeners, as bound
+	Listeners []listenerReport `json:"listeners,omitempty"`
+	AdminPort int              `json:"admin_port"`
+	// 0 without -admin-grpc-port
+	AdminGrpcPort int `json:"admin_grpc_port,omitempty"`
+}
+
+type listenerReport struct {
+	Address  string   `json:"address"`
+	Services []string `json:"services"`
+}
+
+// Build the report from the addresses of a "listening" event, the main
+// listeners' first and then those of listeners, and the admin ports
+func newPortReport(bound []string, listeners []grpcListener, admin stub.ServerPorts) (portReport, error) {
+	main := len(bound) - len(listeners)
+	if main < 1 {
+		return portReport{}, fmt.Errorf("the gRPC server reported %d addresses for %d -listen listeners", len(bound), len(listeners))
+	}
+	report := portReport{GrpcAddresses: bound[:main]}
+	for i, l := range listeners {
+		report.Listeners = append(report.Listeners, listenerReport{Address: bound[main+i], Services: l.services})
+	}
+	var err error
+	if report.GrpcPort, err = addressPort(bound[0]); err != nil {
+		return portReport{}, err
+	}
+	if report.AdminPort, err = strconv.Atoi(admin.Admin); err != nil {
+		return portReport{}, err
+	}
+	if admin.GrpcAdmin != "" {
+		if report.AdminGrpcPort, err = strconv.Atoi(admin.GrpcAdmin); err != nil {
+			return portReport{}, err
+		}
+	}
+	return report, nil
+}
+
+func addressPort(address string) (int, error) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(port)
+}
+
+// Reports the ports to stdout and a file, if set
+type portReporter struct {
+	mx     sync.Mutex
+	stdout io.Writer
+	file   string
+}
+
+func (r *portReporter) enabled() bool {
+	return r.stdout != nil || r.file != ""
+}
+
+func (r *portReporter) write(report portReport) error {
+	byt, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	byt = append(byt, '\n')
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.stdout != nil {
+		if _, err := r.stdout.Write(byt); err != nil {
+			return err
+		}
+	}
+	if r.file == "" {
+		return nil
+	}
+	return writeFileAtomic(r.file, byt)
+}
+
+// Write a file by renaming it into place, so a reader never sees it half
+// written
+func writeFileAtomic(file string, byt []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(byt); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
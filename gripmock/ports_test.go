@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ringerc/gripmock/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newPortReport(t *testing.T) {
+	listeners := []grpcListener{{address: ":0", services: []string{"Greeter"}}}
+	report, err := newPortReport([]string{"127.0.0.1:41234", "[::1]:41235", "[::]:40001"}, listeners, stub.ServerPorts{Admin: "39000"})
+	require.NoError(t, err)
+	assert.Equal(t, portReport{
+		GrpcPort:      41234,
+		GrpcAddresses: []string{"127.0.0.1:41234", "[::1]:41235"},
+		Listeners:     []listenerReport{{Address: "[::]:40001", Services: []string{"Greeter"}}},
+		AdminPort:     39000,
+	}, report)
+
+	_, err = newPortReport([]string{"[::]:40001"}, listeners, stub.ServerPorts{Admin: "39000"})
+	assert.EqualError(t, err, "the gRPC server reported 1 addresses for 1 -listen listeners")
+}
+
+func Test_portReporter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ports.json")
+	var out bytes.Buffer
+	r := &portReporter{stdout: &out, file: file}
+	require.True(t, r.enabled())
+	require.NoError(t, r.write(portReport{GrpcPort: 1, GrpcAddresses: []string{"[::]:1"}, AdminPort: 2}))
+	require.NoError(t, r.write(portReport{GrpcPort: 3, GrpcAddresses: []string{"[::]:3"}, AdminPort: 2, AdminGrpcPort: 4}))
+
+	assert.Equal(t, `{"grpc_port":1,"grpc_addresses":["[::]:1"],"admin_port":2}
+{"grpc_port":3,"grpc_addresses":["[::]:3"],"admin_port":2,"admin_grpc_port":4}
+`, out.String())
+	byt, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, `{"grpc_port":3,"grpc_addresses":["[::]:3"],"admin_port":2,"admin_grpc_port":4}`+"\n", string(byt))
+	files, err := os.ReadDir(filepath.Dir(file))
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "no temp files left")
+
+	assert.False(t, (&portReporter{}).enabled())
+}
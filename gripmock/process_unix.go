@@ -0,0 +1,30 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Name of the built gRPC server in the output dir
+const SERVER_BINARY = "server"
+
+// Nothing to set up; the server shares gripmock's process group, so a
+// terminal's Ctrl-C reaches both and a second one is harmless
+func setServerProcAttr(cmd *exec.Cmd) {}
+
+// Ask the gRPC server to drain and stop
+func stopProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
+
+// Freeze the gRPC server for POST /server/pause
+func suspendProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGSTOP)
+}
+
+func continueProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGCONT)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Name of the built gRPC server in the output dir
+const SERVER_BINARY = "server.exe"
+
+// Windows can't signal another process, but it can send a console control
+// event to a process group, which Go delivers as os.Interrupt, so the server
+// is started in a group of its own. That also means a Ctrl-C in the console
+// only reaches gripmock, which stops the server in turn.
+func setServerProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+var generateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// Ask the gRPC server to drain and stop with a Ctrl-Break, or kill it when
+// there's no console to send one on, e.g. as a service
+func stopProcess(p *os.Process) error {
+	if ok, _, err := generateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(p.Pid)); ok == 0 {
+		log.V(LOG_DEBUG).Info("Can't send the gRPC server Ctrl-Break, killing it", "error", err.Error())
+		return p.Kill()
+	}
+	return nil
+}
+
+// Windows has no way to freeze a process from outside it
+func suspendProcess(p *os.Process) error {
+	return fmt.Errorf("Pausing the gRPC server isn't supported on Windows")
+}
+
+func continueProcess(p *os.Process) error {
+	return fmt.Errorf("Resuming the gRPC server isn't supported on Windows")
+}
@@ -0,0 +1,368 @@
+package main
+
+/*
+ * Service profiles.
+ *
+ * A test environment often needs to stand in for several upstream systems at
+ * once, each with its own protos, ports and stubs. Rather than starting a
+ * gripmock for each, -config names a JSON file of named profiles, each the
+ * protos and flags of one mock:
+ *
+ *	{
+ *	  "profiles": {
+ *	    "payments": {
+ *	      "protos": ["payments/api.proto"],
+ *	      "flags": {"grpc-port": 5000, "admin-port": 5001, "stub": "payments/stubs"}
+ *	    },
+ *	    "users": {...}
+ *	  }
+ *	}
+ *
+ * gripmock runs itself once for each profile, in the config file's dir so
+ * paths in it are relative to the file, with each line of its output
+ * prefixed with the profile's name. A signal is passed on to every profile,
+ * and when one exits the others are stopped, so the environment lives and
+ * dies as one.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+type profilesConfig struct {
+	Profiles map[string]profileConfig `json:"profiles"`
+}
+
+type profileConfig struct {
+	// proto files, directories or buf modules, as given on the command
+	// line
+	Protos []string `json:"protos"`
+	// gripmock flags without the leading "-": a string, number or bool
+	// value, or a list of them for a flag that may be repeated
+	Flags map[string]interface{} `json:"flags"`
+}
+
+// One gripmock to run for a profile
+type profile struct {
+	name string
+	args []string
+}
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// flags that select or run profiles, which a profile can't set
+var profileOnlyFlags = map[string]bool{"config": true, "profile": true}
+
+// flags whose values the profiles must not share, as they're listened on
+var profilePortFlags = []string{"grpc-port", "admin-port", "admin-grpc-port"}
+
+// flags naming files each gripmock writes, which the profiles must not
+// share, so they can't be given on the command line
+var profileFileFlags = []string{"ports-file", "ready-file"}
+
+// path flags that take a comma separated list
+var pathListFlags = map[string]bool{"stub": true, "imports": true, "descriptor": true, "server-files": true}
+
+// Read the profiles named in only, comma separated, or all of them if it's
+// empty, from the config file, and work out the arguments to run each with.
+// The flags set on the command line are passed to every profile after its
+// own, with paths made absolute as the profiles run in the config file's
+// dir, apart from -o: each profile's output dir defaults to a dir named for
+// it in -o.
+func loadProfiles(file string, only string, flags *flag.FlagSet) ([]profile, error) {
+	byt, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := profilesConfig{}
+	dec := json.NewDecoder(bytes.NewReader(byt))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if len(config.Profiles) == 0 {
+		return nil, fmt.Errorf("%s has no profiles", file)
+	}
+
+	names := []string{}
+	if only != "" {
+		for _, name := range strings.Split(only, ",") {
+			if _, ok := config.Profiles[name]; !ok {
+				return nil, fmt.Errorf("%s has no profile \"%s\"", file, name)
+			}
+			names = append(names, name)
+		}
+	} else {
+		for name := range config.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	common := []string{}
+	output := flags.Lookup("o").Value.String()
+	shared := ""
+	flags.Visit(func(f *flag.Flag) {
+		for _, name := range profileFileFlags {
+			if f.Name == name {
+				shared = name
+			}
+		}
+		if !profileOnlyFlags[f.Name] && f.Name != "o" {
+			common = append(common, "-"+f.Name+"="+absFlagValue(f.Name, f.Value.String()))
+		}
+	})
+	if shared != "" {
+		return nil, fmt.Errorf("-%s can't be given with -config, as every profile would write it, set it in each profile's flags instead", shared)
+	}
+	if abs, err := filepath.Abs(output); err == nil {
+		output = abs
+	}
+
+	profiles := []profile{}
+	// profile listening on each port
+	ports := map[string]string{}
+	// profile writing each file
+	files := map[string]string{}
+	for _, name := range names {
+		if !profileNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("profile \"%s\" must be named with letters, digits, '_', '.' and '-'", name)
+		}
+		pc := config.Profiles[name]
+		args, err := profileArgs(pc.Flags, flags)
+		if err != nil {
+			return nil, fmt.Errorf("profile \"%s\": %w", name, err)
+		}
+		if _, ok := pc.Flags["o"]; !ok {
+			args = append(args, "-o="+filepath.Join(output, name))
+		}
+		args = append(args, common...)
+
+		// the last value of each flag is the one used
+		values := flag.NewFlagSet(name, flag.ContinueOnError)
+		values.SetOutput(io.Discard)
+		for _, f := range append(profilePortFlags, profileFileFlags...) {
+			values.String(f, flags.Lookup(f).DefValue, "")
+		}
+		for _, arg := range args {
+			k, _, _ := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+			if values.Lookup(k) != nil {
+				values.Parse([]string{arg})
+			}
+		}
+		for _, f := range profilePortFlags {
+			port := values.Lookup(f).Value.String()
+			if port == "" || port == "0" {
+				continue
+			}
+			if other, ok := ports[port]; ok {
+				return nil, fmt.Errorf("profiles \"%s\" and \"%s\" both listen on port %s, give each its own -grpc-port, -admin-port and -admin-grpc-port, or 0 for a free one", other, name, port)
+			}
+			ports[port] = name
+		}
+		for _, f := range profileFileFlags {
+			path := values.Lookup(f).Value.String()
+			if path == "" {
+				continue
+			}
+			path = filepath.Clean(path)
+			if other, ok := files[path]; ok {
+				return nil, fmt.Errorf("profiles \"%s\" and \"%s\" both write %s, give each its own -ports-file and -ready-file", other, name, path)
+			}
+			files[path] = name
+		}
+
+		profiles = append(profiles, profile{name: name, args: append(args, pc.Protos...)})
+	}
+	return profiles, nil
+}
+
+// A flag's value with each path in it made absolute. URLs, and - for
+// stdin, are left as they are.
+func absFlagValue(name, value string) string {
+	c, ok := valueCompletions[name]
+	if !ok || (c.kind != COMPLETE_DIR && c.kind != COMPLETE_FILE) || value == "" {
+		return value
+	}
+	paths := []string{value}
+	if pathListFlags[name] {
+		paths = strings.Split(value, ",")
+	}
+	for i, path := range paths {
+		if path == "" || path == "-" || strings.Contains(path, "://") {
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			paths[i] = abs
+		}
+	}
+	return strings.Join(paths, ",")
+}
+
+// The command line arguments for a profile's flags, sorted by name
+func profileArgs(values map[string]interface{}, flags *flag.FlagSet) ([]string, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{}
+	for _, name := range names {
+		if flags.Lookup(name) == nil || profileOnlyFlags[name] {
+			return nil, fmt.Errorf("unknown flag \"%s\"", name)
+		}
+		value := values[name]
+		list, ok := value.([]interface{})
+		if !ok {
+			list = []interface{}{value}
+		}
+		for _, v := range list {
+			var s string
+			switch v := v.(type) {
+			case string:
+				s = v
+			case bool:
+				s = strconv.FormatBool(v)
+			case float64:
+				s = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				return nil, fmt.Errorf("flag \"%s\" must be a string, number or bool, or a list of them", name)
+			}
+			args = append(args, "-"+name+"="+s)
+		}
+	}
+	return args, nil
+}
+
+// Run a gripmock for each profile, in dir, until one of them exits or
+// gripmock is signalled, stopping the rest, and return the exit code of the
+// first to fail, if any
+func runProfiles(profiles []profile, dir string) int {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Error(err, "finding the gripmock executable to run the profiles with")
+		return EXITCODE_OTHER_ERROR
+	}
+
+	var mu sync.Mutex
+	type exit struct {
+		name string
+		err  error
+	}
+	exits := make(chan exit, len(profiles))
+	cmds := []*exec.Cmd{}
+	outputs := []*prefixWriter{}
+	stopAll := func() {
+		for _, cmd := range cmds {
+			if err := stopProcess(cmd.Process); err != nil {
+				log.V(LOG_DEBUG).Info("stopping profile", "pid", cmd.Process.Pid, "error", err.Error())
+			}
+		}
+	}
+
+	for _, p := range profiles {
+		log.V(LOG_INFO).Info("Starting profile", "profile", p.name, "args", p.args)
+		out := &prefixWriter{mu: &mu, w: os.Stdout, prefix: "[" + p.name + "] "}
+		errOut := &prefixWriter{mu: &mu, w: os.Stderr, prefix: "[" + p.name + "] "}
+		outputs = append(outputs, out, errOut)
+		cmd := exec.Command(exe, p.args...)
+		cmd.Dir = dir
+		cmd.Stdout = out
+		cmd.Stderr = errOut
+		setServerProcAttr(cmd)
+		if err := cmd.Start(); err != nil {
+			log.Error(err, "starting profile", "profile", p.name)
+			stopAll()
+			return EXITCODE_OTHER_ERROR
+		}
+		cmds = append(cmds, cmd)
+		go func(name string, cmd *exec.Cmd) {
+			exits <- exit{name, cmd.Wait()}
+		}(p.name, cmd)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	code := 0
+	stopping := false
+	for running := len(cmds); running > 0; {
+		select {
+		case sig := <-signals:
+			log.V(LOG_INFO).Info("Caught signal, stopping the profiles", "signal", sig.String())
+			stopping = true
+			stopAll()
+		case e := <-exits:
+			running--
+			if e.err != nil && code == 0 {
+				code = EXITCODE_RUNTIME_ERROR
+				if exitErr, ok := e.err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+					code = exitErr.ExitCode()
+				}
+			}
+			if !stopping {
+				log.V(LOG_INFO).Info("Profile exited, stopping the others", "profile", e.name, "error", e.err)
+				stopping = true
+				stopAll()
+			}
+		}
+	}
+	for _, out := range outputs {
+		out.flush()
+	}
+	return code
+}
+
+// Writes each line written to it to w, with a prefix, leaving a partial
+// line until the rest of it is written or it's flushed. Writers sharing mu
+// don't interleave their lines.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.mu.Lock()
+		_, err := fmt.Fprintf(p.w, "%s%s", p.prefix, p.buf[:i+1])
+		p.mu.Unlock()
+		p.buf = p.buf[i+1:]
+		if err != nil {
+			return len(b), err
+		}
+	}
+	return len(b), nil
+}
+
+func (p *prefixWriter) flush() {
+	if len(p.buf) == 0 {
+		return
+	}
+	p.mu.Lock()
+	fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.buf)
+	p.mu.Unlock()
+	p.buf = nil
+}
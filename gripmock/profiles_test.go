@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadProfiles(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "mocks.json")
+	write := func(config string) {
+		require.NoError(t, os.WriteFile(file, []byte(config), 0644))
+	}
+	flags := func(args ...string) *flag.FlagSet {
+		fs := flag.NewFlagSet("gripmock", flag.ContinueOnError)
+		fs.String("o", "generated", "")
+		fs.String("grpc-port", "4770", "")
+		fs.String("admin-port", "4771", "")
+		fs.String("admin-grpc-port", "", "")
+		fs.Bool("dynamic", false, "")
+		fs.Int("verbosity", 0, "")
+		fs.Var(&stringList{}, "listen", "")
+		fs.String("config", "", "")
+		fs.String("profile", "", "")
+		fs.String("stub", "", "")
+		fs.String("imports", "", "")
+		fs.String("tls-cert", "", "")
+		fs.String("ports-file", "", "")
+		fs.String("ready-file", "", "")
+		require.NoError(t, fs.Parse(args))
+		return fs
+	}
+	out, err := filepath.Abs("generated")
+	require.NoError(t, err)
+
+	write(`{"profiles": {
+		"users": {"protos": ["users/api.proto"], "flags": {"grpc-port": 5010, "admin-port": "5011"}},
+		"payments": {"protos": ["payments/api.proto", "payments/ext.proto"], "flags": {"grpc-port": 5000, "admin-port": 5001, "dynamic": true, "listen": ["unix:///tmp/a", "tcp://:6000"]}}
+	}}`)
+	profiles, err := loadProfiles(file, "", flags("-config", file, "-verbosity", "2"))
+	require.NoError(t, err)
+	assert.Equal(t, []profile{
+		{"payments", []string{"-admin-port=5001", "-dynamic=true", "-grpc-port=5000", "-listen=unix:///tmp/a", "-listen=tcp://:6000",
+			"-o=" + filepath.Join(out, "payments"), "-verbosity=2", "payments/api.proto", "payments/ext.proto"}},
+		{"users", []string{"-admin-port=5011", "-grpc-port=5010", "-o=" + filepath.Join(out, "users"), "-verbosity=2", "users/api.proto"}},
+	}, profiles)
+
+	profiles, err = loadProfiles(file, "users", flags("-o", "/tmp/mocks"))
+	require.NoError(t, err)
+	require.Len(t, profiles, 1)
+	assert.Contains(t, profiles[0].args, "-o="+filepath.Join("/tmp/mocks", "users"))
+
+	// paths on the command line are relative to the working dir, not the
+	// config file's
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	profiles, err = loadProfiles(file, "users", flags("-stub", "stubs,/abs/stubs,https://example.com/s.json,-", "-imports", "protos", "-tls-cert", "certs/cert.pem"))
+	require.NoError(t, err)
+	assert.Contains(t, profiles[0].args, "-stub="+filepath.Join(wd, "stubs")+",/abs/stubs,https://example.com/s.json,-")
+	assert.Contains(t, profiles[0].args, "-imports="+filepath.Join(wd, "protos"))
+	assert.Contains(t, profiles[0].args, "-tls-cert="+filepath.Join(wd, "certs/cert.pem"))
+
+	_, err = loadProfiles(file, "", flags("-ports-file", "ports.json"))
+	assert.ErrorContains(t, err, "-ports-file can't be given with -config")
+	_, err = loadProfiles(file, "", flags("-ready-file", "ready"))
+	assert.ErrorContains(t, err, "-ready-file can't be given with -config")
+
+	_, err = loadProfiles(file, "orders", flags())
+	assert.ErrorContains(t, err, "has no profile \"orders\"")
+	_, err = loadProfiles(file, "", flags("-grpc-port", "7000"))
+	assert.ErrorContains(t, err, "profiles \"payments\" and \"users\" both listen on port 7000")
+
+	write(`{"profiles": {"a": {"protos": ["a.proto"]}, "b": {"protos": ["b.proto"]}}}`)
+	_, err = loadProfiles(file, "", flags())
+	assert.ErrorContains(t, err, "profiles \"a\" and \"b\" both listen on port 4770")
+	_, err = loadProfiles(file, "", flags("-grpc-port", "0", "-admin-port", "0"))
+	assert.NoError(t, err, "free ports")
+
+	write(`{"profiles": {"a": {"flags": {"ready-file": "ready", "grpc-port": 0}}, "b": {"flags": {"ready-file": "./ready", "grpc-port": 0}}}}`)
+	_, err = loadProfiles(file, "", flags("-admin-port", "0"))
+	assert.ErrorContains(t, err, "profiles \"a\" and \"b\" both write ready")
+
+	write(`{"profiles": {"a": {"flags": {"frob": true}}}}`)
+	_, err = loadProfiles(file, "", flags())
+	assert.ErrorContains(t, err, "profile \"a\": unknown flag \"frob\"")
+	write(`{"profiles": {"a": {"flags": {"config": "other.json"}}}}`)
+	_, err = loadProfiles(file, "", flags())
+	assert.ErrorContains(t, err, "profile \"a\": unknown flag \"config\"")
+	write(`{"profiles": {"a": {"flags": {"grpc-port": {"port": 1}}}}}`)
+	_, err = loadProfiles(file, "", flags())
+	assert.ErrorContains(t, err, "flag \"grpc-port\" must be a string, number or bool")
+	write(`{"profiles": {"../a": {}}}`)
+	_, err = loadProfiles(file, "", flags())
+	assert.ErrorContains(t, err, "must be named with letters")
+	write(`{"profile": {}}`)
+	_, err = loadProfiles(file, "", flags())
+	assert.ErrorContains(t, err, "unknown field \"profile\"")
+	write(`{"profiles": {}}`)
+	_, err = loadProfiles(file, "", flags())
+	assert.ErrorContains(t, err, "has no profiles")
+}
+
+func Test_prefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	a := &prefixWriter{mu: &mu, w: &buf, prefix: "[a] "}
+	b := &prefixWriter{mu: &mu, w: &buf, prefix: "[b] "}
+	a.Write([]byte("one\ntw"))
+	b.Write([]byte("three\n"))
+	a.Write([]byte("o\nfour"))
+	a.flush()
+	b.flush()
+	assert.Equal(t, "[a] one\n[b] three\n[a] two\n[a] four\n", buf.String())
+}
@@ -0,0 +1,439 @@
+package main
+
+/*
+ * The embedded protoc.
+ *
+ * gripmock compiles protos with bufbuild/protocompile, in process, so it
+ * needs no protoc installed: -dynamic, check and scaffold-stubs need
+ * nothing but gripmock, and generating the server needs only the Go
+ * plugins, protoc-gen-go, protoc-gen-go-grpc and protoc-gen-gripmock.
+ *
+ * runProtoc takes protoc's arguments, as many as gripmock passes it and
+ * -protoc-arg is likely to add:
+ *
+ *   -I <dir>, -I<dir>, --proto_path=<dir>
+ *   --descriptor_set_in=<files>, --descriptor_set_out=<file>,
+ *   --include_imports, --include_source_info
+ *   --<name>_out=[<params>:]<dir>, --<name>_opt=<params>,
+ *   --plugin=protoc-gen-<name>=<path>
+ *
+ * and the protos, named as protoc names them: by their path within an
+ * import dir, found from a path to the file, or for protos only in the
+ * descriptor sets, their name there. Plugins are run as protoc runs them,
+ * given a CodeGeneratorRequest on stdin, and the files of the
+ * CodeGeneratorResponse they answer with are written to their output dir.
+ *
+ * Errors and warnings are written in protoc's "file:line:col: message"
+ * format, so they're reported against the user's protos the same way, see
+ * protocerrors.go. With -protoc naming a protoc binary, e.g. -protoc protoc,
+ * gripmock runs that instead, for arguments the embedded one doesn't take.
+ */
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/bufbuild/protocompile/reporter"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// -protoc value for the embedded compiler
+const PROTOC_EMBEDDED = "embedded"
+
+// Run protoc, the embedded one or the binary -protoc names, reporting its
+// errors against sources
+func runProtoc(protoc string, args []string, sources protoSources) error {
+	out := &protocOutput{}
+	var err error
+	if protoc == PROTOC_EMBEDDED || protoc == "" {
+		log.V(LOG_VERBOSE).Info("compiling protos", "args", args)
+		err = embeddedProtoc(args, &out.stderr)
+	} else {
+		cmd := exec.Command(protoc, args...)
+		cmd.Stdout = &out.stdout
+		cmd.Stderr = &out.stderr
+		log.V(LOG_VERBOSE).Info("invoking \"protoc\"", "cmd", cmd.String())
+		err = cmd.Run()
+	}
+	out.annotate(sources)
+	out.flush()
+	if err != nil {
+		return fmt.Errorf("running protoc: %w", err)
+	}
+	return nil
+}
+
+// Hash which protoc compiles the protos: the embedded one changes with
+// gripmock, which is hashed already, a binary with its file
+func hashProtoc(h hash.Hash, protoc string) {
+	fmt.Fprintf(h, "protoc %q\n", protoc)
+	if protoc == PROTOC_EMBEDDED || protoc == "" {
+		return
+	}
+	if found, err := exec.LookPath(protoc); err == nil {
+		hashFileStat(h, found)
+	}
+}
+
+// A plugin's output dir and parameters, by plugin name
+type protocPlugin struct {
+	name   string
+	out    string
+	params []string
+	// path of the plugin, if not protoc-gen-<name> on the PATH
+	path string
+}
+
+type protocInvocation struct {
+	importDirs     []string
+	descriptorSets []string
+	setOut         string
+	includeImports bool
+	sourceInfo     bool
+	// in the order of their _out arguments, which is the order they run in
+	plugins []*protocPlugin
+	files   []string
+}
+
+func parseProtocArgs(args []string) (*protocInvocation, error) {
+	p := &protocInvocation{}
+	plugins := map[string]*protocPlugin{}
+	plugin := func(name string) *protocPlugin {
+		if plugins[name] == nil {
+			plugins[name] = &protocPlugin{name: name}
+		}
+		return plugins[name]
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			p.files = append(p.files, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch {
+		case arg == "-I" || arg == "--proto_path":
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("%s needs a directory", arg)
+			}
+			i++
+			p.importDirs = append(p.importDirs, args[i])
+		case strings.HasPrefix(arg, "-I"):
+			p.importDirs = append(p.importDirs, arg[2:])
+		case name == "--proto_path" && hasValue:
+			p.importDirs = append(p.importDirs, value)
+		case name == "--descriptor_set_in" && hasValue:
+			p.descriptorSets = append(p.descriptorSets, filepath.SplitList(value)...)
+		case name == "--descriptor_set_out" && hasValue:
+			p.setOut = value
+		case arg == "--include_imports":
+			p.includeImports = true
+		case arg == "--include_source_info":
+			p.sourceInfo = true
+		case name == "--plugin" && hasValue:
+			pluginName, pluginPath, ok := strings.Cut(value, "=")
+			if !ok {
+				pluginPath = pluginName
+				pluginName = filepath.Base(pluginName)
+			}
+			pluginName = strings.TrimSuffix(strings.TrimPrefix(pluginName, "protoc-gen-"), ".exe")
+			plugin(pluginName).path = pluginPath
+		case strings.HasSuffix(name, "_out") && hasValue:
+			pl := plugin(strings.TrimSuffix(strings.TrimPrefix(name, "--"), "_out"))
+			// params before a colon, but not a Windows drive's
+			if i := strings.Index(value, ":"); i > 1 {
+				pl.params = append(pl.params, value[:i])
+				value = value[i+1:]
+			}
+			pl.out = value
+			p.plugins = append(p.plugins, pl)
+		case strings.HasSuffix(name, "_opt") && hasValue:
+			pl := plugin(strings.TrimSuffix(strings.TrimPrefix(name, "--"), "_opt"))
+			pl.params = append(pl.params, value)
+		default:
+			return nil, fmt.Errorf("the embedded protoc doesn't support %s, -protoc can name a protoc binary to run instead", arg)
+		}
+	}
+	if len(p.files) == 0 {
+		return nil, errors.New("no protos to compile")
+	}
+	if p.setOut == "" && len(p.plugins) == 0 {
+		return nil, errors.New("nothing to output, no --descriptor_set_out or --<plugin>_out")
+	}
+	return p, nil
+}
+
+// Compile the protos and run the plugins the args name, as protoc would.
+// Errors in the protos are written to stderr, as protoc writes them.
+func embeddedProtoc(args []string, stderr io.Writer) error {
+	p, err := parseProtocArgs(args)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return err
+	}
+
+	sets := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, file := range p.descriptorSets {
+		byt, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			return err
+		}
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(byt, set); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			return err
+		}
+		for _, fd := range set.File {
+			if sets[fd.GetName()] == nil {
+				sets[fd.GetName()] = fd
+			}
+		}
+	}
+	names, err := p.protoNames(sets)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return err
+	}
+
+	// the import dirs first, then the descriptor sets, as protoc
+	// searches them, then the protos protoc comes with
+	sourceResolver := &protocompile.SourceResolver{ImportPaths: p.importDirs}
+	resolver := protocompile.WithStandardImports(protocompile.ResolverFunc(func(name string) (protocompile.SearchResult, error) {
+		if len(p.importDirs) > 0 {
+			res, err := sourceResolver.FindFileByPath(name)
+			if err == nil || !errors.Is(err, os.ErrNotExist) {
+				return res, err
+			}
+		}
+		if fd, ok := sets[name]; ok {
+			return protocompile.SearchResult{Proto: fd}, nil
+		}
+		return protocompile.SearchResult{}, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}))
+	compiler := protocompile.Compiler{
+		Resolver:       resolver,
+		SourceInfoMode: protocompile.SourceInfoStandard,
+		Reporter: reporter.NewReporter(func(err reporter.ErrorWithPos) error {
+			fmt.Fprintln(stderr, err.Error())
+			// carry on, to report every error at once
+			return nil
+		}, func(err reporter.ErrorWithPos) {
+			fmt.Fprintf(stderr, "%s: warning: %v\n", err.GetPosition(), err.Unwrap())
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), names...)
+	if err != nil {
+		if !errors.Is(err, reporter.ErrInvalidSource) {
+			fmt.Fprintln(stderr, err)
+		}
+		return err
+	}
+
+	// every file, dependencies first, as protoc gives them to plugins
+	all := []*descriptorpb.FileDescriptorProto{}
+	seen := map[string]bool{}
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		for i := 0; i < fd.Imports().Len(); i++ {
+			add(fd.Imports().Get(i).FileDescriptor)
+		}
+		if original, ok := sets[fd.Path()]; ok && !isCompiledFrom(fd) {
+			all = append(all, original)
+			return
+		}
+		all = append(all, fileDescriptorProto(fd))
+	}
+	for _, f := range files {
+		add(f)
+	}
+
+	if p.setOut != "" {
+		if err := p.writeDescriptorSet(all, names); err != nil {
+			fmt.Fprintln(stderr, err)
+			return err
+		}
+	}
+	for _, pl := range p.plugins {
+		if err := pl.run(all, names, stderr); err != nil {
+			fmt.Fprintf(stderr, "--%s_out: %v\n", pl.name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether a file was compiled from source, rather than taken from a
+// descriptor set
+func isCompiledFrom(fd protoreflect.FileDescriptor) bool {
+	res, ok := fd.(linker.Result)
+	return ok && res.AST() != nil
+}
+
+func fileDescriptorProto(fd protoreflect.FileDescriptor) *descriptorpb.FileDescriptorProto {
+	if res, ok := fd.(linker.Result); ok {
+		return res.FileDescriptorProto()
+	}
+	return protodesc.ToFileDescriptorProto(fd)
+}
+
+// The names of the protos to compile: a path to a proto is named by its
+// path within the first import dir it's in, and anything else that isn't a
+// file is taken to be a name already, in an import dir or descriptor set
+func (p *protocInvocation) protoNames(sets map[string]*descriptorpb.FileDescriptorProto) ([]string, error) {
+	names := []string{}
+	for _, file := range p.files {
+		name, err := p.protoName(file)
+		if err != nil && !filepath.IsAbs(file) {
+			if _, statErr := os.Stat(file); statErr != nil && p.isName(filepath.ToSlash(file), sets) {
+				name, err = filepath.ToSlash(file), nil
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (p *protocInvocation) isName(name string, sets map[string]*descriptorpb.FileDescriptorProto) bool {
+	if _, ok := sets[name]; ok {
+		return true
+	}
+	for _, dir := range p.importDirs {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *protocInvocation) protoName(file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range p.importDirs {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(absDir, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return "", fmt.Errorf("%s: %w", file, err)
+		}
+		return filepath.ToSlash(rel), nil
+	}
+	return "", fmt.Errorf("%s: File does not reside within any path specified using --proto_path (or -I)", file)
+}
+
+func (p *protocInvocation) writeDescriptorSet(all []*descriptorpb.FileDescriptorProto, names []string) error {
+	set := &descriptorpb.FileDescriptorSet{}
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	for _, fd := range all {
+		if !p.includeImports && !wanted[fd.GetName()] {
+			continue
+		}
+		if !p.sourceInfo && fd.SourceCodeInfo != nil {
+			fd = proto.Clone(fd).(*descriptorpb.FileDescriptorProto)
+			fd.SourceCodeInfo = nil
+		}
+		set.File = append(set.File, fd)
+	}
+	byt, err := proto.Marshal(set)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.setOut, byt, 0644)
+}
+
+// Run a plugin as protoc does, and write the files it generates
+func (pl *protocPlugin) run(all []*descriptorpb.FileDescriptorProto, names []string, stderr io.Writer) error {
+	req := &pluginpb.CodeGeneratorRequest{FileToGenerate: names, ProtoFile: all}
+	if len(pl.params) > 0 {
+		req.Parameter = proto.String(strings.Join(pl.params, ","))
+	}
+	byt, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	bin := pl.path
+	if bin == "" {
+		bin = "protoc-gen-" + pl.name
+	}
+	cmd := exec.Command(bin)
+	cmd.Stdin = bytes.NewReader(byt)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = stderr
+	log.V(LOG_VERBOSE).Info("invoking plugin", "cmd", cmd.String(), "parameter", req.GetParameter())
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", bin, err)
+	}
+	resp := &pluginpb.CodeGeneratorResponse{}
+	if err := proto.Unmarshal(out.Bytes(), resp); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", bin, err)
+	}
+	if resp.Error != nil {
+		return errors.New(resp.GetError())
+	}
+
+	// a file without a name continues the one before it
+	var name string
+	var content strings.Builder
+	write := func() error {
+		if name == "" {
+			return nil
+		}
+		file := filepath.Join(pl.out, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+			return err
+		}
+		return os.WriteFile(file, []byte(content.String()), 0644)
+	}
+	for _, f := range resp.File {
+		if f.GetInsertionPoint() != "" {
+			return fmt.Errorf("%s: insertion points aren't supported", f.GetName())
+		}
+		if f.Name == nil {
+			content.WriteString(f.GetContent())
+			continue
+		}
+		if err := write(); err != nil {
+			return err
+		}
+		name = path.Clean(f.GetName())
+		if strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return fmt.Errorf("%s: file name outside the output dir", f.GetName())
+		}
+		content.Reset()
+		content.WriteString(f.GetContent())
+	}
+	return write()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func Test_parseProtocArgs(t *testing.T) {
+	p, err := parseProtocArgs([]string{
+		"-I", "out", "-Iprotos", "--proto_path=more",
+		"--descriptor_set_in=" + strings.Join([]string{"a.pb", "b.pb"}, string(os.PathListSeparator)),
+		"--go_out=out", "--go_opt=module=example.com/m",
+		"--gripmock_out=paths=source_relative:gen", "--gripmock_opt=admin-port=4771",
+		"--plugin=protoc-gen-gripmock=/bin/gm",
+		"api.proto",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"out", "protos", "more"}, p.importDirs)
+	assert.Equal(t, []string{"a.pb", "b.pb"}, p.descriptorSets)
+	assert.Equal(t, []string{"api.proto"}, p.files)
+	require.Len(t, p.plugins, 2)
+	assert.Equal(t, protocPlugin{name: "go", out: "out", params: []string{"module=example.com/m"}}, *p.plugins[0])
+	assert.Equal(t, protocPlugin{name: "gripmock", out: "gen", params: []string{"paths=source_relative", "admin-port=4771"}, path: "/bin/gm"}, *p.plugins[1])
+
+	_, err = parseProtocArgs([]string{"--go_out=out", "--experimental_allow_proto3_optional", "api.proto"})
+	assert.ErrorContains(t, err, "the embedded protoc doesn't support --experimental_allow_proto3_optional")
+	_, err = parseProtocArgs([]string{"-I", "protos", "api.proto"})
+	assert.ErrorContains(t, err, "nothing to output")
+}
+
+func Test_embeddedProtoc(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "shop"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "shop", "types.proto"), []byte(`syntax = "proto3";
+package shop;
+import "google/protobuf/timestamp.proto";
+message Order { string id = 1; google.protobuf.Timestamp at = 2; }
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "shop", "api.proto"), []byte(`syntax = "proto3";
+package shop;
+import "shop/types.proto";
+service Orders { rpc Get(Order) returns (Order); }
+`), 0644))
+
+	read := func(file string) []string {
+		byt, err := os.ReadFile(file)
+		require.NoError(t, err)
+		set := &descriptorpb.FileDescriptorSet{}
+		require.NoError(t, proto.Unmarshal(byt, set))
+		names := []string{}
+		for _, fd := range set.File {
+			names = append(names, fd.GetName())
+			assert.Nil(t, fd.SourceCodeInfo)
+		}
+		return names
+	}
+
+	// named by their path within the import dir
+	var stderr bytes.Buffer
+	out := filepath.Join(dir, "set.pb")
+	require.NoError(t, embeddedProtoc([]string{"-I", dir, "--include_imports", "--descriptor_set_out=" + out, filepath.Join(dir, "shop", "api.proto")}, &stderr), stderr.String())
+	assert.Equal(t, []string{"google/protobuf/timestamp.proto", "shop/types.proto", "shop/api.proto"}, read(out))
+	require.NoError(t, embeddedProtoc([]string{"-I", dir, "--descriptor_set_out=" + out, "shop/api.proto"}, &stderr), stderr.String())
+	assert.Equal(t, []string{"shop/api.proto"}, read(out))
+
+	// or by their name in a descriptor set
+	all := filepath.Join(dir, "all.pb")
+	require.NoError(t, embeddedProtoc([]string{"-I", dir, "--include_imports", "--descriptor_set_out=" + all, "shop/api.proto"}, &stderr))
+	require.NoError(t, embeddedProtoc([]string{"--descriptor_set_in=" + all, "--include_imports", "--descriptor_set_out=" + out, "shop/api.proto"}, &stderr), stderr.String())
+	assert.Equal(t, []string{"google/protobuf/timestamp.proto", "shop/types.proto", "shop/api.proto"}, read(out))
+
+	// errors as protoc reports them
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "shop", "bad.proto"), []byte("syntax = \"proto3\";\nmessage Bad {\n  Missing m = 1;\n  Gone g = 2;\n}\n"), 0644))
+	stderr.Reset()
+	assert.Error(t, embeddedProtoc([]string{"-I", dir, "--descriptor_set_out=" + out, "shop/bad.proto"}, &stderr))
+	assert.Equal(t, "shop/bad.proto:3:3: field Bad.m: unknown type Missing\nshop/bad.proto:4:3: field Bad.g: unknown type Gone\n", stderr.String())
+	stderr.Reset()
+	assert.Error(t, embeddedProtoc([]string{"-I", filepath.Join(dir, "shop"), "--descriptor_set_out=" + out, filepath.Join(dir, "elsewhere.proto")}, &stderr))
+	assert.Contains(t, stderr.String(), "File does not reside within any path specified using --proto_path")
+}
+
+func Test_embeddedProtoc_plugin(t *testing.T) {
+	if _, err := exec.LookPath("protoc-gen-go"); err != nil {
+		t.Skip("needs protoc-gen-go")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello.proto"), []byte(`syntax = "proto3";
+package hello;
+option go_package = "example.com/hello";
+// A greeting
+message Hello { string name = 1; }
+`), 0644))
+	out := filepath.Join(dir, "out")
+	require.NoError(t, os.Mkdir(out, 0755))
+	var stderr bytes.Buffer
+	require.NoError(t, embeddedProtoc([]string{"-I", dir, "--go_out=" + out, "--go_opt=module=example.com", filepath.Join(dir, "hello.proto")}, &stderr), stderr.String())
+	byt, err := os.ReadFile(filepath.Join(out, "hello", "hello.pb.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(byt), "// A greeting\ntype Hello struct", "with the comments of the source info")
+
+	stderr.Reset()
+	assert.Error(t, embeddedProtoc([]string{"-I", dir, "--go_out=" + out, "--go_opt=bogus=1", filepath.Join(dir, "hello.proto")}, &stderr))
+	assert.Contains(t, stderr.String(), "--go_out: ")
+}
@@ -0,0 +1,197 @@
+package main
+
+/*
+ * protoc error presentation.
+ *
+ * protoc compiles the copies of the protos rewritten into the output dir,
+ * see fixGoPackages, and names files as it found them on the import path,
+ * so its errors point at the copies, and at lines moved by a go_package
+ * option that was added. Before protoc's stderr is written out, errors are
+ * mapped back to the user's files, by path, and each is followed by the
+ * line it's about, with a caret under the column:
+ *
+ *   protos/hello.proto:12:3: "Foo" is not defined.
+ *     12 |   Foo foo = 1;
+ *        |   ^
+ *
+ * Generating runs protoc several times over the same protos, see
+ * parallel.go, so an error already reported by another run isn't repeated.
+ * Anything protoc writes that isn't a file:line:col or file error is passed
+ * through as it is.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// spaces a tab advances protoc's column to the next multiple of
+const PROTOC_TAB_WIDTH = 8
+
+var (
+	// protoc's "file:line:col: message" errors
+	protocPositionError = regexp.MustCompile(`^([^:\s][^:]*\.proto):(\d+):(\d+): (.*)$`)
+	// and "file: message" ones
+	protocFileError = regexp.MustCompile(`^([^:\s][^:]*\.proto): (.*)$`)
+)
+
+// Finds the user's files for the names protoc reports errors against
+type protoSources struct {
+	// output dir the rewritten copies are in
+	output string
+	// original path of each rewritten copy, by its name in the output dir
+	originals map[string]string
+	// import dirs, for protos that aren't copied
+	imports []string
+	// errors reported so far, if set
+	reported *sync.Map
+}
+
+// Sources for protoc runs on copies, where copies[i] is the copy of
+// originals[i], with imports searched for everything else
+func newProtoSources(output string, imports, originals, copies []string) protoSources {
+	s := protoSources{output: output, originals: map[string]string{}, imports: imports, reported: &sync.Map{}}
+	for i, c := range copies {
+		if name, err := filepath.Rel(output, c); err == nil {
+			s.originals[filepath.ToSlash(name)] = originals[i]
+		}
+	}
+	return s
+}
+
+// The user's file, and the line in it, for a line of a proto protoc names.
+// Returns "" for a file that can't be found, such as one from a descriptor
+// set.
+func (s protoSources) resolve(name string, line int) (string, int) {
+	if original, ok := s.originals[name]; ok {
+		return original, copyLineToOriginal(filepath.Join(s.output, filepath.FromSlash(name)), original, line)
+	}
+	if filepath.IsAbs(name) {
+		if _, err := os.Stat(name); err == nil {
+			return name, line
+		}
+		return "", line
+	}
+	for _, imp := range s.imports {
+		file := filepath.Join(imp, filepath.FromSlash(name))
+		if _, err := os.Stat(file); err == nil {
+			return file, line
+		}
+	}
+	return "", line
+}
+
+// Map a line of a rewritten copy to its original. Only the go_package option
+// is rewritten, so the files differ in one run of lines: lines before it are
+// the same, lines after it moved by the difference in length, and lines
+// within it map to where it starts in the original.
+func copyLineToOriginal(copyPath, originalPath string, line int) int {
+	cp, err := readLines(copyPath)
+	if err != nil {
+		return line
+	}
+	orig, err := readLines(originalPath)
+	if err != nil {
+		return line
+	}
+	prefix := 0
+	for prefix < len(cp) && prefix < len(orig) && cp[prefix] == orig[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(cp)-prefix && suffix < len(orig)-prefix && cp[len(cp)-1-suffix] == orig[len(orig)-1-suffix] {
+		suffix++
+	}
+	switch {
+	case line <= prefix:
+		return line
+	case line > len(cp)-suffix:
+		return line - len(cp) + len(orig)
+	}
+	return prefix + 1
+}
+
+func readLines(file string) ([]string, error) {
+	byt, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(byt), "\n"), nil
+}
+
+// Rewrite protoc's stderr with errors against the user's files, each
+// followed by a snippet of the line it's about
+func (s protoSources) annotate(stderr string) string {
+	var b strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(stderr))
+	for scanner.Scan() {
+		text := scanner.Text()
+		if m := protocPositionError.FindStringSubmatch(text); m != nil {
+			line, _ := strconv.Atoi(m[2])
+			col, _ := strconv.Atoi(m[3])
+			file, line := s.resolve(m[1], line)
+			if file == "" {
+				s.write(&b, text, "")
+				continue
+			}
+			s.write(&b, fmt.Sprintf("%s:%d:%d: %s", file, line, col, m[4]), sourceSnippet(file, line, col))
+			continue
+		}
+		if m := protocFileError.FindStringSubmatch(text); m != nil {
+			if file, _ := s.resolve(m[1], 0); file != "" {
+				s.write(&b, fmt.Sprintf("%s: %s", file, m[2]), "")
+				continue
+			}
+		}
+		b.WriteString(text + "\n")
+	}
+	return b.String()
+}
+
+// Write an error and its snippet, unless it's been reported already
+func (s protoSources) write(b *strings.Builder, text, snippet string) {
+	if s.reported != nil {
+		if _, seen := s.reported.LoadOrStore(text, true); seen {
+			return
+		}
+	}
+	b.WriteString(text + "\n" + snippet)
+}
+
+// The line of a file, numbered, with a caret under the column, or "" if
+// it can't be read
+func sourceSnippet(file string, line, col int) string {
+	lines, err := readLines(file)
+	if err != nil || line < 1 || line > len(lines) {
+		return ""
+	}
+	text := strings.TrimRight(lines[line-1], "\r")
+	number := strconv.Itoa(line)
+	gutter := strings.Repeat(" ", len(number))
+	snippet := fmt.Sprintf("  %s | %s\n", number, text)
+	if col < 1 {
+		return snippet
+	}
+	// the caret lines up under tabs as well as spaces
+	var caret strings.Builder
+	visual := 0
+	for _, r := range text {
+		if visual >= col-1 {
+			break
+		}
+		if r == '\t' {
+			caret.WriteRune('\t')
+			visual += PROTOC_TAB_WIDTH - visual%PROTOC_TAB_WIDTH
+		} else {
+			caret.WriteRune(' ')
+			visual++
+		}
+	}
+	return snippet + fmt.Sprintf("  %s | %s^\n", gutter, caret.String())
+}
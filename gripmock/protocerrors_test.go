@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_protoSources_annotate(t *testing.T) {
+	src, output := t.TempDir(), t.TempDir()
+	original := filepath.Join(src, "hello.proto")
+	require.NoError(t, os.WriteFile(original, []byte(`syntax = "proto3";
+package hello;
+import "bar/bar.proto";
+message Hello {
+	Foo foo = 1;
+}
+`), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "bar"), 0755))
+	bar := filepath.Join(src, "bar", "bar.proto")
+	require.NoError(t, os.WriteFile(bar, []byte("syntax = \"proto3\";\nmessage Bar { int32 x = 1 }\n"), 0644))
+	// the copy has a go_package line added after the syntax
+	copied := filepath.Join(output, "hello.proto")
+	require.NoError(t, fixGoPackage(original, "example.com/hello", copied))
+
+	sources := newProtoSources(output, []string{src}, []string{original}, []string{copied})
+	stderr := `hello.proto:6:9: "Foo" is not defined.
+bar/bar.proto:2:27: Expected ";".
+google/api/annotations.proto:1:1: something else
+hello.proto: Import "bar/bar.proto" was not found or had errors.
+--gripmock_out: protoc-gen-gripmock: Plugin failed with status code 1.
+`
+	assert.Equal(t, original+`:5:9: "Foo" is not defined.
+  5 | 	Foo foo = 1;
+    | 	^
+`+bar+`:2:27: Expected ";".
+  2 | message Bar { int32 x = 1 }
+    |                           ^
+google/api/annotations.proto:1:1: something else
+`+original+`: Import "bar/bar.proto" was not found or had errors.
+--gripmock_out: protoc-gen-gripmock: Plugin failed with status code 1.
+`, sources.annotate(stderr))
+
+	// another protoc run reporting the same errors adds nothing new
+	assert.Equal(t, "--gripmock_out: protoc-gen-gripmock: Plugin failed with status code 1.\n", sources.annotate(stderr))
+}
+
+func Test_copyLineToOriginal(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+		return file
+	}
+	// a go_package option over two lines replaced by one
+	original := write("original.proto", "syntax = \"proto3\";\noption go_package =\n  \"a/b\";\nmessage A {}\n")
+	copied := write("copy.proto", "syntax = \"proto3\";\noption go_package = \"x/y\";\nmessage A {}\n")
+	assert.Equal(t, 1, copyLineToOriginal(copied, original, 1))
+	assert.Equal(t, 2, copyLineToOriginal(copied, original, 2))
+	assert.Equal(t, 4, copyLineToOriginal(copied, original, 3))
+	// no original to compare with
+	assert.Equal(t, 3, copyLineToOriginal(copied, filepath.Join(dir, "missing.proto"), 3))
+}
+
+func Test_sourceSnippet(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.proto")
+	require.NoError(t, os.WriteFile(file, []byte("line one\r\n\tx\ty;\n"), 0644))
+	assert.Equal(t, "  1 | line one\n    |      ^\n", sourceSnippet(file, 1, 6))
+	// tabs advance protoc's column to the next multiple of 8
+	assert.Equal(t, "  2 | \tx\ty;\n    | \t \t^\n", sourceSnippet(file, 2, 17))
+	assert.Equal(t, "", sourceSnippet(file, 9, 1))
+}
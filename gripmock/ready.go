@@ -0,0 +1,94 @@
+package main
+
+/*
+ * Readiness signalling.
+ *
+ * gripmock takes anything from a fraction of a second to minutes to serve,
+ * depending on whether the server has to be built, so harnesses that wait a
+ * fixed time or poll the port are either slow or flaky. Instead, once the
+ * gRPC server reports it's serving, by which time every listener is bound
+ * and accepting connections:
+ *
+ *   - -ready-file is created, and removed again when the server starts
+ *     draining, so it exists exactly while gripmock serves. A file left from
+ *     an earlier run is removed at startup.
+ *   - with -print-ready, READY_MARKER is printed on a line of its own on
+ *     stdout.
+ *   - if gripmock runs as a systemd Type=notify service, with
+ *     NOTIFY_SOCKET set, systemd is sent READY=1.
+ *
+ * Each happens again whenever a restarted or reloaded server starts serving.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// printed on stdout with -print-ready
+const READY_MARKER = "READY"
+
+type readiness struct {
+	mx sync.Mutex
+	// -ready-file, if set
+	file string
+	// stdout with -print-ready
+	stdout io.Writer
+	// systemd's NOTIFY_SOCKET, if set
+	notifySocket string
+}
+
+// Remove a ready file left from an earlier run
+func (r *readiness) reset() error {
+	if r.file == "" {
+		return nil
+	}
+	if err := os.Remove(r.file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Signal that the gRPC server is serving, or has stopped serving
+func (r *readiness) serving(serving bool) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if !serving {
+		return r.reset()
+	}
+	if r.file != "" {
+		if err := writeFileAtomic(r.file, []byte(fmt.Sprintf("%d\n", os.Getpid()))); err != nil {
+			return err
+		}
+	}
+	if r.stdout != nil {
+		if _, err := fmt.Fprintln(r.stdout, READY_MARKER); err != nil {
+			return err
+		}
+	}
+	if r.notifySocket != "" {
+		return sdNotify(r.notifySocket, "READY=1")
+	}
+	return nil
+}
+
+// Send systemd a notification on its NOTIFY_SOCKET, see sd_notify(3)
+func sdNotify(socket, state string) error {
+	// an abstract socket
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
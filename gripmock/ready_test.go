@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readiness(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ready")
+	require.NoError(t, os.WriteFile(file, []byte("stale"), 0644))
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("no unixgram sockets: %v", err)
+	}
+	defer conn.Close()
+
+	var out bytes.Buffer
+	r := &readiness{file: file, stdout: &out, notifySocket: socket}
+	require.NoError(t, r.reset())
+	assert.NoFileExists(t, file)
+
+	require.NoError(t, r.serving(true))
+	byt, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(byt))
+	assert.Equal(t, "READY\n", out.String())
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+
+	require.NoError(t, r.serving(false))
+	assert.NoFileExists(t, file)
+	require.NoError(t, r.serving(false), "already removed")
+}
@@ -0,0 +1,223 @@
+package main
+
+/*
+ * Bootstrapping from server reflection.
+ *
+ * With -from-reflection host:port, gripmock asks a running server for its
+ * services over gRPC server reflection and mocks those, so users don't
+ * need the proto files at all. The descriptors fetched, with everything
+ * they import, are written to REFLECTION_SET_FILE in the output dir and
+ * served as a -descriptor set would be, so proto arguments can still name
+ * the files to serve.
+ *
+ * The v1 reflection service is tried first, then v1alpha, which is all
+ * older servers have; their messages are the same. The server's own health
+ * and reflection services aren't mocked, the mock has its own. The
+ * connection is plaintext, or TLS with -from-reflection-tls, verifying the
+ * server against the system's CAs, or those in -from-reflection-ca, or not
+ * at all with -from-reflection-insecure.
+ */
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	// descriptor set fetched with -from-reflection, in the output dir
+	REFLECTION_SET_FILE = "gripmock-reflection.pb"
+
+	// how long fetching the descriptors may take
+	REFLECTION_FETCH_TIMEOUT = 30 * time.Second
+)
+
+// reflection methods to try, newest first
+var reflectionMethods = []string{
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo",
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+}
+
+// How to connect to the server to fetch the descriptors from
+type reflectionTLS struct {
+	// dial with TLS
+	enabled bool
+	// PEM file of the CAs to verify the server with, rather than the
+	// system's
+	ca string
+	// don't verify the server's certificate
+	insecure bool
+}
+
+// The -from-reflection-tls, -from-reflection-ca and
+// -from-reflection-insecure flags; either of the last two implies TLS
+func newReflectionTLS(enabled bool, ca string, insecure bool) reflectionTLS {
+	return reflectionTLS{enabled: enabled || ca != "" || insecure, ca: ca, insecure: insecure}
+}
+
+func (c reflectionTLS) credentials() (credentials.TransportCredentials, error) {
+	if !c.enabled {
+		return insecure.NewCredentials(), nil
+	}
+	config := &tls.Config{InsecureSkipVerify: c.insecure}
+	if c.ca != "" {
+		pem, err := os.ReadFile(c.ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates in %s", c.ca)
+		}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// Fetch the descriptors of the services of the server at target, and write
+// them to the output dir. Returns the written descriptor set.
+func fetchReflectionSet(target, output string, tlsConf reflectionTLS) (string, error) {
+	creds, err := tlsConf.credentials()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), REFLECTION_FETCH_TIMEOUT)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	set, err := reflectDescriptors(ctx, conn)
+	if err != nil {
+		return "", fmt.Errorf("fetching descriptors from %s with server reflection: %w", target, err)
+	}
+	byt, err := proto.Marshal(set)
+	if err != nil {
+		return "", err
+	}
+	out := filepath.Join(output, REFLECTION_SET_FILE)
+	if err := os.WriteFile(out, byt, 0644); err != nil {
+		return "", err
+	}
+	log.V(LOG_VERBOSE).Info("fetched descriptors with server reflection", "target", target, "files", len(set.File), "output", out)
+	return out, nil
+}
+
+// The files defining the server's services and everything they import
+func reflectDescriptors(ctx context.Context, conn *grpc.ClientConn) (*descriptorpb.FileDescriptorSet, error) {
+	var stream grpc.ClientStream
+	var services []string
+	for _, method := range reflectionMethods {
+		var err error
+		stream, err = conn.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, method)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := reflectionRequest(stream, &reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		})
+		if status.Code(err) == codes.Unimplemented {
+			log.V(LOG_DEBUG).Info("server reflection method not supported", "method", method)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, svc := range resp.GetListServicesResponse().GetService() {
+			if !isInfrastructureService(svc.GetName()) {
+				services = append(services, svc.GetName())
+			}
+		}
+		break
+	}
+	if services == nil {
+		return nil, fmt.Errorf("server reflection isn't supported, or lists no services to mock")
+	}
+	defer stream.CloseSend()
+
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]bool{}
+	// the server sends a file's imports with it, unless it has sent them
+	// already, but ask for any that are still missing
+	var missing []string
+	add := func(resp *reflectionpb.ServerReflectionResponse) error {
+		if e := resp.GetErrorResponse(); e != nil {
+			return status.Error(codes.Code(e.GetErrorCode()), e.GetErrorMessage())
+		}
+		for _, byt := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(byt, fd); err != nil {
+				return err
+			}
+			if seen[fd.GetName()] {
+				continue
+			}
+			seen[fd.GetName()] = true
+			set.File = append(set.File, fd)
+			missing = append(missing, fd.GetDependency()...)
+		}
+		return nil
+	}
+	for _, svc := range services {
+		resp, err := reflectionRequest(stream, &reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: svc},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := add(resp); err != nil {
+			return nil, fmt.Errorf("service %s: %w", svc, err)
+		}
+	}
+	for len(missing) > 0 {
+		name := missing[0]
+		missing = missing[1:]
+		if seen[name] {
+			continue
+		}
+		resp, err := reflectionRequest(stream, &reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := add(resp); err != nil {
+			return nil, fmt.Errorf("file %s: %w", name, err)
+		}
+	}
+	return set, nil
+}
+
+func reflectionRequest(stream grpc.ClientStream, req *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+	// a send fails with io.EOF if the server ended the call, e.g. as
+	// unimplemented; its status comes from the receive
+	if err := stream.SendMsg(req); err != nil && err != io.EOF {
+		return nil, err
+	}
+	resp := &reflectionpb.ServerReflectionResponse{}
+	if err := stream.RecvMsg(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// The health and reflection services, which the mock has its own of
+func isInfrastructureService(name string) bool {
+	return name == "grpc.health.v1.Health" || strings.HasPrefix(name, "grpc.reflection.")
+}
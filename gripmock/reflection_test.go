@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	testgrpc "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func Test_fetchReflectionSet(t *testing.T) {
+	initLogging(LOG_ERROR)
+	// a server with only v1alpha reflection, and its own health service
+	s := grpc.NewServer()
+	testgrpc.RegisterTestServiceServer(s, testgrpc.UnimplementedTestServiceServer{})
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	reflection.Register(s)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	output := t.TempDir()
+	path, err := fetchReflectionSet(lis.Addr().String(), output, reflectionTLS{})
+	require.NoError(t, err)
+	byt, err := os.ReadFile(path)
+	require.NoError(t, err)
+	set := &descriptorpb.FileDescriptorSet{}
+	require.NoError(t, proto.Unmarshal(byt, set))
+	names := []string{}
+	for _, f := range set.GetFile() {
+		names = append(names, f.GetName())
+	}
+	assert.Contains(t, names, "grpc/testing/test.proto")
+	assert.Contains(t, names, "grpc/testing/messages.proto", "imports come too")
+	assert.NotContains(t, names, "grpc/health/v1/health.proto", "the mock has its own health service")
+
+	// the set serves as a -descriptor set would
+	_, serve, err := readDescriptorSets([]string{path}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"grpc/testing/test.proto"}, serve)
+
+	noReflection := grpc.NewServer()
+	lis2, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go noReflection.Serve(lis2)
+	defer noReflection.Stop()
+	_, err = fetchReflectionSet(lis2.Addr().String(), output, reflectionTLS{})
+	assert.ErrorContains(t, err, "server reflection isn't supported")
+}
+
+func Test_fetchReflectionSetTLS(t *testing.T) {
+	initLogging(LOG_ERROR)
+	cert, key := writeTestCert(t)
+	creds, err := credentials.NewServerTLSFromFile(cert, key)
+	require.NoError(t, err)
+	s := grpc.NewServer(grpc.Creds(creds))
+	testgrpc.RegisterTestServiceServer(s, testgrpc.UnimplementedTestServiceServer{})
+	reflection.Register(s)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(lis)
+	defer s.Stop()
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+	target := "localhost:" + port
+
+	output := t.TempDir()
+	_, err = fetchReflectionSet(target, output, newReflectionTLS(false, cert, false))
+	assert.NoError(t, err, "verified with the CA")
+	_, err = fetchReflectionSet(target, output, newReflectionTLS(false, "", true))
+	assert.NoError(t, err, "not verified")
+	_, err = fetchReflectionSet(target, output, newReflectionTLS(false, key, false))
+	assert.ErrorContains(t, err, "no PEM certificates in "+key)
+}
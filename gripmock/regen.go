@@ -0,0 +1,128 @@
+package main
+
+/*
+ * Incremental regeneration.
+ *
+ * When the build cache can't reuse the server, because a proto changed,
+ * protoc would otherwise regenerate the Go code of every proto, which for
+ * repos with hundreds of protos takes much of the rebuild. Instead, each
+ * proto is hashed along with everything it imports, and the hashes saved in
+ * PROTO_HASH_FILE in the output dir once generation succeeds. Next time,
+ * protoc-gen-go and protoc-gen-go-grpc only run on the protos whose hashes
+ * changed, and go build recompiles only their packages. protoc-gen-gripmock
+ * still sees every proto, as the server registers all their services.
+ *
+ * Anything else that changes the generated code, such as the imports,
+ * layout, protoc arguments or the plugins themselves, regenerates every
+ * proto, as does -build-cache=false. Protos read from descriptor sets are
+ * always regenerated together.
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// hashes of the protos whose code is in the output dir
+const PROTO_HASH_FILE = ".gripmock-proto-hashes"
+
+// the plugins that generate each proto's Go code
+var protoGoTools = []string{"protoc-gen-go", "protoc-gen-go-grpc"}
+
+type protoHashes struct {
+	// hash of the options the code was generated with
+	Options string `json:"options"`
+	// by proto file, relative to the output dir
+	Protos map[string]string `json:"protos"`
+}
+
+// Hash the options that change every proto's generated Go code
+func protoOptionsHash(param protocParam) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "imports %q\ngoogleapis %t\nprotoc args %q\nlayout %q %q\n", param.imports, param.googleapis, param.protocArgs, param.layout.moduleName(), param.layout.protoDir)
+	// gripmock rewrites the protos' go packages
+	if self, err := os.Executable(); err == nil {
+		hashFileStat(h, self)
+	}
+	for _, tool := range protoGoTools {
+		if found, err := exec.LookPath(tool); err == nil {
+			hashFileStat(h, found)
+		}
+	}
+	hashProtoc(h, param.protoc)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Hash each proto with everything it imports, keyed by its copy in the
+// output dir; protos and copies are in the same order
+func hashProtos(param protocParam, protos []string, copies []string) (protoHashes, error) {
+	hashes := protoHashes{Options: protoOptionsHash(param), Protos: map[string]string{}}
+	for i, proto := range protos {
+		importDir, rel, err := findProtoInImports(param.imports, proto)
+		if err != nil {
+			return protoHashes{}, err
+		}
+		h := sha256.New()
+		dirs := append([]string{importDir}, param.imports...)
+		if err := hashProto(h, protoName(rel, proto), dirs, map[string]bool{}); err != nil {
+			return protoHashes{}, err
+		}
+		key, err := filepath.Rel(param.output, copies[i])
+		if err != nil {
+			return protoHashes{}, err
+		}
+		hashes.Protos[key] = hex.EncodeToString(h.Sum(nil))
+	}
+	return hashes, nil
+}
+
+// The hashes saved by the last generation in the output dir, if any
+func loadProtoHashes(output string) protoHashes {
+	byt, err := os.ReadFile(filepath.Join(output, PROTO_HASH_FILE))
+	if err != nil {
+		return protoHashes{}
+	}
+	hashes := protoHashes{}
+	if err := json.Unmarshal(byt, &hashes); err != nil {
+		return protoHashes{}
+	}
+	return hashes
+}
+
+// Save the hashes of the generated protos; nil removes them, so the next
+// generation does every proto
+func saveProtoHashes(output string, hashes *protoHashes) error {
+	file := filepath.Join(output, PROTO_HASH_FILE)
+	if hashes == nil {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	byt, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, byt, 0644)
+}
+
+// The protos, relative to the output dir, whose Go code must be generated
+// again; all of them unless the options are the same as last time
+func (h protoHashes) changed(last protoHashes) []string {
+	changed := []string{}
+	for proto, hash := range h.Protos {
+		if h.Options != last.Options || last.Protos[proto] != hash {
+			changed = append(changed, proto)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtoHashes(t *testing.T) {
+	dir := t.TempDir()
+	output := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	write("orders/orders.proto", "syntax = \"proto3\";\nimport \"common/types.proto\";\n")
+	write("users/users.proto", "syntax = \"proto3\";\n")
+	write("common/types.proto", "syntax = \"proto3\";\nmessage Money {}\n")
+	param := protocParam{imports: []string{dir}, output: output}
+	protos := []string{filepath.Join(dir, "orders/orders.proto"), filepath.Join(dir, "users/users.proto")}
+	copies := []string{filepath.Join(output, "orders/orders.proto"), filepath.Join(output, "users/users.proto")}
+	hash := func(param protocParam) protoHashes {
+		h, err := hashProtos(param, protos, copies)
+		require.NoError(t, err)
+		return h
+	}
+
+	first := hash(param)
+	assert.Len(t, first.Protos, 2)
+	assert.Equal(t, []string{"orders/orders.proto", "users/users.proto"}, first.changed(loadProtoHashes(output)), "everything, the first time")
+	require.NoError(t, saveProtoHashes(output, &first))
+	assert.Equal(t, first, loadProtoHashes(output))
+	assert.Empty(t, hash(param).changed(first))
+
+	write("common/types.proto", "syntax = \"proto3\";\nmessage Money { int64 units = 1; }\n")
+	assert.Equal(t, []string{"orders/orders.proto"}, hash(param).changed(first), "an import changed")
+	write("users/users.proto", "syntax = \"proto3\";\nmessage User {}\n")
+	assert.Equal(t, []string{"orders/orders.proto", "users/users.proto"}, hash(param).changed(first))
+
+	write("common/types.proto", "syntax = \"proto3\";\nmessage Money {}\n")
+	write("users/users.proto", "syntax = \"proto3\";\n")
+	changed := param
+	changed.protocArgs = []string{"--experimental_allow_proto3_optional"}
+	assert.Len(t, hash(changed).changed(first), 2, "different options regenerate everything")
+
+	require.NoError(t, saveProtoHashes(output, nil))
+	assert.Equal(t, protoHashes{}, loadProtoHashes(output))
+	require.NoError(t, saveProtoHashes(output, nil), "already removed")
+}
@@ -0,0 +1,74 @@
+package main
+
+/*
+ * Service allow list and method deny list.
+ *
+ * Protos often define dozens of services when a test only calls a few of
+ * their methods. -only-services names the services to serve, and
+ * -exclude-methods methods of them not to, so the generated server only
+ * has code for the RPCs a test cares about.
+ *
+ * Services are named with or without their proto package, "Greeter" or
+ * "helloworld.Greeter", and methods as "<service>/<method>". The lists are
+ * handed to protoc-gen-gripmock as repeated "only-service" and
+ * "exclude-method" options, and applied to the dynamic server's services
+ * the same way. RPCs left out are answered as if they weren't in the
+ * protos at all: by catch-all stubs, or with UNIMPLEMENTED.
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Parse a comma separated -only-services or -exclude-methods list, with
+// the names methods must have
+func parseRPCList(spec string, methods bool) ([]string, error) {
+	names := []string{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "/")
+		if name == "" {
+			continue
+		}
+		service, method, found := strings.Cut(name, "/")
+		if methods && (!found || service == "" || method == "" || strings.Contains(method, "/")) {
+			return nil, fmt.Errorf("method \"%s\" must be <service>/<method>", name)
+		}
+		if !methods && found {
+			return nil, fmt.Errorf("service \"%s\" must be a service name, with or without its package", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Whether a name from the lists is the service, by its full name or its
+// name without the package
+func isServiceName(name string, fullName string) bool {
+	return name == fullName || name == fullName[strings.LastIndex(fullName, ".")+1:]
+}
+
+// Whether the service with the full name is served, given -only-services
+func serviceSelected(only []string, fullName string) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, name := range only {
+		if isServiceName(name, fullName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the method of the service with the full name is excluded, given
+// -exclude-methods
+func methodExcluded(excluded []string, fullName string, method string) bool {
+	for _, name := range excluded {
+		service, m, _ := strings.Cut(name, "/")
+		if m == method && isServiceName(service, fullName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRPCList(t *testing.T) {
+	services, err := parseRPCList(" Greeter, helloworld.Farewell ,", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Greeter", "helloworld.Farewell"}, services)
+	_, err = parseRPCList("Greeter/SayHello", false)
+	assert.Error(t, err)
+
+	methods, err := parseRPCList("Greeter/SayHello,/helloworld.Greeter/SayGoodbye", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Greeter/SayHello", "helloworld.Greeter/SayGoodbye"}, methods)
+	for _, bad := range []string{"Greeter", "Greeter/", "/SayHello", "a/b/c"} {
+		_, err = parseRPCList(bad, true)
+		assert.Error(t, err, bad)
+	}
+
+	none, err := parseRPCList("", true)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}
+
+func Test_serviceSelected(t *testing.T) {
+	assert.True(t, serviceSelected(nil, "helloworld.Greeter"))
+	assert.True(t, serviceSelected([]string{"Greeter"}, "helloworld.Greeter"))
+	assert.True(t, serviceSelected([]string{"helloworld.Greeter"}, "helloworld.Greeter"))
+	assert.True(t, serviceSelected([]string{"Greeter"}, "Greeter"))
+	assert.False(t, serviceSelected([]string{"other.Greeter"}, "helloworld.Greeter"))
+	assert.False(t, serviceSelected([]string{"Farewell"}, "helloworld.Greeter"))
+}
+
+func Test_methodExcluded(t *testing.T) {
+	excluded := []string{"Greeter/SayHello", "helloworld.Farewell/SayGoodbye"}
+	assert.True(t, methodExcluded(excluded, "helloworld.Greeter", "SayHello"))
+	assert.True(t, methodExcluded(excluded, "helloworld.Farewell", "SayGoodbye"))
+	assert.False(t, methodExcluded(excluded, "helloworld.Greeter", "SayGoodbye"))
+	assert.False(t, methodExcluded(excluded, "other.Farewell", "SayGoodbye"))
+	assert.False(t, methodExcluded(nil, "helloworld.Greeter", "SayHello"))
+}
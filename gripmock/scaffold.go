@@ -0,0 +1,338 @@
+package main
+
+/*
+ * Scaffolding stubs.
+ *
+ * Writing the first stub for every RPC of a large API by hand is slow and
+ * easy to get wrong, so "gripmock scaffold-stubs" writes one for each, to
+ * edit from there:
+ *
+ *   gripmock scaffold-stubs -scaffold-dir stubs -scaffold-data fake api.proto
+ *
+ * writes stubs/<package.Service>/<Method>.json. Each stub's input equals an
+ * example request, as the server sees requests, and its output is an
+ * example response with every field, under "stream" for methods that
+ * stream responses. With -scaffold-data=defaults, the default, fields have
+ * their zero values; with "fake" they're made up, from the field's type and
+ * name, the same on every run. Either way nested messages are filled in, as
+ * are one element of repeated fields and one entry of maps, so the shape of
+ * each message is there to see. Existing files are left alone, unless
+ * -scaffold-overwrite.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	SCAFFOLD_DATA_DEFAULTS = "defaults"
+	SCAFFOLD_DATA_FAKE     = "fake"
+	// how deep nested messages are filled in
+	SCAFFOLD_MAX_DEPTH = 5
+)
+
+// Well-known types left unset, as they can't be marshalled empty
+var scaffoldSkipped = map[protoreflect.FullName]bool{
+	"google.protobuf.Any":       true,
+	"google.protobuf.Value":     true,
+	"google.protobuf.ListValue": true,
+	"google.protobuf.Struct":    true,
+}
+
+// where fake timestamps start from
+var scaffoldEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type scaffoldOptions struct {
+	dir       string
+	data      string
+	overwrite bool
+}
+
+// A scaffolded stub, with its keys in the order of the stub format
+type scaffoldStub struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+	Input   struct {
+		Equals json.RawMessage `json:"equals"`
+	} `json:"input"`
+	Output struct {
+		Data   json.RawMessage   `json:"data,omitempty"`
+		Stream []json.RawMessage `json:"stream,omitempty"`
+	} `json:"output"`
+}
+
+// Write a stub for each method of the services of param, reporting each
+// file to w, and return the exit code. The output dir is a temp dir,
+// removed once done.
+func runScaffold(param protocParam, opts scaffoldOptions, w io.Writer) int {
+	defer os.RemoveAll(param.output)
+	if len(param.protoPath) == 0 && len(param.descriptors) == 0 {
+		log.V(LOG_ERROR).Info("Need at least one proto file or -descriptor")
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+	if opts.data != SCAFFOLD_DATA_DEFAULTS && opts.data != SCAFFOLD_DATA_FAKE {
+		log.V(LOG_ERROR).Info("-scaffold-data must be defaults or fake", "scaffold-data", opts.data)
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+	_, services, err := loadDynamicServices(param)
+	if err != nil {
+		fmt.Fprintf(w, "loading the services: %v\n", err)
+		return EXITCODE_BUILD_ERROR
+	}
+
+	written, skipped := 0, 0
+	for _, sd := range services {
+		for i := 0; i < sd.Methods().Len(); i++ {
+			md := sd.Methods().Get(i)
+			if methodExcluded(param.excludeMethods, string(sd.FullName()), string(md.Name())) {
+				continue
+			}
+			file := filepath.Join(opts.dir, string(sd.FullName()), string(md.Name())+".json")
+			if _, err := os.Stat(file); err == nil && !opts.overwrite {
+				fmt.Fprintf(w, "skipped %s, it exists\n", file)
+				skipped++
+				continue
+			}
+			byt, err := scaffoldMethod(md, opts.data == SCAFFOLD_DATA_FAKE)
+			if err != nil {
+				fmt.Fprintf(w, "scaffolding %s: %v\n", md.FullName(), err)
+				return EXITCODE_OTHER_ERROR
+			}
+			if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+				fmt.Fprintln(w, err)
+				return EXITCODE_OTHER_ERROR
+			}
+			if err := os.WriteFile(file, byt, 0644); err != nil {
+				fmt.Fprintln(w, err)
+				return EXITCODE_OTHER_ERROR
+			}
+			fmt.Fprintf(w, "wrote %s\n", file)
+			written++
+		}
+	}
+	fmt.Fprintf(w, "%d stubs written for %d services", written, len(services))
+	if skipped > 0 {
+		fmt.Fprintf(w, ", %d existing left alone, -scaffold-overwrite replaces them", skipped)
+	}
+	fmt.Fprintln(w)
+	return 0
+}
+
+// The stub file for a method
+func scaffoldMethod(md protoreflect.MethodDescriptor, fake bool) ([]byte, error) {
+	// seeded by the method, so a method's fake data is the same every run
+	h := fnv.New64a()
+	h.Write([]byte(md.FullName()))
+	f := &scaffolder{fake: fake, rand: rand.New(rand.NewSource(int64(h.Sum64())))}
+
+	s := scaffoldStub{Service: string(md.Parent().Name()), Method: string(md.Name())}
+	// the input matches the request as the server sends it to be matched
+	in, err := dynamicMarshal.Marshal(f.message(md.Input(), 0))
+	if err != nil {
+		return nil, err
+	}
+	s.Input.Equals = in
+	out, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(f.message(md.Output(), 0))
+	if err != nil {
+		return nil, err
+	}
+	if md.IsStreamingServer() {
+		s.Output.Stream = []json.RawMessage{out}
+	} else {
+		s.Output.Data = out
+	}
+	byt, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(byt, '\n'), nil
+}
+
+// Fills in example messages
+type scaffolder struct {
+	fake bool
+	rand *rand.Rand
+	// messages being filled in, to stop at recursive ones
+	filling []protoreflect.FullName
+}
+
+func (f *scaffolder) message(md protoreflect.MessageDescriptor, depth int) *dynamicpb.Message {
+	msg := dynamicpb.NewMessage(md)
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		if f.fake {
+			msg.Set(md.Fields().ByName("seconds"), protoreflect.ValueOfInt64(scaffoldEpoch.Unix()+f.rand.Int63n(365*24*3600)))
+		}
+		return msg
+	case "google.protobuf.Duration":
+		if f.fake {
+			msg.Set(md.Fields().ByName("seconds"), protoreflect.ValueOfInt64(1+f.rand.Int63n(300)))
+		}
+		return msg
+	}
+	f.filling = append(f.filling, md.FullName())
+	defer func() { f.filling = f.filling[:len(f.filling)-1] }()
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		// only the first field of a oneof, setting another would clear it,
+		// and set even to its zero value, as an unset oneof isn't shown
+		oneof := fd.ContainingOneof()
+		if oneof != nil && !oneof.IsSynthetic() && oneof.Fields().Get(0) != fd {
+			continue
+		}
+		switch {
+		case fd.IsMap():
+			if !f.fills(fd.MapValue(), depth) || (!f.fake && fd.MapValue().Message() == nil) {
+				continue
+			}
+			m := msg.Mutable(fd).Map()
+			m.Set(f.scalar(fd.MapKey()).MapKey(), f.value(fd.MapValue(), fd.MapValue().Default(), depth))
+		case fd.IsList():
+			if !f.fills(fd, depth) || (!f.fake && fd.Message() == nil) {
+				continue
+			}
+			l := msg.Mutable(fd).List()
+			l.Append(f.value(fd, l.NewElement(), depth))
+		default:
+			if !f.fills(fd, depth) {
+				continue
+			}
+			if fd.Message() != nil {
+				msg.Set(fd, protoreflect.ValueOfMessage(f.message(fd.Message(), depth+1)))
+			} else if f.fake || (oneof != nil && !oneof.IsSynthetic()) {
+				msg.Set(fd, f.scalar(fd))
+			}
+		}
+	}
+	return msg
+}
+
+// Whether to fill in a field: message fields are left unset past
+// SCAFFOLD_MAX_DEPTH, for messages that contain themselves, and for
+// well-known types that can't be empty
+func (f *scaffolder) fills(fd protoreflect.FieldDescriptor, depth int) bool {
+	md := fd.Message()
+	if md == nil {
+		return true
+	}
+	if depth >= SCAFFOLD_MAX_DEPTH || scaffoldSkipped[md.FullName()] {
+		return false
+	}
+	for _, name := range f.filling {
+		if name == md.FullName() {
+			return false
+		}
+	}
+	return true
+}
+
+// A list element or map value, given an empty one
+func (f *scaffolder) value(fd protoreflect.FieldDescriptor, empty protoreflect.Value, depth int) protoreflect.Value {
+	if fd.Message() != nil {
+		return protoreflect.ValueOfMessage(f.message(fd.Message(), depth+1))
+	}
+	if f.fake {
+		return f.scalar(fd)
+	}
+	return empty
+}
+
+// A scalar value for a field, made up from its type and name if fake,
+// otherwise its zero value
+func (f *scaffolder) scalar(fd protoreflect.FieldDescriptor) protoreflect.Value {
+	if !f.fake {
+		return fd.Default()
+	}
+	name := strings.ToLower(string(fd.Name()))
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(f.rand.Intn(2) == 1)
+	case protoreflect.EnumKind:
+		// the first value after the zero one, usually UNSPECIFIED
+		values := fd.Enum().Values()
+		if values.Len() > 1 {
+			return protoreflect.ValueOfEnum(values.Get(1).Number())
+		}
+		return protoreflect.ValueOfEnum(values.Get(0).Number())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(f.number(name)))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(f.number(name))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(uint32(f.number(name)))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(uint64(f.number(name)))
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(f.rand.Intn(10000)) / 100)
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(float64(f.rand.Intn(10000)) / 100)
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(f.text(name))
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(f.text(name)))
+	}
+	return fd.Default()
+}
+
+func (f *scaffolder) number(name string) int64 {
+	switch {
+	case strings.Contains(name, "age"):
+		return 18 + f.rand.Int63n(60)
+	case strings.Contains(name, "year"):
+		return 2000 + f.rand.Int63n(25)
+	case strings.HasSuffix(name, "time") || strings.HasSuffix(name, "_at"):
+		return scaffoldEpoch.Unix() + f.rand.Int63n(365*24*3600)
+	}
+	return 1 + f.rand.Int63n(100)
+}
+
+var (
+	scaffoldFirstNames = []string{"Alice", "Bob", "Carol", "Dave", "Erin", "Frank"}
+	scaffoldLastNames  = []string{"Smith", "Jones", "Garcia", "Chen", "Okafor", "Novak"}
+	scaffoldCities     = []string{"Lisbon", "Osaka", "Nairobi", "Toronto", "Auckland"}
+	scaffoldWords      = []string{"alpha", "bravo", "delta", "echo", "kilo", "lima", "oscar", "tango"}
+)
+
+func (f *scaffolder) pick(from []string) string {
+	return from[f.rand.Intn(len(from))]
+}
+
+func (f *scaffolder) text(name string) string {
+	switch {
+	case strings.Contains(name, "email"):
+		return strings.ToLower(f.pick(scaffoldFirstNames)) + "@example.com"
+	case strings.Contains(name, "url") || strings.Contains(name, "uri") || strings.Contains(name, "link"):
+		return "https://example.com/" + f.pick(scaffoldWords)
+	case strings.Contains(name, "phone"):
+		return fmt.Sprintf("+1-555-%04d", f.rand.Intn(10000))
+	case name == "id" || strings.HasSuffix(name, "_id") || strings.Contains(name, "uuid"):
+		return fmt.Sprintf("%08x-%04x-4%03x-a%03x-%012x", f.rand.Uint32(), f.rand.Intn(1<<16), f.rand.Intn(1<<12), f.rand.Intn(1<<12), f.rand.Int63n(1<<48))
+	case strings.Contains(name, "first_name") || strings.Contains(name, "given_name"):
+		return f.pick(scaffoldFirstNames)
+	case strings.Contains(name, "last_name") || strings.Contains(name, "family_name") || strings.Contains(name, "surname"):
+		return f.pick(scaffoldLastNames)
+	case strings.Contains(name, "name"):
+		return f.pick(scaffoldFirstNames) + " " + f.pick(scaffoldLastNames)
+	case strings.Contains(name, "city"):
+		return f.pick(scaffoldCities)
+	case strings.Contains(name, "address"):
+		return fmt.Sprintf("%d %s Street", 1+f.rand.Intn(200), f.pick(scaffoldLastNames))
+	case strings.Contains(name, "time") || strings.Contains(name, "date") || strings.HasSuffix(name, "_at"):
+		return scaffoldEpoch.Add(time.Duration(f.rand.Int63n(365*24)) * time.Hour).Format(time.RFC3339)
+	}
+	return f.pick(scaffoldWords) + "-" + f.pick(scaffoldWords)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/ringerc/gripmock/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const scaffoldTestProto = `syntax = "proto3";
+package shop;
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/struct.proto";
+
+enum Status {
+  STATUS_UNSPECIFIED = 0;
+  STATUS_ACTIVE = 1;
+}
+
+message Customer {
+  string id = 1;
+  string email = 2;
+  int32 age = 3;
+  repeated string tags = 4;
+  Customer referred_by = 5;
+}
+
+message Order {
+  string order_id = 1;
+  Customer customer = 2;
+  repeated Line lines = 3;
+  map<string, Line> by_sku = 4;
+  Status status = 5;
+  google.protobuf.Timestamp created_at = 6;
+  google.protobuf.Struct extra = 7;
+  oneof payment {
+    string card = 8;
+    string voucher = 9;
+  }
+}
+
+message Line {
+  string sku = 1;
+  double price = 2;
+}
+
+message GetOrderRequest {
+  string order_id = 1;
+}
+
+service Orders {
+  rpc GetOrder(GetOrderRequest) returns (Order);
+  rpc WatchOrders(GetOrderRequest) returns (stream Order);
+}
+`
+
+// Compile scaffoldTestProto to a descriptor set file
+func writeShopSet(t *testing.T) string {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: func(path string) (io.ReadCloser, error) {
+				if path != "shop.proto" {
+					return nil, os.ErrNotExist
+				}
+				return io.NopCloser(strings.NewReader(scaffoldTestProto)), nil
+			},
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), "shop.proto")
+	require.NoError(t, err)
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]bool{}
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		for i := 0; i < fd.Imports().Len(); i++ {
+			add(fd.Imports().Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	add(files[0])
+	byt, err := proto.Marshal(set)
+	require.NoError(t, err)
+	setPath := filepath.Join(t.TempDir(), "shop.pb")
+	require.NoError(t, os.WriteFile(setPath, byt, 0644))
+	return setPath
+}
+
+func Test_runScaffold(t *testing.T) {
+	initLogging(LOG_ERROR)
+	setPath := writeShopSet(t)
+	dir := t.TempDir()
+	scaffold := func(opts scaffoldOptions) (int, string) {
+		var out bytes.Buffer
+		code := runScaffold(protocParam{descriptors: []string{setPath}, protoPath: []string{"shop.proto"}, output: t.TempDir()}, opts, &out)
+		return code, out.String()
+	}
+	read := func(name string) map[string]interface{} {
+		byt, err := os.ReadFile(filepath.Join(dir, "shop.Orders", name))
+		require.NoError(t, err)
+		s := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(byt, &s))
+		return s
+	}
+
+	code, out := scaffold(scaffoldOptions{dir: dir, data: SCAFFOLD_DATA_DEFAULTS})
+	require.Equal(t, 0, code, out)
+	assert.Equal(t, "wrote "+filepath.Join(dir, "shop.Orders", "GetOrder.json")+"\n"+
+		"wrote "+filepath.Join(dir, "shop.Orders", "WatchOrders.json")+"\n"+
+		"2 stubs written for 1 services\n", out)
+	get := read("GetOrder.json")
+	assert.Equal(t, "Orders", get["service"])
+	assert.Equal(t, map[string]interface{}{"equals": map[string]interface{}{}}, get["input"])
+	data := get["output"].(map[string]interface{})["data"].(map[string]interface{})
+	assert.Equal(t, "", data["order_id"])
+	assert.Equal(t, "STATUS_UNSPECIFIED", data["status"])
+	assert.Nil(t, data["extra"], "left unset")
+	customer := data["customer"].(map[string]interface{})
+	assert.Equal(t, []interface{}{}, customer["tags"])
+	assert.Nil(t, customer["referred_by"], "recursive")
+	assert.Len(t, data["lines"], 1)
+	assert.Contains(t, data["by_sku"], "")
+	assert.Equal(t, "", data["card"])
+	assert.NotContains(t, data, "voucher", "in the same oneof")
+	watch := read("WatchOrders.json")
+	assert.Len(t, watch["output"].(map[string]interface{})["stream"], 1)
+
+	// the same fake data every time
+	code, out = scaffold(scaffoldOptions{dir: dir, data: SCAFFOLD_DATA_FAKE})
+	require.Equal(t, 0, code, out)
+	assert.Contains(t, out, "0 stubs written for 1 services, 2 existing left alone")
+	assert.Contains(t, out, "skipped "+filepath.Join(dir, "shop.Orders", "GetOrder.json")+", it exists")
+	code, out = scaffold(scaffoldOptions{dir: dir, data: SCAFFOLD_DATA_FAKE, overwrite: true})
+	require.Equal(t, 0, code, out)
+	first, err := os.ReadFile(filepath.Join(dir, "shop.Orders", "GetOrder.json"))
+	require.NoError(t, err)
+	scaffold(scaffoldOptions{dir: dir, data: SCAFFOLD_DATA_FAKE, overwrite: true})
+	second, err := os.ReadFile(filepath.Join(dir, "shop.Orders", "GetOrder.json"))
+	require.NoError(t, err)
+	assert.Equal(t, string(first), string(second))
+
+	get = read("GetOrder.json")
+	assert.Regexp(t, `^[0-9a-f]{8}-`, get["input"].(map[string]interface{})["equals"].(map[string]interface{})["order_id"])
+	data = get["output"].(map[string]interface{})["data"].(map[string]interface{})
+	customer = data["customer"].(map[string]interface{})
+	assert.Regexp(t, `@example\.com$`, customer["email"])
+	assert.Len(t, customer["tags"], 1)
+	assert.Equal(t, "STATUS_ACTIVE", data["status"])
+	assert.Regexp(t, `^2024-`, data["created_at"])
+
+	// and the stubs pass gripmock check
+	_, services, err := loadDynamicServices(protocParam{descriptors: []string{setPath}, protoPath: []string{"shop.proto"}})
+	require.NoError(t, err)
+	problems, count, err := stub.CheckStubFiles(dir, false, stubChecker(services))
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+	assert.Equal(t, 2, count)
+
+	code, _ = scaffold(scaffoldOptions{dir: dir, data: "random"})
+	assert.Equal(t, EXITCODE_ARGUMENTS_ERROR, code)
+}
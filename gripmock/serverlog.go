@@ -0,0 +1,93 @@
+package main
+
+/*
+ * Capture of the generated server's output.
+ *
+ * The generated server logs with the standard library logger and prints a
+ * few lines such as "Serving gRPC on ...", none of which have levels. Its
+ * stdout and stderr are read line by line and logged through gripmock's own
+ * logger with "component"="server", so -verbosity applies to both processes
+ * and their output can be told apart. The standard logger's timestamp is
+ * dropped, and each line is given a level by what it says: errors, fatal
+ * errors and panics, with the whole of a panic's stack, are LOG_ERROR, the
+ * per-service and per-method registration lines are LOG_VERBOSE, and the
+ * rest LOG_INFO.
+ */
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// the standard logger's default date and time prefix
+var stdlogPrefix = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(\.\d+)? `)
+
+var serverErrorPattern = regexp.MustCompile(`(?i)\b(error|failed|fatal)\b`)
+
+// Lines starting with these are detail about the services registered
+var serverVerbosePrefixes = []string{"Registering server for ", "Registered method ", "Excluded method "}
+
+// Logs what the server writes to one of its output streams, a line at a
+// time
+type serverLogWriter struct {
+	stream string
+	// a line without its newline yet
+	partial []byte
+	// a panic started, so the rest is its stack
+	panicking bool
+}
+
+func newServerLogWriter(stream string) *serverLogWriter {
+	return &serverLogWriter{stream: stream}
+}
+
+func (w *serverLogWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.logLine(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Log any last line without a newline, once the server has exited
+func (w *serverLogWriter) flush() {
+	if len(w.partial) > 0 {
+		w.logLine(string(w.partial))
+		w.partial = nil
+	}
+}
+
+func (w *serverLogWriter) logLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if strings.HasPrefix(line, "panic: ") || strings.HasPrefix(line, "fatal error: ") {
+		w.panicking = true
+	}
+	level, msg := serverLogLevel(line)
+	if w.panicking {
+		level = LOG_ERROR
+	}
+	log.V(level).Info(msg, "component", "server", "stream", w.stream)
+}
+
+// The level and message of a line of server output
+func serverLogLevel(line string) (int, string) {
+	msg := stdlogPrefix.ReplaceAllString(line, "")
+	for _, prefix := range serverVerbosePrefixes {
+		if strings.HasPrefix(msg, prefix) {
+			return LOG_VERBOSE, msg
+		}
+	}
+	if serverErrorPattern.MatchString(msg) {
+		return LOG_ERROR, msg
+	}
+	return LOG_INFO, msg
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_serverLogLevel(t *testing.T) {
+	for line, want := range map[string]struct {
+		level int
+		msg   string
+	}{
+		"Serving gRPC on tcp://:4770":                                   {LOG_INFO, "Serving gRPC on tcp://:4770"},
+		"2023/05/01 10:00:00 Registering server for helloworld.Greeter": {LOG_VERBOSE, "Registering server for helloworld.Greeter"},
+		"2023/05/01 10:00:00.123456 failed to listen: address in use":   {LOG_ERROR, "failed to listen: address in use"},
+		"2023/05/01 10:00:00 setting up tracing...":                     {LOG_INFO, "setting up tracing..."},
+	} {
+		level, msg := serverLogLevel(line)
+		assert.Equal(t, want.level, level, line)
+		assert.Equal(t, want.msg, msg, line)
+	}
+}
+
+// Records what's logged at any level
+type captureSink struct {
+	logged []capturedLog
+}
+
+type capturedLog struct {
+	level int
+	msg   string
+	kv    []interface{}
+}
+
+func (s *captureSink) Init(logr.RuntimeInfo)  {}
+func (s *captureSink) Enabled(level int) bool { return true }
+func (s *captureSink) Info(level int, msg string, kv ...interface{}) {
+	s.logged = append(s.logged, capturedLog{level, msg, kv})
+}
+func (s *captureSink) Error(err error, msg string, kv ...interface{}) {}
+func (s *captureSink) WithValues(kv ...interface{}) logr.LogSink      { return s }
+func (s *captureSink) WithName(name string) logr.LogSink              { return s }
+
+func Test_serverLogWriter(t *testing.T) {
+	saved := log
+	defer func() { log = saved }()
+	sink := &captureSink{}
+	log = logr.New(sink)
+
+	w := newServerLogWriter("stderr")
+	w.Write([]byte("2023/05/01 10:00:00 Registered method helloworld.Greeter/SayHello (standard)\nServing"))
+	w.Write([]byte(" gRPC\n\npanic: oops\n\ngoroutine 1 [running]:\nmain.main()"))
+	w.flush()
+	kv := []interface{}{"component", "server", "stream", "stderr"}
+	assert.Equal(t, []capturedLog{
+		{LOG_VERBOSE, "Registered method helloworld.Greeter/SayHello (standard)", kv},
+		{LOG_INFO, "Serving gRPC", kv},
+		{LOG_ERROR, "panic: oops", kv},
+		{LOG_ERROR, "goroutine 1 [running]:", kv},
+		{LOG_ERROR, "main.main()", kv},
+	}, sink.logged)
+}
@@ -0,0 +1,189 @@
+package main
+
+/*
+ * Standalone generated projects.
+ *
+ * The generated server normally looks up every call's stub on the admin
+ * server of the gripmock process that built and runs it. "gripmock generate"
+ * instead stops once the server module is generated and its dependencies
+ * resolved, and makes the module standalone: gripmock's stub package, and
+ * the admin API package it uses, are copied into it under
+ * STANDALONE_PACKAGE_DIR, and the server runs the stub admin server in its
+ * own process, loading the stub files of its -stub flag, by default the
+ * copies of gripmock's -stub files in STANDALONE_STUB_DIR. The module can
+ * then be vendored, customized and built with plain go build, with no
+ * gripmock, protoc or code generation at test time.
+ *
+ * The stub package's dependencies are required at the versions gripmock was
+ * built with, which go mod tidy then resolves along with the server's own.
+ */
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ringerc/gripmock/stub"
+)
+
+const (
+	// dir in the module the stub and admin API packages are copied to
+	STANDALONE_PACKAGE_DIR = "internal/gripmock"
+	// dir in the module the stub files are copied to
+	STANDALONE_STUB_DIR = "stubs"
+	// import path of gripmock's own packages, rewritten in the copies
+	GRIPMOCK_MODULE = "github.com/ringerc/gripmock"
+)
+
+//go:embed stub/*.go adminpb/*.go
+var standaloneSources embed.FS
+
+// Import path of the copied stub package in the generated module
+func standaloneStubPackage(layout generatedLayout) string {
+	return path.Join(layout.moduleName(), STANDALONE_PACKAGE_DIR, "stub")
+}
+
+// Copy the stub and admin API packages' sources into the module in dir,
+// with their imports of each other rewritten to the copies. Returns the
+// packages they import.
+func writeStandaloneSources(dir string, layout generatedLayout) ([]string, error) {
+	prefix := path.Join(layout.moduleName(), STANDALONE_PACKAGE_DIR)
+	imported := map[string]bool{}
+	err := fs.WalkDir(standaloneSources, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(name, "_test.go") {
+			return err
+		}
+		byt, err := standaloneSources.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), name, byt, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+		for _, imp := range file.Imports {
+			if p, err := strconv.Unquote(imp.Path.Value); err == nil && !strings.HasPrefix(p, GRIPMOCK_MODULE+"/") {
+				imported[p] = true
+			}
+		}
+		byt = bytes.ReplaceAll(byt, []byte(`"`+GRIPMOCK_MODULE+"/"), []byte(`"`+prefix+"/"))
+		out := filepath.Join(dir, filepath.FromSlash(STANDALONE_PACKAGE_DIR), filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(out), os.ModePerm); err != nil {
+			return err
+		}
+		return os.WriteFile(out, byt, 0644)
+	})
+	if err != nil {
+		return nil, err
+	}
+	imports := []string{}
+	for p := range imported {
+		imports = append(imports, p)
+	}
+	sort.Strings(imports)
+	return imports, nil
+}
+
+// The modules gripmock was built with that provide the packages imports,
+// as path@version
+func standaloneRequirements(imports []string, deps []*debug.Module) []string {
+	reqs := []string{}
+	for _, dep := range deps {
+		for _, imp := range imports {
+			if imp == dep.Path || strings.HasPrefix(imp, dep.Path+"/") {
+				reqs = append(reqs, dep.Path+"@"+dep.Version)
+				break
+			}
+		}
+	}
+	sort.Strings(reqs)
+	return reqs
+}
+
+// Add the stub package's sources to the module in dir, requiring the
+// modules it needs that go.mod doesn't already
+func makeStandalone(dir string, layout generatedLayout, env []string) error {
+	imports, err := writeStandaloneSources(dir, layout)
+	if err != nil {
+		return fmt.Errorf("copying the stub package: %w", err)
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		log.V(LOG_INFO).Info("WARNING: gripmock has no module build info, leaving go mod tidy to resolve the stub package's dependencies")
+		return nil
+	}
+
+	run := exec.Command("go", "mod", "edit", "-json")
+	run.Dir = dir
+	run.Env = env
+	run.Stderr = os.Stderr
+	out, err := run.Output()
+	if err != nil {
+		return fmt.Errorf("reading go.mod: %w", err)
+	}
+	mod := struct {
+		Require []struct{ Path string }
+	}{}
+	if err := json.Unmarshal(out, &mod); err != nil {
+		return fmt.Errorf("reading go.mod: %w", err)
+	}
+	required := map[string]bool{}
+	for _, r := range mod.Require {
+		required[r.Path] = true
+	}
+	args := []string{"mod", "edit"}
+	for _, req := range standaloneRequirements(imports, info.Deps) {
+		if p, _, _ := strings.Cut(req, "@"); !required[p] {
+			args = append(args, "-require="+req)
+		}
+	}
+	if len(args) == 2 {
+		return nil
+	}
+	run = exec.Command("go", args...)
+	run.Dir = dir
+	run.Env = env
+	run.Stdout = os.Stdout
+	run.Stderr = os.Stderr
+	log.V(LOG_DEBUG).Info("requiring the stub package's dependencies", "cmd", run.String())
+	if err := run.Run(); err != nil {
+		return fmt.Errorf("requiring the stub package's dependencies: %w", err)
+	}
+	return nil
+}
+
+// Copy the stub files of a -stub list into STANDALONE_STUB_DIR in dir, by
+// their path below the directory or pattern base they were found in
+func copyStandaloneStubs(stubPath, dir string) error {
+	files, err := stub.FindStubFiles(stubPath)
+	if err != nil {
+		return err
+	}
+	sources := map[string]string{}
+	for _, f := range files {
+		name := filepath.Join(dir, STANDALONE_STUB_DIR, filepath.FromSlash(f.Rel))
+		if other, ok := sources[name]; ok {
+			return fmt.Errorf("stub files %s and %s would both be copied to %s", other, f.Path, name)
+		}
+		sources[name] = f.Path
+		if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+			return err
+		}
+		if err := copyFile(f.Path, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStandaloneSources(t *testing.T) {
+	dir := t.TempDir()
+	layout := generatedLayout{module: "example.com/mocks"}
+	imports, err := writeStandaloneSources(dir, layout)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/mocks/internal/gripmock/stub", standaloneStubPackage(layout))
+
+	stubDir := filepath.Join(dir, "internal", "gripmock", "stub")
+	files, err := filepath.Glob(filepath.Join(stubDir, "*.go"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, files)
+	for _, f := range files {
+		assert.False(t, strings.HasSuffix(f, "_test.go"), f)
+	}
+	byt, err := os.ReadFile(filepath.Join(stubDir, "grpcadmin.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(byt), `"example.com/mocks/internal/gripmock/adminpb"`)
+	assert.NotContains(t, string(byt), GRIPMOCK_MODULE)
+	assert.FileExists(t, filepath.Join(dir, "internal", "gripmock", "adminpb", "admin.pb.go"))
+
+	assert.Contains(t, imports, "github.com/go-chi/chi")
+	for _, imp := range imports {
+		assert.False(t, strings.HasPrefix(imp, GRIPMOCK_MODULE), imp)
+	}
+}
+
+func TestStandaloneRequirements(t *testing.T) {
+	deps := []*debug.Module{
+		{Path: "github.com/go-chi/chi", Version: "v4.1.2+incompatible"},
+		{Path: "go.starlark.net", Version: "v0.0.0-20230302034142-4b1e35fe2254"},
+		{Path: "github.com/bufbuild/protocompile", Version: "v0.6.0"},
+	}
+	assert.Equal(t, []string{
+		"github.com/go-chi/chi@v4.1.2+incompatible",
+		"go.starlark.net@v0.0.0-20230302034142-4b1e35fe2254",
+	}, standaloneRequirements([]string{"fmt", "github.com/go-chi/chi", "go.starlark.net/starlark", "go.starlark.net/syntax"}, deps))
+}
+
+func TestCopyStandaloneStubs(t *testing.T) {
+	src := t.TempDir()
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "users"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "hello.json"), []byte(`{}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "users", "get.json"), []byte(`[]`), 0644))
+
+	require.NoError(t, copyStandaloneStubs(src, dir))
+	assert.FileExists(t, filepath.Join(dir, STANDALONE_STUB_DIR, "hello.json"))
+	assert.FileExists(t, filepath.Join(dir, STANDALONE_STUB_DIR, "users", "get.json"))
+}
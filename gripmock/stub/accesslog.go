@@ -0,0 +1,195 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ * Access log.
+ *
+ * With Options.AccessLog, each finished call is written to that file as one
+ * JSON line, with its method, the client's address, status code, duration
+ * and the IDs of the stubs it matched, taken from the request journal. It's
+ * separate from gripmock's own log, so it can be kept for longer and fed to
+ * log tooling without the rest of the output.
+ *
+ * The file is rotated when it would grow past Options.AccessLogMaxSize
+ * bytes, or when it's been open for Options.AccessLogRotate: it's renamed
+ * with the time as a suffix, e.g. "access.log.20060102-150405.000", and a
+ * new file started. Only the newest Options.AccessLogBackups rotated files
+ * are kept.
+ */
+
+// Rotated files are named after the log file with the time they were
+// rotated in this format
+const ACCESS_LOG_TIME_FORMAT = "20060102-150405.000"
+
+type accessLogEntry struct {
+	Time          time.Time `json:"time"`
+	CallID        string    `json:"call_id,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	// full gRPC method name, e.g. "/pkg.Service/Method"
+	Method string `json:"method"`
+	Peer   string `json:"peer,omitempty"`
+	Status string `json:"status"`
+	// as a go duration string
+	Duration string   `json:"duration,omitempty"`
+	Stubs    []string `json:"stubs"`
+}
+
+// A log file that rotates itself
+type rotatingFile struct {
+	mx   sync.Mutex
+	path string
+	// rotate before a write would make the file bigger than this; 0 for
+	// no limit
+	maxSize int64
+	// rotate once the file has been open this long; 0 for no limit
+	maxAge time.Duration
+	// rotated files kept; 0 keeps them all
+	backups int
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// the access log, if Options.AccessLog is set
+var accessLog *rotatingFile
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, backups int) (*rotatingFile, error) {
+	if maxSize < 0 || maxAge < 0 || backups < 0 {
+		return nil, fmt.Errorf("access log size, age and backups can't be negative")
+	}
+	f := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, backups: backups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Open the log file, appending to it if it exists
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	full := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	old := f.maxAge > 0 && time.Since(f.opened) >= f.maxAge
+	if full || old {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Move the file aside and start a new one, then drop the oldest rotated
+// files beyond the number of backups
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	stamp := time.Now().Format(ACCESS_LOG_TIME_FORMAT)
+	rotated := f.path + "." + stamp
+	for i := 1; fileExists(rotated); i++ {
+		// rotated twice in a millisecond
+		rotated = fmt.Sprintf("%s.%s.%d", f.path, stamp, i)
+	}
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	if f.backups == 0 {
+		return nil
+	}
+	old, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	// the suffixes sort in time order
+	sort.Strings(old)
+	for len(old) > f.backups {
+		if err := os.Remove(old[0]); err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (f *rotatingFile) Close() error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// Write a finished call to the access log, if there is one, with the stubs
+// from its journal entry
+func logAccess(c callReport, entry JournalEntry) {
+	if accessLog == nil {
+		return
+	}
+	stubs := entry.Stubs
+	if stubs == nil {
+		stubs = []string{}
+	}
+	byt, err := json.Marshal(accessLogEntry{
+		Time:          time.Now(),
+		CallID:        c.CallID,
+		CorrelationID: entry.CorrelationID,
+		Method:        c.Method,
+		Peer:          c.Peer,
+		Status:        c.Code.String(),
+		Duration:      c.Latency,
+		Stubs:         stubs,
+	})
+	if err != nil {
+		log.Printf("access log: encoding %s: %v", c.Method, err)
+		return
+	}
+	if _, err := accessLog.Write(append(byt, '\n')); err != nil {
+		log.Printf("access log: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package stub
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFileSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := openRotatingFile(path, 10, 0, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	byt, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "gggg\n", string(byt))
+
+	rotated, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	require.Len(t, rotated, 2, "only the newest backups are kept")
+	byt, err = os.ReadFile(rotated[1])
+	require.NoError(t, err)
+	assert.Equal(t, "eeee\nffff\n", string(byt))
+}
+
+func TestRotatingFileAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, os.WriteFile(path, []byte("earlier\n"), 0644))
+	f, err := openRotatingFile(path, 0, time.Hour, 0)
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("appended\n"))
+	require.NoError(t, err)
+	byt, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "earlier\nappended\n", string(byt))
+
+	f.opened = time.Now().Add(-2 * time.Hour)
+	_, err = f.Write([]byte("rotated\n"))
+	require.NoError(t, err)
+	byt, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "rotated\n", string(byt))
+	rotated, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	assert.Len(t, rotated, 1)
+}
+
+func TestLogAccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := openRotatingFile(path, 0, 0, 0)
+	require.NoError(t, err)
+	accessLog = f
+	defer func() {
+		accessLog = nil
+		f.Close()
+	}()
+
+	logAccess(callReport{
+		Method:  "/pkg.Greeter/SayHello",
+		CallID:  "1-1",
+		Code:    StatusCode(5),
+		Latency: "1.5ms",
+		Peer:    "127.0.0.1:5000",
+	}, JournalEntry{Stubs: []string{"abc", JOURNAL_UNMATCHED}, CorrelationID: "req-1"})
+	logAccess(callReport{Method: "/pkg.Greeter/SayBye"}, JournalEntry{})
+
+	byt, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(byt)), "\n")
+	require.Len(t, lines, 2)
+	entry := accessLogEntry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "/pkg.Greeter/SayHello", entry.Method)
+	assert.Equal(t, "127.0.0.1:5000", entry.Peer)
+	assert.Equal(t, "NOT_FOUND", entry.Status)
+	assert.Equal(t, "1.5ms", entry.Duration)
+	assert.Equal(t, []string{"abc", JOURNAL_UNMATCHED}, entry.Stubs)
+	assert.Equal(t, "req-1", entry.CorrelationID)
+	assert.Contains(t, lines[1], `"stubs":[]`)
+}
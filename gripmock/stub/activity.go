@@ -0,0 +1,224 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Live activity stream.
+ *
+ * As calls come in, the admin server publishes an event for each step:
+ * the call starting, each stub lookup matching or not, and the call
+ * finishing. /activity streams them to any number of watchers as
+ * server-sent events, so traffic can be followed while debugging with
+ * nothing more than curl or a browser's EventSource. Nothing is kept: a
+ * watcher only sees what happens while it's connected, and a watcher that
+ * falls too far behind misses events rather than holding up calls.
+ */
+
+const (
+	// a call started; has its headers
+	ACTIVITY_REQUEST = "request"
+	// a lookup matched a stub; has the message and stub ID
+	ACTIVITY_MATCHED = "matched"
+	// a lookup matched no stub; has the message and the error
+	ACTIVITY_UNMATCHED = "unmatched"
+	// a call finished with OK
+	ACTIVITY_FINISHED = "finished"
+	// a call finished with an error status
+	ACTIVITY_ERROR = "error"
+)
+
+// Events buffered for each watcher before further events are dropped
+const ACTIVITY_BUFFER_SIZE = 256
+
+// Time between keepalive comments on an idle stream
+const ACTIVITY_HEARTBEAT = 15 * time.Second
+
+type ActivityEvent struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	CallID string    `json:"call_id,omitempty"`
+	// test session of the call, see session.go
+	Session string `json:"session,omitempty"`
+	// see correlation.go
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// fully qualified when reported by the gRPC server
+	Service string                 `json:"service"`
+	Method  string                 `json:"method"`
+	Headers map[string]string      `json:"headers,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	StubID  string                 `json:"stub_id,omitempty"`
+	// status code name and duration of a finished call
+	Status  string `json:"status,omitempty"`
+	Latency string `json:"latency,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type activityHub struct {
+	mx       sync.Mutex
+	watchers map[chan ActivityEvent]bool
+}
+
+var activity = &activityHub{watchers: map[chan ActivityEvent]bool{}}
+
+func (h *activityHub) watch() chan ActivityEvent {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	ch := make(chan ActivityEvent, ACTIVITY_BUFFER_SIZE)
+	h.watchers[ch] = true
+	return ch
+}
+
+func (h *activityHub) unwatch(ch chan ActivityEvent) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	delete(h.watchers, ch)
+}
+
+// Send an event to every watcher with room for it
+func (h *activityHub) publish(e ActivityEvent) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if len(h.watchers) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for ch := range h.watchers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (h *activityHub) callStarted(c callReport) {
+	service, method := splitMethod(c.Method)
+	h.publish(ActivityEvent{
+		Type:          ACTIVITY_REQUEST,
+		CallID:        c.CallID,
+		Session:       callSession(c.Headers),
+		CorrelationID: correlationID(c.Headers),
+		Service:       service,
+		Method:        method,
+		Headers:       c.Headers,
+	})
+}
+
+// Publish a finished call, with its journal entry for what the report
+// doesn't carry
+func (h *activityHub) callFinished(c callReport, entry JournalEntry) {
+	service, method := splitMethod(c.Method)
+	e := ActivityEvent{
+		Type:          ACTIVITY_FINISHED,
+		CallID:        c.CallID,
+		Session:       entry.Session,
+		CorrelationID: entry.CorrelationID,
+		Service:       service,
+		Method:        method,
+		Status:        c.Code.String(),
+		Latency:       c.Latency,
+	}
+	if c.Code != 0 {
+		e.Type = ACTIVITY_ERROR
+	}
+	h.publish(e)
+}
+
+// Publish a stub lookup; err is the not found error if no stub matched
+func (h *activityHub) lookup(call *findStubPayload, stubID string, err error) {
+	e := ActivityEvent{
+		Type:          ACTIVITY_MATCHED,
+		CallID:        call.CallID,
+		Session:       callSession(call.Headers),
+		CorrelationID: correlationID(call.Headers),
+		Service:       call.Service,
+		Method:        call.Method,
+		Data:          call.Data,
+		StubID:        stubID,
+	}
+	if err != nil {
+		e.Type = ACTIVITY_UNMATCHED
+		e.Error = err.Error()
+	}
+	h.publish(e)
+}
+
+// Criteria for streamed events. Every one that is set must match.
+type activityFilter struct {
+	// fully qualified or short service name
+	service string
+	method  string
+	session string
+	types   map[string]bool
+}
+
+func (f activityFilter) matches(e ActivityEvent) bool {
+	if f.service != "" && e.Service != f.service && !strings.HasSuffix(e.Service, "."+f.service) {
+		return false
+	}
+	if f.method != "" && !strings.EqualFold(e.Method, f.method) {
+		return false
+	}
+	if f.session != "" && e.Session != f.session {
+		return false
+	}
+	return len(f.types) == 0 || f.types[e.Type]
+}
+
+// Stream activity as server-sent events until the client goes away.
+// ?service=, ?method= and ?type= (a comma separated list) pick the events
+// sent, and in a session only its calls' events are sent.
+func streamActivity(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		responseError(fmt.Errorf("Streaming isn't supported on this connection"), w)
+		return
+	}
+	q := r.URL.Query()
+	f := activityFilter{service: q.Get("service"), method: q.Get("method"), session: requestSession(r)}
+	if v := q.Get("type"); v != "" {
+		f.types = map[string]bool{}
+		for _, t := range strings.Split(v, ",") {
+			f.types[strings.TrimSpace(t)] = true
+		}
+	}
+
+	ch := activity.watch()
+	defer activity.unwatch(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// let the client know it's connected before the first event
+	fmt.Fprint(w, ": watching gripmock activity\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(ACTIVITY_HEARTBEAT)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-ch:
+			if !f.matches(e) {
+				continue
+			}
+			byt, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, byt)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,86 @@
+package stub
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivityStream(t *testing.T) {
+	defer clearStorage()
+
+	wrt := httptest.NewRecorder()
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(
+		`{"id":"hello","service":"Greeter","method":"SayHello","input":{"equals":{"name":"bob"}},"output":{"data":{"message":"hi"}}}`))))
+	require.Equal(t, "Success add stub", wrt.Body.String())
+
+	server := httptest.NewServer(http.HandlerFunc(streamActivity))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "?type=request,matched,unmatched,error")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	lines := bufio.NewScanner(resp.Body)
+	require.True(t, lines.Scan())
+	assert.Equal(t, ": watching gripmock activity", lines.Text())
+
+	post := func(handler http.HandlerFunc, body string) {
+		handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/", bytes.NewReader([]byte(body))))
+	}
+	post(reportCall, `{"method":"/hello.Greeter/SayHello","type":"unary","delta":1,"call_id":"1-1","headers":{"x-user":"bob"}}`)
+	post(handleFindStub, `{"service":"Greeter","method":"SayHello","data":{"name":"bob"},"call_id":"1-1"}`)
+	post(reportCall, `{"method":"/hello.Greeter/SayHello","type":"unary","delta":-1,"call_id":"1-1","latency":"2ms"}`)
+	post(handleFindStub, `{"service":"Greeter","method":"SayHello","data":{"name":"eve"}}`)
+	post(reportCall, `{"method":"/hello.Greeter/SayHello","type":"unary","delta":-1,"call_id":"1-2","code":5,"latency":"3ms"}`)
+
+	next := func() ActivityEvent {
+		var typ string
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e := ActivityEvent{}
+				require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e))
+				assert.Equal(t, typ, e.Type)
+				return e
+			}
+		}
+		t.Fatal("activity stream ended")
+		return ActivityEvent{}
+	}
+
+	e := next()
+	assert.Equal(t, ACTIVITY_REQUEST, e.Type)
+	assert.Equal(t, "hello.Greeter", e.Service)
+	assert.Equal(t, map[string]string{"x-user": "bob"}, e.Headers)
+	e = next()
+	assert.Equal(t, ACTIVITY_MATCHED, e.Type)
+	assert.Equal(t, "hello", e.StubID)
+	assert.Equal(t, "1-1", e.CallID)
+	// the OK finish is filtered out
+	e = next()
+	assert.Equal(t, ACTIVITY_UNMATCHED, e.Type)
+	assert.Contains(t, e.Error, "Can't find stub")
+	e = next()
+	assert.Equal(t, ACTIVITY_ERROR, e.Type)
+	assert.Equal(t, "NOT_FOUND", e.Status)
+	assert.Equal(t, "3ms", e.Latency)
+}
+
+func TestActivitySlowWatcher(t *testing.T) {
+	ch := activity.watch()
+	defer activity.unwatch(ch)
+	for i := 0; i < ACTIVITY_BUFFER_SIZE+10; i++ {
+		activity.publish(ActivityEvent{Type: ACTIVITY_REQUEST})
+	}
+	assert.Len(t, ch, ACTIVITY_BUFFER_SIZE)
+}
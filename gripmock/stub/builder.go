@@ -0,0 +1,211 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+ * Stub builder.
+ *
+ * Go tests can build their stubs with a fluent builder instead of writing
+ * JSON or nested maps:
+ *
+ *	id, err := stub.For("helloworld.Greeter", "SayHello").
+ *		WhenInputEquals(&pb.HelloRequest{Name: "bob"}).
+ *		Return(&pb.HelloReply{Message: "Hello bob"}).
+ *		Post("http://localhost:4771")
+ *
+ * Messages can be generated proto messages, which are converted for input
+ * as the gRPC server sends requests to the admin server, Go structs with
+ * json tags, or maps. The builder keeps the first error it
+ * hits, e.g. a message that can't be converted, and returns it from Build,
+ * MarshalJSON or Post.
+ */
+
+// Builds a stub; see For
+type Builder struct {
+	stub Stub
+	err  error
+}
+
+// Start building a stub for a method. The service may be fully qualified,
+// e.g. "helloworld.Greeter"; stubs name it without its package.
+func For(service, method string) *Builder {
+	if i := strings.LastIndex(service, "."); i >= 0 {
+		service = service[i+1:]
+	}
+	return &Builder{stub: Stub{Service: service, Method: method}}
+}
+
+// Give the stub an ID, rather than having one generated when it's added
+func (b *Builder) ID(id string) *Builder {
+	b.stub.ID = id
+	return b
+}
+
+// Put the stub in a namespace, see Options.TenantKey
+func (b *Builder) Namespace(namespace string) *Builder {
+	b.stub.Namespace = namespace
+	return b
+}
+
+// Put the stub in a test session, see Options.SessionKey
+func (b *Builder) Session(session string) *Builder {
+	b.stub.Session = session
+	return b
+}
+
+// Match calls whose request is exactly this message
+func (b *Builder) WhenInputEquals(msg interface{}) *Builder {
+	b.stub.Input.Equals = b.fields("input", msg, true)
+	return b
+}
+
+// Match calls whose request has the fields set in this message
+func (b *Builder) WhenInputContains(msg interface{}) *Builder {
+	b.stub.Input.Contains = b.fields("input", msg, true)
+	return b
+}
+
+// Match calls whose request fields match these regular expressions, by
+// field name
+func (b *Builder) WhenInputMatches(patterns map[string]string) *Builder {
+	b.stub.Input.Matches = map[string]interface{}{}
+	for field, pattern := range patterns {
+		b.stub.Input.Matches[field] = pattern
+	}
+	return b
+}
+
+// Respond with this message
+func (b *Builder) Return(msg interface{}) *Builder {
+	b.stub.Output.Data = b.fields("output", msg, false)
+	return b
+}
+
+// Respond to a server-streaming or bidirectional call with these messages,
+// in order
+func (b *Builder) ReturnStream(msgs ...interface{}) *Builder {
+	b.stub.Output.Stream = make([]map[string]interface{}, len(msgs))
+	for i, msg := range msgs {
+		b.stub.Output.Stream[i] = b.fields(fmt.Sprintf("stream message %d", i), msg, false)
+	}
+	return b
+}
+
+// End the call with an error status; after ReturnStream, once the messages
+// have been sent
+func (b *Builder) ReturnError(code codes.Code, message string) *Builder {
+	b.stub.Output.Code = StatusCode(code)
+	b.stub.Output.Error = message
+	return b
+}
+
+// Send these header metadata before the response
+func (b *Builder) WithHeaders(headers map[string]string) *Builder {
+	b.stub.Output.Headers = headers
+	return b
+}
+
+// Send these trailing metadata with the response status
+func (b *Builder) WithTrailers(trailers map[string]string) *Builder {
+	b.stub.Output.Trailers = trailers
+	return b
+}
+
+// Wait this long before responding
+func (b *Builder) Delay(d time.Duration) *Builder {
+	b.stub.Output.Delay = d.String()
+	return b
+}
+
+// The stub built, checked as the admin API checks stubs added to it
+func (b *Builder) Build() (*Stub, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	stub := b.stub
+	if stub.Input.Equals == nil && stub.Input.Contains == nil && stub.Input.Matches == nil {
+		// matches any call, as no fields are required
+		stub.Input.Contains = map[string]interface{}{}
+	}
+	if err := validateStub(&stub); err != nil {
+		return nil, err
+	}
+	return &stub, nil
+}
+
+// The stub as JSON, as added on the admin API's /add or in a stub file
+func (b *Builder) MarshalJSON() ([]byte, error) {
+	stub, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stub)
+}
+
+// Add the stub on the admin API at adminURL, e.g. "http://localhost:4771",
+// returning its ID
+func (b *Builder) Post(adminURL string) (string, error) {
+	byt, err := b.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.Post(strings.TrimSuffix(adminURL, "/")+"/add", "application/json", bytes.NewReader(byt))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("adding stub for %s/%s: %s: %s", b.stub.Service, b.stub.Method, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.Header.Get("X-Gripmock-Stub-Id"), nil
+}
+
+// A message as a map of its fields. Proto messages are converted for input
+// as the generated gRPC server sends requests to the admin server, with
+// encoding/json of the generated struct, so int64s are numbers and well
+// known types such as Timestamp are {"seconds": ..., "nanos": ...}
+// objects. Output is converted with protojson, which the server parses
+// responses with.
+func (b *Builder) fields(what string, msg interface{}, input bool) map[string]interface{} {
+	if b.err != nil {
+		return nil
+	}
+	var byt []byte
+	var err error
+	switch msg := msg.(type) {
+	case nil:
+		return map[string]interface{}{}
+	case map[string]interface{}:
+		return msg
+	case proto.Message:
+		if input {
+			byt, err = json.Marshal(msg)
+		} else {
+			byt, err = protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+		}
+	default:
+		byt, err = json.Marshal(msg)
+	}
+	fields := map[string]interface{}{}
+	if err == nil {
+		err = json.Unmarshal(byt, &fields)
+	}
+	if err != nil {
+		b.err = fmt.Errorf("stub for %s/%s: %s must be a proto message, or a struct or map that is a JSON object: %w", b.stub.Service, b.stub.Method, what, err)
+		return nil
+	}
+	return fields
+}
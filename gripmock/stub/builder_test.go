@@ -0,0 +1,106 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/ringerc/gripmock/adminpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestBuilder(t *testing.T) {
+	type reply struct {
+		Message string `json:"message"`
+		Count   int    `json:"count,omitempty"`
+	}
+
+	byt, err := For("helloworld.Greeter", "SayHello").
+		ID("hello-bob").
+		WhenInputEquals(&adminpb.FindStubRequest{Service: "Greeter", Headers: map[string]string{"x-user": "bob"}}).
+		Return(reply{Message: "Hello bob"}).
+		WithTrailers(map[string]string{"x-served-by": "gripmock"}).
+		Delay(1500 * time.Millisecond).
+		MarshalJSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"id": "hello-bob", "service": "Greeter", "method": "SayHello",
+		"input": {"equals": {"service": "Greeter", "headers": {"x-user": "bob"}}, "contains": null, "matches": null},
+		"output": {"data": {"message": "Hello bob"}, "error": "", "trailers": {"x-served-by": "gripmock"}, "delay": "1.5s"}
+	}`, string(byt))
+
+	s, err := For("Greeter", "sayBye").
+		WhenInputMatches(map[string]string{"name": "^b"}).
+		ReturnStream(map[string]interface{}{"message": "bye"}, reply{Message: "bye", Count: 2}).
+		ReturnError(codes.Unavailable, "gone").
+		Build()
+	require.NoError(t, err)
+	assert.Equal(t, "SayBye", s.Method)
+	assert.Equal(t, []map[string]interface{}{{"message": "bye"}, {"message": "bye", "count": float64(2)}}, s.Output.Stream)
+	assert.Equal(t, StatusCode(codes.Unavailable), s.Output.Code)
+
+	// any call without input rules
+	s, err = For("Greeter", "SayHello").Return(nil).Build()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{}, s.Input.Contains)
+
+	_, err = For("Greeter", "SayHello").WhenInputEquals(make(chan int)).Return(nil).Build()
+	assert.ErrorContains(t, err, "stub for Greeter/SayHello: input must be a proto message, or a struct or map")
+	_, err = For("Greeter", "SayHello").WhenInputEquals("bob").Return(nil).Build()
+	assert.Error(t, err)
+	_, err = For("", "SayHello").Build()
+	assert.ErrorContains(t, err, "Service name can't be empty")
+}
+
+func TestBuilderPost(t *testing.T) {
+	defer clearStorage()
+	srv := httptest.NewServer(http.HandlerFunc(addStub))
+	defer srv.Close()
+
+	id, err := For("Greeter", "SayHello").ID("posted").WhenInputContains(map[string]interface{}{"name": "bob"}).Return(nil).Post(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "posted", id)
+	s, err := getStub("posted")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "bob"}, s.Input.Contains)
+
+	_, err = For("Greeter", "SayHello").ID("posted").Return(nil).Post(srv.URL)
+	assert.ErrorContains(t, err, "adding stub for Greeter/SayHello: 409 Conflict")
+}
+
+func TestBuilderMatchesGeneratedServer(t *testing.T) {
+	defer clearStorage()
+	r := chi.NewRouter()
+	r.Post("/add", addStub)
+	r.Post("/find", handleFindStub)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// a request with an int64 and a Timestamp, posted to /find as the
+	// generated server's postFind does, with encoding/json
+	req := &adminpb.Stub{Id: "a", Hits: 5, LastHit: timestamppb.New(time.Unix(1700000000, 500))}
+	find := func() int {
+		byt, err := json.Marshal(map[string]interface{}{"service": "Admin", "method": "Check", "data": req})
+		require.NoError(t, err)
+		resp, err := http.Post(srv.URL+"/find", "application/json", bytes.NewReader(byt))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	_, err := For("Admin", "Check").ID("equals").WhenInputEquals(req).Return(nil).Post(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, find())
+	clearStorage()
+
+	_, err = For("Admin", "Check").ID("contains").WhenInputContains(&adminpb.Stub{Hits: 5, LastHit: req.LastHit}).Return(nil).Post(srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, find())
+}
@@ -0,0 +1,373 @@
+package stub
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Bulk import and export.
+ *
+ * /export downloads every stub as a JSON array, which can be loaded again
+ * with -stub or POSTed to /import on another gripmock. /import takes a JSON
+ * array (or a single stub), or a tar, tar.gz or zip archive of stub files
+ * laid out as for -stub, and adds all of them or, if any is invalid or has
+ * an ID already in use, none. An archive may only decompress to
+ * Options.ImportMaxSize, so a small upload can't exhaust memory.
+ */
+
+// Largest an import archive may decompress to by default
+const DEFAULT_IMPORT_MAX_SIZE = 64 << 20
+
+// see Options.ImportMaxSize
+var importMaxSize int64 = DEFAULT_IMPORT_MAX_SIZE
+
+var errImportTooLarge = errors.New("import too large")
+
+// A stub from an import, with where it came from for error messages
+type importedStub struct {
+	from string
+	stub *Stub
+}
+
+// The stubs of a session, or every stub if session is empty, as listStubs
+func sessionStubs(session string) []*Stub {
+	stubs := listStubs()
+	if session == "" {
+		return stubs
+	}
+	inSession := []*Stub{}
+	for _, s := range stubs {
+		if s.Session == session {
+			inSession = append(inSession, s)
+		}
+	}
+	return inSession
+}
+
+// Every stub, by service then method and in match order within a method
+func listStubs() []*Stub {
+	mx.Lock()
+	defer mx.Unlock()
+	listed := []*Stub{}
+	for service, methods := range stubStorage {
+		for method, stubs := range methods {
+			for _, s := range stubs {
+				listed = append(listed, &Stub{
+					ID:        s.ID,
+					Service:   service,
+					Method:    method,
+					Namespace: s.Namespace,
+					Session:   s.Session,
+					Input:     s.Input,
+					Output:    s.Output,
+				})
+			}
+		}
+	}
+	sort.SliceStable(listed, func(i, j int) bool {
+		if listed[i].Service != listed[j].Service {
+			return listed[i].Service < listed[j].Service
+		}
+		return listed[i].Method < listed[j].Method
+	})
+	return listed
+}
+
+func (sm stubMapping) clone() stubMapping {
+	c := stubMapping{}
+	for service, methods := range sm {
+		c[service] = map[string][]storage{}
+		for method, stubs := range methods {
+			c[service][method] = append([]storage{}, stubs...)
+		}
+	}
+	return c
+}
+
+// Add all the stubs, after the existing ones or in place of them, or none
+// if any can't be stored. Replacing in a session only replaces its stubs.
+func importStubs(stubs []importedStub, replace bool, session string) error {
+	mx.Lock()
+	defer mx.Unlock()
+	staged := stubStorage.clone()
+	if replace && session == "" {
+		staged = stubMapping{}
+	} else if replace {
+		staged.removeSession(session)
+	}
+	for _, s := range stubs {
+		if err := staged.add(s.stub); err != nil {
+			return fmt.Errorf("%s: %w", s.from, err)
+		}
+	}
+	if replace && session == "" {
+		forgetHits(stubStorage)
+	} else if replace {
+		forgetHits(stubStorage.session(session))
+	}
+	stubStorage = staged
+	publishStubs()
+	stubsChanged()
+	return nil
+}
+
+// Read the stubs in an import body, which is stub JSON or an archive of
+// .json stub files
+func parseImport(body []byte) ([]importedStub, error) {
+	files, archive, err := archiveStubFiles(body)
+	if err != nil {
+		return nil, err
+	}
+	if !archive {
+		files = []stubFile{{"", body}}
+	}
+	imported := []importedStub{}
+	for _, f := range files {
+		if err := checkImportSchema(f.name, f.byt); err != nil {
+			return nil, err
+		}
+		stubs, err := parseStubs(f.byt)
+		if err != nil {
+			if f.name == "" {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s: %v", f.name, err)
+		}
+		imported = append(imported, importedFrom(f.name, stubs)...)
+	}
+	return imported, nil
+}
+
+// A stub file in an archive
+type stubFile struct {
+	name string
+	byt  []byte
+}
+
+// The stub files in a tar, tar.gz or zip archive, or false if body isn't
+// one
+func archiveStubFiles(body []byte) ([]stubFile, bool, error) {
+	budget := importMaxSize
+	switch {
+	case bytes.HasPrefix(body, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, true, err
+		}
+		tarball, err := readLimited(gz, &budget)
+		if err != nil {
+			return nil, true, err
+		}
+		files, err := tarStubFiles(tarball)
+		return files, true, err
+	case bytes.HasPrefix(body, []byte("PK\x03\x04")):
+		files, err := zipStubFiles(body, &budget)
+		return files, true, err
+	case len(body) > 262 && string(body[257:262]) == "ustar":
+		files, err := tarStubFiles(body)
+		return files, true, err
+	}
+	return nil, false, nil
+}
+
+func importedFrom(file string, stubs []*Stub) []importedStub {
+	imported := []importedStub{}
+	for i, s := range stubs {
+		from := fmt.Sprintf("stub %d", i)
+		if file != "" {
+			from = file + " " + from
+		}
+		imported = append(imported, importedStub{from, s})
+	}
+	return imported
+}
+
+func tarStubFiles(tarball []byte) ([]stubFile, error) {
+	files := []stubFile{}
+	tr := tar.NewReader(bytes.NewReader(tarball))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || !isStubFile(hdr.Name) {
+			continue
+		}
+		byt, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, stubFile{hdr.Name, byt})
+	}
+}
+
+// Read r to the end, failing with errImportTooLarge once it's read more
+// than budget bytes, which it takes what it read from
+func readLimited(r io.Reader, budget *int64) ([]byte, error) {
+	byt, err := ioutil.ReadAll(io.LimitReader(r, *budget+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(byt)) > *budget {
+		return nil, fmt.Errorf("%w, it decompresses to more than %d bytes", errImportTooLarge, importMaxSize)
+	}
+	*budget -= int64(len(byt))
+	return byt, nil
+}
+
+func zipStubFiles(archive []byte, budget *int64) ([]stubFile, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+	files := []stubFile{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isStubFile(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		byt, err := readLimited(rc, budget)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, stubFile{f.Name, byt})
+	}
+	return files, nil
+}
+
+// Archives may carry other files, such as a README, and the metadata
+// files macOS adds beside each file
+func isStubFile(name string) bool {
+	base := name[strings.LastIndex(name, "/")+1:]
+	return strings.HasSuffix(base, ".json") && !strings.HasPrefix(base, ".")
+}
+
+// Add a set of stubs in one go. With ?replace=true they replace all the
+// existing stubs, or in a session the session's stubs.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	replace, ok := importReplace(w, r)
+	if !ok {
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responseError(err, w)
+		return
+	}
+	stubs, err := decodeStubList(r, body)
+	if errors.Is(err, errWrongMessage) {
+		decodeError(err, w)
+		return
+	}
+	if err == nil && stubs == nil {
+		stubs, err = parseImport(body)
+	}
+	if err != nil {
+		w.WriteHeader(importErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	finishImport(w, r, stubs, replace, importResult{})
+}
+
+// 413 for an archive that decompresses to too much, else 400
+func importErrorStatus(err error) int {
+	if errors.Is(err, errImportTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// The ?replace= of an import, or false having answered 400 if it's invalid
+func importReplace(w http.ResponseWriter, r *http.Request) (replace bool, ok bool) {
+	v := r.URL.Query().Get("replace")
+	if v == "" {
+		return false, true
+	}
+	replace, err := strconv.ParseBool(v)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Invalid replace \"%s\", must be true or false", v)))
+		return false, false
+	}
+	return replace, true
+}
+
+// The answer to an import
+type importResult struct {
+	Imported int      `json:"imported"`
+	IDs      []string `json:"ids"`
+	// WireMock mappings that couldn't be converted, and parts of the
+	// converted ones that were left out, see wiremock.go
+	Skipped []string `json:"skipped,omitempty"`
+	Dropped []string `json:"dropped,omitempty"`
+}
+
+// Validate and add the stubs of an import, and answer with result and
+// their IDs
+func finishImport(w http.ResponseWriter, r *http.Request, stubs []importedStub, replace bool, result importResult) {
+	for _, s := range stubs {
+		if err := validateStub(s.stub); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("%s: %v", s.from, err)))
+			return
+		}
+		if s.stub.Session == "" {
+			s.stub.Session = requestSession(r)
+		}
+	}
+
+	err := importStubs(stubs, replace, requestSession(r))
+	if errors.Is(err, errStubExists) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		responseError(err, w)
+		return
+	}
+
+	result.IDs = []string{}
+	for _, s := range stubs {
+		result.IDs = append(result.IDs, s.stub.ID)
+	}
+	result.Imported = len(result.IDs)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	stubs := sessionStubs(requestSession(r))
+	if responseEncoding(r) == ENCODING_PROTOBUF {
+		w.Header().Set("Content-Disposition", `attachment; filename="stubs.binpb"`)
+	} else {
+		w.Header().Set("Content-Disposition", `attachment; filename="stubs.json"`)
+	}
+	if writeStubListProto(w, r, stubs) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(stubs)
+}
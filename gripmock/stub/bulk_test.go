@@ -0,0 +1,129 @@
+package stub
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportExport(t *testing.T) {
+	defer clearStorage()
+	clearStorage()
+
+	post := func(query string, body []byte) *httptest.ResponseRecorder {
+		wrt := httptest.NewRecorder()
+		handleImport(wrt, httptest.NewRequest("POST", "/import"+query, bytes.NewReader(body)))
+		return wrt
+	}
+	hello := `{"id":"hello","service":"Greeter","method":"SayHello","input":{"equals":{"name":"bob"}},"output":{"data":{"message":"hi"}}}`
+	bye := `{"id":"bye","service":"Greeter","method":"SayGoodbye","input":{"contains":{}},"output":{"error":"gone","code":"NOT_FOUND"}}`
+
+	wrt := post("", []byte("["+hello+","+bye+"]"))
+	require.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+	assert.JSONEq(t, `{"imported":2,"ids":["hello","bye"]}`, wrt.Body.String())
+
+	// nothing is added if any stub is rejected
+	wrt = post("", []byte(`[{"id":"new","service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{}}},`+hello+`]`))
+	assert.Equal(t, http.StatusConflict, wrt.Code)
+	assert.Equal(t, "stub 1: A stub already exists with ID: hello", wrt.Body.String())
+	wrt = post("", []byte(`[{"service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{}}},{"service":"Greeter"}]`))
+	assert.Equal(t, http.StatusBadRequest, wrt.Code)
+	assert.Equal(t, "stub 1: Method name can't be emtpy", wrt.Body.String())
+	assert.Len(t, listStubs(), 2)
+
+	export := httptest.NewRecorder()
+	handleExport(export, httptest.NewRequest("GET", "/export", nil))
+	exported := []*Stub{}
+	require.NoError(t, json.Unmarshal(export.Body.Bytes(), &exported))
+	require.Len(t, exported, 2)
+	assert.Equal(t, "bye", exported[0].ID)
+	assert.Equal(t, "hello", exported[1].ID)
+
+	// the export can be imported again in place of the current stubs
+	clearStorage()
+	post("", []byte(`{"id":"other","service":"Other","method":"Do","input":{"contains":{}},"output":{"data":{}}}`))
+	wrt = post("?replace=true", export.Body.Bytes())
+	require.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+	stubs := listStubs()
+	require.Len(t, stubs, 2)
+	assert.Equal(t, exported, stubs)
+}
+
+func TestImportArchive(t *testing.T) {
+	defer clearStorage()
+	files := map[string]string{
+		"stubs/hello.json":   `{"service":"Greeter","method":"SayHello","input":{"equals":{"name":"bob"}},"output":{"data":{"message":"hi"}}}`,
+		"stubs/more.json":    `[{"service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{"message":"hello"}}}]`,
+		"stubs/README.md":    "not a stub",
+		"stubs/._hello.json": "\x00\x05",
+	}
+
+	var tarball bytes.Buffer
+	gz := gzip.NewWriter(&tarball)
+	tw := tar.NewWriter(gz)
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		tw.Write([]byte(content))
+		f, err := zw.Create(name)
+		require.NoError(t, err)
+		f.Write([]byte(content))
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, zw.Close())
+
+	for name, archive := range map[string][]byte{"tar.gz": tarball.Bytes(), "zip": zipped.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			clearStorage()
+			stubs, err := parseImport(archive)
+			require.NoError(t, err)
+			assert.Len(t, stubs, 2)
+			require.NoError(t, importStubs(stubs, false, ""))
+			assert.Len(t, listStubs(), 2)
+		})
+	}
+
+	_, err := parseImport([]byte(`{"service":`))
+	assert.Error(t, err)
+}
+
+func TestImportTooLarge(t *testing.T) {
+	defer clearStorage()
+	importMaxSize = 1 << 20
+	defer func() { importMaxSize = DEFAULT_IMPORT_MAX_SIZE }()
+
+	// a tar.gz and a zip of a few KiB that decompress to 2MiB
+	padding := bytes.Repeat([]byte(" "), 2<<20)
+	var tarball bytes.Buffer
+	gz := gzip.NewWriter(&tarball)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "big.json", Mode: 0644, Size: int64(len(padding)), Typeflag: tar.TypeReg}))
+	tw.Write(padding)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	f, err := zw.Create("big.json")
+	require.NoError(t, err)
+	f.Write(padding)
+	require.NoError(t, zw.Close())
+
+	for name, archive := range map[string][]byte{"tar.gz": tarball.Bytes(), "zip": zipped.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			wrt := httptest.NewRecorder()
+			handleImport(wrt, httptest.NewRequest("POST", "/import", bytes.NewReader(archive)))
+			assert.Equal(t, http.StatusRequestEntityTooLarge, wrt.Code)
+			assert.Equal(t, "import too large, it decompresses to more than 1048576 bytes", wrt.Body.String())
+		})
+	}
+}
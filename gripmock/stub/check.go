@@ -0,0 +1,119 @@
+package stub
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+/*
+ * Checking stub files without loading them.
+ *
+ * CheckStubFiles reads every stub file of a -stub list the way the stub
+ * server would load it, rendering templates and resolving includes, but
+ * stores nothing, and reports every problem that would get a stub skipped
+ * or leave it not doing what it says: malformed JSON, schema problems
+ * whatever -stub-validation is, includes that can't be resolved, invalid
+ * output and IDs used by more than one stub. A check function can add its
+ * own problems with each stub, e.g. that it doesn't fit the protos.
+ * Problems are SchemaErrors, placed at the offending field where it's known
+ * and otherwise at the start of the stub.
+ */
+
+// Check the stub files of a -stub list, rendering them as templates if
+// templates is set, calling check, if set, with each stub that parses.
+// Fields of check's problems are relative to the stub. Returns the problems
+// and how many stubs were checked.
+func CheckStubFiles(spec string, templates bool, check func(*Stub) SchemaErrors) (SchemaErrors, int, error) {
+	files, err := FindStubFiles(spec)
+	if err != nil {
+		return nil, 0, err
+	}
+	stubTemplates = templates
+
+	problems := SchemaErrors{}
+	count := 0
+	ids := map[string]string{}
+	for _, file := range files {
+		if isFragment(file.Rel) {
+			continue
+		}
+		byt, err := ioutil.ReadFile(file.Path)
+		if err == nil {
+			byt, err = renderStubFile(file.Path, byt)
+		}
+		if err != nil {
+			problems = append(problems, SchemaError{File: file.Path, Stub: -1, Message: err.Error()})
+			continue
+		}
+
+		errs := validateSchema(file.Path, byt)
+		problems = append(problems, errs...)
+		resolved, unresolved := resolveIncludes(file.Path, byt)
+		stubs, err := parseStubs(resolved)
+		if err != nil {
+			if len(errs) == 0 {
+				problems = append(problems, SchemaError{File: file.Path, Line: 1, Column: 1, Stub: -1, Message: err.Error()})
+			}
+			continue
+		}
+
+		isArray := bytes.HasPrefix(bytes.TrimSpace(byt), []byte("["))
+		starts := stubStarts(byt, isArray)
+		for i, s := range stubs {
+			count++
+			at := filePosition{1, 1}
+			if i < len(starts) {
+				at = starts[i]
+			}
+			stubProblem := func(e SchemaError) SchemaError {
+				e.File = file.Path
+				if e.Line == 0 {
+					e.Line, e.Column = at.line, at.column
+				}
+				e.Stub = i
+				if isArray {
+					e.Field = strings.TrimSuffix(fmt.Sprintf("[%d].%s", i, e.Field), ".")
+				}
+				return e
+			}
+
+			if err := unresolved[i]; err != nil {
+				problems = append(problems, stubProblem(SchemaError{Message: err.Error()}))
+				continue
+			}
+			if err := validateOutput(s.Output); err != nil {
+				problems = append(problems, stubProblem(SchemaError{Field: "output", Message: err.Error()}))
+			}
+			if s.ID != "" {
+				where := fmt.Sprintf("%s stub %d", file.Path, i)
+				if other, ok := ids[s.ID]; ok {
+					problems = append(problems, stubProblem(SchemaError{Field: "id", Message: fmt.Sprintf("ID %s is already used by %s", s.ID, other)}))
+				} else {
+					ids[s.ID] = where
+				}
+			}
+			if check != nil {
+				for _, e := range check(s) {
+					problems = append(problems, stubProblem(e))
+				}
+			}
+		}
+	}
+	return problems, count, nil
+}
+
+// Where each stub of a stub document starts, as far as it's valid JSON
+func stubStarts(byt []byte, isArray bool) []filePosition {
+	pos := &positionReader{r: bufio.NewReader(bytes.NewReader(byt)), pos: filePosition{1, 1}}
+	if !isArray {
+		return []filePosition{pos.advance(int64(len(byt) - len(bytes.TrimLeft(byt, " \t\r\n"))))}
+	}
+	starts := []filePosition{}
+	eachStub(bytes.NewReader(byt), func(i int, raw []byte, end int64) {
+		starts = append(starts, pos.advance(end-int64(len(raw))))
+	})
+	return starts
+}
@@ -0,0 +1,54 @@
+package stub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckStubFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"good.json": `{"service": "Greeter", "method": "SayHello", "input": {"equals": {"name": "a"}}, "output": {"data": {"message": "hi"}}}`,
+		"array.json": `[
+  {"id": "one", "service": "Greeter", "method": "SayHello", "input": {"equals": {}}, "output": {"data": {}}},
+  {"service": "Greeter", "method": "SayHello", "input": {"equal": {}}, "output": {"data": {}}},
+  {"id": "one", "service": "Greeter", "method": "SayBye", "input": {"equals": {}}, "output": {}}
+]`,
+		"broken.json":    `{"service": `,
+		"include.json":   `{"service": "Greeter", "method": "SayHello", "include": ["_missing.json"], "input": {"equals": {}}, "output": {"data": {}}}`,
+		"_fragment.json": `not json`,
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	checked := []string{}
+	problems, count, err := CheckStubFiles(dir, false, func(s *Stub) SchemaErrors {
+		checked = append(checked, s.Method)
+		if s.Method == "SayBye" {
+			return SchemaErrors{{Field: "method", Message: "no method SayBye"}}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+	assert.Equal(t, []string{"SayHello", "SayHello", "SayBye", "SayHello"}, checked)
+
+	msgs := []string{}
+	for _, p := range problems {
+		msgs = append(msgs, p.Error())
+	}
+	array := filepath.Join(dir, "array.json")
+	assert.Equal(t, []string{
+		array + `:3:58: [1].input.equal: unknown matcher "equal", did you mean "equals"?`,
+		array + ":4:3: [2].output: Output can't be empty",
+		array + ":4:3: [2].id: ID one is already used by " + array + " stub 0",
+		array + ":4:3: [2].method: no method SayBye",
+		filepath.Join(dir, "broken.json") + ":1:12: unexpected end of JSON input",
+		filepath.Join(dir, "include.json") + ":1:1: open " + filepath.Join(dir, "_missing.json") + ": no such file or directory",
+	}, msgs)
+}
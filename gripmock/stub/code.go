@@ -0,0 +1,69 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// gRPC status code of a stub output. Unmarshals from either the numeric code
+// or its canonical name, e.g. 5 or "NOT_FOUND", and always marshals as the
+// number.
+type StatusCode int
+
+// canonical status code names, indexed by code
+var codeNames = []string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+func (c StatusCode) String() string {
+	if c < 0 || int(c) >= len(codeNames) {
+		return fmt.Sprintf("CODE(%d)", int(c))
+	}
+	return codeNames[c]
+}
+
+func (c StatusCode) valid() bool {
+	return c >= 0 && c <= CODE_MAX
+}
+
+func (c *StatusCode) UnmarshalJSON(b []byte) error {
+	if len(b) == 0 || b[0] != '"' {
+		var n int
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("Output code %s must be a gRPC status code number or name", string(bytes.TrimSpace(b)))
+		}
+		*c = StatusCode(n)
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	for i, n := range codeNames {
+		if strings.EqualFold(name, n) {
+			*c = StatusCode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Output code \"%s\" is not a valid gRPC status code name, must be one of %s",
+		name, strings.Join(codeNames, ", "))
+}
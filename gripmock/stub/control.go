@@ -0,0 +1,67 @@
+package stub
+
+import (
+	"errors"
+	"net/http"
+)
+
+/*
+ * gRPC server control.
+ *
+ * The gRPC server runs as a child process of gripmock, or within it with
+ * -dynamic, next to the admin server. POST /server/stop, /server/start,
+ * /server/restart, /server/pause and /server/resume control it while the
+ * admin server stays up, so orchestration tooling and tests can take the
+ * mock down, bring it back or freeze it without sending gripmock signals. Stopping and restarting drain
+ * the server as a shutdown would; pausing freezes it, so calls hang until
+ * it's resumed, as against an unresponsive backend.
+ */
+
+const (
+	SERVER_RELOAD  = "reload"
+	SERVER_STOP    = "stop"
+	SERVER_START   = "start"
+	SERVER_RESTART = "restart"
+	SERVER_PAUSE   = "pause"
+	SERVER_RESUME  = "resume"
+)
+
+var (
+	// Returned by Options.Control if another action is still running
+	ErrServerBusy = errors.New("The gRPC server is busy with another stop, start or reload")
+	// Wrapped by Options.Control errors for an action that doesn't apply
+	// in the server's current state, e.g. starting it while it runs
+	ErrServerState = errors.New("Not possible now")
+)
+
+// carries out a server action, see Options.Control
+var controlServer func(action string) error
+
+// Answer a control action's error, if any. Conflicts with the server's state
+// answer 409.
+func controlError(err error, w http.ResponseWriter) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, ErrServerBusy), errors.Is(err, ErrServerState):
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+	default:
+		responseError(err, w)
+	}
+	return true
+}
+
+func handleServerControl(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if controlServer == nil {
+			w.WriteHeader(http.StatusNotImplemented)
+			w.Write([]byte("Controlling the gRPC server isn't supported"))
+			return
+		}
+		if controlError(controlServer(action), w) {
+			return
+		}
+		w.Write([]byte("OK"))
+	}
+}
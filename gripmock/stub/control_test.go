@@ -0,0 +1,46 @@
+package stub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerControl(t *testing.T) {
+	defer func() { controlServer = nil }()
+
+	post := func(action string) *httptest.ResponseRecorder {
+		wrt := httptest.NewRecorder()
+		handleServerControl(action)(wrt, httptest.NewRequest("POST", "/server/"+action, nil))
+		return wrt
+	}
+
+	controlServer = nil
+	assert.Equal(t, http.StatusNotImplemented, post(SERVER_STOP).Code)
+
+	actions := []string{}
+	var result error
+	controlServer = func(action string) error {
+		actions = append(actions, action)
+		return result
+	}
+	wrt := post(SERVER_STOP)
+	assert.Equal(t, http.StatusOK, wrt.Code)
+	assert.Equal(t, "OK", wrt.Body.String())
+
+	result = fmt.Errorf("%w, the gRPC server is stopped", ErrServerState)
+	wrt = post(SERVER_PAUSE)
+	assert.Equal(t, http.StatusConflict, wrt.Code)
+	assert.Equal(t, "Not possible now, the gRPC server is stopped", wrt.Body.String())
+
+	result = ErrServerBusy
+	assert.Equal(t, http.StatusConflict, post(SERVER_RESTART).Code)
+
+	result = fmt.Errorf("Pausing the gRPC server isn't supported on Windows")
+	assert.Equal(t, http.StatusInternalServerError, post(SERVER_PAUSE).Code)
+
+	assert.Equal(t, []string{SERVER_STOP, SERVER_PAUSE, SERVER_RESTART, SERVER_PAUSE}, actions)
+}
@@ -0,0 +1,38 @@
+package stub
+
+import (
+	"fmt"
+	"log"
+)
+
+/*
+ * Correlation IDs.
+ *
+ * Clients often tag each call with an ID of their own, in metadata such as
+ * "x-request-id", and log it. With Options.CorrelationKey set to that key,
+ * its value on each call is recorded as the call's correlation ID in its
+ * journal entry, activity events, wire log and access log lines, and
+ * prefixed to log lines about the call, so the mock's side of a call can be
+ * found from the client's logs and the other way round.
+ */
+
+// gRPC metadata key holding each call's correlation ID, lower case; empty
+// if disabled. Set from Options.CorrelationKey.
+var correlationKey string
+
+// The correlation ID of a call with these headers
+func correlationID(headers map[string]string) string {
+	if correlationKey == "" {
+		return ""
+	}
+	return headers[correlationKey]
+}
+
+// Log a line about a call, prefixed with its correlation ID if it has one
+func logCall(headers map[string]string, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if id := correlationID(headers); id != "" {
+		msg = fmt.Sprintf("[%s=%s] %s", correlationKey, id, msg)
+	}
+	log.Print(msg)
+}
@@ -0,0 +1,72 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationID(t *testing.T) {
+	defer clearStorage()
+	journal = &requestJournal{}
+	defer func() { journal = &requestJournal{} }()
+	correlationKey = "x-request-id"
+	defer func() { correlationKey = "" }()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	post := func(handler http.HandlerFunc, body string) {
+		handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/", bytes.NewReader([]byte(body))))
+	}
+	post(reportCall, `{"method":"/hello.Greeter/SayHello","type":"unary","delta":1,"call_id":"1-1","headers":{"x-request-id":"req-1"}}`)
+	post(reportCall, `{"method":"/hello.Greeter/SayHello","type":"unary","delta":1,"call_id":"1-2"}`)
+	post(handleFindStub, `{"service":"Greeter","method":"SayHello","data":{"name":"bob"},"call_id":"1-1","headers":{"x-request-id":"req-1"}}`)
+	// a lookup that wasn't reported as a call
+	post(handleFindStub, `{"service":"Greeter","method":"SayHello","data":{"name":"eve"},"headers":{"x-request-id":"req-2"}}`)
+
+	list := func(query string) []JournalEntry {
+		wrt := httptest.NewRecorder()
+		listJournal(wrt, httptest.NewRequest("GET", "/journal"+query, nil))
+		entries := []JournalEntry{}
+		require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &entries))
+		return entries
+	}
+	entries := list("")
+	require.Len(t, entries, 3)
+	assert.Equal(t, "req-1", entries[0].CorrelationID)
+	assert.Equal(t, "", entries[1].CorrelationID)
+	assert.Equal(t, "req-2", entries[2].CorrelationID)
+
+	entries = list("?correlation_id=req-2")
+	require.Len(t, entries, 1)
+	assert.Equal(t, "eve", entries[0].Data["name"])
+
+	assert.Contains(t, buf.String(), "[x-request-id=req-1] Can't find stub")
+	assert.Contains(t, buf.String(), "[x-request-id=req-2] Can't find stub")
+}
+
+func TestLogCall(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	headers := map[string]string{"x-request-id": "abc"}
+	logCall(headers, "failed: %v", "oops")
+	correlationKey = "x-request-id"
+	defer func() { correlationKey = "" }()
+	logCall(headers, "failed: %v", "oops")
+	logCall(nil, "failed: %v", "oops")
+	assert.Equal(t, "failed: oops\n[x-request-id=abc] failed: oops\nfailed: oops\n", buf.String())
+}
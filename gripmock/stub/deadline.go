@@ -0,0 +1,75 @@
+package stub
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+ * Matching on the time left before a call's deadline.
+ *
+ * The gRPC server sends the time remaining when it looks the call up, so a
+ * rule like {"below": "1s"} picks a different response for callers with
+ * tight deadlines. Calls without a deadline have unlimited time left: they
+ * never match "below" and always match "above".
+ */
+
+// Rules on the time remaining before the call's deadline, as go duration
+// strings. Every rule that is set must match.
+type DeadlineInput struct {
+	// less than this much time left
+	Below string `json:"below,omitempty"`
+	// at least this much time left
+	Above string `json:"above,omitempty"`
+}
+
+func validateDeadlineInput(deadline *DeadlineInput) error {
+	if deadline == nil {
+		return nil
+	}
+	if deadline.Below == "" && deadline.Above == "" {
+		return fmt.Errorf("Input deadline needs below or above")
+	}
+	bounds := map[string]time.Duration{}
+	for field, value := range map[string]string{"below": deadline.Below, "above": deadline.Above} {
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("Input deadline %s \"%s\" is not a valid duration, e.g. \"1.5s\"", field, value)
+		}
+		bounds[field] = d
+	}
+	if deadline.Below != "" && deadline.Above != "" && bounds["above"] >= bounds["below"] {
+		return fmt.Errorf("Input deadline above %s is not less than below %s, so can never match",
+			deadline.Above, deadline.Below)
+	}
+	return nil
+}
+
+// Report whether a call with remaining time left, as sent by the gRPC server,
+// satisfies the rules. Empty remaining means the call has no deadline.
+func deadlineMatches(rules *DeadlineInput, remaining string) bool {
+	if remaining == "" {
+		return rules.Below == ""
+	}
+	left, err := time.ParseDuration(remaining)
+	if err != nil {
+		return false
+	}
+	// the rules were validated when the stub was added
+	if rules.Below != "" {
+		below, _ := time.ParseDuration(rules.Below)
+		if left >= below {
+			return false
+		}
+	}
+	if rules.Above != "" {
+		above, _ := time.ParseDuration(rules.Above)
+		if left < above {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package stub
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadlineMatching(t *testing.T) {
+	defer clearStorage()
+
+	stubs := []string{
+		`{"service":"Quote","method":"Get","input":{"equals":{"id":"a"},"deadline":{"below":"100ms"}},"output":{"exceed_deadline":true}}`,
+		`{"service":"Quote","method":"Get","input":{"deadline":{"above":"1s","below":"10s"}},"output":{"data":{"v":"normal"}}}`,
+		`{"service":"Quote","method":"Get","input":{"equals":{"id":"a"}},"output":{"data":{"v":"a"}}}`,
+	}
+	for _, payload := range stubs {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		assert.Equal(t, "Success add stub", wrt.Body.String())
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		expect  string
+	}{
+		{
+			name:    "tight deadline",
+			payload: `{"service":"Quote","method":"Get","data":{"id":"a"},"deadline":"50ms"}`,
+			expect:  `{"data":null,"error":"","exceed_deadline":true}`,
+		},
+		{
+			name:    "deadline only rule",
+			payload: `{"service":"Quote","method":"Get","data":{"id":"b"},"deadline":"5s"}`,
+			expect:  `{"data":{"v":"normal"},"error":""}`,
+		},
+		{
+			name:    "no deadline",
+			payload: `{"service":"Quote","method":"Get","data":{"id":"a"}}`,
+			expect:  `{"data":{"v":"a"},"error":""}`,
+		},
+		{
+			name:    "outside bounds",
+			payload: `{"service":"Quote","method":"Get","data":{"id":"a"},"deadline":"1m"}`,
+			expect:  `{"data":{"v":"a"},"error":""}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrt := httptest.NewRecorder()
+			handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(tt.payload))))
+			assert.JSONEq(t, tt.expect, wrt.Body.String())
+		})
+	}
+}
+
+func Test_deadlineMatches(t *testing.T) {
+	below := &DeadlineInput{Below: "1s"}
+	above := &DeadlineInput{Above: "1s"}
+	assert.True(t, deadlineMatches(below, "999ms"))
+	assert.True(t, deadlineMatches(below, "-5ms"))
+	assert.False(t, deadlineMatches(below, "1s"))
+	assert.False(t, deadlineMatches(below, ""))
+	assert.True(t, deadlineMatches(above, "1s"))
+	assert.False(t, deadlineMatches(above, "999ms"))
+	assert.True(t, deadlineMatches(above, ""))
+}
+
+func Test_validateDeadlineInput(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline *DeadlineInput
+		err      string
+	}{
+		{"no deadline", nil, ""},
+		{"below", &DeadlineInput{Below: "1s"}, ""},
+		{"bounds", &DeadlineInput{Above: "1s", Below: "2s"}, ""},
+		{"empty", &DeadlineInput{}, "Input deadline needs below or above"},
+		{"invalid", &DeadlineInput{Below: "soon"}, `Input deadline below "soon" is not a valid duration, e.g. "1.5s"`},
+		{"negative", &DeadlineInput{Above: "-1s"}, `Input deadline above "-1s" is not a valid duration, e.g. "1.5s"`},
+		{"inverted bounds", &DeadlineInput{Above: "2s", Below: "1s"}, "Input deadline above 2s is not less than below 1s, so can never match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDeadlineInput(tt.deadline)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
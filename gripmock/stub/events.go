@@ -0,0 +1,131 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Lifecycle event log.
+ *
+ * The admin server keeps the most recent events of the mock server, such as
+ * health status transitions reported by the gRPC server, in a bounded ring
+ * and serves them on /events. Clients under test can poll it with ?since=
+ * to observe transitions as they happen.
+ */
+
+// Number of events kept before the oldest are dropped
+const EVENT_BUFFER_SIZE = 1000
+
+const (
+	// gRPC health status change; detail has "service" ("" for the whole
+	// server), "status" and "reason"
+	EVENT_HEALTH = "health"
+	// the gRPC server is listening, reported as it starts; detail has
+	// "addresses", comma separated, as bound, so with the ports picked for
+	// any port of 0: the main listeners' first, then the -listen ones'
+	EVENT_LISTENING = "listening"
+)
+
+// see Options.OnListening
+var onListening func(addresses []string)
+
+type Event struct {
+	// increases by one for each event, starting at 1
+	Seq    uint64            `json:"seq"`
+	Time   time.Time         `json:"time"`
+	Type   string            `json:"type"`
+	Detail map[string]string `json:"detail,omitempty"`
+}
+
+type eventLog struct {
+	mx     sync.Mutex
+	seq    uint64
+	events []Event
+}
+
+var events = &eventLog{}
+
+// Append an event to the log, stamping it with the next sequence number and
+// the current time unless it already has one.
+func (l *eventLog) record(e Event) Event {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.seq++
+	e.Seq = l.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.events = append(l.events, e)
+	if len(l.events) > EVENT_BUFFER_SIZE {
+		l.events = l.events[len(l.events)-EVENT_BUFFER_SIZE:]
+	}
+	return e
+}
+
+// Return the retained events with a sequence number greater than since
+func (l *eventLog) since(since uint64) []Event {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	found := []Event{}
+	for _, e := range l.events {
+		if e.Seq > since {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// Record a lifecycle event of the mock server
+func RecordEvent(typ string, detail map[string]string) {
+	events.record(Event{Type: typ, Detail: detail})
+}
+
+func listEvents(w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			responseError(fmt.Errorf("Invalid since \"%s\", must be an event seq number", s), w)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events.since(since))
+}
+
+// Record an event reported by the gRPC server
+func addEvent(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responseError(err, w)
+		return
+	}
+	e := Event{}
+	if err := json.Unmarshal(body, &e); err != nil {
+		responseError(err, w)
+		return
+	}
+	if e.Type == "" {
+		responseError(fmt.Errorf("Event type can't be empty"), w)
+		return
+	}
+	e = events.record(e)
+	stateFromEvent(e)
+	if e.Type == EVENT_LISTENING {
+		addresses := strings.Split(e.Detail["addresses"], ",")
+		probe.listening(addresses)
+		if onListening != nil {
+			onListening(addresses)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
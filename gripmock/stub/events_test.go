@@ -0,0 +1,61 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	events = &eventLog{}
+	defer func() { events = &eventLog{} }()
+
+	wrt := httptest.NewRecorder()
+	addEvent(wrt, httptest.NewRequest("POST", "/events", bytes.NewReader([]byte(`{"type":"health","detail":{"status":"SERVING"}}`))))
+	assert.Contains(t, wrt.Body.String(), `"seq":1`)
+
+	RecordEvent(EVENT_HEALTH, map[string]string{"status": "NOT_SERVING"})
+
+	wrt = httptest.NewRecorder()
+	addEvent(wrt, httptest.NewRequest("POST", "/events", bytes.NewReader([]byte(`{"detail":{}}`))))
+	assert.Equal(t, "Event type can't be empty", wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	listEvents(wrt, httptest.NewRequest("GET", "/events?since=1", nil))
+	var found []Event
+	require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &found))
+	require.Len(t, found, 1)
+	assert.Equal(t, uint64(2), found[0].Seq)
+	assert.Equal(t, "NOT_SERVING", found[0].Detail["status"])
+
+	wrt = httptest.NewRecorder()
+	listEvents(wrt, httptest.NewRequest("GET", "/events?since=x", nil))
+	assert.Equal(t, "Invalid since \"x\", must be an event seq number", wrt.Body.String())
+}
+
+func TestEventsBounded(t *testing.T) {
+	l := &eventLog{}
+	for i := 0; i < EVENT_BUFFER_SIZE+10; i++ {
+		l.record(Event{Type: "test"})
+	}
+	found := l.since(0)
+	assert.Len(t, found, EVENT_BUFFER_SIZE)
+	assert.Equal(t, uint64(11), found[0].Seq)
+}
+
+func TestListeningEvent(t *testing.T) {
+	events = &eventLog{}
+	defer func() { events = &eventLog{} }()
+	var got []string
+	onListening = func(addresses []string) { got = addresses }
+	defer func() { onListening = nil }()
+
+	wrt := httptest.NewRecorder()
+	addEvent(wrt, httptest.NewRequest("POST", "/events", bytes.NewReader([]byte(`{"type":"listening","detail":{"addresses":"[::]:41234,127.0.0.1:5000"}}`))))
+	assert.Equal(t, []string{"[::]:41234", "127.0.0.1:5000"}, got)
+	assert.Len(t, events.since(0), 1)
+}
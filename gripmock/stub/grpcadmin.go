@@ -0,0 +1,281 @@
+package stub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ringerc/gripmock/adminpb"
+)
+
+/*
+ * gRPC admin service.
+ *
+ * The StubAdmin service in adminpb/admin.proto offers the stub management
+ * parts of the HTTP admin API (add, list, get, delete, clear, find and
+ * verify) to test suites that would rather use a generated client. Stub
+ * inputs and outputs are Structs holding the same JSON as the HTTP API's,
+ * so they are converted through JSON and go through the same validation
+ * and storage.
+ */
+
+//go:generate protoc -I ../adminpb --go_out=../adminpb --go_opt=paths=source_relative --go-grpc_out=../adminpb --go-grpc_opt=paths=source_relative admin.proto
+
+// Serve the gRPC admin service on addr, with TLS if tlsCert is set. Returns
+// the port it listens on.
+func serveGrpcAdmin(addr, tlsCert, tlsKey string) string {
+	var opts []grpc.ServerOption
+	if tlsCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(tlsCert, tlsKey)
+		if err != nil {
+			log.Fatalf("Loading the gRPC stub admin's TLS certificate: %v", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Listening for the gRPC stub admin on %s: %v", addr, err)
+	}
+	s := grpc.NewServer(opts...)
+	adminpb.RegisterStubAdminServer(s, &grpcAdmin{})
+	reflection.Register(s)
+	host, _, _ := net.SplitHostPort(addr)
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+	fmt.Println("Serving gRPC stub admin on " + net.JoinHostPort(host, port))
+	go func() {
+		log.Fatal(s.Serve(lis))
+	}()
+	return port
+}
+
+type grpcAdmin struct {
+	adminpb.UnimplementedStubAdminServer
+}
+
+func (a *grpcAdmin) AddStub(ctx context.Context, req *adminpb.AddStubRequest) (*adminpb.AddStubResponse, error) {
+	stub, err := stubFromProto(req.GetStub())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := validateStub(stub); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if stub.Session == "" {
+		stub.Session = grpcSession(ctx)
+	}
+	if overlapCheck == OVERLAP_REJECT || overlapCheck == OVERLAP_WARN {
+		if err := findOverlap(stub); err != nil {
+			if overlapCheck == OVERLAP_REJECT {
+				return nil, status.Error(codes.AlreadyExists, err.Error())
+			}
+			log.Printf("Warning: %v", err)
+		}
+	}
+	if err := storeStub(stub); err != nil {
+		return nil, stubStatus(err)
+	}
+	return &adminpb.AddStubResponse{Id: stub.ID}, nil
+}
+
+func (a *grpcAdmin) ListStubs(ctx context.Context, req *adminpb.ListStubsRequest) (*adminpb.ListStubsResponse, error) {
+	session := grpcSession(ctx)
+	resp := &adminpb.ListStubsResponse{}
+	for _, s := range listStubs() {
+		if session != "" && s.Session != session {
+			continue
+		}
+		if req.Service != "" && s.Service != req.Service {
+			continue
+		}
+		if req.Method != "" && !strings.EqualFold(s.Method, req.Method) {
+			continue
+		}
+		pb, err := stubToProto(s)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Stubs = append(resp.Stubs, pb)
+	}
+	return resp, nil
+}
+
+func (a *grpcAdmin) GetStub(ctx context.Context, req *adminpb.GetStubRequest) (*adminpb.Stub, error) {
+	stub, err := getStub(req.Id)
+	if err != nil {
+		return nil, stubStatus(err)
+	}
+	pb, err := stubToProto(stub)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return pb, nil
+}
+
+func (a *grpcAdmin) DeleteStub(ctx context.Context, req *adminpb.DeleteStubRequest) (*adminpb.DeleteStubResponse, error) {
+	if err := deleteStub(req.Id); err != nil {
+		return nil, stubStatus(err)
+	}
+	return &adminpb.DeleteStubResponse{}, nil
+}
+
+func (a *grpcAdmin) ClearStubs(ctx context.Context, req *adminpb.ClearStubsRequest) (*adminpb.ClearStubsResponse, error) {
+	clearStubs(grpcSession(ctx))
+	return &adminpb.ClearStubsResponse{}, nil
+}
+
+func (a *grpcAdmin) FindStub(ctx context.Context, req *adminpb.FindStubRequest) (*adminpb.FindStubResponse, error) {
+	call := &findStubPayload{
+		Service: req.Service,
+		Method:  req.Method,
+		Data:    req.GetData().AsMap(),
+		Headers: req.Headers,
+	}
+	id, output, matched, err := lookupStub(call)
+	if !matched {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	pb, err := toStruct(output)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &adminpb.FindStubResponse{StubId: id, Output: pb}, nil
+}
+
+func (a *grpcAdmin) Verify(ctx context.Context, req *adminpb.VerifyRequest) (*adminpb.VerifyResponse, error) {
+	history := callsFor(grpcSession(ctx))
+	resp := &adminpb.VerifyResponse{Pass: true}
+	for i, pe := range req.Expectations {
+		e := &Expectation{Service: pe.Service, Method: pe.Method, StubID: pe.StubId}
+		if pe.Input != nil {
+			e.Input = new(Input)
+			if err := fromStruct(pe.Input, e.Input); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "expectation %d: %v", i, err)
+			}
+		}
+		for _, c := range []struct {
+			from *int32
+			to   **int
+		}{{pe.Count, &e.Count}, {pe.MinCount, &e.MinCount}, {pe.MaxCount, &e.MaxCount}} {
+			if c.from != nil {
+				n := int(*c.from)
+				*c.to = &n
+			}
+		}
+		if err := validateExpectation(e); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "expectation %d: %v", i, err)
+		}
+		// due to golang implementation, method names are capitalized
+		e.Method = strings.Title(e.Method)
+		result := history.verify(e)
+		resp.Pass = resp.Pass && result.Pass
+		resp.Results = append(resp.Results, &adminpb.VerifyResult{
+			Pass:       result.Pass,
+			Actual:     int32(result.Actual),
+			Expected:   result.Expected,
+			Message:    result.Message,
+			Incomplete: result.Incomplete,
+		})
+	}
+	return resp, nil
+}
+
+// The session of an admin call, from its metadata
+func grpcSession(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if sessionKey == "" || len(md.Get(sessionKey)) == 0 {
+		return ""
+	}
+	return md.Get(sessionKey)[0]
+}
+
+func stubStatus(err error) error {
+	switch {
+	case errors.Is(err, errStubNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errStubExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func stubFromProto(pb *adminpb.Stub) (*Stub, error) {
+	if pb == nil {
+		return nil, fmt.Errorf("Request has no stub")
+	}
+	stub := &Stub{ID: pb.Id, Service: pb.Service, Method: pb.Method, Namespace: pb.Namespace, Session: pb.Session}
+	if err := fromStruct(pb.Input, &stub.Input); err != nil {
+		return nil, fmt.Errorf("Invalid input: %v", err)
+	}
+	if err := fromStruct(pb.Output, &stub.Output); err != nil {
+		return nil, fmt.Errorf("Invalid output: %v", err)
+	}
+	return stub, nil
+}
+
+func stubToProto(stub *Stub) (*adminpb.Stub, error) {
+	input, err := toStruct(stub.Input)
+	if err != nil {
+		return nil, err
+	}
+	output, err := toStruct(stub.Output)
+	if err != nil {
+		return nil, err
+	}
+	pb := &adminpb.Stub{
+		Id:        stub.ID,
+		Service:   stub.Service,
+		Method:    stub.Method,
+		Namespace: stub.Namespace,
+		Session:   stub.Session,
+		Input:     input,
+		Output:    output,
+	}
+	var lastHit *time.Time
+	pb.Hits, lastHit = hitsFor(stub.ID)
+	if lastHit != nil {
+		pb.LastHit = timestamppb.New(*lastHit)
+	}
+	return pb, nil
+}
+
+// Decode a Struct holding stub JSON into v
+func fromStruct(s *structpb.Struct, v interface{}) error {
+	if s == nil {
+		return nil
+	}
+	byt, err := json.Marshal(s.AsMap())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(byt, v)
+}
+
+// Encode v as stub JSON in a Struct
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	byt, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(byt, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
@@ -0,0 +1,100 @@
+package stub
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/ringerc/gripmock/adminpb"
+)
+
+func TestGrpcAdmin(t *testing.T) {
+	defer clearStorage()
+	calls = newCallHistory()
+	defer func() { calls = newCallHistory() }()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	adminpb.RegisterStubAdminServer(s, &grpcAdmin{})
+	go s.Serve(lis)
+	defer s.Stop()
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := adminpb.NewStubAdminClient(conn)
+	ctx := context.Background()
+
+	mustStruct := func(m map[string]interface{}) *structpb.Struct {
+		s, err := structpb.NewStruct(m)
+		require.NoError(t, err)
+		return s
+	}
+
+	added, err := client.AddStub(ctx, &adminpb.AddStubRequest{Stub: &adminpb.Stub{
+		Id:      "hello",
+		Service: "Greeter",
+		Method:  "sayHello",
+		Input:   mustStruct(map[string]interface{}{"equals": map[string]interface{}{"name": "bob"}}),
+		Output:  mustStruct(map[string]interface{}{"data": map[string]interface{}{"message": "hi"}}),
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", added.Id)
+
+	_, err = client.AddStub(ctx, &adminpb.AddStubRequest{Stub: &adminpb.Stub{Id: "hello", Service: "Greeter", Method: "SayHello"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.AddStub(ctx, &adminpb.AddStubRequest{Stub: &adminpb.Stub{
+		Id:      "hello",
+		Service: "Greeter",
+		Method:  "SayHello",
+		Input:   mustStruct(map[string]interface{}{"contains": map[string]interface{}{}}),
+		Output:  mustStruct(map[string]interface{}{"error": "nope", "code": "NOT_FOUND"}),
+	}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	got, err := client.GetStub(ctx, &adminpb.GetStubRequest{Id: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "SayHello", got.Method)
+	assert.Equal(t, map[string]interface{}{"name": "bob"}, got.Input.AsMap()["equals"])
+
+	list, err := client.ListStubs(ctx, &adminpb.ListStubsRequest{Service: "Greeter"})
+	require.NoError(t, err)
+	assert.Len(t, list.Stubs, 1)
+	list, err = client.ListStubs(ctx, &adminpb.ListStubsRequest{Service: "Other"})
+	require.NoError(t, err)
+	assert.Len(t, list.Stubs, 0)
+
+	found, err := client.FindStub(ctx, &adminpb.FindStubRequest{Service: "Greeter", Method: "SayHello", Data: mustStruct(map[string]interface{}{"name": "bob"})})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", found.StubId)
+	assert.Equal(t, map[string]interface{}{"message": "hi"}, found.Output.AsMap()["data"])
+	_, err = client.FindStub(ctx, &adminpb.FindStubRequest{Service: "Greeter", Method: "SayHello", Data: mustStruct(map[string]interface{}{"name": "eve"})})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	one, two := int32(1), int32(2)
+	verified, err := client.Verify(ctx, &adminpb.VerifyRequest{Expectations: []*adminpb.Expectation{
+		{StubId: "hello", Count: &one},
+		{Service: "Greeter", Method: "SayHello", MinCount: &two},
+	}})
+	require.NoError(t, err)
+	assert.True(t, verified.Pass)
+	require.Len(t, verified.Results, 2)
+	assert.Equal(t, "stub hello was called 1 time, as expected", verified.Results[0].Message)
+	_, err = client.Verify(ctx, &adminpb.VerifyRequest{Expectations: []*adminpb.Expectation{{Service: "Greeter"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.DeleteStub(ctx, &adminpb.DeleteStubRequest{Id: "hello"})
+	require.NoError(t, err)
+	_, err = client.GetStub(ctx, &adminpb.GetStubRequest{Id: "hello"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,153 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Health status overrides.
+ *
+ * The gRPC server reports SERVING on the standard grpc.health.v1.Health
+ * service for the server as a whole, service "", and each service it
+ * serves. Tests of clients and orchestration that rely on the health
+ * protocol can set a service's status on /health/services, e.g. to
+ * NOT_SERVING and back, to see how they react.
+ *
+ * The admin server only keeps the statuses set. The gRPC server long-polls
+ * GET /health/services?wait=<version>, which answers once the statuses
+ * differ from that version, and applies them to the services it serves,
+ * so a change reaches health checks and watches at once.
+ */
+
+// Health statuses a service can be set to
+var healthStatuses = []string{"SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}
+
+// Longest a GET /health/services?wait=<version> waits for a change
+const HEALTH_WAIT_TIMEOUT = 30 * time.Second
+
+type healthOverrides struct {
+	mx       sync.Mutex
+	version  int
+	statuses map[string]string
+	// closed and replaced on each change, waking the waiters
+	changed chan struct{}
+}
+
+// The statuses set, and their version, on /health/services
+type HealthStatuses struct {
+	Version int `json:"version"`
+	// status by fully qualified service name, "" for the whole server
+	Services map[string]string `json:"services"`
+}
+
+type healthUpdate struct {
+	Service string `json:"service"`
+	Status  string `json:"status"`
+}
+
+var health = &healthOverrides{statuses: map[string]string{}, changed: make(chan struct{})}
+
+func (h *healthOverrides) snapshot() (HealthStatuses, chan struct{}) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	statuses := HealthStatuses{Version: h.version, Services: map[string]string{}}
+	for service, status := range h.statuses {
+		statuses.Services[service] = status
+	}
+	return statuses, h.changed
+}
+
+// Set the service's status; SERVING clears any status set before
+func (h *healthOverrides) set(service, status string) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if status == "SERVING" {
+		delete(h.statuses, service)
+	} else {
+		h.statuses[service] = status
+	}
+	h.bump()
+}
+
+// Clear every status set, so every service is SERVING again
+func (h *healthOverrides) reset() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if len(h.statuses) == 0 {
+		return
+	}
+	h.statuses = map[string]string{}
+	h.bump()
+}
+
+func (h *healthOverrides) bump() {
+	h.version++
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// The statuses set, at once or, with ?wait=<version>, once they differ
+// from that version or HEALTH_WAIT_TIMEOUT passes
+func listHealth(w http.ResponseWriter, r *http.Request) {
+	statuses, changed := health.snapshot()
+	if s := r.URL.Query().Get("wait"); s != "" {
+		version, err := strconv.Atoi(s)
+		if err != nil {
+			responseError(fmt.Errorf("Invalid wait \"%s\", must be a version number", s), w)
+			return
+		}
+		if version == statuses.Version {
+			select {
+			case <-changed:
+			case <-time.After(HEALTH_WAIT_TIMEOUT):
+			case <-r.Context().Done():
+				return
+			}
+			statuses, _ = health.snapshot()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// Set a service's status, from {"service": "...", "status": "NOT_SERVING"}
+func setHealth(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responseError(err, w)
+		return
+	}
+	update := healthUpdate{}
+	if err := json.Unmarshal(body, &update); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	status := ""
+	for _, s := range healthStatuses {
+		if strings.EqualFold(update.Status, s) {
+			status = s
+		}
+	}
+	if status == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Health status \"%s\" must be one of %s", update.Status, strings.Join(healthStatuses, ", "))
+		return
+	}
+	health.set(update.Service, status)
+	RecordEvent("health", map[string]string{"service": update.Service, "status": status, "reason": "admin"})
+	w.Write([]byte("OK"))
+}
+
+func resetHealth(w http.ResponseWriter, r *http.Request) {
+	health.reset()
+	RecordEvent("health", map[string]string{"service": "", "status": "SERVING", "reason": "admin reset"})
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,65 @@
+package stub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthOverrides(t *testing.T) {
+	defer health.reset()
+	list := func(query string) HealthStatuses {
+		wrt := httptest.NewRecorder()
+		listHealth(wrt, httptest.NewRequest("GET", "/health/services"+query, nil))
+		require.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+		statuses := HealthStatuses{}
+		require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &statuses))
+		return statuses
+	}
+	set := func(body string) *httptest.ResponseRecorder {
+		wrt := httptest.NewRecorder()
+		setHealth(wrt, httptest.NewRequest("POST", "/health/services", strings.NewReader(body)))
+		return wrt
+	}
+
+	before := list("")
+	assert.Empty(t, before.Services)
+
+	// a waiter gets the change as soon as it's made
+	waited := make(chan HealthStatuses)
+	go func() {
+		waited <- list("?wait=" + strconv.Itoa(before.Version))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, http.StatusOK, set(`{"service":"helloworld.Greeter","status":"not_serving"}`).Code)
+	select {
+	case statuses := <-waited:
+		assert.Equal(t, before.Version+1, statuses.Version)
+		assert.Equal(t, map[string]string{"helloworld.Greeter": "NOT_SERVING"}, statuses.Services)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the waiter wasn't woken")
+	}
+	// and one behind gets the statuses at once
+	assert.Equal(t, before.Version+1, list("?wait="+strconv.Itoa(before.Version)).Version)
+
+	require.Equal(t, http.StatusOK, set(`{"service":"","status":"SERVICE_UNKNOWN"}`).Code)
+	assert.Equal(t, map[string]string{"helloworld.Greeter": "NOT_SERVING", "": "SERVICE_UNKNOWN"}, list("").Services)
+	require.Equal(t, http.StatusOK, set(`{"service":"helloworld.Greeter","status":"SERVING"}`).Code)
+	assert.Equal(t, map[string]string{"": "SERVICE_UNKNOWN"}, list("").Services)
+
+	wrt := set(`{"service":"helloworld.Greeter","status":"DOWN"}`)
+	assert.Equal(t, http.StatusBadRequest, wrt.Code)
+	assert.Equal(t, "Health status \"DOWN\" must be one of SERVING, NOT_SERVING, SERVICE_UNKNOWN", wrt.Body.String())
+
+	resetState()
+	statuses := list("")
+	assert.Empty(t, statuses.Services)
+	assert.Equal(t, before.Version+4, statuses.Version)
+}
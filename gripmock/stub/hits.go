@@ -0,0 +1,83 @@
+package stub
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * Per-stub hit statistics.
+ *
+ * Each stub counts the calls it has matched and keeps the time of the
+ * latest, shown in the stub list on / and in the gRPC admin service's
+ * stubs, so stubs no test reaches, or one matching far more calls than
+ * expected, stand out. Replacing a stub keeps its statistics; they're lost
+ * when it's deleted or cleared.
+ *
+ * They're counted by stub ID with atomics, apart from the stubs, so calls
+ * don't take the stubs' lock to count their hits, see snapshot.go.
+ */
+
+// Count a match of the stub with the ID, if it's still there
+func recordHit(id string) {
+	if _, _, _, found := locateStub(id); !found {
+		return
+	}
+	h, _ := stubHits.LoadOrStore(id, &hitCount{})
+	h.(*hitCount).record()
+}
+
+// The hit statistics of the stub with the ID; none if it's gone
+func hitsFor(id string) (hits int64, lastHit *time.Time) {
+	if _, _, _, found := locateStub(id); !found {
+		return 0, nil
+	}
+	return hitCountOf(id)
+}
+
+type hitCount struct {
+	hits    atomic.Int64
+	lastHit atomic.Pointer[time.Time]
+}
+
+// *hitCount by stub ID
+var stubHits sync.Map
+
+func (h *hitCount) record() {
+	now := time.Now()
+	h.hits.Add(1)
+	h.lastHit.Store(&now)
+}
+
+// The hit statistics counted for a stub ID
+func hitCountOf(id string) (int64, *time.Time) {
+	h, ok := stubHits.Load(id)
+	if !ok {
+		return 0, nil
+	}
+	return h.(*hitCount).hits.Load(), h.(*hitCount).lastHit.Load()
+}
+
+// Forget the hit statistics of the stubs, which have been removed
+func forgetHits(sm stubMapping) {
+	for _, methods := range sm {
+		for _, stubs := range methods {
+			for _, s := range stubs {
+				stubHits.Delete(s.ID)
+			}
+		}
+	}
+}
+
+// Fill in the hit statistics of a copy of the stubs
+func (sm stubMapping) withHits() stubMapping {
+	for _, methods := range sm {
+		for _, stubs := range methods {
+			for i := range stubs {
+				stubs[i].Hits, stubs[i].LastHit = hitCountOf(stubs[i].ID)
+			}
+		}
+	}
+	return sm
+}
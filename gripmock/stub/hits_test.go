@@ -0,0 +1,58 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStubHits(t *testing.T) {
+	defer clearStorage()
+	defer resetJournal()
+
+	for _, body := range []string{
+		`{"id":"bob","service":"Hits","method":"Get","input":{"equals":{"name":"bob"}},"output":{"data":{"v":"bob"}}}`,
+		`{"id":"unused","service":"Hits","method":"Get","input":{"equals":{"name":"alice"}},"output":{"data":{"v":"alice"}}}`,
+	} {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(body))))
+		require.Equal(t, "Success add stub", wrt.Body.String())
+	}
+	find := func() {
+		handleFindStub(httptest.NewRecorder(), httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(
+			`{"service":"Hits","method":"Get","data":{"name":"bob"}}`))))
+	}
+	find()
+	find()
+
+	list := func() []storage {
+		wrt := httptest.NewRecorder()
+		listStub(wrt, httptest.NewRequest("GET", "/", nil))
+		listed := stubMapping{}
+		require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &listed))
+		return listed["Hits"]["Get"]
+	}
+	stubs := list()
+	require.Len(t, stubs, 2)
+	assert.Equal(t, int64(2), stubs[0].Hits)
+	assert.NotNil(t, stubs[0].LastHit)
+	assert.Equal(t, int64(0), stubs[1].Hits)
+	assert.Nil(t, stubs[1].LastHit)
+
+	// kept when the stub is replaced
+	require.NoError(t, updateStub(&Stub{ID: "bob", Service: "Hits", Method: "Get",
+		Input: Input{Equals: map[string]interface{}{"name": "bob"}}}))
+	find()
+	assert.Equal(t, int64(3), list()[0].Hits)
+
+	stub, err := getStub("bob")
+	require.NoError(t, err)
+	pb, err := stubToProto(stub)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), pb.Hits)
+	assert.NotNil(t, pb.LastHit)
+}
@@ -0,0 +1,200 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * Stub includes and inheritance.
+ *
+ * A stub in a stub file can be based on another with "extends", and take in
+ * shared fragments, such as common headers or a base output, with
+ * "include", so similar stubs don't each repeat the same boilerplate:
+ *
+ *   {
+ *     "extends": "base/greeter.json",
+ *     "include": ["fragments/auth-headers.json"],
+ *     "input": {"equals": {"name": "bob"}},
+ *     "output": {"data": {"message": "Hello bob"}}
+ *   }
+ *
+ * Each name is a file of a single stub, or part of one, relative to the
+ * file that refers to it. The stub is the base it extends, then each
+ * include in turn, then its own keys, merged: objects are merged key by
+ * key and anything else replaces what came before. Bases and fragments
+ * may extend and include others in turn, but not themselves.
+ *
+ * Files and directories whose names start with _, e.g. _fragments/, aren't
+ * loaded as stubs themselves, so bases and fragments can sit alongside the
+ * stubs that use them. Includes are resolved when a stub file is loaded;
+ * stubs added on the admin API can't use them.
+ */
+
+// Whether a stub file, by its slash separated path, is only there to be
+// included
+func isFragment(rel string) bool {
+	for _, name := range strings.Split(rel, "/") {
+		if strings.HasPrefix(name, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve extends and include in each stub of a stub file. Stubs that can't
+// be resolved are returned as they are, with their errors by index.
+func resolveIncludes(path string, byt []byte) ([]byte, map[int]error) {
+	if !bytes.Contains(byt, []byte(`"extends"`)) && !bytes.Contains(byt, []byte(`"include"`)) {
+		return byt, nil
+	}
+	var doc interface{}
+	if err := decodeJSON(byt, &doc); err != nil {
+		// left for parseStubs to report
+		return byt, nil
+	}
+	errs := map[int]error{}
+	switch d := doc.(type) {
+	case []interface{}:
+		for i, s := range d {
+			stub, ok := s.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			resolved, err := resolveStub(path, stub, []string{absPath(path)})
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			d[i] = resolved
+		}
+	case map[string]interface{}:
+		resolved, err := resolveStub(path, d, []string{absPath(path)})
+		if err != nil {
+			return byt, map[int]error{0: err}
+		}
+		doc = resolved
+	default:
+		return byt, nil
+	}
+	resolved, err := json.Marshal(doc)
+	if err != nil {
+		return byt, map[int]error{-1: err}
+	}
+	return resolved, errs
+}
+
+// Merge a stub from file over what it extends and includes. chain is the
+// files being resolved, to catch cycles.
+func resolveStub(file string, stub map[string]interface{}, chain []string) (map[string]interface{}, error) {
+	refs := []string{}
+	if v, ok := stub["extends"]; ok && v != nil {
+		ref, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("extends must be a file name")
+		}
+		refs = append(refs, ref)
+	}
+	if v, ok := stub["include"]; ok && v != nil {
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("include must be an array of file names")
+		}
+		for _, item := range list {
+			ref, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("include must be an array of file names")
+			}
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return stub, nil
+	}
+
+	merged := map[string]interface{}{}
+	for _, ref := range refs {
+		ref = filepath.FromSlash(ref)
+		if !filepath.IsAbs(ref) {
+			ref = filepath.Join(filepath.Dir(file), ref)
+		}
+		abs := absPath(ref)
+		for _, f := range chain {
+			if f == abs {
+				return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(chain, " -> "), abs)
+			}
+		}
+		fragment, err := loadFragment(ref, append(chain[:len(chain):len(chain)], abs))
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeJSON(merged, fragment)
+	}
+	own := map[string]interface{}{}
+	for k, v := range stub {
+		if k != "extends" && k != "include" {
+			own[k] = v
+		}
+	}
+	return mergeJSON(merged, own), nil
+}
+
+// Read a base stub or fragment, itself resolved
+func loadFragment(path string, chain []string) (map[string]interface{}, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if byt, err = renderStubFile(path, byt); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := checkImportSchema(path, byt); err != nil {
+		return nil, err
+	}
+	var fragment interface{}
+	if err := decodeJSON(byt, &fragment); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	stub, ok := fragment.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: must hold a single stub", path)
+	}
+	return resolveStub(path, stub, chain)
+}
+
+// Decode JSON keeping numbers as they're written, so they're marshalled
+// again unchanged
+func decodeJSON(byt []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(byt))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// over merged onto base: objects key by key, anything else replaced
+func mergeJSON(base, over map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		b, bok := merged[k].(map[string]interface{})
+		o, ook := v.(map[string]interface{})
+		if bok && ook {
+			merged[k] = mergeJSON(b, o)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,69 @@
+package stub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveIncludes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	write("_base/greeter.json", `{
+		"service": "Greeter", "method": "SayHello",
+		"include": ["../_fragments/headers.json"],
+		"output": {"data": {"message": "default", "count": 3}}
+	}`)
+	write("_fragments/headers.json", `{"output": {"headers": {"x-env": "test", "x-region": "eu"}}}`)
+	write("_fragments/loop.json", `{"include": ["loop.json"]}`)
+	write("stubs/hello.json", `[
+		{
+			"extends": "../_base/greeter.json",
+			"input": {"equals": {"name": "bob"}},
+			"output": {"data": {"message": "Hello bob"}, "headers": {"x-region": "us"}}
+		},
+		{
+			"extends": "../_base/greeter.json",
+			"include": ["../_fragments/loop.json"],
+			"input": {"equals": {"name": "eve"}}
+		},
+		{
+			"extends": "../_base/missing.json",
+			"input": {"equals": {"name": "mallory"}}
+		},
+		{"service": "Greeter", "method": "SayBye", "input": {"contains": {}}, "output": {"data": {}}}
+	]`)
+
+	sm := stubMapping{}
+	sm.readStubFromFile(dir)
+	hello := sm["Greeter"]["SayHello"]
+	require.Len(t, hello, 1, "the fragments aren't stubs, and stubs that can't be resolved are skipped")
+	assert.Equal(t, map[string]interface{}{"name": "bob"}, hello[0].Input.Equals)
+	assert.Equal(t, "Hello bob", hello[0].Output.Data["message"])
+	assert.Equal(t, float64(3), hello[0].Output.Data["count"])
+	assert.Equal(t, map[string]string{"x-env": "test", "x-region": "us"}, hello[0].Output.Headers)
+	assert.Len(t, sm["Greeter"]["SayBye"], 1)
+
+	_, errs := resolveIncludes(filepath.Join(dir, "stubs/hello.json"), []byte(`{"extends": "../_fragments/loop.json"}`))
+	assert.ErrorContains(t, errs[0], "include cycle: ")
+	_, errs = resolveIncludes(filepath.Join(dir, "stubs/hello.json"), []byte(`{"include": "../_fragments/headers.json"}`))
+	assert.EqualError(t, errs[0], "include must be an array of file names")
+
+	assert.Equal(t, map[string]interface{}{
+		"a": map[string]interface{}{"b": 1, "c": 3},
+		"d": []interface{}{4},
+	}, mergeJSON(
+		map[string]interface{}{"a": map[string]interface{}{"b": 1, "c": 2}, "d": []interface{}{1, 2}},
+		map[string]interface{}{"a": map[string]interface{}{"c": 3}, "d": []interface{}{4}},
+	))
+
+	assert.EqualError(t, validateStub(&Stub{Service: "Greeter", Method: "SayHello", Extends: "base.json"}),
+		"extends and include are only supported in stub files")
+}
@@ -0,0 +1,146 @@
+package stub
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"math"
+)
+
+/*
+ * Stub store index.
+ *
+ * Matching a call used to try every stub of its method in turn, and counting
+ * the hit then searched every stub for the matched one's ID, so suites with
+ * tens of thousands of stubs paid for all of them on each call. The index
+ * finds stubs by ID, and splits each method's stubs into those with only an
+ * equals rule, by the hash of their payload, and the rest. A call is matched
+ * against the equals stubs with its own payload's hash and the other stubs,
+ * in their match order, so the same stub wins as with a full scan.
+ *
+ * Payloads with nulls, or negative zeros, aren't hashed: equals treats a
+ * null as matching a missing field, and -0 as 0, which their encodings
+ * don't. Stubs with them are always tried, and calls with them try every
+ * stub. A call that matches nothing is looked up again against every stub,
+ * to report the closest match.
+ *
+ * Each snapshot of the stubs has its own index, see snapshot.go, built by
+ * the first lookup in it, so loading stubs costs nothing extra.
+ */
+
+type stubLocation struct {
+	service string
+	method  string
+	index   int
+}
+
+type methodIndex struct {
+	// positions of the stubs with only an equals rule, by payload hash
+	equals map[[sha256.Size]byte][]int
+	// positions of the other stubs
+	scan []int
+}
+
+type storeIndex struct {
+	ids     map[string]stubLocation
+	methods map[string]map[string]*methodIndex
+}
+
+func buildIndex(sm stubMapping) *storeIndex {
+	ix := &storeIndex{ids: map[string]stubLocation{}, methods: map[string]map[string]*methodIndex{}}
+	for service, methods := range sm {
+		ix.methods[service] = map[string]*methodIndex{}
+		for method, stubs := range methods {
+			m := &methodIndex{equals: map[[sha256.Size]byte][]int{}}
+			for i, s := range stubs {
+				ix.ids[s.ID] = stubLocation{service, method, i}
+				if key, ok := equalsOnlyKey(s.Input); ok {
+					m.equals[key] = append(m.equals[key], i)
+				} else {
+					m.scan = append(m.scan, i)
+				}
+			}
+			ix.methods[service][method] = m
+		}
+	}
+	return ix
+}
+
+// Find the stub with the given ID in the published stubs, which are
+// stubStorage's when mx is held
+func locateStub(id string) (service, method string, index int, found bool) {
+	loc, found := currentStubs().indexed().ids[id]
+	return loc.service, loc.method, loc.index, found
+}
+
+// The stubs of a method that could match a call's payload, in match order
+func (ix *storeIndex) candidates(service, method string, stubs []storage, data map[string]interface{}) []storage {
+	m := ix.methods[service][method]
+	key, ok := equalsKey(data)
+	if m == nil || !ok {
+		return stubs
+	}
+	equal, scan := m.equals[key], m.scan
+	found := make([]storage, 0, len(equal)+len(scan))
+	for len(equal) > 0 || len(scan) > 0 {
+		if len(scan) == 0 || (len(equal) > 0 && equal[0] < scan[0]) {
+			found = append(found, stubs[equal[0]])
+			equal = equal[1:]
+		} else {
+			found = append(found, stubs[scan[0]])
+			scan = scan[1:]
+		}
+	}
+	return found
+}
+
+// The payload hash of a stub whose only rule is equals
+func equalsOnlyKey(input Input) ([sha256.Size]byte, bool) {
+	if input.Contains != nil || input.Matches != nil || input.Wasm != nil || input.Stream != nil || input.Deadline != nil {
+		return [sha256.Size]byte{}, false
+	}
+	if input.Equals == nil {
+		return [sha256.Size]byte{}, false
+	}
+	return equalsKey(input.Equals)
+}
+
+// Hash a payload by its JSON encoding, which has the map keys in order.
+// Not ok for payloads equals could match with a different encoding.
+func equalsKey(payload map[string]interface{}) ([sha256.Size]byte, bool) {
+	if !hashable(payload) {
+		return [sha256.Size]byte{}, false
+	}
+	byt, err := json.Marshal(payload)
+	if err != nil {
+		return [sha256.Size]byte{}, false
+	}
+	return sha256.Sum256(byt), true
+}
+
+func hashable(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case float64:
+		return !(v == 0 && math.Signbit(v))
+	case map[string]interface{}:
+		if v == nil {
+			return false
+		}
+		for _, field := range v {
+			if !hashable(field) {
+				return false
+			}
+		}
+	case []interface{}:
+		if v == nil {
+			return false
+		}
+		for _, item := range v {
+			if !hashable(item) {
+				return false
+			}
+		}
+	}
+	return true
+}
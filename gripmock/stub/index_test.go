@@ -0,0 +1,79 @@
+package stub
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexedLookup(t *testing.T) {
+	defer clearStorage()
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, storeStub(&Stub{ID: fmt.Sprint("user", i), Service: "Index", Method: "Get",
+			Input:  Input{Equals: map[string]interface{}{"id": float64(i)}},
+			Output: Output{Data: map[string]interface{}{"n": float64(i)}}}))
+	}
+	find := func(data map[string]interface{}) (string, string) {
+		match, rule, err := findStubRule(&findStubPayload{Service: "Index", Method: "Get", Data: data})
+		if err != nil {
+			return "", ""
+		}
+		return match.ID, rule
+	}
+	id, rule := find(map[string]interface{}{"id": float64(500)})
+	assert.Equal(t, "user500", id)
+	assert.Equal(t, "equals", rule)
+
+	// stubs with other rules keep their place in the match order
+	require.NoError(t, storeStub(&Stub{ID: "late", Service: "Index", Method: "Get",
+		Input: Input{Contains: map[string]interface{}{"id": float64(7)}}}))
+	require.NoError(t, updateStub(&Stub{ID: "user3", Service: "Index", Method: "Get",
+		Input: Input{Contains: map[string]interface{}{"id": float64(7)}}}))
+	id, _ = find(map[string]interface{}{"id": float64(7)})
+	assert.Equal(t, "user3", id)
+	require.NoError(t, deleteStub("user3"))
+	id, _ = find(map[string]interface{}{"id": float64(7)})
+	assert.Equal(t, "user7", id)
+
+	// equals treats a null as matching a missing field
+	require.NoError(t, storeStub(&Stub{ID: "null", Service: "Index", Method: "Get",
+		Input: Input{Equals: map[string]interface{}{"name": "x", "other": true}}}))
+	id, _ = find(map[string]interface{}{"name": "x", "id": nil})
+	assert.Equal(t, "null", id)
+
+	_, _, err := findStubRule(&findStubPayload{Service: "Index", Method: "Get", Data: map[string]interface{}{"id": "none"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Closest Match")
+
+	service, method, i, found := locateStub("user999")
+	require.True(t, found)
+	assert.Equal(t, "Index", service)
+	assert.Equal(t, "Get", method)
+	assert.Equal(t, "user999", stubStorage[service][method][i].ID)
+
+	clearStubs("")
+	_, _, _, found = locateStub("user999")
+	assert.False(t, found)
+}
+
+func TestEqualsKey(t *testing.T) {
+	a, ok := equalsKey(map[string]interface{}{"a": float64(1), "b": []interface{}{"x", map[string]interface{}{"c": true}}})
+	require.True(t, ok)
+	b, ok := equalsKey(map[string]interface{}{"b": []interface{}{"x", map[string]interface{}{"c": true}}, "a": float64(1)})
+	require.True(t, ok)
+	assert.Equal(t, a, b)
+
+	for _, payload := range []map[string]interface{}{
+		nil,
+		{"a": nil},
+		{"a": []interface{}{nil}},
+		{"a": math.Copysign(0, -1)},
+	} {
+		_, ok := equalsKey(payload)
+		assert.False(t, ok, "%v", payload)
+	}
+}
@@ -0,0 +1,160 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+ * In-flight call gauges.
+ *
+ * The gRPC server reports each call to the admin server as it starts and
+ * finishes, so the admin server can tell how many calls of each method are
+ * executing right now, and the most there have been at once. That's what's
+ * needed to check that clients respect their max-concurrency settings under
+ * load. The gauges are served as JSON on /inflight and in Prometheus text
+ * format on /metrics.
+ */
+
+// Call types, as reported by the gRPC server
+const (
+	CALL_UNARY         = "unary"
+	CALL_CLIENT_STREAM = "client_stream"
+	CALL_SERVER_STREAM = "server_stream"
+	CALL_BIDI_STREAM   = "bidi_stream"
+)
+
+type methodGauge struct {
+	// call type, one of the CALL_* consts
+	Type string `json:"type"`
+	// calls executing now
+	Current int `json:"current"`
+	// most calls ever executing at once
+	Max int `json:"max"`
+	// calls started
+	Total int `json:"total"`
+}
+
+type inflightGauges struct {
+	mx      sync.Mutex
+	methods map[string]*methodGauge
+}
+
+var inflight = &inflightGauges{methods: map[string]*methodGauge{}}
+
+// A call started (delta 1) or finished (delta -1)
+type callReport struct {
+	// full gRPC method name, e.g. "/pkg.Service/Method"
+	Method string `json:"method"`
+	Type   string `json:"type"`
+	Delta  int    `json:"delta"`
+	// the rest are for the request journal
+	CallID  string            `json:"call_id,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// status code, duration and client address of a finished call
+	Code    StatusCode `json:"code,omitempty"`
+	Latency string     `json:"latency,omitempty"`
+	Peer    string     `json:"peer,omitempty"`
+}
+
+func (g *inflightGauges) update(c callReport) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	m, ok := g.methods[c.Method]
+	if !ok {
+		m = &methodGauge{Type: c.Type}
+		g.methods[c.Method] = m
+	}
+	m.Current += c.Delta
+	if m.Current < 0 {
+		// a report from before a restart of the admin server
+		m.Current = 0
+	}
+	if c.Delta > 0 {
+		m.Total += c.Delta
+	}
+	if m.Current > m.Max {
+		m.Max = m.Current
+	}
+}
+
+// Return a copy of the gauges, keyed by method
+func (g *inflightGauges) snapshot() map[string]methodGauge {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	snap := make(map[string]methodGauge, len(g.methods))
+	for k, v := range g.methods {
+		snap[k] = *v
+	}
+	return snap
+}
+
+func reportCall(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responseError(err, w)
+		return
+	}
+	c := callReport{}
+	if err := json.Unmarshal(body, &c); err != nil {
+		responseError(err, w)
+		return
+	}
+	if c.Method == "" {
+		responseError(fmt.Errorf("Call method can't be empty"), w)
+		return
+	}
+	if c.Delta != 1 && c.Delta != -1 {
+		responseError(fmt.Errorf("Call delta must be 1 or -1, not %d", c.Delta), w)
+		return
+	}
+	inflight.update(c)
+	if c.Delta > 0 {
+		journal.start(c)
+		activity.callStarted(c)
+	} else {
+		entry := journal.finish(c)
+		activity.callFinished(c, entry)
+		logAccess(c, entry)
+		summary.add(c)
+	}
+	w.Write([]byte("OK"))
+}
+
+func listInflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inflight.snapshot())
+}
+
+// Serve the gauges in the Prometheus text exposition format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	snap := inflight.snapshot()
+	methods := make([]string, 0, len(snap))
+	for m := range snap {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+
+	var b strings.Builder
+	metric := func(name, kind, help string, value func(methodGauge) int) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, m := range methods {
+			g := snap[m]
+			fmt.Fprintf(&b, "%s{method=%q,type=%q} %d\n", name, m, g.Type, value(g))
+		}
+	}
+	metric("gripmock_inflight_calls", "gauge", "Calls executing now.",
+		func(g methodGauge) int { return g.Current })
+	metric("gripmock_inflight_calls_max", "gauge", "Most calls executing at once.",
+		func(g methodGauge) int { return g.Max })
+	metric("gripmock_calls_started_total", "counter", "Calls started.",
+		func(g methodGauge) int { return g.Total })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,44 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInflight(t *testing.T) {
+	inflight = &inflightGauges{methods: map[string]*methodGauge{}}
+	defer func() { inflight = &inflightGauges{methods: map[string]*methodGauge{}} }()
+
+	report := func(payload string) string {
+		wrt := httptest.NewRecorder()
+		reportCall(wrt, httptest.NewRequest("POST", "/inflight", bytes.NewReader([]byte(payload))))
+		return wrt.Body.String()
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "OK", report(`{"method":"/pkg.Svc/Get","type":"unary","delta":1}`))
+	}
+	assert.Equal(t, "OK", report(`{"method":"/pkg.Svc/Get","type":"unary","delta":-1}`))
+	assert.Equal(t, "OK", report(`{"method":"/pkg.Svc/Watch","type":"server_stream","delta":1}`))
+	assert.Equal(t, "Call delta must be 1 or -1, not 2", report(`{"method":"/pkg.Svc/Get","type":"unary","delta":2}`))
+	assert.Equal(t, "Call method can't be empty", report(`{"type":"unary","delta":1}`))
+
+	wrt := httptest.NewRecorder()
+	listInflight(wrt, httptest.NewRequest("GET", "/inflight", nil))
+	var gauges map[string]methodGauge
+	require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &gauges))
+	assert.Equal(t, methodGauge{Type: CALL_UNARY, Current: 2, Max: 3, Total: 3}, gauges["/pkg.Svc/Get"])
+	assert.Equal(t, methodGauge{Type: CALL_SERVER_STREAM, Current: 1, Max: 1, Total: 1}, gauges["/pkg.Svc/Watch"])
+
+	wrt = httptest.NewRecorder()
+	handleMetrics(wrt, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, wrt.Body.String(), "# TYPE gripmock_inflight_calls gauge\n"+
+		`gripmock_inflight_calls{method="/pkg.Svc/Get",type="unary"} 2`+"\n"+
+		`gripmock_inflight_calls{method="/pkg.Svc/Watch",type="server_stream"} 1`+"\n")
+	assert.Contains(t, wrt.Body.String(), `gripmock_inflight_calls_max{method="/pkg.Svc/Get",type="unary"} 3`)
+	assert.Contains(t, wrt.Body.String(), `gripmock_calls_started_total{method="/pkg.Svc/Get",type="unary"} 3`)
+}
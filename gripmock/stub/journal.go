@@ -0,0 +1,252 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Request journal.
+ *
+ * The gRPC server reports each call as it starts and finishes (see
+ * inflight.go), and tags its stub lookups with the same call ID. The journal
+ * puts these together into one entry per call: its method, metadata, the
+ * messages it was looked up with, the stubs that matched and the status and
+ * latency it ended with. The most recent entries are kept in a ring and
+ * served on /journal, so a failing test can see what the mock actually
+ * received.
+ */
+
+// Number of calls kept before the oldest are dropped
+const JOURNAL_SIZE = 1000
+
+// Recorded in an entry's stubs for a lookup no stub matched
+const JOURNAL_UNMATCHED = "unmatched"
+
+type JournalEntry struct {
+	// increases by one for each entry, starting at 1
+	Seq    uint64    `json:"seq"`
+	CallID string    `json:"call_id,omitempty"`
+	Time   time.Time `json:"time"`
+	// test session of the call, see session.go
+	Session string `json:"session,omitempty"`
+	// the call's Options.CorrelationKey metadata, see correlation.go
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// fully qualified when reported by the gRPC server
+	Service string `json:"service"`
+	Method  string `json:"method"`
+	// one of the CALL_* consts
+	Type    string            `json:"type,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// the message of the last lookup, and for client-streaming and
+	// bidirectional calls every message received by then
+	Data   map[string]interface{}   `json:"data,omitempty"`
+	Stream []map[string]interface{} `json:"stream,omitempty"`
+	// ID of the stub each lookup matched, in order, or JOURNAL_UNMATCHED.
+	// Bidirectional streams are looked up once per message.
+	Stubs []string `json:"stubs"`
+	// status code name and duration, empty while the call is in flight
+	Status  string `json:"status,omitempty"`
+	Latency string `json:"latency,omitempty"`
+}
+
+type requestJournal struct {
+	mx      sync.Mutex
+	seq     uint64
+	entries []*JournalEntry
+}
+
+var journal = &requestJournal{}
+
+func (j *requestJournal) add(e *JournalEntry) {
+	j.seq++
+	e.Seq = j.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	j.entries = append(j.entries, e)
+	if len(j.entries) > JOURNAL_SIZE {
+		j.entries = j.entries[len(j.entries)-JOURNAL_SIZE:]
+	}
+}
+
+// The retained entry for a call, newest first since that's where a call
+// that's still running will be
+func (j *requestJournal) call(id string) *JournalEntry {
+	if id == "" {
+		return nil
+	}
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		if j.entries[i].CallID == id {
+			return j.entries[i]
+		}
+	}
+	return nil
+}
+
+func (j *requestJournal) start(c callReport) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	service, method := splitMethod(c.Method)
+	j.add(&JournalEntry{
+		CallID:        c.CallID,
+		Session:       callSession(c.Headers),
+		CorrelationID: correlationID(c.Headers),
+		Service:       service,
+		Method:        method,
+		Type:          c.Type,
+		Headers:       c.Headers,
+		Stubs:         []string{},
+	})
+}
+
+// Split a full method name, "/pkg.Service/Method", into its service and
+// method
+func splitMethod(full string) (service, method string) {
+	if parts := strings.Split(strings.TrimPrefix(full, "/"), "/"); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", full
+}
+
+// Record a call's status, returning a copy of its entry, since the report
+// doesn't carry its headers; empty if it's not retained
+func (j *requestJournal) finish(c callReport) JournalEntry {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	if e := j.call(c.CallID); e != nil {
+		e.Status = c.Code.String()
+		e.Latency = c.Latency
+		entry := *e
+		entry.Stubs = append([]string{}, e.Stubs...)
+		return entry
+	}
+	return JournalEntry{}
+}
+
+// Record a stub lookup against its call, or as an entry of its own if the
+// call wasn't reported
+func (j *requestJournal) lookup(call *findStubPayload, stubID string) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	if stubID == "" {
+		stubID = JOURNAL_UNMATCHED
+	}
+	e := j.call(call.CallID)
+	if e == nil {
+		e = &JournalEntry{
+			CallID:        call.CallID,
+			Session:       callSession(call.Headers),
+			CorrelationID: correlationID(call.Headers),
+			Service:       call.Service,
+			Method:        call.Method,
+			Headers:       call.Headers,
+			Stubs:         []string{},
+		}
+		j.add(e)
+	}
+	e.Data = call.Data
+	e.Stream = call.Stream
+	e.Stubs = append(e.Stubs, stubID)
+}
+
+// Criteria for listing journal entries. Every one that is set must match.
+type journalFilter struct {
+	// fully qualified or short service name
+	service string
+	method  string
+	// a stub ID that matched, or JOURNAL_UNMATCHED
+	stub          string
+	status        string
+	session       string
+	correlationID string
+	since         uint64
+	// only the last limit matching entries, if more than 0
+	limit int
+}
+
+func (f journalFilter) matches(e *JournalEntry) bool {
+	if e.Seq <= f.since {
+		return false
+	}
+	if f.service != "" && e.Service != f.service && !strings.HasSuffix(e.Service, "."+f.service) {
+		return false
+	}
+	if f.method != "" && !strings.EqualFold(e.Method, f.method) {
+		return false
+	}
+	if f.status != "" && !strings.EqualFold(e.Status, f.status) {
+		return false
+	}
+	if f.session != "" && e.Session != f.session {
+		return false
+	}
+	if f.correlationID != "" && e.CorrelationID != f.correlationID {
+		return false
+	}
+	if f.stub != "" {
+		found := false
+		for _, id := range e.Stubs {
+			found = found || id == f.stub
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Return copies of the retained entries that match f, oldest first
+func (j *requestJournal) list(f journalFilter) []JournalEntry {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	found := []JournalEntry{}
+	for _, e := range j.entries {
+		if f.matches(e) {
+			c := *e
+			c.Stubs = append([]string{}, e.Stubs...)
+			found = append(found, c)
+		}
+	}
+	if f.limit > 0 && len(found) > f.limit {
+		found = found[len(found)-f.limit:]
+	}
+	return found
+}
+
+func listJournal(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := journalFilter{
+		service:       q.Get("service"),
+		method:        q.Get("method"),
+		stub:          q.Get("stub"),
+		status:        q.Get("status"),
+		session:       requestSession(r),
+		correlationID: q.Get("correlation_id"),
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Invalid limit \"%s\", must be a number of entries", v)))
+			return
+		}
+		f.limit = limit
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Invalid since \"%s\", must be an entry seq", v)))
+			return
+		}
+		f.since = since
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(journal.list(f))
+}
@@ -0,0 +1,90 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	defer clearStorage()
+	journal = &requestJournal{}
+	defer func() { journal = &requestJournal{} }()
+
+	wrt := httptest.NewRecorder()
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(
+		`{"id":"hello","service":"Greeter","method":"SayHello","input":{"equals":{"name":"bob"}},"output":{"data":{"message":"hi"}}}`))))
+	require.Equal(t, "Success add stub", wrt.Body.String())
+
+	post := func(handler http.HandlerFunc, body string) {
+		handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/", bytes.NewReader([]byte(body))))
+	}
+	post(reportCall, `{"method":"/hello.Greeter/SayHello","type":"unary","delta":1,"call_id":"1-1","headers":{"x-user":"bob"}}`)
+	post(reportCall, `{"method":"/hello.Greeter/SayHello","type":"unary","delta":1,"call_id":"1-2"}`)
+	post(handleFindStub, `{"service":"Greeter","method":"SayHello","data":{"name":"bob"},"call_id":"1-1"}`)
+	post(handleFindStub, `{"service":"Greeter","method":"SayHello","data":{"name":"eve"},"call_id":"1-2"}`)
+	post(reportCall, `{"method":"/hello.Greeter/SayHello","type":"unary","delta":-1,"call_id":"1-1","latency":"2ms"}`)
+	post(reportCall, `{"method":"/hello.Greeter/SayHello","type":"unary","delta":-1,"call_id":"1-2","code":2,"latency":"3ms"}`)
+	// a lookup that wasn't reported as a call
+	post(handleFindStub, `{"service":"Greeter","method":"SayHello","data":{"name":"bob"}}`)
+
+	list := func(query string) []JournalEntry {
+		wrt := httptest.NewRecorder()
+		listJournal(wrt, httptest.NewRequest("GET", "/journal"+query, nil))
+		require.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+		entries := []JournalEntry{}
+		require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &entries))
+		return entries
+	}
+
+	entries := list("")
+	require.Len(t, entries, 3)
+	first := entries[0]
+	assert.Equal(t, uint64(1), first.Seq)
+	assert.Equal(t, "hello.Greeter", first.Service)
+	assert.Equal(t, "SayHello", first.Method)
+	assert.Equal(t, CALL_UNARY, first.Type)
+	assert.Equal(t, map[string]string{"x-user": "bob"}, first.Headers)
+	assert.Equal(t, map[string]interface{}{"name": "bob"}, first.Data)
+	assert.Equal(t, []string{"hello"}, first.Stubs)
+	assert.Equal(t, "OK", first.Status)
+	assert.Equal(t, "2ms", first.Latency)
+	assert.Equal(t, []string{JOURNAL_UNMATCHED}, entries[1].Stubs)
+	assert.Equal(t, "UNKNOWN", entries[1].Status)
+	assert.Equal(t, "Greeter", entries[2].Service)
+	assert.Equal(t, "", entries[2].Status)
+
+	seqs := func(entries []JournalEntry) []uint64 {
+		found := []uint64{}
+		for _, e := range entries {
+			found = append(found, e.Seq)
+		}
+		return found
+	}
+	assert.Equal(t, []uint64{1, 3}, seqs(list("?stub=hello")))
+	assert.Equal(t, []uint64{2}, seqs(list("?stub=unmatched")))
+	assert.Equal(t, []uint64{2}, seqs(list("?status=unknown")))
+	assert.Equal(t, []uint64{1, 2}, seqs(list("?service=hello.Greeter&method=sayHello")))
+	assert.Equal(t, []uint64{1, 2, 3}, seqs(list("?service=Greeter")))
+	assert.Equal(t, []uint64{2, 3}, seqs(list("?since=1")))
+	assert.Equal(t, []uint64{3}, seqs(list("?limit=1")))
+
+	wrt = httptest.NewRecorder()
+	listJournal(wrt, httptest.NewRequest("GET", "/journal?limit=lots", nil))
+	assert.Equal(t, http.StatusBadRequest, wrt.Code)
+}
+
+func TestJournalSize(t *testing.T) {
+	j := &requestJournal{}
+	for i := 0; i < JOURNAL_SIZE+1; i++ {
+		j.start(callReport{Method: "/S/M", Type: CALL_UNARY, Delta: 1})
+	}
+	entries := j.list(journalFilter{})
+	assert.Len(t, entries, JOURNAL_SIZE)
+	assert.Equal(t, uint64(2), entries[0].Seq)
+}
@@ -0,0 +1,313 @@
+package stub
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ * Streaming and lazy stub loading.
+ *
+ * A stub file holding an array of stubs is read from disk a stub at a time,
+ * rather than read whole and unmarshalled along with all its stubs, so
+ * multi-gigabyte fixture sets only ever hold the stubs themselves in memory.
+ * The file is read twice: first to check it's valid JSON, as a broken file
+ * is skipped whole, then to load it. Single stub files, and every file when
+ * they're rendered as templates (see template.go), are still read whole.
+ *
+ * With Options.LazyStubs, the stub files are only scanned at startup for the
+ * services they have stubs for. A service's stubs are loaded when its first
+ * call is looked up, so the fixtures of services a test run doesn't call
+ * aren't loaded at all. Until then they aren't listed or exported on the
+ * admin API, and they're loaded after any stubs added for the service
+ * meanwhile, which take precedence. Clearing every stub drops them too.
+ */
+
+// Load each service's stubs from the stub files when it's first called,
+// see Options.LazyStubs
+var lazyStubLoading bool
+
+type lazyStubFiles struct {
+	mx sync.Mutex
+	// stub files with stubs for each service that isn't loaded yet
+	pending map[string][]string
+	// services pending, to skip the lock once they're all loaded
+	count atomic.Int32
+}
+
+var lazyStubs = &lazyStubFiles{pending: map[string][]string{}}
+
+// Note the services a stub file has stubs for, to load them when they're
+// first called. A file that can't be scanned is loaded now, which reports
+// why.
+func deferStubFile(path string) {
+	services, err := stubFileServices(path)
+	if err != nil {
+		stubStorage.readStubFile(path)
+		return
+	}
+	lazyStubs.mx.Lock()
+	defer lazyStubs.mx.Unlock()
+	for _, service := range services {
+		if _, ok := lazyStubs.pending[service]; !ok {
+			lazyStubs.count.Add(1)
+		}
+		lazyStubs.pending[service] = append(lazyStubs.pending[service], path)
+	}
+}
+
+// The services a stub file has stubs for
+func stubFileServices(path string) ([]string, error) {
+	seen := map[string]bool{}
+	services := []string{}
+	note := func(byt []byte) error {
+		resolved, _ := resolveIncludes(path, byt)
+		stubs, err := parseStubs(resolved)
+		if err != nil {
+			return err
+		}
+		for _, s := range stubs {
+			if !seen[s.Service] {
+				seen[s.Service] = true
+				services = append(services, s.Service)
+			}
+		}
+		return nil
+	}
+
+	var noted error
+	streamed, err := streamStubFile(path, func(i int, raw []byte, at filePosition) {
+		if err := note(raw); err != nil && noted == nil {
+			noted = err
+		}
+	})
+	if err == nil {
+		err = noted
+	}
+	if err != nil {
+		return nil, err
+	}
+	if streamed {
+		return services, nil
+	}
+	byt, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if byt, err = renderStubFile(path, byt); err != nil {
+		return nil, err
+	}
+	if err := note(byt); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// Load the stubs of a service deferred at startup, if it has any, before
+// its first call is looked up
+func loadLazyStubs(service string) {
+	if lazyStubs.count.Load() == 0 {
+		return
+	}
+	lazyStubs.mx.Lock()
+	defer lazyStubs.mx.Unlock()
+	files, ok := lazyStubs.pending[service]
+	if !ok {
+		return
+	}
+	delete(lazyStubs.pending, service)
+	lazyStubs.count.Add(-1)
+
+	loaded := stubMapping{}
+	for _, path := range files {
+		loaded.readStubFileOf(path, service)
+	}
+	n := 0
+	for method, stubs := range loaded[service] {
+		for _, s := range stubs {
+			// they came from the stub files, so aren't saved, see persist.go
+			persistMx.Lock()
+			loadedStubs[s.ID] = true
+			persistMx.Unlock()
+			err := storeStub(&Stub{
+				ID:        s.ID,
+				Service:   service,
+				Method:    method,
+				Namespace: s.Namespace,
+				Session:   s.Session,
+				Input:     s.Input,
+				Output:    s.Output,
+			})
+			if err != nil {
+				log.Printf("Can't store stub %s of %s. %v. skipping...", s.ID, service, err)
+				continue
+			}
+			n++
+		}
+	}
+	log.Printf("Loaded %d stubs of %s from %d stub files", n, service, len(files))
+}
+
+// Forget the stubs not loaded yet, when every stub is cleared
+func dropLazyStubs() {
+	lazyStubs.mx.Lock()
+	defer lazyStubs.mx.Unlock()
+	lazyStubs.pending = map[string][]string{}
+	lazyStubs.count.Store(0)
+}
+
+// Where a streamed stub starts in its file, from 1
+type filePosition struct {
+	line   int
+	column int
+}
+
+// Read a stub file holding an array of stubs a stub at a time, calling fn
+// with each as a document of its own. Other files, and every file when stub
+// templates are on, aren't streamed, and streamed is false. A file that
+// isn't valid JSON is reported before fn is called.
+func streamStubFile(path string, fn func(i int, raw []byte, at filePosition)) (streamed bool, err error) {
+	if stubTemplates {
+		return false, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if !startsArray(r) {
+		return false, nil
+	}
+	if err := eachStub(r, nil); err != nil {
+		return true, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return true, err
+	}
+	r.Reset(f)
+	pos := &positionReader{r: bufio.NewReader(io.NewSectionReader(f, 0, math.MaxInt64)), pos: filePosition{1, 1}}
+	return true, eachStub(r, func(i int, raw []byte, end int64) {
+		fn(i, raw, pos.advance(end-int64(len(raw))))
+	})
+}
+
+// Whether a stub document is an array, leaving r where it was but for any
+// leading whitespace
+func startsArray(r *bufio.Reader) bool {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		r.UnreadByte()
+		return b == '['
+	}
+}
+
+// Decode an array of stubs a stub at a time, calling fn, if set, with each
+// and the offset of its end
+func eachStub(r io.Reader, fn func(i int, raw []byte, end int64)) error {
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for i := 0; dec.More(); i++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if fn != nil {
+			fn(i, raw, dec.InputOffset())
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the stubs")
+	}
+	return nil
+}
+
+// Tracks the line and column of offsets in a file, read in order
+type positionReader struct {
+	r      *bufio.Reader
+	offset int64
+	pos    filePosition
+}
+
+func (p *positionReader) advance(to int64) filePosition {
+	for p.offset < to {
+		b, err := p.r.ReadByte()
+		if err != nil {
+			break
+		}
+		p.offset++
+		if b == '\n' {
+			p.pos.line++
+			p.pos.column = 1
+		} else {
+			p.pos.column++
+		}
+	}
+	return p.pos
+}
+
+// Load stub i of a streamed stub file, if service is empty or it's one of
+// service's
+func (sm *stubMapping) loadStreamedStub(path string, i int, raw []byte, at filePosition, service string) {
+	resolved, unresolved := resolveIncludes(path, raw)
+	stubs, err := parseStubs(resolved)
+	if err == nil && len(stubs) != 1 {
+		err = fmt.Errorf("a stub must be an object")
+	}
+	if err != nil {
+		log.Printf("Error when unmarshalling stub %d in file %s. %v. skipping...", i, path, err)
+		return
+	}
+	s := stubs[0]
+	if service != "" && s.Service != service {
+		return
+	}
+	if errs := schemaProblems(path, raw); len(errs) > 0 {
+		log.Printf("Stub file %s doesn't match the stub schema:\n%v", path, errs.inStub(i, at))
+		if stubValidation == VALIDATION_REJECT {
+			log.Printf("Skipping stub %d in file %s", i, path)
+			return
+		}
+	}
+	for _, err := range unresolved {
+		log.Printf("Can't resolve stub %d in file %s. %v. skipping...", i, path, err)
+		return
+	}
+	sm.storeFileStub(path, i, s)
+}
+
+// Place the schema problems of a streamed stub, checked as a document of
+// its own, where it is in its file
+func (errs SchemaErrors) inStub(i int, at filePosition) SchemaErrors {
+	for j := range errs {
+		e := &errs[j]
+		if e.Line == 1 {
+			e.Column += at.column - 1
+		}
+		e.Line += at.line - 1
+		e.Stub = i
+		e.Field = strings.TrimSuffix(fmt.Sprintf("[%d].%s", i, e.Field), ".")
+	}
+	return errs
+}
@@ -0,0 +1,86 @@
+package stub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamStubFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stubs.json")
+	doc := `[
+  {"service":"Greeter","method":"SayHello","input":{"equals":{"name":"a"}},"output":{"data":{}}},
+  {"service":"Greeter","method":"SayHello",
+   "input":{"equals":{"name":"b"}},"output":{"data":{},"dealy":"1s"}}
+]
+`
+	require.NoError(t, os.WriteFile(file, []byte(doc), 0644))
+
+	// problems are placed in the file as when it's checked whole
+	errs := SchemaErrors{}
+	n := 0
+	streamed, err := streamStubFile(file, func(i int, raw []byte, at filePosition) {
+		n++
+		errs = append(errs, validateSchema(file, raw).inStub(i, at)...)
+	})
+	require.NoError(t, err)
+	assert.True(t, streamed)
+	assert.Equal(t, 2, n)
+	require.Len(t, errs, 1)
+	assert.Equal(t, validateSchema(file, []byte(doc)), errs)
+
+	// a broken file isn't loaded at all
+	require.NoError(t, os.WriteFile(file, []byte(doc[:len(doc)-3]), 0644))
+	n = 0
+	streamed, err = streamStubFile(file, func(int, []byte, filePosition) { n++ })
+	assert.True(t, streamed)
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+
+	// nor are single stubs streamed
+	require.NoError(t, os.WriteFile(file, []byte(`{"service":"Greeter","method":"SayHello"}`), 0644))
+	streamed, err = streamStubFile(file, func(int, []byte, filePosition) { n++ })
+	assert.False(t, streamed)
+	assert.NoError(t, err)
+}
+
+func TestLazyStubs(t *testing.T) {
+	lazyStubLoading = true
+	defer func() {
+		lazyStubLoading = false
+		loadedStubs = map[string]bool{}
+		clearStorage()
+	}()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stubs.json"), []byte(`[
+		{"id":"hello","service":"Greeter","method":"SayHello","input":{"equals":{"name":"a"}},"output":{"data":{"v":"file"}}},
+		{"id":"other","service":"Other","method":"Get","input":{"equals":{}},"output":{"data":{}}}
+	]`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bye.json"), []byte(
+		`{"id":"bye","service":"Greeter","method":"SayBye","input":{"equals":{}},"output":{"data":{}}}`), 0644))
+	stubStorage.readStubFromFile(dir)
+	assert.Empty(t, listStubs())
+
+	// stubs added meanwhile take precedence
+	require.NoError(t, storeStub(&Stub{ID: "runtime", Service: "Greeter", Method: "SayHello",
+		Input: Input{Equals: map[string]interface{}{"name": "a"}}}))
+	match, err := findStub(&findStubPayload{Service: "Greeter", Method: "SayHello", Data: map[string]interface{}{"name": "a"}})
+	require.NoError(t, err)
+	assert.Equal(t, "runtime", match.ID)
+
+	ids := []string{}
+	for _, s := range listStubs() {
+		ids = append(ids, s.ID)
+	}
+	assert.ElementsMatch(t, []string{"runtime", "hello", "bye"}, ids)
+	assert.True(t, loadedStubs["hello"])
+	assert.False(t, loadedStubs["runtime"])
+
+	clearStorage()
+	_, err = findStub(&findStubPayload{Service: "Other", Method: "Get", Data: map[string]interface{}{}})
+	assert.Error(t, err)
+}
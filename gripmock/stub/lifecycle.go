@@ -0,0 +1,226 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+ * Lifecycle state machine.
+ *
+ * gripmock moves through a fixed sequence of phases: it generates the server
+ * sources, builds them, starts the server, serves until it's told to stop,
+ * drains, and stops. The current phase is served on /state and each
+ * transition is recorded as a "state" event. gripmock can also be told to
+ * pause once a phase is done, e.g. to inspect the generated sources before
+ * they're built or run, and waits for POST /state/resume to carry on.
+ */
+
+const (
+	STATE_GENERATING = "generating"
+	STATE_BUILDING   = "building"
+	STATE_STARTING   = "starting"
+	STATE_SERVING    = "serving"
+	STATE_DRAINING   = "draining"
+	STATE_STOPPED    = "stopped"
+
+	// lifecycle state change; detail has "state" and "previous", "paused"
+	// while waiting to be resumed, or "serverPaused" when the gRPC server
+	// is paused or resumed
+	EVENT_STATE = "state"
+)
+
+// order of the lifecycle phases; a phase is only re-entered when the server
+// is started again by a reload or /server/start or /server/restart
+var stateOrder = map[string]int{
+	STATE_GENERATING: 0,
+	STATE_BUILDING:   1,
+	STATE_STARTING:   2,
+	STATE_SERVING:    3,
+	STATE_DRAINING:   4,
+	STATE_STOPPED:    5,
+}
+
+type stateChange struct {
+	State string    `json:"state"`
+	Time  time.Time `json:"time"`
+}
+
+type lifecycleState struct {
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+	// waiting for POST /state/resume before leaving State
+	Paused bool `json:"paused"`
+	// gRPC server frozen by POST /server/pause
+	ServerPaused bool          `json:"serverPaused"`
+	History      []stateChange `json:"history"`
+	// how long each phase of startup took, once the server first served,
+	// see startup.go
+	Startup *startupTiming `json:"startup,omitempty"`
+}
+
+type lifecycle struct {
+	mx     sync.Mutex
+	state  lifecycleState
+	resume chan struct{}
+}
+
+var states = newLifecycle()
+
+func newLifecycle() *lifecycle {
+	now := time.Now()
+	return &lifecycle{state: lifecycleState{
+		State:   STATE_GENERATING,
+		Since:   now,
+		History: []stateChange{{STATE_GENERATING, now}},
+	}}
+}
+
+// Move to a later phase. Going back to an earlier phase, or staying in the
+// same one, is ignored, so the gRPC server and gripmock can both report a
+// transition.
+func (l *lifecycle) set(state string) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	previous := l.state.State
+	if stateOrder[state] <= stateOrder[previous] {
+		return
+	}
+	now := time.Now()
+	l.state.State = state
+	l.state.Since = now
+	l.state.History = append(l.state.History, stateChange{state, now})
+	RecordEvent(EVENT_STATE, map[string]string{"state": state, "previous": previous})
+	if state == STATE_SERVING {
+		startup.finish(now.Sub(l.since(STATE_STARTING)), now.Sub(l.state.History[0].Time))
+	}
+}
+
+// When the latest change to a state was. Must be called with l.mx held.
+func (l *lifecycle) since(state string) time.Time {
+	for i := len(l.state.History) - 1; i >= 0; i-- {
+		if l.state.History[i].State == state {
+			return l.state.History[i].Time
+		}
+	}
+	return l.state.History[0].Time
+}
+
+// Go back to starting for a restarted server, from whatever phase the old
+// one reached
+func (l *lifecycle) restart() {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	previous := l.state.State
+	now := time.Now()
+	l.state.State = STATE_STARTING
+	l.state.Since = now
+	l.state.History = append(l.state.History, stateChange{STATE_STARTING, now})
+	RecordEvent(EVENT_STATE, map[string]string{"state": STATE_STARTING, "previous": previous})
+}
+
+// Block until resumed
+func (l *lifecycle) pause() {
+	l.mx.Lock()
+	resume := make(chan struct{})
+	l.resume = resume
+	l.state.Paused = true
+	RecordEvent(EVENT_STATE, map[string]string{"state": l.state.State, "paused": "true"})
+	l.mx.Unlock()
+
+	<-resume
+}
+
+// Wake a paused lifecycle, returning false if it isn't paused
+func (l *lifecycle) unpause() bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.resume == nil {
+		return false
+	}
+	close(l.resume)
+	l.resume = nil
+	l.state.Paused = false
+	RecordEvent(EVENT_STATE, map[string]string{"state": l.state.State, "paused": "false"})
+	return true
+}
+
+func (l *lifecycle) serverPaused(paused bool) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.state.ServerPaused == paused {
+		return
+	}
+	l.state.ServerPaused = paused
+	RecordEvent(EVENT_STATE, map[string]string{"state": l.state.State, "serverPaused": fmt.Sprint(paused)})
+}
+
+func (l *lifecycle) get() lifecycleState {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	st := l.state
+	st.History = append([]stateChange{}, l.state.History...)
+	st.Startup = startup.get()
+	return st
+}
+
+// Record that gripmock entered a lifecycle phase
+func SetState(state string) {
+	states.set(state)
+}
+
+// Record that gripmock is starting a new gRPC server in place of the old one
+func Restarting() {
+	states.restart()
+}
+
+// Record that the gRPC server was paused or resumed
+func ServerPaused(paused bool) {
+	states.serverPaused(paused)
+}
+
+// Wait in the current phase until POST /state/resume
+func Pause() {
+	states.pause()
+}
+
+// see Options.OnServing
+var onServing func(serving bool)
+
+// The gRPC server reports its health transitions as events; its whole
+// server status going SERVING or NOT_SERVING marks the serving and draining
+// phases.
+func stateFromEvent(e Event) {
+	if service, ok := e.Detail["service"]; e.Type != EVENT_HEALTH || !ok || service != "" {
+		return
+	}
+	switch e.Detail["status"] {
+	case "SERVING":
+		states.set(STATE_SERVING)
+		if onServing != nil {
+			onServing(true)
+		}
+	case "NOT_SERVING":
+		states.set(STATE_DRAINING)
+		if onServing != nil {
+			onServing(false)
+		}
+	}
+}
+
+func getState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(states.get())
+}
+
+func resumeState(w http.ResponseWriter, r *http.Request) {
+	if !states.unpause() {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("Not paused, the state is %s", states.get().State)))
+		return
+	}
+	w.Write([]byte("OK"))
+}
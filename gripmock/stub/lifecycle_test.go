@@ -0,0 +1,96 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycle(t *testing.T) {
+	states = newLifecycle()
+	events = &eventLog{}
+	defer func() {
+		states = newLifecycle()
+		events = &eventLog{}
+	}()
+
+	get := func() lifecycleState {
+		wrt := httptest.NewRecorder()
+		getState(wrt, httptest.NewRequest("GET", "/state", nil))
+		st := lifecycleState{}
+		require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &st))
+		return st
+	}
+
+	assert.Equal(t, STATE_GENERATING, get().State)
+	SetState(STATE_BUILDING)
+	SetState(STATE_GENERATING)
+	assert.Equal(t, STATE_BUILDING, get().State)
+
+	wrt := httptest.NewRecorder()
+	resumeState(wrt, httptest.NewRequest("POST", "/state/resume", nil))
+	assert.Equal(t, 409, wrt.Code)
+	assert.Equal(t, "Not paused, the state is building", wrt.Body.String())
+
+	resumed := make(chan struct{})
+	go func() {
+		Pause()
+		close(resumed)
+	}()
+	require.Eventually(t, func() bool { return get().Paused }, time.Second, time.Millisecond)
+	wrt = httptest.NewRecorder()
+	resumeState(wrt, httptest.NewRequest("POST", "/state/resume", nil))
+	assert.Equal(t, "OK", wrt.Body.String())
+	<-resumed
+	assert.False(t, get().Paused)
+
+	// the gRPC server's health events mark serving and draining
+	SetState(STATE_STARTING)
+	wrt = httptest.NewRecorder()
+	addEvent(wrt, httptest.NewRequest("POST", "/events", bytes.NewReader([]byte(`{"type":"health","detail":{"service":"","status":"SERVING"}}`))))
+	assert.Equal(t, STATE_SERVING, get().State)
+	wrt = httptest.NewRecorder()
+	addEvent(wrt, httptest.NewRequest("POST", "/events", bytes.NewReader([]byte(`{"type":"health","detail":{"service":"","status":"NOT_SERVING"}}`))))
+
+	st := get()
+	assert.Equal(t, STATE_DRAINING, st.State)
+	history := []string{}
+	for _, change := range st.History {
+		history = append(history, change.State)
+	}
+	assert.Equal(t, []string{STATE_GENERATING, STATE_BUILDING, STATE_STARTING, STATE_SERVING, STATE_DRAINING}, history)
+
+	stateEvents := []string{}
+	for _, e := range events.since(0) {
+		if e.Type == EVENT_STATE {
+			stateEvents = append(stateEvents, e.Detail["state"]+e.Detail["paused"])
+		}
+	}
+	assert.Equal(t, []string{"building", "buildingtrue", "buildingfalse", "starting", "serving", "draining"}, stateEvents)
+}
+
+func TestOnServing(t *testing.T) {
+	states = newLifecycle()
+	events = &eventLog{}
+	got := []bool{}
+	onServing = func(serving bool) { got = append(got, serving) }
+	defer func() {
+		states = newLifecycle()
+		events = &eventLog{}
+		onServing = nil
+	}()
+
+	for _, body := range []string{
+		`{"type":"health","detail":{"service":"","status":"SERVING","reason":"started"}}`,
+		`{"type":"health","detail":{"service":"Greeter","status":"NOT_SERVING"}}`,
+		`{"type":"health","detail":{"service":"","status":"NOT_SERVING","reason":"draining"}}`,
+	} {
+		addEvent(httptest.NewRecorder(), httptest.NewRequest("POST", "/events", bytes.NewReader([]byte(body))))
+	}
+	assert.Equal(t, []bool{true, false}, got)
+}
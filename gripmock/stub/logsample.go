@@ -0,0 +1,125 @@
+package stub
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ * Log sampling.
+ *
+ * A client that keeps making calls no stub matches, e.g. one retrying in a
+ * tight loop, gets a multi-line "Can't find stub" logged for each, which
+ * can bury everything else in a long running mock's log. With
+ * Options.LogSample set, only the first LogSample lines of each category
+ * about each method are logged per Options.LogSampleInterval; the rest are
+ * counted, and at the end of the interval a summary line says how many of
+ * each were dropped.
+ */
+
+// Categories of sampled log lines
+const (
+	// a lookup no stub matched
+	LOG_NO_MATCH = "no stub matched"
+	// a matched stub's output couldn't be computed, e.g. its script failed
+	LOG_CALL_ERROR = "call error"
+)
+
+const DEFAULT_LOG_SAMPLE_INTERVAL = time.Minute
+
+type logSampler struct {
+	mu sync.Mutex
+	// lines of each category and method logged per interval; 0 logs them
+	// all
+	limit    int
+	interval time.Duration
+	counts   map[logSampleKey]*logSampleCount
+}
+
+type logSampleKey struct {
+	category string
+	// "Service/Method"
+	subject string
+}
+
+type logSampleCount struct {
+	logged, dropped int
+}
+
+// set from Options.LogSample and Options.LogSampleInterval
+var logSampling = &logSampler{}
+
+func (s *logSampler) configure(limit int, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if interval <= 0 {
+		interval = DEFAULT_LOG_SAMPLE_INTERVAL
+	}
+	s.limit = limit
+	s.interval = interval
+	s.counts = map[logSampleKey]*logSampleCount{}
+}
+
+// Whether to log a line of the category about the subject, counting it as
+// dropped if not
+func (s *logSampler) allow(category, subject string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limit <= 0 {
+		return true
+	}
+	key := logSampleKey{category, subject}
+	count := s.counts[key]
+	if count == nil {
+		count = &logSampleCount{}
+		s.counts[key] = count
+	}
+	if count.logged < s.limit {
+		count.logged++
+		return true
+	}
+	count.dropped++
+	return false
+}
+
+// End the interval, returning a summary line for each category and subject
+// that had lines dropped, sorted
+func (s *logSampler) flush() []string {
+	s.mu.Lock()
+	counts := s.counts
+	s.counts = map[logSampleKey]*logSampleCount{}
+	interval := s.interval
+	s.mu.Unlock()
+
+	summaries := []string{}
+	for key, count := range counts {
+		if count.dropped == 0 {
+			continue
+		}
+		summaries = append(summaries, fmt.Sprintf("Dropped %d %q log lines about %s in the last %s, after logging %d",
+			count.dropped, key.category, key.subject, interval, count.logged))
+	}
+	sort.Strings(summaries)
+	return summaries
+}
+
+// Log the summaries of dropped lines at the end of each interval, forever
+func (s *logSampler) summarize() {
+	for range time.Tick(s.interval) {
+		for _, summary := range s.flush() {
+			log.Print(summary)
+		}
+	}
+}
+
+// Like logCall, for a line of the category about a call to the method,
+// unless too many like it have been logged this interval
+func logCallSampled(category string, call *findStubPayload, format string, args ...interface{}) {
+	if !logSampling.allow(category, call.Service+"/"+call.Method) {
+		return
+	}
+	logCall(call.Headers, format, args...)
+}
@@ -0,0 +1,52 @@
+package stub
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSampling(t *testing.T) {
+	defer clearStorage()
+	logSampling.configure(2, time.Minute)
+	defer logSampling.configure(0, 0)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	find := func(method string) {
+		body := `{"service":"Greeter","method":"` + method + `","data":{"name":"bob"}}`
+		handleFindStub(httptest.NewRecorder(), httptest.NewRequest("POST", "/find", strings.NewReader(body)))
+	}
+	for i := 0; i < 5; i++ {
+		find("SayHello")
+	}
+	find("SayBye")
+	assert.Equal(t, 3, strings.Count(buf.String(), "Can't find stub"), "two of SayHello's and SayBye's")
+	assert.Equal(t, []string{
+		`Dropped 3 "no stub matched" log lines about Greeter/SayHello in the last 1m0s, after logging 2`,
+	}, logSampling.flush())
+
+	// a new interval
+	buf.Reset()
+	find("SayHello")
+	assert.Equal(t, 1, strings.Count(buf.String(), "Can't find stub"))
+	assert.Empty(t, logSampling.flush())
+
+	// off
+	logSampling.configure(0, 0)
+	buf.Reset()
+	for i := 0; i < 5; i++ {
+		find("SayHello")
+	}
+	assert.Equal(t, 5, strings.Count(buf.String(), "Can't find stub"))
+	assert.Empty(t, logSampling.flush())
+}
@@ -0,0 +1,219 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+ * Near-miss diagnostics.
+ *
+ * When no stub matches a call, every stub for the method is compared with
+ * it field by field, and the few with the fewest differences are reported
+ * along with what differed: fields that are missing, unexpected, have
+ * another value or don't match a regex, and stream, deadline or wasm rules
+ * that failed. A stub matches if any one of its equals, contains and
+ * matches rules does, so each stub is shown with its closest rule.
+ */
+
+// Most near misses reported when no stub matches a call
+const NEAR_MISS_LIMIT = 3
+
+type nearMiss struct {
+	id string
+	// the rule shown, e.g. "equals"
+	rule string
+	// what didn't match, e.g. `name: expected "bob", got "eve"`
+	diffs []string
+}
+
+// Compare the call with each stub for its method in the given namespaces
+// and the call's sessions, returning the closest first
+func findNearMisses(stubs []storage, namespaces []string, call *findStubPayload) []nearMiss {
+	inNamespace := map[string]bool{}
+	for _, ns := range namespaces {
+		inNamespace[ns] = true
+	}
+
+	inSession := map[string]bool{}
+	for _, session := range callSessions(call.Headers) {
+		inSession[session] = true
+	}
+
+	misses := []nearMiss{}
+	for _, s := range stubs {
+		if !inNamespace[s.Namespace] || !inSession[s.Session] {
+			continue
+		}
+		if miss, ok := compareStub(s, call); ok {
+			misses = append(misses, miss)
+		}
+	}
+	// fewest differences first, otherwise in match order
+	sort.SliceStable(misses, func(i, j int) bool { return len(misses[i].diffs) < len(misses[j].diffs) })
+	if len(misses) > NEAR_MISS_LIMIT {
+		misses = misses[:NEAR_MISS_LIMIT]
+	}
+	return misses
+}
+
+// Describe why a stub didn't match the call, if there's anything to say
+func compareStub(s storage, call *findStubPayload) (nearMiss, bool) {
+	miss := nearMiss{id: s.ID}
+	input := s.Input
+
+	// prerequisites that apply whichever message rule matches
+	if input.Deadline != nil && !deadlineMatches(input.Deadline, call.Deadline) {
+		left := call.Deadline
+		if left == "" {
+			left = "no deadline"
+		} else {
+			left += " left"
+		}
+		miss.diffs = append(miss.diffs, fmt.Sprintf("deadline: %s, expected %s", left, describeDeadline(input.Deadline)))
+	}
+	if input.Stream != nil && (call.Stream == nil || !streamMatches(input.Stream, call.Stream)) {
+		if call.Stream == nil {
+			miss.diffs = append(miss.diffs, "stream: only matches client-streaming and bidirectional calls")
+		} else {
+			miss.diffs = append(miss.diffs, fmt.Sprintf("stream: rules don't match the %d messages received", len(call.Stream)))
+		}
+	}
+	if input.Wasm != nil {
+		miss.rule = "wasm"
+		miss.diffs = append(miss.diffs, fmt.Sprintf("wasm: %s.%s didn't match", input.Wasm.Module, input.Wasm.Function))
+	}
+
+	// the closest of the message rules
+	var closest []string
+	for _, r := range []struct {
+		kind   string
+		expect map[string]interface{}
+	}{
+		{"equals", input.Equals},
+		{"contains", input.Contains},
+		{"matches", input.Matches},
+	} {
+		if r.expect == nil {
+			continue
+		}
+		diffs := []string{}
+		diffFields(r.kind, r.expect, call.Data, "", &diffs)
+		if closest == nil || len(diffs) < len(closest) {
+			miss.rule, closest = r.kind, diffs
+		}
+	}
+	miss.diffs = append(miss.diffs, closest...)
+	if miss.rule == "" && input.Stream != nil {
+		miss.rule = "stream"
+	} else if miss.rule == "" && input.Deadline != nil {
+		miss.rule = "deadline"
+	}
+	return miss, len(miss.diffs) > 0
+}
+
+func describeDeadline(d *DeadlineInput) string {
+	parts := []string{}
+	if d.Above != "" {
+		parts = append(parts, "at least "+d.Above)
+	}
+	if d.Below != "" {
+		parts = append(parts, "less than "+d.Below)
+	}
+	return strings.Join(parts, " and ")
+}
+
+// Append a description of each way actual fails the rule's expected value,
+// mirroring find: "equals" needs exactly the same fields and list lengths,
+// "contains" and "matches" at least the expected ones, and "matches"
+// compares strings as regexes.
+func diffFields(rule string, expect, actual interface{}, path string, diffs *[]string) {
+	exact := rule == "equals"
+	at := path
+	if at == "" {
+		at = "(message)"
+	}
+	switch e := expect.(type) {
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		switch {
+		case !ok:
+			*diffs = append(*diffs, fmt.Sprintf("%s: expected a list, got %s", at, renderValue(actual)))
+		case exact && len(a) != len(e):
+			*diffs = append(*diffs, fmt.Sprintf("%s: expected %d items, got %d", at, len(e), len(a)))
+		case len(a) < len(e):
+			*diffs = append(*diffs, fmt.Sprintf("%s: expected at least %d items, got %d", at, len(e), len(a)))
+		default:
+			for i := range e {
+				diffFields(rule, e[i], a[i], fmt.Sprintf("%s[%d]", path, i), diffs)
+			}
+		}
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			*diffs = append(*diffs, fmt.Sprintf("%s: expected an object, got %s", at, renderValue(actual)))
+			return
+		}
+		for _, k := range sortedKeys(e) {
+			field := k
+			if path != "" {
+				field = path + "." + k
+			}
+			v, present := a[k]
+			if !present {
+				*diffs = append(*diffs, fmt.Sprintf("%s: missing, expected %s", field, renderValue(e[k])))
+				continue
+			}
+			diffFields(rule, e[k], v, field, diffs)
+		}
+		if exact {
+			for _, k := range sortedKeys(a) {
+				if _, expected := e[k]; !expected {
+					field := k
+					if path != "" {
+						field = path + "." + k
+					}
+					*diffs = append(*diffs, fmt.Sprintf("%s: unexpected, got %s", field, renderValue(a[k])))
+				}
+			}
+		}
+	default:
+		if rule == "matches" {
+			if !regexMatch(expect, actual) {
+				*diffs = append(*diffs, fmt.Sprintf("%s: %s doesn't match %s", at, renderValue(actual), renderValue(expect)))
+			}
+		} else if !deepEqual(expect, actual) {
+			*diffs = append(*diffs, fmt.Sprintf("%s: expected %s, got %s", at, renderValue(expect), renderValue(actual)))
+		}
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func renderValue(v interface{}) string {
+	byt, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(byt)
+}
+
+func renderNearMisses(misses []nearMiss) string {
+	rendered := "Near misses"
+	for _, m := range misses {
+		rendered += fmt.Sprintf("\n\nstub %s, %s:", m.id, m.rule)
+		for _, d := range m.diffs {
+			rendered += "\n\t" + d
+		}
+	}
+	return rendered
+}
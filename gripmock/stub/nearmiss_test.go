@@ -0,0 +1,89 @@
+package stub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_diffFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		expect map[string]interface{}
+		actual interface{}
+		diffs  []string
+	}{
+		{
+			name:   "equal",
+			rule:   "equals",
+			expect: map[string]interface{}{"a": "x"},
+			actual: map[string]interface{}{"a": "x"},
+			diffs:  []string{},
+		},
+		{
+			name:   "nested value and unexpected field",
+			rule:   "equals",
+			expect: map[string]interface{}{"user": map[string]interface{}{"name": "bob", "age": float64(3)}},
+			actual: map[string]interface{}{"user": map[string]interface{}{"name": "eve", "age": float64(3)}, "extra": true},
+			diffs:  []string{`user.name: expected "bob", got "eve"`, `extra: unexpected, got true`},
+		},
+		{
+			name:   "contains ignores other fields",
+			rule:   "contains",
+			expect: map[string]interface{}{"tags": []interface{}{"a", "b"}},
+			actual: map[string]interface{}{"tags": []interface{}{"a", "c", "d"}, "other": 1},
+			diffs:  []string{`tags[1]: expected "b", got "c"`},
+		},
+		{
+			name:   "list lengths",
+			rule:   "equals",
+			expect: map[string]interface{}{"tags": []interface{}{"a"}, "ids": []interface{}{1, 2}},
+			actual: map[string]interface{}{"tags": []interface{}{"a", "b"}, "ids": "1,2"},
+			diffs:  []string{`ids: expected a list, got "1,2"`, `tags: expected 1 items, got 2`},
+		},
+		{
+			name:   "regex",
+			rule:   "matches",
+			expect: map[string]interface{}{"name": "^bo", "id": "[0-9]+"},
+			actual: map[string]interface{}{"name": "eve"},
+			diffs:  []string{`id: missing, expected "[0-9]+"`, `name: "eve" doesn't match "^bo"`},
+		},
+		{
+			name:   "no message",
+			rule:   "equals",
+			expect: map[string]interface{}{"a": "x"},
+			actual: nil,
+			diffs:  []string{`(message): expected an object, got null`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := []string{}
+			diffFields(tt.rule, tt.expect, tt.actual, "", &diffs)
+			assert.Equal(t, tt.diffs, diffs)
+		})
+	}
+}
+
+func Test_findNearMisses(t *testing.T) {
+	zero := 0
+	stubs := []storage{
+		{ID: "far", Input: Input{Equals: map[string]interface{}{"a": "1", "b": "2", "c": "3"}}},
+		{ID: "other-tenant", Namespace: "acme", Input: Input{Equals: map[string]interface{}{"a": "x"}}},
+		{ID: "close", Input: Input{Contains: map[string]interface{}{"a": "1"}, Matches: map[string]interface{}{"a": "^z"}}},
+		{ID: "tight", Input: Input{Contains: map[string]interface{}{"a": "x"}, Deadline: &DeadlineInput{Below: "1s"}}},
+		{ID: "streamed", Input: Input{Stream: &StreamInput{Count: &zero}}},
+		{ID: "middling", Input: Input{Equals: map[string]interface{}{"a": "x", "b": "2"}}},
+	}
+	call := &findStubPayload{Data: map[string]interface{}{"a": "x"}, Deadline: "5s"}
+
+	misses := findNearMisses(stubs, []string{""}, call)
+	assert.Equal(t, []nearMiss{
+		{id: "close", rule: "contains", diffs: []string{`a: expected "1", got "x"`}},
+		{id: "tight", rule: "contains", diffs: []string{"deadline: 5s left, expected less than 1s"}},
+		{id: "streamed", rule: "stream", diffs: []string{"stream: only matches client-streaming and bidirectional calls"}},
+	}, misses)
+
+	assert.Equal(t, "Near misses\n\nstub close, contains:\n\ta: expected \"1\", got \"x\"", renderNearMisses(misses[:1]))
+}
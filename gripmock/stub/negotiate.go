@@ -0,0 +1,247 @@
+package stub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/ringerc/gripmock/adminpb"
+)
+
+/*
+ * Content negotiation.
+ *
+ * Besides the loose stub JSON, the stub endpoints accept and return stubs
+ * as the gripmock.admin.v1.Stub message of adminpb/admin.proto, and lists
+ * of them as gripmock.admin.v1.ListStubsResponse, so tooling with generated
+ * types can round-trip stubs. The Content-Type of the request body and the
+ * Accept header of the request pick the encoding:
+ *
+ *   application/x-protobuf                     binary protobuf
+ *   application/json; proto=<message name>     protojson of the message
+ *   anything else                              stub JSON, as before
+ *
+ * The Accept header's q-values are honoured: a protobuf encoding is used if
+ * it's accepted with the highest q-value, ties going to it, and never with
+ * q=0. Responses in a protobuf encoding name their message in the proto=
+ * parameter of their Content-Type. protojson is decoded strictly: unknown
+ * fields are rejected rather than ignored as they are in stub JSON.
+ */
+
+const (
+	MEDIA_JSON     = "application/json"
+	MEDIA_PROTOBUF = "application/x-protobuf"
+
+	ENCODING_JSON      = "json"
+	ENCODING_PROTOBUF  = "protobuf"
+	ENCODING_PROTOJSON = "protojson"
+)
+
+// other names binary protobuf goes by
+var protobufMedia = map[string]bool{
+	MEDIA_PROTOBUF:                    true,
+	"application/protobuf":            true,
+	"application/vnd.google.protobuf": true,
+}
+
+// A body's Content-Type names a message the endpoint doesn't take
+var errWrongMessage = errors.New("Unsupported message")
+
+var (
+	stubMessage     = (&adminpb.Stub{}).ProtoReflect().Descriptor().FullName()
+	stubListMessage = (&adminpb.ListStubsResponse{}).ProtoReflect().Descriptor().FullName()
+)
+
+// The encoding of one media type, and the message named in its proto=
+// parameter, if any
+func mediaEncoding(value string) (encoding string, message string) {
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ENCODING_JSON, ""
+	}
+	switch {
+	case protobufMedia[mediaType]:
+		return ENCODING_PROTOBUF, params["proto"]
+	case mediaType == MEDIA_JSON && params["proto"] != "":
+		return ENCODING_PROTOJSON, params["proto"]
+	}
+	return ENCODING_JSON, ""
+}
+
+// The encoding of a request's body. A message named in the Content-Type
+// must be the one expected.
+func requestEncoding(r *http.Request, expected protoreflect.FullName) (string, error) {
+	encoding, message := mediaEncoding(r.Header.Get("Content-Type"))
+	if message != "" && message != string(expected) {
+		return "", fmt.Errorf("%w %s, expected a %s", errWrongMessage, message, expected)
+	}
+	return encoding, nil
+}
+
+// The encoding to respond in: the first of the Accept header's media types
+// in a protobuf encoding with the highest q-value, unless another media
+// type has a higher one, or stub JSON
+func responseEncoding(r *http.Request) string {
+	best, bestQ := ENCODING_JSON, 0.0
+	jsonQ := 0.0
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		accept = strings.TrimSpace(accept)
+		if accept == "" {
+			continue
+		}
+		q := acceptQ(accept)
+		encoding, _ := mediaEncoding(accept)
+		if encoding == ENCODING_JSON {
+			if q > jsonQ {
+				jsonQ = q
+			}
+		} else if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	if bestQ == 0 || bestQ < jsonQ {
+		return ENCODING_JSON
+	}
+	return best
+}
+
+// The q-value of one of an Accept header's media ranges, 1 if it has none
+// and 0 if it's invalid
+func acceptQ(value string) float64 {
+	_, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return 0
+	}
+	s, ok := params["q"]
+	if !ok {
+		return 1
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0
+	}
+	return q
+}
+
+// Answer an error decoding a request's body; 415 if it's the wrong message
+func decodeError(err error, w http.ResponseWriter) {
+	if errors.Is(err, errWrongMessage) {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	responseError(err, w)
+}
+
+func unmarshalProto(encoding string, body []byte, m proto.Message) error {
+	if encoding == ENCODING_PROTOBUF {
+		return proto.Unmarshal(body, m)
+	}
+	return protojson.Unmarshal(body, m)
+}
+
+// Decode a stub in the encoding of the request's Content-Type
+func decodeStub(r *http.Request, body []byte) (*Stub, error) {
+	encoding, err := requestEncoding(r, stubMessage)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == ENCODING_JSON {
+		stub := new(Stub)
+		if err := json.Unmarshal(body, stub); err != nil {
+			return nil, err
+		}
+		return stub, nil
+	}
+	pb := &adminpb.Stub{}
+	if err := unmarshalProto(encoding, body, pb); err != nil {
+		return nil, fmt.Errorf("Invalid %s: %v", stubMessage, err)
+	}
+	return stubFromProto(pb)
+}
+
+// Decode a list of stubs in a protobuf encoding, or return nil if the
+// request's body isn't in one
+func decodeStubList(r *http.Request, body []byte) ([]importedStub, error) {
+	encoding, err := requestEncoding(r, stubListMessage)
+	if err != nil || encoding == ENCODING_JSON {
+		return nil, err
+	}
+	pb := &adminpb.ListStubsResponse{}
+	if err := unmarshalProto(encoding, body, pb); err != nil {
+		return nil, fmt.Errorf("Invalid %s: %v", stubListMessage, err)
+	}
+	stubs := []importedStub{}
+	for i, s := range pb.Stubs {
+		stub, err := stubFromProto(s)
+		if err != nil {
+			return nil, fmt.Errorf("stub %d: %v", i, err)
+		}
+		stubs = append(stubs, importedStub{from: fmt.Sprintf("stub %d", i), stub: stub})
+	}
+	return stubs, nil
+}
+
+// Write a message in a protobuf encoding
+func writeProto(w http.ResponseWriter, encoding string, m proto.Message) {
+	name := m.ProtoReflect().Descriptor().FullName()
+	var byt []byte
+	var err error
+	if encoding == ENCODING_PROTOBUF {
+		w.Header().Set("Content-Type", fmt.Sprintf("%s; proto=%s", MEDIA_PROTOBUF, name))
+		byt, err = proto.Marshal(m)
+	} else {
+		w.Header().Set("Content-Type", fmt.Sprintf("%s; proto=%s", MEDIA_JSON, name))
+		byt, err = protojson.MarshalOptions{Multiline: true}.Marshal(m)
+	}
+	if err != nil {
+		w.Header().Del("Content-Type")
+		responseError(err, w)
+		return
+	}
+	w.Write(byt)
+}
+
+// Write a stub in the encoding the request accepts, returning false if
+// that's stub JSON, for the caller to write
+func writeStubProto(w http.ResponseWriter, r *http.Request, stub *Stub) bool {
+	encoding := responseEncoding(r)
+	if encoding == ENCODING_JSON {
+		return false
+	}
+	pb, err := stubToProto(stub)
+	if err != nil {
+		responseError(err, w)
+		return true
+	}
+	writeProto(w, encoding, pb)
+	return true
+}
+
+// Write stubs as a ListStubsResponse in the encoding the request accepts,
+// returning false if that's stub JSON, for the caller to write
+func writeStubListProto(w http.ResponseWriter, r *http.Request, stubs []*Stub) bool {
+	encoding := responseEncoding(r)
+	if encoding == ENCODING_JSON {
+		return false
+	}
+	list := &adminpb.ListStubsResponse{}
+	for _, s := range stubs {
+		pb, err := stubToProto(s)
+		if err != nil {
+			responseError(err, w)
+			return true
+		}
+		list.Stubs = append(list.Stubs, pb)
+	}
+	writeProto(w, encoding, list)
+	return true
+}
@@ -0,0 +1,122 @@
+package stub
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/ringerc/gripmock/adminpb"
+)
+
+func TestContentNegotiation(t *testing.T) {
+	defer clearStorage()
+	clearStorage()
+
+	r := chi.NewRouter()
+	r.Post("/add", addStub)
+	r.Get("/", listStub)
+	r.Get("/stub/{id}", handleGetStub)
+	r.Put("/stub/{id}", handleUpdateStub)
+	r.Post("/import", handleImport)
+	r.Get("/export", handleExport)
+	do := func(method, url, contentType, accept string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Accept", accept)
+		wrt := httptest.NewRecorder()
+		r.ServeHTTP(wrt, req)
+		return wrt
+	}
+
+	input, err := structpb.NewStruct(map[string]interface{}{"equals": map[string]interface{}{"name": "bob"}})
+	require.NoError(t, err)
+	output, err := structpb.NewStruct(map[string]interface{}{"data": map[string]interface{}{"message": "hi"}})
+	require.NoError(t, err)
+	hello := &adminpb.Stub{Id: "hello", Service: "Greeter", Method: "SayHello", Input: input, Output: output}
+	byt, err := proto.Marshal(hello)
+	require.NoError(t, err)
+
+	assertStub := func(got *adminpb.Stub) {
+		assert.Equal(t, "hello", got.Id)
+		assert.Equal(t, "SayHello", got.Method)
+		assert.Equal(t, input.AsMap()["equals"], got.Input.AsMap()["equals"])
+		assert.Equal(t, output.AsMap()["data"], got.Output.AsMap()["data"])
+	}
+
+	wrt := do("POST", "/add", MEDIA_PROTOBUF, "", byt)
+	require.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+	assert.Equal(t, "hello", wrt.Header().Get("X-Gripmock-Stub-Id"))
+
+	// binary and protojson out
+	wrt = do("GET", "/stub/hello", "", "application/protobuf", nil)
+	assert.Equal(t, "application/x-protobuf; proto=gripmock.admin.v1.Stub", wrt.Header().Get("Content-Type"))
+	got := &adminpb.Stub{}
+	require.NoError(t, proto.Unmarshal(wrt.Body.Bytes(), got))
+	assertStub(got)
+
+	wrt = do("GET", "/stub/hello", "", "application/json; proto=gripmock.admin.v1.Stub", nil)
+	assert.Equal(t, "application/json; proto=gripmock.admin.v1.Stub", wrt.Header().Get("Content-Type"))
+	got = &adminpb.Stub{}
+	require.NoError(t, protojson.Unmarshal(wrt.Body.Bytes(), got))
+	assertStub(got)
+
+	// loose JSON is still the default
+	wrt = do("GET", "/stub/hello", "", "*/*", nil)
+	assert.Equal(t, "application/json", wrt.Header().Get("Content-Type"))
+
+	// protojson in is strict about fields
+	wrt = do("PUT", "/stub/hello", "application/json; proto=gripmock.admin.v1.Stub", "",
+		[]byte(`{"service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{}},"priority":1}`))
+	assert.Equal(t, http.StatusInternalServerError, wrt.Code)
+	assert.Contains(t, wrt.Body.String(), `unknown field "priority"`)
+	wrt = do("PUT", "/stub/hello", "application/json; proto=gripmock.admin.v1.Stub", "",
+		[]byte(`{"service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{"message":"hello"}}}`))
+	assert.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+
+	wrt = do("POST", "/add", "application/x-protobuf; proto=gripmock.admin.v1.ListStubsResponse", "", byt)
+	assert.Equal(t, http.StatusUnsupportedMediaType, wrt.Code)
+	assert.Equal(t, "Unsupported message gripmock.admin.v1.ListStubsResponse, expected a gripmock.admin.v1.Stub", wrt.Body.String())
+
+	// lists round-trip through export and import
+	for _, path := range []string{"/", "/export"} {
+		wrt = do("GET", path, "", MEDIA_PROTOBUF, nil)
+		list := &adminpb.ListStubsResponse{}
+		require.NoError(t, proto.Unmarshal(wrt.Body.Bytes(), list))
+		require.Len(t, list.Stubs, 1)
+		assert.Equal(t, map[string]interface{}{"message": "hello"}, list.Stubs[0].Output.AsMap()["data"], path)
+	}
+	clearStorage()
+	wrt = do("POST", "/import", MEDIA_PROTOBUF, "", wrt.Body.Bytes())
+	require.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+	assert.JSONEq(t, `{"imported":1,"ids":["hello"]}`, wrt.Body.String())
+	wrt = do("POST", "/import", "application/x-protobuf; proto=gripmock.admin.v1.Stub", "", byt)
+	assert.Equal(t, http.StatusUnsupportedMediaType, wrt.Code)
+}
+
+func TestResponseEncoding(t *testing.T) {
+	for accept, expected := range map[string]string{
+		"":                       ENCODING_JSON,
+		"*/*":                    ENCODING_JSON,
+		"application/x-protobuf": ENCODING_PROTOBUF,
+		"application/json, application/x-protobuf":                       ENCODING_PROTOBUF,
+		"application/x-protobuf;q=0, application/json":                   ENCODING_JSON,
+		"application/x-protobuf;q=0":                                     ENCODING_JSON,
+		"application/x-protobuf;q=0.5, application/json":                 ENCODING_JSON,
+		"application/json;q=0, application/x-protobuf":                   ENCODING_PROTOBUF,
+		"application/x-protobuf;q=0.5, application/json;q=0.2":           ENCODING_PROTOBUF,
+		"application/x-protobuf;q=0.5, application/json; proto=x; q=0.9": ENCODING_PROTOJSON,
+		"application/x-protobuf;q=bad":                                   ENCODING_JSON,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		assert.Equal(t, expected, responseEncoding(req), accept)
+	}
+}
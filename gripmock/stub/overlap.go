@@ -0,0 +1,128 @@
+package stub
+
+import (
+	"fmt"
+	"reflect"
+)
+
+/*
+ * Overlap analysis for newly added stubs.
+ *
+ * Stubs are matched in the order they were added and the first match wins, so
+ * a new stub is dead if some existing stub for the same service, method and
+ * namespace already matches every input the new one could match. A stub
+ * matches if any one of its equals/contains/matches rules does, so the new
+ * stub is subsumed if each of its rules is covered by some rule of a single
+ * existing stub.
+ *
+ * The analysis is conservative: it only reports overlaps it can prove, so a
+ * regex rule is only considered covered by an identical regex rule.
+ */
+
+const (
+	OVERLAP_OFF    = "off"
+	OVERLAP_WARN   = "warn"
+	OVERLAP_REJECT = "reject"
+)
+
+type rule struct {
+	kind   string
+	expect map[string]interface{}
+}
+
+func stubRules(input Input) []rule {
+	rules := []rule{}
+	if input.Equals != nil {
+		rules = append(rules, rule{"equals", input.Equals})
+	}
+	if input.Contains != nil {
+		rules = append(rules, rule{"contains", input.Contains})
+	}
+	if input.Matches != nil {
+		rules = append(rules, rule{"matches", input.Matches})
+	}
+	return rules
+}
+
+// Report whether every input matched by rule n is also matched by rule e
+func ruleCovers(e, n rule) bool {
+	switch e.kind {
+	case "equals":
+		return n.kind == "equals" && equals(e.expect, n.expect)
+	case "contains":
+		switch n.kind {
+		case "equals", "contains":
+			// anything equal to or containing n contains e if n does
+			return contains(e.expect, n.expect)
+		}
+	case "matches":
+		switch n.kind {
+		case "equals":
+			return matches(e.expect, n.expect)
+		case "matches":
+			return reflect.DeepEqual(e.expect, n.expect)
+		}
+	}
+	return false
+}
+
+// Report whether an existing stub's input matches everything the new input does
+func inputSubsumes(existing, input Input) bool {
+	// stream rules only narrow what a stub matches, so an existing stub
+	// with them can only be known to cover one with the very same rules
+	if existing.Stream != nil && !reflect.DeepEqual(existing.Stream, input.Stream) {
+		return false
+	}
+	// likewise for deadline rules
+	if existing.Deadline != nil && !reflect.DeepEqual(existing.Deadline, input.Deadline) {
+		return false
+	}
+	// what a wasm matcher matches can't be known
+	if input.Wasm != nil {
+		return false
+	}
+	existingRules := stubRules(existing)
+	newRules := stubRules(input)
+	if len(newRules) == 0 {
+		return false
+	}
+	for _, n := range newRules {
+		covered := false
+		for _, e := range existingRules {
+			if ruleCovers(e, n) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// Find an existing stub that would always be matched in preference to stub.
+// Returns a description of the overlap, or nil if there is none.
+func findOverlap(stub *Stub) error {
+	mx.Lock()
+	defer mx.Unlock()
+
+	for i, existing := range stubStorage[stub.Service][stub.Method] {
+		if existing.Namespace != stub.Namespace || existing.Session != stub.Session {
+			continue
+		}
+		if inputSubsumes(existing.Input, stub.Input) {
+			return fmt.Errorf("Stub for Service:%s and Method:%s is unreachable, existing stub %d matches every input it does:\n%s",
+				stub.Service, stub.Method, i, renderInput(existing.Input))
+		}
+	}
+	return nil
+}
+
+func renderInput(input Input) string {
+	rendered := ""
+	for _, r := range stubRules(input) {
+		rendered += fmt.Sprintf("%s:%s\n", r.kind, renderFieldAsString(r.expect))
+	}
+	return rendered
+}
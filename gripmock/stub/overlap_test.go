@@ -0,0 +1,126 @@
+package stub
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_inputSubsumes(t *testing.T) {
+	m := func(kv ...interface{}) map[string]interface{} {
+		r := map[string]interface{}{}
+		for i := 0; i < len(kv); i += 2 {
+			r[kv[i].(string)] = kv[i+1]
+		}
+		return r
+	}
+	tests := []struct {
+		name     string
+		existing Input
+		input    Input
+		expect   bool
+	}{
+		{
+			name:     "identical equals",
+			existing: Input{Equals: m("name", "a")},
+			input:    Input{Equals: m("name", "a")},
+			expect:   true,
+		},
+		{
+			name:     "different equals",
+			existing: Input{Equals: m("name", "a")},
+			input:    Input{Equals: m("name", "b")},
+			expect:   false,
+		},
+		{
+			name:     "equals does not cover contains",
+			existing: Input{Equals: m("name", "a")},
+			input:    Input{Contains: m("name", "a")},
+			expect:   false,
+		},
+		{
+			name:     "contains covers equals superset",
+			existing: Input{Contains: m("name", "a")},
+			input:    Input{Equals: m("name", "a", "age", 1.0)},
+			expect:   true,
+		},
+		{
+			name:     "contains covers narrower contains",
+			existing: Input{Contains: m("name", "a")},
+			input:    Input{Contains: m("name", "a", "age", 1.0)},
+			expect:   true,
+		},
+		{
+			name:     "narrower contains does not cover wider contains",
+			existing: Input{Contains: m("name", "a", "age", 1.0)},
+			input:    Input{Contains: m("name", "a")},
+			expect:   false,
+		},
+		{
+			name:     "matches covers matching equals",
+			existing: Input{Matches: m("name", "^gr.*")},
+			input:    Input{Equals: m("name", "gripmock")},
+			expect:   true,
+		},
+		{
+			name:     "matches does not cover contains",
+			existing: Input{Matches: m("name", "^gr.*")},
+			input:    Input{Contains: m("name", "gripmock")},
+			expect:   false,
+		},
+		{
+			name:     "identical matches",
+			existing: Input{Matches: m("name", "^gr.*")},
+			input:    Input{Matches: m("name", "^gr.*")},
+			expect:   true,
+		},
+		{
+			name:     "every rule must be covered",
+			existing: Input{Contains: m("name", "a")},
+			input:    Input{Equals: m("name", "a"), Matches: m("name", "^a")},
+			expect:   false,
+		},
+		{
+			name:     "rules covered by different existing rules",
+			existing: Input{Contains: m("name", "a"), Matches: m("name", "^a")},
+			input:    Input{Equals: m("name", "a"), Matches: m("name", "^a")},
+			expect:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, inputSubsumes(tt.existing, tt.input))
+		})
+	}
+}
+
+func TestAddStubOverlap(t *testing.T) {
+	defer clearStorage()
+
+	add := func(query, payload string) *httptest.ResponseRecorder {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add"+query, bytes.NewReader([]byte(payload))))
+		return wrt
+	}
+
+	wrt := add("", `{"service":"Overlap","method":"Get","input":{"contains":{"id":"1"}},"output":{"data":{"v":"first"}}}`)
+	assert.Equal(t, http.StatusOK, wrt.Code)
+
+	shadowed := `{"service":"Overlap","method":"Get","input":{"equals":{"id":"1","x":"y"}},"output":{"data":{"v":"second"}}}`
+	wrt = add("?overlap=reject", shadowed)
+	assert.Equal(t, http.StatusConflict, wrt.Code)
+	assert.Contains(t, wrt.Body.String(), "is unreachable, existing stub 0 matches every input it does")
+
+	wrt = add("?overlap=warn", shadowed)
+	assert.Equal(t, http.StatusOK, wrt.Code)
+	assert.Contains(t, wrt.Header().Get("X-Gripmock-Warning"), "is unreachable")
+
+	wrt = add("?overlap=reject", `{"service":"Overlap","method":"Get","namespace":"other","input":{"equals":{"id":"1"}},"output":{"data":{"v":"ns"}}}`)
+	assert.Equal(t, http.StatusOK, wrt.Code)
+
+	wrt = add("?overlap=bogus", shadowed)
+	assert.Equal(t, "Unknown overlap mode \"bogus\", must be one of off, warn, reject", wrt.Body.String())
+}
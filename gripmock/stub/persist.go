@@ -0,0 +1,155 @@
+package stub
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*
+ * Persisting stubs added at runtime.
+ *
+ * Stubs added on the admin API live in memory, so fixtures authored
+ * interactively are lost when the container stops. POST /save writes them
+ * to PERSIST_FILE in the -stub directory (the first, if there are several),
+ * and with Options.PersistStubs they're written there whenever they
+ * change. The file is loaded with the rest of the directory on the next
+ * start, and its stubs still count as added at runtime, so they're saved
+ * again with any new ones rather than becoming stub files of their own.
+ *
+ * Stubs loaded from -stub aren't saved, even if they're changed: their
+ * files are their source. The file is removed once there are no runtime
+ * stubs, e.g. after /clear.
+ */
+
+// file in the stub directory holding the stubs added at runtime
+const PERSIST_FILE = "runtime-stubs.json"
+
+// PERSIST_FILE in the -stub directory, or empty if there isn't one
+var persistPath string
+
+// IDs of the stubs loaded from -stub at startup, which aren't saved
+var loadedStubs = map[string]bool{}
+
+// signals the saver that the stubs changed, if Options.PersistStubs is on
+var persistSignal = make(chan struct{}, 1)
+
+// one save at a time
+var persistMx sync.Mutex
+
+var errNoPersistPath = errors.New("no -stub directory to save stubs to")
+
+// Note that the stubs changed. Doesn't block, so may be called with mx held.
+func stubsChanged() {
+	select {
+	case persistSignal <- struct{}{}:
+	default:
+		// a save is already due
+	}
+}
+
+// PERSIST_FILE in the first directory of a -stub list
+func findPersistPath(spec string) string {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || entry == STUB_STDIN || IsStubURL(entry) || hasGlob(entry) {
+			continue
+		}
+		if info, err := os.Stat(entry); err == nil && info.IsDir() {
+			return filepath.Join(entry, PERSIST_FILE)
+		}
+	}
+	return ""
+}
+
+// Load the -stub list, noting which stubs are from files, then the saved
+// runtime stubs
+func loadStubsAndSaved(spec string) {
+	stubStorage.readStubFromFile(spec)
+	for _, s := range listStubs() {
+		loadedStubs[s.ID] = true
+	}
+	if persistPath == "" {
+		return
+	}
+	if _, err := os.Stat(persistPath); err == nil {
+		stubStorage.readStubFile(persistPath)
+	}
+}
+
+// Save the stubs added at runtime to persistPath, returning how many there
+// were
+func saveStubs() (int, error) {
+	if persistPath == "" {
+		return 0, errNoPersistPath
+	}
+	persistMx.Lock()
+	defer persistMx.Unlock()
+	runtime := []*Stub{}
+	for _, s := range listStubs() {
+		if !loadedStubs[s.ID] {
+			runtime = append(runtime, s)
+		}
+	}
+	if len(runtime) == 0 {
+		if err := os.Remove(persistPath); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		return 0, nil
+	}
+	byt, err := json.MarshalIndent(runtime, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	// write it whole or not at all, so a stop part way through doesn't
+	// leave a broken file
+	tmp, err := ioutil.TempFile(filepath.Dir(persistPath), "."+PERSIST_FILE+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(byt, '\n')); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), persistPath); err != nil {
+		return 0, err
+	}
+	return len(runtime), nil
+}
+
+// Save the stubs whenever they change
+func persistStubs() {
+	for range persistSignal {
+		if _, err := saveStubs(); err != nil {
+			log.Printf("Error when saving stubs to %s. %v", persistPath, err)
+		}
+	}
+}
+
+func handleSave(w http.ResponseWriter, r *http.Request) {
+	saved, err := saveStubs()
+	if errors.Is(err, errNoPersistPath) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		responseError(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Saved int    `json:"saved"`
+		File  string `json:"file"`
+	}{saved, persistPath})
+}
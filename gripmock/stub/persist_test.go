@@ -0,0 +1,71 @@
+package stub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistStubs(t *testing.T) {
+	defer func() {
+		persistPath = ""
+		loadedStubs = map[string]bool{}
+		clearStorage()
+	}()
+	clearStorage()
+
+	wrt := httptest.NewRecorder()
+	handleSave(wrt, httptest.NewRequest("POST", "/save", nil))
+	assert.Equal(t, http.StatusConflict, wrt.Code)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello.json"),
+		[]byte(`{"id":"from-file","service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{}}}`), 0644))
+	persistPath = findPersistPath("-," + filepath.Join(dir, "*.json") + "," + dir)
+	assert.Equal(t, filepath.Join(dir, PERSIST_FILE), persistPath)
+	loadStubsAndSaved(dir)
+	assert.True(t, loadedStubs["from-file"])
+
+	require.NoError(t, storeStub(&Stub{ID: "runtime", Service: "Greeter", Method: "SayBye",
+		Input: Input{Contains: map[string]interface{}{}}, Output: Output{Data: map[string]interface{}{"message": "bye"}}}))
+	wrt = httptest.NewRecorder()
+	handleSave(wrt, httptest.NewRequest("POST", "/save", nil))
+	require.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+	assert.JSONEq(t, `{"saved":1,"file":"`+persistPath+`"}`, wrt.Body.String())
+	var saved []Stub
+	byt, err := os.ReadFile(persistPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(byt, &saved))
+	require.Len(t, saved, 1, "only the stub added at runtime")
+	assert.Equal(t, "runtime", saved[0].ID)
+
+	// on the next start the saved stubs come back, and are still runtime
+	// stubs, so saving again keeps them
+	clearStorage()
+	loadedStubs = map[string]bool{}
+	loadStubsAndSaved(dir)
+	assert.Len(t, listStubs(), 2)
+	assert.False(t, loadedStubs["runtime"])
+	n, err := saveStubs()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// with no runtime stubs left the file goes
+	require.NoError(t, deleteStub("runtime"))
+	n, err = saveStubs()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.NoFileExists(t, persistPath)
+
+	// changes are signalled without blocking
+	stubsChanged()
+	stubsChanged()
+	assert.Len(t, persistSignal, 1)
+	<-persistSignal
+}
@@ -0,0 +1,27 @@
+package stub
+
+import (
+	"net/http/pprof"
+
+	"github.com/go-chi/chi"
+)
+
+/*
+ * Profiling.
+ *
+ * With Options.Pprof, the admin server serves the runtime profiles of
+ * net/http/pprof under /debug/pprof/, e.g. for
+ * "go tool pprof http://localhost:4771/debug/pprof/profile". They profile
+ * gripmock itself, which matches the stubs and, with -dynamic, serves the
+ * gRPC calls too; the generated server is a separate process.
+ */
+
+func mountPprof(r chi.Router) {
+	r.HandleFunc("/debug/pprof/", pprof.Index)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// the named profiles, e.g. heap and goroutine
+	r.HandleFunc("/debug/pprof/*", pprof.Index)
+}
@@ -0,0 +1,87 @@
+package stub
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+/*
+ * Match preview.
+ *
+ * /preview takes a sample call in the same form as /find and reports which
+ * stub it would match, by which input rule, and the output it would get,
+ * or if none matches, the nearest misses and what differed. Nothing is
+ * recorded, so stub authors can try out matchers without calling the gRPC
+ * server or disturbing call counts and the journal.
+ */
+
+type MatchPreview struct {
+	Matched bool `json:"matched"`
+	// the stub the call would match, and the input rule it matched by:
+	// "equals", "contains", "matches", "wasm", "stream" or "deadline"
+	Stub *Stub  `json:"stub,omitempty"`
+	Rule string `json:"rule,omitempty"`
+	// the output the call would get, after any transform or script
+	Output *Output `json:"output,omitempty"`
+	// why no stub matched, or why the output couldn't be computed
+	Error string `json:"error,omitempty"`
+	// the stubs closest to matching, if none did
+	NearMisses []NearMissReport `json:"near_misses,omitempty"`
+}
+
+type NearMissReport struct {
+	ID    string   `json:"id"`
+	Rule  string   `json:"rule"`
+	Diffs []string `json:"diffs"`
+}
+
+func previewMatch(call *findStubPayload) MatchPreview {
+	// due to golang implementation
+	// method name must capital
+	call.Method = strings.Title(call.Method)
+
+	preview := MatchPreview{}
+	match, rule, err := findStubRule(call)
+	if err != nil {
+		preview.Error = err.Error()
+		mx.Lock()
+		stubs := stubStorage[call.Service][call.Method]
+		misses := findNearMisses(stubs, callNamespaces(call.Headers), call)
+		mx.Unlock()
+		for _, m := range misses {
+			preview.NearMisses = append(preview.NearMisses, NearMissReport{m.id, m.rule, m.diffs})
+		}
+		return preview
+	}
+
+	preview.Matched = true
+	preview.Rule = rule
+	preview.Stub = &Stub{
+		ID:        match.ID,
+		Service:   call.Service,
+		Method:    call.Method,
+		Namespace: match.Namespace,
+		Session:   match.Session,
+		Input:     match.Input,
+		Output:    match.Output,
+	}
+	output, err := computeOutput(call, match.Output)
+	if err != nil {
+		preview.Error = err.Error()
+		return preview
+	}
+	preview.Output = &output
+	return preview
+}
+
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	call := new(findStubPayload)
+	if err := json.NewDecoder(r.Body).Decode(call); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(previewMatch(call))
+}
@@ -0,0 +1,61 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreview(t *testing.T) {
+	defer clearStorage()
+	calls = newCallHistory()
+	defer func() { calls = newCallHistory() }()
+	journal = &requestJournal{}
+	defer func() { journal = &requestJournal{} }()
+
+	for _, payload := range []string{
+		`{"id":"bob","service":"Greeter","method":"SayHello","input":{"equals":{"name":"bob"}},"output":{"data":{"message":"hi bob"}}}`,
+		`{"id":"scripted","service":"Greeter","method":"SayHello","input":{"matches":{"name":"^a"}},"output":{"script":"response.message = 'hi ' + request.name"}}`,
+	} {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		require.Equal(t, "Success add stub", wrt.Body.String())
+	}
+
+	preview := func(body string) MatchPreview {
+		wrt := httptest.NewRecorder()
+		handlePreview(wrt, httptest.NewRequest("POST", "/preview", bytes.NewReader([]byte(body))))
+		require.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+		p := MatchPreview{}
+		require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &p))
+		return p
+	}
+
+	p := preview(`{"service":"Greeter","method":"sayHello","data":{"name":"alice"}}`)
+	assert.True(t, p.Matched)
+	assert.Equal(t, "scripted", p.Stub.ID)
+	assert.Equal(t, "matches", p.Rule)
+	assert.Equal(t, map[string]interface{}{"message": "hi alice"}, p.Output.Data)
+
+	p = preview(`{"service":"Greeter","method":"SayHello","data":{"name":"bobby"}}`)
+	assert.False(t, p.Matched)
+	assert.Nil(t, p.Stub)
+	assert.Contains(t, p.Error, "Can't find stub")
+	assert.Equal(t, []NearMissReport{
+		{ID: "bob", Rule: "equals", Diffs: []string{`name: expected "bob", got "bobby"`}},
+		{ID: "scripted", Rule: "matches", Diffs: []string{`name: "bobby" doesn't match "^a"`}},
+	}, p.NearMisses)
+
+	// previews aren't calls
+	assert.Empty(t, calls.counts().Methods)
+	assert.Empty(t, journal.list(journalFilter{}))
+
+	wrt := httptest.NewRecorder()
+	handlePreview(wrt, httptest.NewRequest("POST", "/preview", bytes.NewReader([]byte(`{"service":`))))
+	assert.Equal(t, http.StatusBadRequest, wrt.Code)
+}
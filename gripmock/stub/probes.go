@@ -0,0 +1,114 @@
+package stub
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+/*
+ * Liveness and readiness probes.
+ *
+ * GET /health/live answers as long as the admin server does. GET
+ * /health/ready answers 200 only while the gRPC server is serving: the
+ * lifecycle is in the serving phase, the server isn't paused, and a
+ * grpc.health.v1 check of the whole server, service "", on its first
+ * listening address comes back SERVING. Anything else is a 503 with the
+ * reason, so Kubernetes probes and wait-for scripts need only the one URL.
+ */
+
+// Longest the readiness probe waits for the gRPC server's health check
+const PROBE_TIMEOUT = 2 * time.Second
+
+// see Options.GrpcTLS
+var grpcTLS bool
+
+// The address the gRPC server was last reported listening on, from the
+// first address of each EVENT_LISTENING
+type probeTarget struct {
+	mx      sync.Mutex
+	address string
+}
+
+var probe = &probeTarget{}
+
+// Take the first of the listening addresses, with an unspecified host,
+// i.e. every interface, replaced by localhost
+func (p *probeTarget) listening(addresses []string) {
+	address := addresses[0]
+	if host, port, err := net.SplitHostPort(address); err == nil && (host == "" || net.ParseIP(host).IsUnspecified()) {
+		address = net.JoinHostPort("localhost", port)
+	}
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.address = address
+}
+
+func (p *probeTarget) get() string {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.address
+}
+
+// Check the gRPC server's health as a client would, returning why it isn't
+// serving
+func checkGrpcHealth(ctx context.Context, address string, useTLS bool) error {
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+	}
+	conn, err := grpc.DialContext(ctx, address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("health status is %s", resp.Status)
+	}
+	return nil
+}
+
+func handleLive(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK"))
+}
+
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	notReady := func(format string, args ...interface{}) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, format, args...)
+	}
+	st := states.get()
+	if st.State != STATE_SERVING {
+		notReady("Not ready, the state is %s", st.State)
+		return
+	}
+	if st.ServerPaused {
+		notReady("Not ready, the gRPC server is paused")
+		return
+	}
+	address := probe.get()
+	if address == "" {
+		notReady("Not ready, the gRPC server hasn't reported its address")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), PROBE_TIMEOUT)
+	defer cancel()
+	if err := checkGrpcHealth(ctx, address, grpcTLS); err != nil {
+		notReady("Not ready, checking the gRPC server on %s: %v", address, err)
+		return
+	}
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,81 @@
+package stub
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestProbes(t *testing.T) {
+	states = newLifecycle()
+	events = &eventLog{}
+	probe = &probeTarget{}
+	defer func() {
+		states = newLifecycle()
+		events = &eventLog{}
+		probe = &probeTarget{}
+	}()
+
+	ready := func() (int, string) {
+		wrt := httptest.NewRecorder()
+		handleReady(wrt, httptest.NewRequest("GET", "/health/ready", nil))
+		return wrt.Code, wrt.Body.String()
+	}
+
+	wrt := httptest.NewRecorder()
+	handleLive(wrt, httptest.NewRequest("GET", "/health/live", nil))
+	assert.Equal(t, 200, wrt.Code)
+
+	code, body := ready()
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "Not ready, the state is generating", body)
+
+	SetState(STATE_SERVING)
+	code, body = ready()
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "Not ready, the gRPC server hasn't reported its address", body)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	healthSrv := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(srv, healthSrv)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+	probe.listening([]string{"0.0.0.0:" + port, "unix:///tmp/other.sock"})
+	assert.Equal(t, "localhost:"+port, probe.get())
+	probe.listening([]string{lis.Addr().String()})
+
+	code, body = ready()
+	assert.Equal(t, 200, code, body)
+
+	healthSrv.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	code, body = ready()
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "Not ready, checking the gRPC server on "+lis.Addr().String()+": health status is NOT_SERVING", body)
+	healthSrv.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+
+	ServerPaused(true)
+	code, body = ready()
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "Not ready, the gRPC server is paused", body)
+	ServerPaused(false)
+
+	srv.Stop()
+	code, body = ready()
+	assert.Equal(t, 503, code)
+	assert.Contains(t, body, "Unavailable")
+
+	SetState(STATE_DRAINING)
+	code, body = ready()
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "Not ready, the state is draining", body)
+}
@@ -0,0 +1,47 @@
+package stub
+
+import (
+	"fmt"
+	"net/http"
+)
+
+/*
+ * Proto reload.
+ *
+ * POST /reload has gripmock generate and build the gRPC server again from
+ * the proto files, which may have changed, and swap it in for the running
+ * one. The running server keeps serving while the new one builds, and is
+ * only stopped, draining as it would on shutdown, once the build succeeds.
+ * Stubs, the journal and call counts are kept.
+ */
+
+const (
+	// reload progress; detail has "status", one of "started", "done" or
+	// "failed", and "error" if it failed
+	EVENT_RELOAD = "reload"
+)
+
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if controlServer == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte("Reloading isn't supported"))
+		return
+	}
+	if state := states.get().State; state != STATE_SERVING {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("Can only reload while serving, the state is %s", state)))
+		return
+	}
+
+	RecordEvent(EVENT_RELOAD, map[string]string{"status": "started"})
+	err := controlServer(SERVER_RELOAD)
+	if err != nil {
+		RecordEvent(EVENT_RELOAD, map[string]string{"status": "failed", "error": err.Error()})
+	} else {
+		RecordEvent(EVENT_RELOAD, map[string]string{"status": "done"})
+	}
+	if controlError(err, w) {
+		return
+	}
+	w.Write([]byte("OK"))
+}
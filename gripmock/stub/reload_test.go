@@ -0,0 +1,61 @@
+package stub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	states = newLifecycle()
+	events = &eventLog{}
+	defer func() {
+		states = newLifecycle()
+		events = &eventLog{}
+		controlServer = nil
+	}()
+
+	post := func() *httptest.ResponseRecorder {
+		wrt := httptest.NewRecorder()
+		handleReload(wrt, httptest.NewRequest("POST", "/reload", nil))
+		return wrt
+	}
+
+	controlServer = nil
+	assert.Equal(t, http.StatusNotImplemented, post().Code)
+
+	var result error
+	controlServer = func(action string) error {
+		assert.Equal(t, SERVER_RELOAD, action)
+		return result
+	}
+	wrt := post()
+	assert.Equal(t, http.StatusConflict, wrt.Code)
+	assert.Equal(t, "Can only reload while serving, the state is generating", wrt.Body.String())
+
+	SetState(STATE_BUILDING)
+	SetState(STATE_STARTING)
+	SetState(STATE_SERVING)
+	wrt = post()
+	assert.Equal(t, http.StatusOK, wrt.Code)
+	assert.Equal(t, "OK", wrt.Body.String())
+
+	result = fmt.Errorf("running protoc: exit status 1")
+	wrt = post()
+	assert.Equal(t, http.StatusInternalServerError, wrt.Code)
+	assert.Equal(t, "running protoc: exit status 1", wrt.Body.String())
+
+	result = ErrServerBusy
+	assert.Equal(t, http.StatusConflict, post().Code)
+
+	statuses := []string{}
+	for _, e := range events.since(0) {
+		if e.Type == EVENT_RELOAD {
+			statuses = append(statuses, e.Detail["status"]+e.Detail["error"])
+		}
+	}
+	assert.Equal(t, []string{"started", "done", "started", "failedrunning protoc: exit status 1", "started", "failed" + ErrServerBusy.Error()}, statuses)
+}
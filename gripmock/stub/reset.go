@@ -0,0 +1,107 @@
+package stub
+
+import (
+	"net/http"
+)
+
+/*
+ * Granular resets, so tests can clear what one case did without reloading
+ * the stub fixtures shared between cases, or the other way round.
+ */
+
+// Forget every recorded call: the request journal and the verification
+// counts and call log, of every session
+func resetJournal() {
+	journal.reset()
+	calls.reset()
+	sessionCallsMx.Lock()
+	defer sessionCallsMx.Unlock()
+	sessionCalls = map[string]*callHistory{}
+}
+
+// Reset per-test counters: the in-flight peaks and started totals, and the
+// latency and error summary, and the health statuses set. Calls still
+// running keep counting as in flight, but not as started.
+func resetState() {
+	inflight.reset()
+	summary.reset()
+	health.reset()
+}
+
+func (j *requestJournal) reset() {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	// seq carries on, so clients polling with since don't miss entries
+	j.entries = nil
+}
+
+func (j *requestJournal) removeSession(session string) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	kept := []*JournalEntry{}
+	for _, e := range j.entries {
+		if e.Session != session {
+			kept = append(kept, e)
+		}
+	}
+	j.entries = kept
+}
+
+func (h *callHistory) reset() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.log = nil
+	h.dropped = 0
+	h.methods = map[string]*methodCalls{}
+	h.stubs = map[string]int{}
+}
+
+func (g *inflightGauges) reset() {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	for k, m := range g.methods {
+		if m.Current == 0 {
+			delete(g.methods, k)
+			continue
+		}
+		m.Max = m.Current
+		m.Total = 0
+	}
+}
+
+// In a session, the stub and journal resets only clear the session's own
+// stubs and calls
+
+func handleResetStubs(w http.ResponseWriter, r *http.Request) {
+	clearStubs(requestSession(r))
+	w.Write([]byte("OK"))
+}
+
+func handleResetJournal(w http.ResponseWriter, r *http.Request) {
+	if session := requestSession(r); session != "" {
+		resetSessionJournal(session)
+	} else {
+		resetJournal()
+	}
+	w.Write([]byte("OK"))
+}
+
+func handleResetState(w http.ResponseWriter, r *http.Request) {
+	resetState()
+	w.Write([]byte("OK"))
+}
+
+// Reset stubs, journal and state together. In a session the state, which
+// is shared, is left alone.
+func handleReset(w http.ResponseWriter, r *http.Request) {
+	if session := requestSession(r); session != "" {
+		clearStubs(session)
+		resetSessionJournal(session)
+		w.Write([]byte("OK"))
+		return
+	}
+	clearStorage()
+	resetJournal()
+	resetState()
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,61 @@
+package stub
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResets(t *testing.T) {
+	defer clearStorage()
+	defer resetJournal()
+	defer func() { inflight = &inflightGauges{methods: map[string]*methodGauge{}} }()
+
+	setup := func() {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(
+			`{"service":"Reset","method":"Get","input":{"contains":{}},"output":{"data":{"v":"x"}}}`))))
+		assert.Equal(t, "Success add stub", wrt.Body.String())
+		for _, c := range []callReport{
+			{Method: "/Reset/Get", Type: CALL_UNARY, Delta: 1, CallID: "1"},
+			{Method: "/Reset/Get", Type: CALL_UNARY, Delta: 1, CallID: "2"},
+			{Method: "/Reset/Get", Type: CALL_UNARY, Delta: -1, CallID: "1"},
+			{Method: "/Other/Get", Type: CALL_UNARY, Delta: 1, CallID: "3"},
+			{Method: "/Other/Get", Type: CALL_UNARY, Delta: -1, CallID: "3"},
+		} {
+			inflight.update(c)
+			if c.Delta > 0 {
+				journal.start(c)
+			}
+		}
+		handleFindStub(httptest.NewRecorder(), httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(
+			`{"service":"Reset","method":"Get","data":{},"call_id":"2"}`))))
+	}
+	stubCount := func() int { return len(allStub()["Reset"]["Get"]) }
+
+	setup()
+	handleResetJournal(httptest.NewRecorder(), httptest.NewRequest("POST", "/reset/journal", nil))
+	assert.Equal(t, 1, stubCount())
+	assert.Empty(t, journal.list(journalFilter{}))
+	assert.Empty(t, calls.counts().Methods)
+	assert.Equal(t, 2, inflight.snapshot()["/Reset/Get"].Total)
+
+	handleResetState(httptest.NewRecorder(), httptest.NewRequest("POST", "/reset/state", nil))
+	assert.Equal(t, map[string]methodGauge{
+		"/Reset/Get": {Type: CALL_UNARY, Current: 1, Max: 1, Total: 0},
+	}, inflight.snapshot())
+	assert.Equal(t, 1, stubCount())
+
+	handleResetStubs(httptest.NewRecorder(), httptest.NewRequest("POST", "/reset/stubs", nil))
+	assert.Equal(t, 0, stubCount())
+
+	setup()
+	handleReset(httptest.NewRecorder(), httptest.NewRequest("POST", "/reset", nil))
+	assert.Equal(t, 0, stubCount())
+	assert.Empty(t, journal.list(journalFilter{}))
+	assert.Empty(t, calls.counts().Stubs)
+	assert.Equal(t, 2, inflight.snapshot()["/Reset/Get"].Current)
+	assert.Equal(t, 0, inflight.snapshot()["/Reset/Get"].Total)
+}
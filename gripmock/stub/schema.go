@@ -0,0 +1,411 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/lithammer/fuzzysearch/fuzzy"
+)
+
+/*
+ * Stub schema validation.
+ *
+ * Stub JSON is decoded leniently: unknown keys are ignored, so a misspelt
+ * matcher like "equal" leaves a stub that matches everything, or an
+ * output field that never takes effect. Stub files and stubs added on the
+ * admin API are checked against the schema of the Stub type first, walking
+ * the JSON alongside the struct's json tags, so each problem is reported
+ * with where it is: the file, line and column, and the field's path, e.g.
+ *
+ *   stubs/users.json:4:7: [1].input.equal: unknown matcher "equal", did you mean "equals"?
+ *
+ * Unknown keys, values of the wrong JSON type and malformed JSON are
+ * reported. Data under equals, contains, matches and output data is free
+ * form and isn't checked.
+ */
+
+const (
+	VALIDATION_OFF    = "off"
+	VALIDATION_WARN   = "warn"
+	VALIDATION_REJECT = "reject"
+)
+
+// what to do with stubs that don't match the schema, see
+// Options.StubValidation
+var stubValidation string
+
+// Most problems reported for one document
+const SCHEMA_MAX_ERRORS = 20
+
+type SchemaError struct {
+	// stub file, empty for an admin request
+	File string `json:"file,omitempty"`
+	// position of the offending key or value, from 1, or 0 if it isn't
+	// known
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	// index of the stub in a file or request holding an array of them, or
+	// -1 if it isn't in a particular stub
+	Stub int `json:"stub"`
+	// path of the field, e.g. "[1].output.code"
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e SchemaError) Error() string {
+	where := fmt.Sprintf("line %d column %d", e.Line, e.Column)
+	switch {
+	case e.File != "" && e.Line == 0:
+		where = e.File
+	case e.File != "":
+		where = fmt.Sprintf("%s:%d:%d", e.File, e.Line, e.Column)
+	}
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", where, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", where, e.Field, e.Message)
+}
+
+// Schema problems of a document, joined one per line
+type SchemaErrors []SchemaError
+
+func (errs SchemaErrors) Error() string {
+	msgs := []string{}
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// The stubs, by index, with problems; -1 if the whole document has
+func (errs SchemaErrors) stubs() map[int]bool {
+	bad := map[int]bool{}
+	for _, e := range errs {
+		bad[e.Stub] = true
+	}
+	return bad
+}
+
+var (
+	stubType  = reflect.TypeOf(Stub{})
+	inputType = reflect.TypeOf(Input{})
+
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// Check a stub document, a single stub or an array of them, against the
+// schema. file is only used in the errors.
+func validateSchema(file string, byt []byte) SchemaErrors {
+	w := &schemaWalker{byt: byt, dec: json.NewDecoder(bytes.NewReader(byt)), file: file, stub: -1}
+	w.dec.UseNumber()
+	trimmed := bytes.TrimSpace(byt)
+	root := stubType
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		root = reflect.SliceOf(stubType)
+	}
+	err := w.value(root, "")
+	if err == nil {
+		if _, err = w.dec.Token(); err == io.EOF {
+			err = nil
+		} else if err == nil {
+			err = fmt.Errorf("unexpected data after the stub")
+		}
+	}
+	if err != nil && !errors.Is(err, errTooManySchemaErrors) {
+		w.syntaxError(err)
+	}
+	return w.errs
+}
+
+var errTooManySchemaErrors = errors.New("too many schema errors")
+
+// The schema problems of a stub document, unless validation is off
+func schemaProblems(file string, byt []byte) SchemaErrors {
+	if stubValidation == "" || stubValidation == VALIDATION_OFF {
+		return nil
+	}
+	return validateSchema(file, byt)
+}
+
+// Check an imported stub document: return its problems to reject it, or log
+// them as a warning
+func checkImportSchema(file string, byt []byte) error {
+	errs := schemaProblems(file, byt)
+	if len(errs) == 0 {
+		return nil
+	}
+	if stubValidation == VALIDATION_REJECT {
+		return errs
+	}
+	log.Printf("Warning: %v", errs)
+	return nil
+}
+
+// Check a stub JSON request body: answer 400 with its problems and return
+// false to reject it, or warn in the log and X-Gripmock-Warning
+func checkRequestSchema(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	if encoding, _ := mediaEncoding(r.Header.Get("Content-Type")); encoding != ENCODING_JSON {
+		return true
+	}
+	errs := schemaProblems("", body)
+	if len(errs) == 0 {
+		return true
+	}
+	if stubValidation == VALIDATION_WARN {
+		log.Printf("Warning: %v", errs)
+		w.Header().Set("X-Gripmock-Warning", strings.ReplaceAll(errs.Error(), "\n", "; "))
+		return true
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(errs.Error()))
+	return false
+}
+
+type schemaWalker struct {
+	byt  []byte
+	dec  *json.Decoder
+	file string
+	// index of the stub being walked in an array of them
+	stub int
+	errs SchemaErrors
+}
+
+// The offset of the next token
+func (w *schemaWalker) next() int64 {
+	off := w.dec.InputOffset()
+	for off < int64(len(w.byt)) && strings.IndexByte(" \t\r\n:,", w.byt[off]) >= 0 {
+		off++
+	}
+	return off
+}
+
+func (w *schemaWalker) report(off int64, field, msg string) error {
+	line, col := 1, 1
+	for _, c := range w.byt[:off] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	w.errs = append(w.errs, SchemaError{File: w.file, Line: line, Column: col, Stub: w.stub, Field: field, Message: msg})
+	if len(w.errs) >= SCHEMA_MAX_ERRORS {
+		return errTooManySchemaErrors
+	}
+	return nil
+}
+
+// Report malformed JSON where the decoder found it
+func (w *schemaWalker) syntaxError(err error) {
+	off := w.dec.InputOffset()
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) && syntax.Offset < int64(len(w.byt)) {
+		// the offset is after the offending character
+		off = syntax.Offset - 1
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("unexpected end of JSON input")
+	}
+	if off > int64(len(w.byt)) {
+		off = int64(len(w.byt))
+	}
+	w.stub = -1
+	w.report(off, "", err.Error())
+}
+
+// Walk a value that should decode into t, reporting what doesn't fit
+func (w *schemaWalker) value(t reflect.Type, field string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	off := w.next()
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		// null leaves any field unset
+		return nil
+	}
+	got := jsonKind(tok)
+	free := t.Kind() == reflect.Interface || reflect.PtrTo(t).Implements(unmarshalerType)
+
+	switch {
+	case got == "an object" && !free && (t.Kind() == reflect.Struct || t.Kind() == reflect.Map):
+		return w.object(t, field)
+	case got == "an array" && !free && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for i := 0; w.dec.More(); i++ {
+			if field == "" && t.Elem() == stubType {
+				w.stub = i
+			}
+			if err := w.value(t.Elem(), fmt.Sprintf("%s[%d]", field, i)); err != nil {
+				return err
+			}
+		}
+		_, err := w.dec.Token()
+		return err
+	}
+
+	if !free {
+		if want := expectedKind(t); want != got {
+			if err := w.report(off, strings.TrimPrefix(field, "."), fmt.Sprintf("expected %s, got %s", want, got)); err != nil {
+				return err
+			}
+		}
+	}
+	if got == "an object" || got == "an array" {
+		return w.skip()
+	}
+	return nil
+}
+
+// Walk the keys of an object, having read its opening brace
+func (w *schemaWalker) object(t reflect.Type, field string) error {
+	fields := map[string]reflect.Type{}
+	if t.Kind() == reflect.Struct {
+		fields = jsonFields(t)
+	}
+	for w.dec.More() {
+		off := w.next()
+		tok, err := w.dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		path := strings.TrimPrefix(field+"."+key, ".")
+		if t.Kind() == reflect.Map {
+			if err := w.value(t.Elem(), field+"."+key); err != nil {
+				return err
+			}
+			continue
+		}
+		ft, ok := fields[key]
+		if !ok {
+			if err := w.report(off, path, unknownKey(t, key, fields)); err != nil {
+				return err
+			}
+			if err := w.skipValue(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.value(ft, field+"."+key); err != nil {
+			return err
+		}
+	}
+	_, err := w.dec.Token()
+	return err
+}
+
+// Skip the rest of an object or array, having read its opening delimiter
+func (w *schemaWalker) skip() error {
+	for depth := 1; depth > 0; {
+		tok, err := w.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// Skip a whole value
+func (w *schemaWalker) skipValue() error {
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == json.Delim('{') || tok == json.Delim('[') {
+		return w.skip()
+	}
+	return nil
+}
+
+// The fields of a struct by their JSON keys
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func unknownKey(t reflect.Type, key string, fields map[string]reflect.Type) string {
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msg := fmt.Sprintf("unknown field %q", key)
+	if t == inputType {
+		msg = fmt.Sprintf("unknown matcher %q", key)
+	}
+	best, bestDistance := "", 3
+	for _, name := range names {
+		if d := fuzzy.LevenshteinDistance(strings.ToLower(key), name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("%s, did you mean %q?", msg, best)
+	}
+	return fmt.Sprintf("%s, must be one of %s", msg, strings.Join(names, ", "))
+}
+
+// How a JSON token reads in an error
+func jsonKind(tok json.Token) string {
+	switch tok.(type) {
+	case json.Delim:
+		if tok == json.Delim('{') {
+			return "an object"
+		}
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
+
+// The JSON a Go type decodes from, as jsonKind has it
+func expectedKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	}
+	return "a number"
+}
@@ -0,0 +1,117 @@
+package stub
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		errs []string
+	}{
+		{
+			name: "valid",
+			doc:  `{"service":"Greeter","method":"SayHello","input":{"equals":{"anything":[1,{"goes":true}]}},"output":{"data":{"message":"hi"},"code":"NOT_FOUND","trailers":{"k":"v"}}}`,
+		},
+		{
+			name: "misspelt matcher",
+			doc: `{
+  "service": "Greeter",
+  "method": "SayHello",
+  "input": {"equal": {"name": "bob"}},
+  "output": {"data": {}}
+}`,
+			errs: []string{`stubs.json:4:13: input.equal: unknown matcher "equal", did you mean "equals"?`},
+		},
+		{
+			name: "unknown keys and wrong types",
+			doc: `[
+  {"service": "Greeter", "method": "SayHello", "input": {"contains": {}}, "output": {"data": {}}},
+  {"service": "Greeter", "method": 42, "input": {"contains": {}},
+   "output": {"data": {}, "delay": 5, "trailers": {"n": 1}, "bogus": {"x": 1}}}
+]`,
+			errs: []string{
+				`stubs.json:3:36: [1].method: expected a string, got a number`,
+				`stubs.json:4:36: [1].output.delay: expected a string, got a number`,
+				`stubs.json:4:57: [1].output.trailers.n: expected a string, got a number`,
+				`stubs.json:4:61: [1].output.bogus: unknown field "bogus", must be one of code, compression, data, delay, early_headers, error, exceed_deadline, half_close, headers, push, raw, repeat, retry_delay, script, send_rate, stream, throttle, trailers, trailers_only, transform`,
+			},
+		},
+		{
+			name: "malformed",
+			doc:  "{\"service\": \"Greeter\",\n \"method\" \"SayHello\"}",
+			errs: []string{`stubs.json:2:11: invalid character '"' after object key`},
+		},
+		{
+			name: "truncated",
+			doc:  `{"service": "Greeter", "input": {`,
+			errs: []string{`stubs.json:1:34: unexpected end of JSON input`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := []string{}
+			for _, e := range validateSchema("stubs.json", []byte(tt.doc)) {
+				errs = append(errs, e.Error())
+			}
+			if tt.errs == nil {
+				tt.errs = []string{}
+			}
+			assert.Equal(t, tt.errs, errs)
+		})
+	}
+
+	errs := validateSchema("", []byte(`[{"service":"a","method":"b","input":{"x":1}},{"service":"a","method":"b","output":{"y":1}}]`))
+	assert.Equal(t, map[int]bool{0: true, 1: true}, errs.stubs())
+	assert.Equal(t, `line 1 column 39: [0].input.x: unknown matcher "x", must be one of contains, deadline, equals, matches, stream, wasm`, errs[0].Error())
+}
+
+func TestStubValidation(t *testing.T) {
+	defer func() {
+		stubValidation = ""
+		clearStorage()
+	}()
+	clearStorage()
+	bad := `{"service":"Greeter","method":"SayHello","input":{"equal":{"name":"bob"}},"output":{"data":{}}}`
+
+	add := func(body string) *httptest.ResponseRecorder {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(body))))
+		return wrt
+	}
+	stubValidation = VALIDATION_REJECT
+	wrt := add(bad)
+	assert.Equal(t, http.StatusBadRequest, wrt.Code)
+	assert.Equal(t, `line 1 column 51: input.equal: unknown matcher "equal", did you mean "equals"?`, wrt.Body.String())
+	assert.Empty(t, listStubs())
+
+	// a warning still adds the stub, with the misspelt delay ignored
+	stubValidation = VALIDATION_WARN
+	wrt = add(`{"service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{},"dealy":"1s"}}`)
+	assert.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+	assert.Equal(t, `line 1 column 86: output.dealy: unknown field "dealy", did you mean "delay"?`, wrt.Header().Get("X-Gripmock-Warning"))
+
+	// only the stubs at fault are skipped when loading a file
+	dir := t.TempDir()
+	file := filepath.Join(dir, "stubs.json")
+	require.NoError(t, os.WriteFile(file, []byte(`[`+bad+`,{"service":"Greeter","method":"SayBye","input":{"contains":{}},"output":{"data":{}}}]`), 0644))
+	stubValidation = VALIDATION_REJECT
+	sm := stubMapping{}
+	sm.readStubFromFile(dir)
+	assert.Empty(t, sm["Greeter"]["SayHello"])
+	assert.Len(t, sm["Greeter"]["SayBye"], 1)
+
+	stubValidation = VALIDATION_WARN
+	sm = stubMapping{}
+	sm.readStubFromFile(dir)
+	assert.Len(t, sm["Greeter"]["SayHello"], 1)
+}
@@ -0,0 +1,362 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+/*
+ * Starlark response scripts.
+ *
+ * A stub output can carry a "script" that computes the response from the
+ * call, for logic beyond what static data can express. The script runs in a
+ * Starlark interpreter (a Python dialect, see
+ * https://github.com/bazelbuild/starlark) with these predeclared names:
+ *
+ *   - request: the call's message, fields readable as request.name or
+ *     request["name"]
+ *   - headers: the call's metadata, a dict of strings
+ *   - stream: every message a client-streaming or bidirectional call has
+ *     received so far, or None
+ *   - service, method: the called method's names
+ *   - response: the output's data, fields writable as response.name = value
+ *   - sum(items, key=None): add up numbers, or each item's key field
+ *   - status(code, message): fail the call with a gRPC status code, by
+ *     number or name
+ *
+ * Each invocation gets a fresh interpreter limited in execution steps and
+ * wall clock time. The request it's given and the response it produces are
+ * limited in size too; the values it builds along the way aren't measured,
+ * the interpreter has no allocation accounting, beyond Starlark's own refusal
+ * to repeat a string or list past 1GiB.
+ */
+
+const (
+	// execution step limit of one script invocation
+	SCRIPT_MAX_STEPS = 1000000
+	// time limit of one script invocation
+	SCRIPT_TIMEOUT = time.Second
+	// size limit, roughly in bytes, of the request and stream a script is
+	// given and of the response it produces
+	SCRIPT_MAX_SIZE = 4 << 20
+)
+
+var scriptNames = map[string]bool{
+	"request": true, "headers": true, "stream": true, "service": true,
+	"method": true, "response": true, "sum": true, "status": true,
+}
+
+func isScriptName(name string) bool {
+	return scriptNames[name]
+}
+
+// Check a script compiles and only uses names it will have
+func validateScript(src string) error {
+	if src == "" {
+		return nil
+	}
+	if _, _, err := starlark.SourceProgram("script", src, isScriptName); err != nil {
+		return fmt.Errorf("Output script doesn't compile: %v", err)
+	}
+	return nil
+}
+
+// Run the output's script on a call, returning the output with the data it
+// computed
+func runScript(call *findStubPayload, output Output) (Output, error) {
+	_, prog, err := starlark.SourceProgram("script", output.Script, isScriptName)
+	if err != nil {
+		return Output{}, fmt.Errorf("Output script doesn't compile: %v", err)
+	}
+
+	data := output.Data
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	response, err := toStarlark(data)
+	if err != nil {
+		return Output{}, err
+	}
+	input := call.Data
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+	request, err := toStarlark(input)
+	if err != nil {
+		return Output{}, err
+	}
+	request.Freeze()
+	headers := starlark.NewDict(len(call.Headers))
+	for k, v := range call.Headers {
+		headers.SetKey(starlark.String(k), starlark.String(v))
+	}
+	headers.Freeze()
+	var stream starlark.Value = starlark.None
+	if call.Stream != nil {
+		msgs := []starlark.Value{}
+		for _, msg := range call.Stream {
+			v, err := toStarlark(msg)
+			if err != nil {
+				return Output{}, err
+			}
+			msgs = append(msgs, v)
+		}
+		list := starlark.NewList(msgs)
+		list.Freeze()
+		stream = list
+	}
+	if scriptSize(request)+scriptSize(stream) > SCRIPT_MAX_SIZE {
+		return Output{}, fmt.Errorf("Output script request exceeds the size limit of %d bytes", SCRIPT_MAX_SIZE)
+	}
+
+	status := starlark.NewBuiltin("status", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var code starlark.Value
+		var message string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "code", &code, "message?", &message); err != nil {
+			return nil, err
+		}
+		var raw []byte
+		switch c := code.(type) {
+		case starlark.Int:
+			raw = []byte(c.String())
+		case starlark.String:
+			raw, _ = json.Marshal(string(c))
+		default:
+			return nil, fmt.Errorf("status: code must be an int or a name, not %s", code.Type())
+		}
+		if err := output.Code.UnmarshalJSON(raw); err != nil {
+			return nil, fmt.Errorf("status: %v", err)
+		}
+		if !output.Code.valid() {
+			return nil, fmt.Errorf("status: %d is not a valid gRPC status code", output.Code)
+		}
+		output.Error = message
+		return starlark.None, nil
+	})
+
+	thread := &starlark.Thread{Name: "script"}
+	thread.SetMaxExecutionSteps(SCRIPT_MAX_STEPS)
+	stop := limitScript(thread)
+	_, err = prog.Init(thread, starlark.StringDict{
+		"request":  request,
+		"headers":  headers,
+		"stream":   stream,
+		"service":  starlark.String(call.Service),
+		"method":   starlark.String(call.Method),
+		"response": response,
+		"sum":      starlark.NewBuiltin("sum", scriptSum),
+		"status":   status,
+	})
+	stop()
+	if err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			return Output{}, fmt.Errorf("Output script failed: %s", evalErr.Backtrace())
+		}
+		return Output{}, fmt.Errorf("Output script failed: %v", err)
+	}
+	if scriptSize(response)+len(output.Error) > SCRIPT_MAX_SIZE {
+		return Output{}, fmt.Errorf("Output script response exceeds the size limit of %d bytes", SCRIPT_MAX_SIZE)
+	}
+
+	result, err := fromStarlark(response)
+	if err != nil {
+		return Output{}, fmt.Errorf("Output script response: %v", err)
+	}
+	output.Data = result.(map[string]interface{})
+	output.Script = ""
+	return output, nil
+}
+
+// Cancel the thread once it runs out of time. The returned func stops
+// watching.
+func limitScript(thread *starlark.Thread) func() {
+	timeout := time.AfterFunc(SCRIPT_TIMEOUT, func() {
+		thread.Cancel(fmt.Sprintf("time limit of %s exceeded", SCRIPT_TIMEOUT))
+	})
+	return func() { timeout.Stop() }
+}
+
+// The approximate size in bytes of a value, counted only until it passes
+// SCRIPT_MAX_SIZE, so a value that contains itself doesn't walk forever
+func scriptSize(v starlark.Value) int {
+	size := 0
+	var walk func(v starlark.Value)
+	walk = func(v starlark.Value) {
+		if size > SCRIPT_MAX_SIZE {
+			return
+		}
+		size += 16
+		switch v := v.(type) {
+		case starlark.String:
+			size += len(v)
+		case scriptObject:
+			walk(v.Dict)
+		case *starlark.Dict:
+			for _, item := range v.Items() {
+				walk(item[0])
+				walk(item[1])
+			}
+		case starlark.Indexable:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		}
+	}
+	walk(v)
+	return size
+}
+
+// sum(items, key=None): the total of a list of numbers, or of each item's
+// key field
+func scriptSum(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var items starlark.Iterable
+	var key starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "items", &items, "key?", &key); err != nil {
+		return nil, err
+	}
+
+	var total starlark.Value = starlark.MakeInt(0)
+	iter := items.Iterate()
+	defer iter.Done()
+	var item starlark.Value
+	for iter.Next(&item) {
+		if key != starlark.None {
+			mapping, ok := item.(starlark.Mapping)
+			if !ok {
+				return nil, fmt.Errorf("sum: can't get %s of %s", key, item.Type())
+			}
+			v, found, err := mapping.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+			item = v
+		}
+		var err error
+		total, err = starlark.Binary(syntax.PLUS, total, item)
+		if err != nil {
+			return nil, fmt.Errorf("sum: %v", err)
+		}
+	}
+	return total, nil
+}
+
+// A JSON object, a dict whose string keys can also be read and written as
+// fields
+type scriptObject struct {
+	*starlark.Dict
+}
+
+var (
+	_ starlark.HasAttrs    = scriptObject{}
+	_ starlark.HasSetField = scriptObject{}
+)
+
+func (o scriptObject) Type() string { return "object" }
+
+func (o scriptObject) Attr(name string) (starlark.Value, error) {
+	if v, found, _ := o.Dict.Get(starlark.String(name)); found {
+		return v, nil
+	}
+	return o.Dict.Attr(name)
+}
+
+func (o scriptObject) SetField(name string, v starlark.Value) error {
+	return o.Dict.SetKey(starlark.String(name), v)
+}
+
+// Convert a decoded JSON value to Starlark. Whole numbers become ints.
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v)), nil
+		}
+		return starlark.Float(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []interface{}:
+		items := make([]starlark.Value, 0, len(v))
+		for _, item := range v {
+			sv, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, sv)
+		}
+		return starlark.NewList(items), nil
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(v))
+		for k, item := range v {
+			sv, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			dict.SetKey(starlark.String(k), sv)
+		}
+		return scriptObject{dict}, nil
+	}
+	return nil, fmt.Errorf("can't convert %T to a script value", v)
+}
+
+// Convert a Starlark value back to what encoding/json would decode
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return float64(i), nil
+		}
+		return float64(v.Float()), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case scriptObject:
+		return fromStarlarkDict(v.Dict)
+	case *starlark.Dict:
+		return fromStarlarkDict(v)
+	case starlark.Indexable:
+		// lists and tuples
+		items := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := fromStarlark(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("can't convert %s to JSON", v.Type())
+}
+
+func fromStarlarkDict(dict *starlark.Dict) (interface{}, error) {
+	out := make(map[string]interface{}, dict.Len())
+	for _, item := range dict.Items() {
+		k, ok := item[0].(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("object keys must be strings, not %s", item[0].Type())
+		}
+		v, err := fromStarlark(item[1])
+		if err != nil {
+			return nil, err
+		}
+		out[string(k)] = v
+	}
+	return out, nil
+}
@@ -0,0 +1,55 @@
+package stub
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptOutput(t *testing.T) {
+	defer clearStorage()
+
+	add := func(payload string) string {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		return wrt.Body.String()
+	}
+	find := func(payload string) string {
+		wrt := httptest.NewRecorder()
+		handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload))))
+		return wrt.Body.String()
+	}
+
+	assert.Contains(t, add(`{"service":"S","method":"M","input":{"equals":{}},"output":{"script":"response.x = undefined_name"}}`),
+		"Output script doesn't compile: script:1:14: undefined: undefined_name")
+	assert.Equal(t, "Output script computes data, it can't be used with stream",
+		add(`{"service":"S","method":"M","input":{"equals":{}},"output":{"stream":[{}],"script":"pass"}}`))
+
+	assert.Equal(t, "Success add stub",
+		add(`{"service":"S","method":"Total","input":{"contains":{}},"output":{"data":{"currency":"EUR"},"script":"response.total = sum(request.items, 'price')\nresponse.count = len(request['items'])"}}`))
+	assert.Equal(t, "Success add stub",
+		add(`{"service":"S","method":"Fail","input":{"equals":{"id":"x"}},"output":{"script":"status('NOT_FOUND', 'no ' + request.id + ' for ' + headers['user'])"}}`))
+	assert.Equal(t, "Success add stub",
+		add(`{"service":"S","method":"Loop","input":{"equals":{}},"output":{"script":"def f():\n  for i in range(100000000):\n    pass\nf()"}}`))
+
+	assert.JSONEq(t, `{"data":{"currency":"EUR","total":12.5,"count":2},"error":""}`,
+		find(`{"service":"S","method":"Total","data":{"items":[{"price":10},{"price":2.5}]}}`))
+	assert.JSONEq(t, `{"data":{},"error":"no x for alice","code":5}`,
+		find(`{"service":"S","method":"Fail","data":{"id":"x"},"headers":{"user":"alice"}}`))
+	assert.Contains(t, find(`{"service":"S","method":"Loop","data":{}}`), "too many steps")
+
+	assert.Equal(t, "Success add stub",
+		add(`{"service":"S","method":"Big","input":{"contains":{}},"output":{"script":"response.big = 'x' * request.n"}}`))
+	assert.Equal(t, "Success add stub",
+		add(`{"service":"S","method":"Cycle","input":{"equals":{}},"output":{"script":"d = {}\nd['d'] = d\nresponse.d = d"}}`))
+	assert.Contains(t, find(`{"service":"S","method":"Big","data":{"n":1000}}`), `"big":"xxx`)
+	assert.Contains(t, find(`{"service":"S","method":"Big","data":{"n":5000000}}`),
+		"Output script response exceeds the size limit of 4194304 bytes")
+	assert.Contains(t, find(`{"service":"S","method":"Big","data":{"n":1,"pad":"`+strings.Repeat("x", SCRIPT_MAX_SIZE)+`"}}`),
+		"Output script request exceeds the size limit of 4194304 bytes")
+	assert.Contains(t, find(`{"service":"S","method":"Cycle","data":{}}`),
+		"Output script response exceeds the size limit of 4194304 bytes")
+}
@@ -0,0 +1,129 @@
+package stub
+
+import (
+	"net/http"
+	"sync"
+)
+
+/*
+ * Test sessions.
+ *
+ * With a session key (Options.SessionKey), parallel test runs can share one
+ * gripmock without seeing each other's stubs or calls. Each run picks a
+ * session ID and sends it as the key's gRPC metadata on its calls, and as
+ * the HTTP header of the same name (or ?session=) on its admin requests.
+ *
+ * Stubs added in a session only match calls in that session, ahead of the
+ * shared stubs added without one. Calls are recorded in their session's own
+ * history, so verification only counts them there, and the journal and
+ * resets can be limited to one session.
+ */
+
+// gRPC metadata key and admin HTTP header carrying the session ID, see
+// Options.SessionKey
+var sessionKey string
+
+// Call histories of sessions, by ID; calls without a session are in calls
+var (
+	sessionCallsMx sync.Mutex
+	sessionCalls   = map[string]*callHistory{}
+)
+
+// The session of a call, from its metadata
+func callSession(headers map[string]string) string {
+	if sessionKey == "" {
+		return ""
+	}
+	return headers[sessionKey]
+}
+
+// The session of an admin request, from its header or ?session=
+func requestSession(r *http.Request) string {
+	if sessionKey == "" {
+		return ""
+	}
+	if s := r.Header.Get(sessionKey); s != "" {
+		return s
+	}
+	return r.URL.Query().Get("session")
+}
+
+// The sessions to look for a call's stub in, in order: its own, then the
+// shared stubs
+func callSessions(headers map[string]string) []string {
+	if s := callSession(headers); s != "" {
+		return []string{s, ""}
+	}
+	return []string{""}
+}
+
+// The call history of a session
+func callsFor(session string) *callHistory {
+	if session == "" {
+		return calls
+	}
+	sessionCallsMx.Lock()
+	defer sessionCallsMx.Unlock()
+	h, ok := sessionCalls[session]
+	if !ok {
+		h = newCallHistory()
+		sessionCalls[session] = h
+	}
+	return h
+}
+
+// Remove the stubs added in a session, or every stub if session is empty
+func clearStubs(session string) {
+	if session == "" {
+		clearStorage()
+		return
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	defer stubsChanged()
+	forgetHits(stubStorage.session(session))
+	stubStorage.removeSession(session)
+	publishStubs()
+}
+
+// Remove the stubs of a session. Must be called with mx held.
+func (sm stubMapping) removeSession(session string) {
+	for service, methods := range sm {
+		for method, stubs := range methods {
+			kept := []storage{}
+			for _, s := range stubs {
+				if s.Session != session {
+					kept = append(kept, s)
+				}
+			}
+			sm[service][method] = kept
+		}
+	}
+}
+
+// Forget the calls recorded in a session
+func resetSessionJournal(session string) {
+	journal.removeSession(session)
+	sessionCallsMx.Lock()
+	defer sessionCallsMx.Unlock()
+	delete(sessionCalls, session)
+}
+
+// The stubs added in a session
+func (sm stubMapping) session(session string) stubMapping {
+	found := stubMapping{}
+	for service, methods := range sm {
+		for method, stubs := range methods {
+			for _, s := range stubs {
+				if s.Session != session {
+					continue
+				}
+				if found[service] == nil {
+					found[service] = map[string][]storage{}
+				}
+				found[service][method] = append(found[service][method], s)
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,84 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	sessionKey = "x-gripmock-session"
+	defer func() { sessionKey = "" }()
+	defer clearStorage()
+	defer resetJournal()
+	resetJournal()
+
+	add := func(session, payload string) {
+		wrt := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload)))
+		if session != "" {
+			req.Header.Set("X-Gripmock-Session", session)
+		}
+		addStub(wrt, req)
+		require.Equal(t, "Success add stub", wrt.Body.String())
+	}
+	add("", `{"id":"shared","service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{"message":"shared"}}}`)
+	add("a", `{"id":"a","service":"Greeter","method":"SayHello","input":{"equals":{"name":"bob"}},"output":{"data":{"message":"session a"}}}`)
+	add("", `{"id":"b","session":"b","service":"Greeter","method":"SayHello","input":{"equals":{"name":"bob"}},"output":{"data":{"message":"session b"}}}`)
+
+	find := func(session, name string) string {
+		wrt := httptest.NewRecorder()
+		handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(
+			`{"service":"Greeter","method":"SayHello","data":{"name":"`+name+`"},"headers":{"x-gripmock-session":"`+session+`"}}`))))
+		require.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+		out := Output{}
+		require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &out))
+		return out.Data["message"].(string)
+	}
+	assert.Equal(t, "session a", find("a", "bob"))
+	assert.Equal(t, "session b", find("b", "bob"))
+	assert.Equal(t, "shared", find("a", "eve"))
+	assert.Equal(t, "shared", find("", "bob"))
+	assert.Equal(t, "shared", find("c", "bob"))
+
+	inSession := func(method, target, session string) *http.Request {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-Gripmock-Session", session)
+		return req
+	}
+	counts := func(session string) callCounts {
+		wrt := httptest.NewRecorder()
+		listCallCounts(wrt, inSession("GET", "/verify/counts", session))
+		c := callCounts{}
+		require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &c))
+		return c
+	}
+	assert.Equal(t, map[string]int{"a": 1, "shared": 1}, counts("a").Stubs)
+	assert.Equal(t, map[string]int{"b": 1}, counts("b").Stubs)
+	assert.Equal(t, map[string]int{"shared": 1}, counts("").Stubs)
+
+	wrt := httptest.NewRecorder()
+	listJournal(wrt, httptest.NewRequest("GET", "/journal?session=a", nil))
+	entries := []JournalEntry{}
+	require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &entries))
+	assert.Len(t, entries, 2)
+
+	// a session's resets leave the others alone
+	handleReset(httptest.NewRecorder(), inSession("POST", "/reset", "a"))
+	assert.Equal(t, "shared", find("a", "bob"))
+	assert.Equal(t, "session b", find("b", "bob"))
+	assert.Equal(t, map[string]int{"shared": 1}, counts("a").Stubs)
+	assert.Equal(t, map[string]int{"b": 2}, counts("b").Stubs)
+
+	wrt = httptest.NewRecorder()
+	listStub(wrt, inSession("GET", "/", "b"))
+	listed := stubMapping{}
+	require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &listed))
+	require.Len(t, listed["Greeter"]["SayHello"], 1)
+	assert.Equal(t, "b", listed["Greeter"]["SayHello"][0].ID)
+}
@@ -0,0 +1,58 @@
+package stub
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ * Copy-on-write stub store.
+ *
+ * Every call looks up its stub and counts the hit, so with one lock around
+ * the stubs, load tests at high QPS queued on it. Now stubStorage is only
+ * the writers' copy, guarded by mx, and each change to it publishes a
+ * snapshot that calls read without locking. A snapshot has its own maps of
+ * services and methods but shares the stubs' slices with stubStorage. The
+ * writers never change a stub in a slice once it's published, they only
+ * append to a slice or replace it, so snapshots don't see later changes,
+ * and publishing costs little however many stubs there are.
+ *
+ * Hit statistics are kept apart from the stubs, see hits.go.
+ */
+
+type stubSnapshot struct {
+	stubs     stubMapping
+	indexOnce sync.Once
+	index     *storeIndex
+}
+
+var publishedStubs atomic.Pointer[stubSnapshot]
+
+// Publish the stubs after changing stubStorage. Must be called with mx held.
+func publishStubs() {
+	snap := &stubSnapshot{stubs: make(stubMapping, len(stubStorage))}
+	for service, methods := range stubStorage {
+		snap.stubs[service] = make(map[string][]storage, len(methods))
+		for method, stubs := range methods {
+			// appending to the snapshot can't write to stubStorage's slice
+			snap.stubs[service][method] = stubs[:len(stubs):len(stubs)]
+		}
+	}
+	publishedStubs.Store(snap)
+}
+
+// The latest published stubs, which mustn't be changed
+func currentStubs() *stubSnapshot {
+	if snap := publishedStubs.Load(); snap != nil {
+		return snap
+	}
+	return &stubSnapshot{stubs: stubMapping{}}
+}
+
+// The snapshot's index, building it if need be
+func (s *stubSnapshot) indexed() *storeIndex {
+	s.indexOnce.Do(func() {
+		s.index = buildIndex(s.stubs)
+	})
+	return s.index
+}
@@ -0,0 +1,117 @@
+package stub
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotUnchangedByWrites(t *testing.T) {
+	defer clearStorage()
+
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, storeStub(&Stub{ID: id, Service: "Snap", Method: "Get",
+			Input: Input{Equals: map[string]interface{}{"id": id}}}))
+	}
+	snap := currentStubs()
+
+	require.NoError(t, updateStub(&Stub{ID: "b", Service: "Snap", Method: "Get",
+		Input: Input{Equals: map[string]interface{}{"id": "changed"}}}))
+	require.NoError(t, deleteStub("a"))
+	require.NoError(t, storeStub(&Stub{ID: "d", Service: "Snap", Method: "Get",
+		Input: Input{Equals: map[string]interface{}{"id": "d"}}}))
+
+	old := snap.stubs["Snap"]["Get"]
+	require.Len(t, old, 3)
+	assert.Equal(t, "a", old[0].ID)
+	assert.Equal(t, "b", old[1].Input.Equals["id"])
+
+	current := currentStubs().stubs["Snap"]["Get"]
+	require.Len(t, current, 3)
+	assert.Equal(t, []string{"b", "c", "d"}, []string{current[0].ID, current[1].ID, current[2].ID})
+	assert.Equal(t, "changed", current[0].Input.Equals["id"])
+}
+
+// Run with -race to check calls and writers don't share anything unlocked
+func TestConcurrentLookupsAndWrites(t *testing.T) {
+	defer clearStorage()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, storeStub(&Stub{ID: fmt.Sprint("s", i), Service: "Snap", Method: "Get",
+			Input:  Input{Equals: map[string]interface{}{"id": float64(i)}},
+			Output: Output{Data: map[string]interface{}{}}}))
+	}
+	wg := sync.WaitGroup{}
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				match, err := findStub(&findStubPayload{Service: "Snap", Method: "Get",
+					Data: map[string]interface{}{"id": float64(i % 50)}})
+				if assert.NoError(t, err) {
+					recordHit(match.ID)
+				}
+			}
+		}()
+	}
+	for i := 50; i < 100; i++ {
+		require.NoError(t, updateStub(&Stub{ID: fmt.Sprint("s", i), Service: "Snap", Method: "Get",
+			Input: Input{Contains: map[string]interface{}{"id": float64(i)}}}))
+		require.NoError(t, storeStub(&Stub{Service: "Snap", Method: "Get",
+			Input: Input{Equals: map[string]interface{}{"id": float64(i)}}}))
+		_ = allStub()
+	}
+	wg.Wait()
+
+	hits := int64(0)
+	for i := 0; i < 50; i++ {
+		n, _ := hitsFor(fmt.Sprint("s", i))
+		hits += n
+	}
+	assert.Equal(t, int64(4*500), hits)
+}
+
+func benchmarkStubs(b *testing.B) {
+	for i := 0; i < 10000; i++ {
+		require.NoError(b, storeStub(&Stub{Service: "Bench", Method: "Get",
+			Input:  Input{Equals: map[string]interface{}{"id": float64(i), "name": fmt.Sprint("user", i)}},
+			Output: Output{Data: map[string]interface{}{"n": float64(i)}}}))
+	}
+	// built by the first call rather than timed
+	currentStubs().indexed()
+}
+
+func benchmarkCall(i int) {
+	match, err := findStub(&findStubPayload{Service: "Bench", Method: "Get",
+		Data: map[string]interface{}{"id": float64(i % 10000), "name": fmt.Sprint("user", i%10000)}})
+	if err != nil {
+		panic(err)
+	}
+	recordHit(match.ID)
+}
+
+func BenchmarkFindStub(b *testing.B) {
+	defer clearStorage()
+	benchmarkStubs(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchmarkCall(i)
+	}
+}
+
+func BenchmarkFindStubParallel(b *testing.B) {
+	defer clearStorage()
+	benchmarkStubs(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			benchmarkCall(i)
+			i++
+		}
+	})
+}
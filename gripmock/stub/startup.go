@@ -0,0 +1,104 @@
+package stub
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Startup timing.
+ *
+ * gripmock reports how long each phase of its startup took as it finishes
+ * it: rewriting the protos' go packages, protoc, resolving the generated
+ * module's dependencies and go build, or loading the services with
+ * -dynamic. Once the gRPC server first reports serving, the time it took to
+ * boot is added, and the breakdown is logged and served as "startup" on
+ * /state, to tell where the time of a slow startup went. A build reused
+ * from the build cache leaves out the phases it skipped. Reloads and
+ * restarts after that aren't counted.
+ */
+
+const (
+	STARTUP_MUNGE  = "munge"
+	STARTUP_PROTOC = "protoc"
+	STARTUP_MODULE = "module"
+	STARTUP_BUILD  = "build"
+	STARTUP_LOAD   = "load"
+	STARTUP_BOOT   = "boot"
+)
+
+type startupPhase struct {
+	Phase string `json:"phase"`
+	// as a go duration string
+	Duration string `json:"duration"`
+}
+
+type startupTiming struct {
+	// in the order they first ran
+	Phases []startupPhase `json:"phases"`
+	// from gripmock starting until the gRPC server was serving
+	Total string `json:"total"`
+}
+
+type startupTimer struct {
+	mx        sync.Mutex
+	order     []string
+	durations map[string]time.Duration
+	// once the server is serving
+	timing *startupTiming
+}
+
+var startup = newStartupTimer()
+
+func newStartupTimer() *startupTimer {
+	return &startupTimer{durations: map[string]time.Duration{}}
+}
+
+// Record the time a startup phase took; a phase that runs more than once,
+// such as protoc, adds up
+func RecordStartupPhase(phase string, d time.Duration) {
+	startup.record(phase, d)
+}
+
+func (t *startupTimer) record(phase string, d time.Duration) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.timing != nil {
+		return
+	}
+	if _, ok := t.durations[phase]; !ok {
+		t.order = append(t.order, phase)
+	}
+	t.durations[phase] += d
+}
+
+// Finish the timing once the server is serving, the first time, and log it
+func (t *startupTimer) finish(boot, total time.Duration) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.timing != nil {
+		return
+	}
+	t.order = append(t.order, STARTUP_BOOT)
+	t.durations[STARTUP_BOOT] = boot
+	total = total.Round(time.Millisecond)
+	timing := &startupTiming{Total: total.String()}
+	fields := []string{}
+	for _, phase := range t.order {
+		d := t.durations[phase].Round(time.Millisecond)
+		timing.Phases = append(timing.Phases, startupPhase{phase, d.String()})
+		fields = append(fields, fmt.Sprintf("%s=%s", phase, d))
+	}
+	t.timing = timing
+	log.Printf("Startup took %s: %s", total, strings.Join(fields, " "))
+}
+
+// The finished timing, nil until the server is serving
+func (t *startupTimer) get() *startupTiming {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.timing
+}
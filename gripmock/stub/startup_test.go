@@ -0,0 +1,65 @@
+package stub
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartupTiming(t *testing.T) {
+	states = newLifecycle()
+	events = &eventLog{}
+	startup = newStartupTimer()
+	defer func() {
+		states = newLifecycle()
+		events = &eventLog{}
+		startup = newStartupTimer()
+	}()
+
+	get := func() lifecycleState {
+		wrt := httptest.NewRecorder()
+		getState(wrt, httptest.NewRequest("GET", "/state", nil))
+		st := lifecycleState{}
+		require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &st))
+		return st
+	}
+
+	RecordStartupPhase(STARTUP_MUNGE, 20*time.Millisecond)
+	RecordStartupPhase(STARTUP_PROTOC, time.Second)
+	RecordStartupPhase(STARTUP_PROTOC, 500*time.Millisecond)
+	RecordStartupPhase(STARTUP_BUILD, 3*time.Second)
+	SetState(STATE_STARTING)
+	assert.Nil(t, get().Startup)
+
+	SetState(STATE_SERVING)
+	timing := get().Startup
+	require.NotNil(t, timing)
+	phases := []string{}
+	for _, p := range timing.Phases {
+		phases = append(phases, p.Phase)
+	}
+	assert.Equal(t, []string{STARTUP_MUNGE, STARTUP_PROTOC, STARTUP_BUILD, STARTUP_BOOT}, phases)
+	assert.Equal(t, "1.5s", timing.Phases[1].Duration)
+	assert.NotEmpty(t, timing.Total)
+
+	// a reload after startup isn't counted
+	RecordStartupPhase(STARTUP_PROTOC, time.Second)
+	Restarting()
+	SetState(STATE_SERVING)
+	assert.Equal(t, "1.5s", get().Startup.Phases[1].Duration)
+}
+
+func TestPprof(t *testing.T) {
+	r := chi.NewRouter()
+	mountPprof(r)
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/heap?debug=1"} {
+		wrt := httptest.NewRecorder()
+		r.ServeHTTP(wrt, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, 200, wrt.Code, path)
+	}
+}
@@ -0,0 +1,86 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+/*
+ * Stubs from stdin.
+ *
+ * A -stub entry of - reads stubs from stdin at startup, so scripts can pipe
+ * generated fixtures straight in:
+ *
+ *   generate-fixtures | gripmock --stub=- hello.proto
+ *
+ * The input is a stream of stub JSON values, each a single stub or an array
+ * of them, one after another as from jq or in JSON lines; or a tar, tar.gz
+ * or zip archive of stub files as POST /import takes. It's read to the end
+ * before gripmock carries on, so the writer has to close it.
+ */
+
+// -stub entry for stdin
+const STUB_STDIN = "-"
+
+// where STUB_STDIN reads from
+var stubStdin io.Reader = os.Stdin
+
+// Load the stubs piped to stdin
+func (sm *stubMapping) readStubStdin() {
+	byt, err := ioutil.ReadAll(stubStdin)
+	if err != nil {
+		log.Printf("Error when reading stubs from stdin. %v", err)
+		return
+	}
+	trimmed := bytes.TrimSpace(byt)
+	if len(trimmed) == 0 {
+		return
+	}
+	if trimmed[0] != '{' && trimmed[0] != '[' {
+		stubs, err := parseImport(byt)
+		if err != nil {
+			log.Printf("Error when reading stubs from stdin. %v. skipping...", err)
+			return
+		}
+		sm.storeImported("stdin", stubs)
+		return
+	}
+
+	if byt, err = renderStubFile("stdin", byt); err != nil {
+		log.Printf("Error when rendering stdin. %v. skipping...", err)
+		return
+	}
+	docs, err := splitJSONStream(byt)
+	if err != nil {
+		log.Printf("Error when reading stubs from stdin. %v", err)
+	}
+	for i, doc := range docs {
+		name := "stdin"
+		if len(docs) > 1 {
+			name = fmt.Sprintf("stdin document %d", i)
+		}
+		sm.loadStubs(name, doc)
+	}
+}
+
+// The JSON values in a stream, up to the first that's malformed
+func splitJSONStream(byt []byte) ([][]byte, error) {
+	docs := [][]byte{}
+	dec := json.NewDecoder(bytes.NewReader(byt))
+	for {
+		var doc json.RawMessage
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return docs, fmt.Errorf("document %d: %v", len(docs), err)
+		}
+		docs = append(docs, doc)
+	}
+}
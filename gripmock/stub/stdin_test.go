@@ -0,0 +1,38 @@
+package stub
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStubStdin(t *testing.T) {
+	defer func() { stubStdin = os.Stdin }()
+
+	stubStdin = bytes.NewReader([]byte(`{"service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{"message":"one"}}}
+{"service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{"message":"two"}}}
+[
+  {"service":"Greeter","method":"SayBye","input":{"contains":{}},"output":{"data":{}}},
+  {"service":"Greeter","method":"SayBye","input":{"contains":{}},"output":{"data":{}}}
+]
+{"service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{}}} garbage`))
+	sm := stubMapping{}
+	sm.readStubFromFile("-")
+	hello := sm["Greeter"]["SayHello"]
+	require.Len(t, hello, 3, "up to the malformed value")
+	assert.Equal(t, "one", hello[0].Output.Data["message"])
+	assert.Equal(t, "two", hello[1].Output.Data["message"])
+	assert.Len(t, sm["Greeter"]["SayBye"], 2)
+
+	// nothing piped in is nothing to load
+	stubStdin = bytes.NewReader(nil)
+	sm = stubMapping{}
+	sm.readStubFromFile("-")
+	assert.Empty(t, sm)
+
+	_, err := FindStubFiles("-")
+	assert.EqualError(t, err, "stubs from stdin aren't files, save them first")
+}
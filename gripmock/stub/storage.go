@@ -1,13 +1,19 @@
 package stub
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"reflect"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/lithammer/fuzzysearch/fuzzy"
 )
@@ -21,34 +27,169 @@ type matchFunc func(interface{}, interface{}) bool
 
 var stubStorage = stubMapping{}
 
+// metadata key used to route calls to per-tenant stub namespaces, see
+// Options.TenantKey
+var tenantKey string
+
 type storage struct {
-	Input  Input
-	Output Output
+	ID        string
+	Namespace string `json:",omitempty"`
+	Session   string `json:",omitempty"`
+	Input     Input
+	Output    Output
+	// calls matched, and when the latest was, see hits.go
+	Hits    int64      `json:",omitempty"`
+	LastHit *time.Time `json:",omitempty"`
 }
 
 func storeStub(stub *Stub) error {
+	defer stubsChanged()
 	return stubStorage.storeStub(stub)
 }
 
+// Store a stub after any existing ones for its method. A stub without an ID
+// is given a new one, which is set on stub.
 func (sm *stubMapping) storeStub(stub *Stub) error {
 	mx.Lock()
 	defer mx.Unlock()
+	if err := sm.add(stub); err != nil {
+		return err
+	}
+	stubHits.Delete(stub.ID)
+	publishStubs()
+	return nil
+}
+
+// storeStub without taking mx, which must be held
+func (sm stubMapping) add(stub *Stub) error {
+	if stub.ID == "" {
+		id, err := newStubID()
+		if err != nil {
+			return err
+		}
+		stub.ID = id
+	} else if _, _, _, found := sm.locate(stub.ID); found {
+		return fmt.Errorf("%w: %s", errStubExists, stub.ID)
+	}
+
+	if sm[stub.Service] == nil {
+		sm[stub.Service] = make(map[string][]storage)
+	}
+	sm[stub.Service][stub.Method] = append(sm[stub.Service][stub.Method], stubStorageEntry(stub))
+	return nil
+}
+
+var (
+	errStubExists   = errors.New("A stub already exists with ID")
+	errStubNotFound = errors.New("Can't find stub with ID")
+)
+
+func stubStorageEntry(stub *Stub) storage {
+	return storage{
+		ID:        stub.ID,
+		Namespace: stub.Namespace,
+		Session:   stub.Session,
+		Input:     stub.Input,
+		Output:    stub.Output,
+	}
+}
+
+// Random version 4 UUID, for stubs added without an ID
+func newStubID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// Find the stub with the given ID. Must be called with mx held.
+func (sm stubMapping) locate(id string) (service, method string, index int, found bool) {
+	for service, methods := range sm {
+		for method, stubs := range methods {
+			for i, s := range stubs {
+				if s.ID == id {
+					return service, method, i, true
+				}
+			}
+		}
+	}
+	return "", "", 0, false
+}
+
+func getStub(id string) (*Stub, error) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	service, method, i, found := locateStub(id)
+	if !found {
+		return nil, fmt.Errorf("%w: %s", errStubNotFound, id)
+	}
+	s := stubStorage[service][method][i]
+	return &Stub{
+		ID:        s.ID,
+		Service:   service,
+		Method:    method,
+		Namespace: s.Namespace,
+		Session:   s.Session,
+		Input:     s.Input,
+		Output:    s.Output,
+	}, nil
+}
+
+func deleteStub(id string) error {
+	mx.Lock()
+	defer mx.Unlock()
+	defer stubsChanged()
+
+	service, method, i, found := locateStub(id)
+	if !found {
+		return fmt.Errorf("%w: %s", errStubNotFound, id)
+	}
+	stubs := stubStorage[service][method]
+	stubStorage[service][method] = append(stubs[:i:i], stubs[i+1:]...)
+	stubHits.Delete(id)
+	publishStubs()
+	return nil
+}
+
+// Replace the stub with stub.ID. It keeps its place in the match order
+// unless it moves to another service or method, when it goes after the
+// stubs already there.
+func updateStub(stub *Stub) error {
+	mx.Lock()
+	defer mx.Unlock()
+	defer stubsChanged()
 
-	strg := storage{
-		Input:  stub.Input,
-		Output: stub.Output,
+	service, method, i, found := locateStub(stub.ID)
+	if !found {
+		return fmt.Errorf("%w: %s", errStubNotFound, stub.ID)
+	}
+	entry := stubStorageEntry(stub)
+	defer publishStubs()
+	if service == stub.Service && method == stub.Method {
+		// published snapshots share the slice
+		stubs := append([]storage{}, stubStorage[service][method]...)
+		stubs[i] = entry
+		stubStorage[service][method] = stubs
+		return nil
 	}
-	if (*sm)[stub.Service] == nil {
-		(*sm)[stub.Service] = make(map[string][]storage)
+	stubs := stubStorage[service][method]
+	stubStorage[service][method] = append(stubs[:i:i], stubs[i+1:]...)
+	if stubStorage[stub.Service] == nil {
+		stubStorage[stub.Service] = make(map[string][]storage)
 	}
-	(*sm)[stub.Service][stub.Method] = append((*sm)[stub.Service][stub.Method], strg)
+	stubStorage[stub.Service][stub.Method] = append(stubStorage[stub.Service][stub.Method], entry)
 	return nil
 }
 
+// A copy of every stub, with their hit statistics as of now
 func allStub() stubMapping {
 	mx.Lock()
 	defer mx.Unlock()
-	return stubStorage
+	return stubStorage.clone().withHits()
 }
 
 type closeMatch struct {
@@ -56,58 +197,159 @@ type closeMatch struct {
 	expect map[string]interface{}
 }
 
-func findStub(stub *findStubPayload) (*Output, error) {
-	mx.Lock()
-	defer mx.Unlock()
-	if _, ok := stubStorage[stub.Service]; !ok {
-		return nil, fmt.Errorf("Can't find stub for Service: %s", stub.Service)
+func findStub(stub *findStubPayload) (*storage, error) {
+	match, _, err := findStubRule(stub)
+	return match, err
+}
+
+// findStub, also returning the input rule the stub matched by: "equals",
+// "contains", "matches", "wasm", "stream" or "deadline"
+func findStubRule(stub *findStubPayload) (*storage, string, error) {
+	loadLazyStubs(stub.Service)
+	snap := currentStubs()
+	if _, ok := snap.stubs[stub.Service]; !ok {
+		return nil, "", fmt.Errorf("Can't find stub for Service: %s", stub.Service)
 	}
 
-	if _, ok := stubStorage[stub.Service][stub.Method]; !ok {
-		return nil, fmt.Errorf("Can't find stub for Service:%s and Method:%s", stub.Service, stub.Method)
+	if _, ok := snap.stubs[stub.Service][stub.Method]; !ok {
+		return nil, "", fmt.Errorf("Can't find stub for Service:%s and Method:%s", stub.Service, stub.Method)
 	}
 
-	stubs := stubStorage[stub.Service][stub.Method]
+	stubs := snap.stubs[stub.Service][stub.Method]
 	if len(stubs) == 0 {
-		return nil, fmt.Errorf("Stub for Service:%s and Method:%s is empty", stub.Service, stub.Method)
+		return nil, "", fmt.Errorf("Stub for Service:%s and Method:%s is empty", stub.Service, stub.Method)
 	}
 
+	candidates := snap.indexed().candidates(stub.Service, stub.Method, stubs, stub.Data)
+	if match, rule, ok := matchCall(candidates, stub, &[]closeMatch{}); ok {
+		return match, rule, nil
+	}
+
+	// the index leaves out the stubs to report as the closest match
 	closestMatch := []closeMatch{}
+	matchCall(stubs, stub, &closestMatch)
+	return nil, "", stubNotFoundError(stub, closestMatch, findNearMisses(stubs, callNamespaces(stub.Headers), stub))
+}
+
+// Return the first of stubs that matches the call, trying the caller's
+// sessions and namespaces in order of precedence
+func matchCall(stubs []storage, call *findStubPayload, closestMatch *[]closeMatch) (*storage, string, bool) {
+	namespaces := callNamespaces(call.Headers)
+	for _, session := range callSessions(call.Headers) {
+		for _, ns := range namespaces {
+			if match, rule, ok := matchStubs(stubs, session, ns, call, closestMatch); ok {
+				return match, rule, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// The namespaces to look for a call's stub in, in order. Stubs in the
+// caller's tenant namespace take precedence over the shared stubs in the
+// default namespace, which are used as a fallback.
+func callNamespaces(headers map[string]string) []string {
+	if ns := tenantNamespace(headers); ns != "" {
+		return []string{ns, ""}
+	}
+	return []string{""}
+}
+
+// Look up the namespace a call should be served from based on its tenant
+// metadata key, if tenant routing is enabled.
+func tenantNamespace(headers map[string]string) string {
+	if tenantKey == "" {
+		return ""
+	}
+	return headers[tenantKey]
+}
+
+// Return the first stub of session in namespace ns that matches the call and the rule
+// it matched by, recording each candidate rule in closestMatch for error
+// reporting. The
+// call's stream is non-nil for client-streaming and bidirectional lookups,
+// and holds every message received so far.
+func matchStubs(stubs []storage, session, ns string, call *findStubPayload, closestMatch *[]closeMatch) (*storage, string, bool) {
+	data, stream := call.Data, call.Stream
 	for _, stubrange := range stubs {
+		if stubrange.Namespace != ns || stubrange.Session != session {
+			continue
+		}
+
+		if rules := stubrange.Input.Deadline; rules != nil {
+			if !deadlineMatches(rules, call.Deadline) {
+				continue
+			}
+		}
+
+		if rules := stubrange.Input.Stream; rules != nil {
+			// aggregate rules only match client-streaming lookups
+			if stream == nil || !streamMatches(rules, stream) {
+				continue
+			}
+			if !hasRules(stubrange.Input) {
+				return &stubrange, "stream", true
+			}
+		}
+
+		// a stream with no messages yet only matches stream rules
+		if stream != nil && len(stream) == 0 {
+			continue
+		}
+
+		if stubrange.Input.Deadline != nil && !hasRules(stubrange.Input) {
+			return &stubrange, "deadline", true
+		}
+
 		if expect := stubrange.Input.Equals; expect != nil {
-			closestMatch = append(closestMatch, closeMatch{"equals", expect})
-			if equals(stub.Data, expect) {
-				return &stubrange.Output, nil
+			*closestMatch = append(*closestMatch, closeMatch{"equals", expect})
+			if equals(data, expect) {
+				return &stubrange, "equals", true
 			}
 		}
 
 		if expect := stubrange.Input.Contains; expect != nil {
-			closestMatch = append(closestMatch, closeMatch{"contains", expect})
-			if contains(stubrange.Input.Contains, stub.Data) {
-				return &stubrange.Output, nil
+			*closestMatch = append(*closestMatch, closeMatch{"contains", expect})
+			if contains(stubrange.Input.Contains, data) {
+				return &stubrange, "contains", true
 			}
 		}
 
 		if expect := stubrange.Input.Matches; expect != nil {
-			closestMatch = append(closestMatch, closeMatch{"matches", expect})
-			if matches(stubrange.Input.Matches, stub.Data) {
-				return &stubrange.Output, nil
+			*closestMatch = append(*closestMatch, closeMatch{"matches", expect})
+			if matches(stubrange.Input.Matches, data) {
+				return &stubrange, "matches", true
 			}
 		}
-	}
 
-	return nil, stubNotFoundError(stub, closestMatch)
+		if fn := stubrange.Input.Wasm; fn != nil {
+			if wasmMatch(fn, call) {
+				return &stubrange, "wasm", true
+			}
+		}
+	}
+	return nil, "", false
 }
 
-func stubNotFoundError(stub *findStubPayload, closestMatches []closeMatch) error {
+func stubNotFoundError(stub *findStubPayload, closestMatches []closeMatch, nearMisses []nearMiss) error {
 	template := fmt.Sprintf("Can't find stub \n\nService: %s \n\nMethod: %s \n\nInput\n\n", stub.Service, stub.Method)
 	expectString := renderFieldAsString(stub.Data)
 	template += expectString
+	if stub.Stream != nil {
+		template += fmt.Sprintf("\n\n(last of %d streamed messages)", len(stub.Stream))
+	}
 
-	if len(closestMatches) == 0 {
-		return fmt.Errorf(template)
+	if len(closestMatches) > 0 {
+		template += renderClosestMatch(expectString, closestMatches)
+	}
+	if len(nearMisses) > 0 {
+		template += "\n\n" + renderNearMisses(nearMisses)
 	}
+	// not a format string: regexes in the stubs may contain '%'
+	return errors.New(template)
+}
 
+func renderClosestMatch(expectString string, closestMatches []closeMatch) string {
 	highestRank := struct {
 		rank  float32
 		match closeMatch
@@ -130,9 +372,7 @@ func stubNotFoundError(stub *findStubPayload, closestMatches []closeMatch) error
 	}
 
 	closestMatchString := renderFieldAsString(closestMatch.expect)
-	template += fmt.Sprintf("\n\nClosest Match \n\n%s:%s", closestMatch.rule, closestMatchString)
-
-	return fmt.Errorf(template)
+	return fmt.Sprintf("\n\nClosest Match \n\n%s:%s", closestMatch.rule, closestMatchString)
 }
 
 // we made our own simple ranking logic
@@ -158,9 +398,16 @@ func rankMatch(expect string, closeMatch map[string]interface{}) float32 {
 }
 
 func renderFieldAsString(fields map[string]interface{}) string {
+	// render in key order so the output is stable
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
 	template := "{\n"
-	for key, val := range fields {
-		template += fmt.Sprintf("\t%s: %v\n", key, val)
+	for _, key := range keys {
+		template += fmt.Sprintf("\t%s: %v\n", key, fields[key])
 	}
 	template += "}"
 	return template
@@ -266,55 +513,159 @@ func find(expect, actual interface{}, acc, exactMatch bool, f matchFunc) bool {
 }
 
 func clearStorage() {
+	// not under mx, which loading them takes
+	dropLazyStubs()
 	mx.Lock()
 	defer mx.Unlock()
+	defer stubsChanged()
 
+	forgetHits(stubStorage)
 	stubStorage = stubMapping{}
+	publishStubs()
 }
 
-func readStubFromFile(path string) {
-	stubStorage.readStubFromFile(path)
-}
-
-func (sm *stubMapping) readStubFromFile(path string) {
-	files, err := ioutil.ReadDir(path)
-	if err != nil {
-		log.Printf("Can't read stub from %s. %v\n", path, err)
-		return
-	}
-
-	for _, file := range files {
-		if file.IsDir() {
-			readStubFromFile(path + "/" + file.Name())
+// Load the stub files of a -stub list of directories, files, patterns, URLs
+// and stdin, see stubfiles.go, url.go and stdin.go
+func (sm *stubMapping) readStubFromFile(spec string) {
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
 			continue
 		}
-
-		byt, err := ioutil.ReadFile(path + "/" + file.Name())
+		if entry == STUB_STDIN {
+			sm.readStubStdin()
+			continue
+		}
+		if IsStubURL(entry) {
+			sm.readStubURL(entry)
+			continue
+		}
+		files, err := findStubFiles(entry)
 		if err != nil {
-			log.Printf("Error when reading file %s. %v. skipping...", file.Name(), err)
+			log.Printf("Can't read stub from %s. %v\n", entry, err)
 			continue
 		}
-
-		if byt[0] == '[' && byt[len(byt)-1] == ']' {
-			var stubs []*Stub
-			err = json.Unmarshal(byt, &stubs)
-			if err != nil {
-				log.Printf("Error when unmarshalling file %s. %v. skipping...", file.Name(), err)
+		for _, file := range files {
+			if isFragment(file.Rel) {
 				continue
 			}
-			for _, s := range stubs {
-				sm.storeStub(s)
+			if persistPath != "" && absPath(file.Path) == absPath(persistPath) {
+				// loaded after the rest, see persist.go
+				continue
 			}
-			continue
+			if lazyStubLoading {
+				deferStubFile(file.Path)
+				continue
+			}
+			sm.readStubFile(file.Path)
 		}
+	}
+}
 
-		stub := new(Stub)
-		err = json.Unmarshal(byt, stub)
+func (sm *stubMapping) readStubFile(path string) {
+	sm.readStubFileOf(path, "")
+}
+
+// Load a stub file's stubs of service, or all of them if it's empty,
+// streaming it if it's an array of stubs, see lazy.go
+func (sm *stubMapping) readStubFileOf(path, service string) {
+	streamed, err := streamStubFile(path, func(i int, raw []byte, at filePosition) {
+		sm.loadStreamedStub(path, i, raw, at, service)
+	})
+	if streamed {
 		if err != nil {
-			log.Printf("Error when unmarshalling file %s. %v. skipping...", file.Name(), err)
+			log.Printf("Error when unmarshalling file %s. %v. skipping...", path, err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("Error when reading file %s. %v. skipping...", path, err)
+		return
+	}
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Error when reading file %s. %v. skipping...", path, err)
+		return
+	}
+	byt, err = renderStubFile(path, byt)
+	if err != nil {
+		log.Printf("Error when rendering file %s. %v. skipping...", path, err)
+		return
+	}
+	sm.loadStubsOf(path, byt, service)
+}
+
+// Load the stubs of a rendered stub document, skipping those that don't
+// fit the schema or can't be resolved or stored. path is where it came
+// from, for includes and the log.
+func (sm *stubMapping) loadStubs(path string, byt []byte) {
+	sm.loadStubsOf(path, byt, "")
+}
+
+// loadStubs, only storing the stubs of service unless it's empty
+func (sm *stubMapping) loadStubsOf(path string, byt []byte, service string) {
+	errs := schemaProblems(path, byt)
+	if len(errs) > 0 {
+		log.Printf("Stub file %s doesn't match the stub schema:\n%v", path, errs)
+	}
+	bad := errs.stubs()
+	if stubValidation == VALIDATION_REJECT && bad[-1] {
+		log.Printf("Skipping file %s", path)
+		return
+	}
+
+	byt, unresolved := resolveIncludes(path, byt)
+	if err := unresolved[-1]; err != nil {
+		log.Printf("Error when resolving includes in file %s. %v. skipping...", path, err)
+		return
+	}
+
+	stubs, err := parseStubs(byt)
+	if err != nil {
+		log.Printf("Error when unmarshalling file %s. %v. skipping...", path, err)
+		return
+	}
+	for i, s := range stubs {
+		if service != "" && s.Service != service {
+			continue
+		}
+		if stubValidation == VALIDATION_REJECT && bad[i] {
+			log.Printf("Skipping stub %d in file %s", i, path)
+			continue
+		}
+		if err := unresolved[i]; err != nil {
+			log.Printf("Can't resolve stub %d in file %s. %v. skipping...", i, path, err)
 			continue
 		}
+		sm.storeFileStub(path, i, s)
+	}
+}
 
-		sm.storeStub(stub)
+// Store stub i of a stub file, unless its output is invalid
+func (sm *stubMapping) storeFileStub(path string, i int, s *Stub) {
+	if err := validateOutput(s.Output); err != nil {
+		log.Printf("Invalid stub %d in file %s. %v. skipping...", i, path, err)
+		return
+	}
+	if err := sm.storeStub(s); err != nil {
+		log.Printf("Can't store stub %d in file %s. %v. skipping...", i, path, err)
+	}
+}
+
+// Parse a stub file, which holds either a single stub or a JSON array of
+// them
+func parseStubs(byt []byte) ([]*Stub, error) {
+	// tolerate the trailing newline most editors add
+	byt = bytes.TrimSpace(byt)
+	if len(byt) > 0 && byt[0] == '[' && byt[len(byt)-1] == ']' {
+		var stubs []*Stub
+		if err := json.Unmarshal(byt, &stubs); err != nil {
+			return nil, err
+		}
+		return stubs, nil
+	}
+	stub := new(Stub)
+	if err := json.Unmarshal(byt, stub); err != nil {
+		return nil, err
 	}
+	return []*Stub{stub}, nil
 }
@@ -131,7 +131,29 @@ func Test_readStubFromFile(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			sm := stubMapping{}
 			sm.readStubFromFile(tt.mock(tt.service, tt.method, tt.data))
-			require.ElementsMatch(t, tt.data, sm[tt.service][tt.method])
+			stored := sm[tt.service][tt.method]
+			// every stub is given an ID
+			for i := range stored {
+				require.NotEmpty(t, stored[i].ID)
+				stored[i].ID = ""
+			}
+			require.ElementsMatch(t, tt.data, stored)
 		})
 	}
 }
+
+func Test_readStubFromFileInvalidCode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	byt := []byte(`[
+		{"service":"user","method":"getname","input":{"equals":{"id":1}},"output":{"error":"gone","code":"not_found"}},
+		{"service":"user","method":"getname","input":{"equals":{"id":2}},"output":{"error":"bad","code":42}}
+	]
+`)
+	require.NoError(t, ioutil.WriteFile(dir+"/stubs.json", byt, 0644))
+
+	sm := stubMapping{}
+	sm.readStubFromFile(dir)
+	require.Len(t, sm["user"]["getname"], 1)
+	require.Equal(t, StatusCode(5), sm["user"]["getname"][0].Output.Code)
+}
@@ -0,0 +1,137 @@
+package stub
+
+import (
+	"fmt"
+)
+
+/*
+ * Aggregate matching for client-streaming and bidirectional methods.
+ *
+ * A client-streaming call is looked up once, after the client half-closes,
+ * with every message it sent. A bidirectional call is looked up when it
+ * opens, with no messages, then again as each message arrives, with every
+ * message so far. Stubs with a "stream" input rule are matched against the
+ * whole sequence; any equals/contains/matches rules they also have must
+ * match the last message. Stubs without a "stream" rule match on the last
+ * message alone, so never match a stream with no messages.
+ */
+
+// Rules on the sequence of messages received by a client-streaming or
+// bidirectional method.
+// Every rule that is set must match.
+type StreamInput struct {
+	// exact number of messages received
+	Count *int `json:"count,omitempty"`
+	// bounds on the number of messages received, inclusive
+	MinCount *int `json:"min_count,omitempty"`
+	MaxCount *int `json:"max_count,omitempty"`
+	// per-index matchers: message i must match Messages[i]. An empty
+	// matcher ({}) matches any message, so later indexes can be checked
+	// without constraining earlier ones.
+	Messages []Input `json:"messages,omitempty"`
+	// at least one of the messages must match
+	Any *Input `json:"any,omitempty"`
+}
+
+func validateStreamInput(stream *StreamInput) error {
+	if stream == nil {
+		return nil
+	}
+	if stream.Count == nil && stream.MinCount == nil && stream.MaxCount == nil && stream.Messages == nil && stream.Any == nil {
+		return fmt.Errorf("Input stream needs at least one of count, min_count, max_count, messages or any")
+	}
+	if stream.Count != nil && (stream.MinCount != nil || stream.MaxCount != nil) {
+		return fmt.Errorf("Input stream count can't be combined with min_count or max_count")
+	}
+	for field, n := range map[string]*int{"count": stream.Count, "min_count": stream.MinCount, "max_count": stream.MaxCount} {
+		if n != nil && *n < 0 {
+			return fmt.Errorf("Input stream %s can't be negative", field)
+		}
+	}
+	if stream.MinCount != nil && stream.MaxCount != nil && *stream.MinCount > *stream.MaxCount {
+		return fmt.Errorf("Input stream min_count %d is more than max_count %d, so can never match",
+			*stream.MinCount, *stream.MaxCount)
+	}
+	if stream.Count != nil && len(stream.Messages) > *stream.Count {
+		return fmt.Errorf("Input stream has %d message matchers but a count of %d, so can never match",
+			len(stream.Messages), *stream.Count)
+	}
+	if stream.MaxCount != nil && len(stream.Messages) > *stream.MaxCount {
+		return fmt.Errorf("Input stream has %d message matchers but a max_count of %d, so can never match",
+			len(stream.Messages), *stream.MaxCount)
+	}
+	if stream.Any != nil && !hasRules(*stream.Any) {
+		return fmt.Errorf("Input stream any needs an equals, contains, matches or wasm rule")
+	}
+	if stream.Any != nil {
+		if err := validateWasmFunc("Input stream any", stream.Any.Wasm); err != nil {
+			return err
+		}
+	}
+	for i, m := range stream.Messages {
+		if err := validateWasmFunc(fmt.Sprintf("Input stream message %d", i), m.Wasm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Report whether input has any single-message rule
+func hasRules(input Input) bool {
+	return input.Equals != nil || input.Contains != nil || input.Matches != nil || input.Wasm != nil
+}
+
+// Report whether one message satisfies any of input's single-message rules.
+// An input without rules matches every message.
+func inputMatches(input Input, data map[string]interface{}) bool {
+	if !hasRules(input) {
+		return true
+	}
+	if input.Equals != nil && equals(data, input.Equals) {
+		return true
+	}
+	if input.Contains != nil && contains(input.Contains, data) {
+		return true
+	}
+	if input.Matches != nil && matches(input.Matches, data) {
+		return true
+	}
+	if input.Wasm != nil && wasmMatch(input.Wasm, &findStubPayload{Data: data}) {
+		return true
+	}
+	return false
+}
+
+// Report whether the received message sequence satisfies stream
+func streamMatches(stream *StreamInput, messages []map[string]interface{}) bool {
+	if stream.Count != nil && len(messages) != *stream.Count {
+		return false
+	}
+	if stream.MinCount != nil && len(messages) < *stream.MinCount {
+		return false
+	}
+	if stream.MaxCount != nil && len(messages) > *stream.MaxCount {
+		return false
+	}
+	if len(messages) < len(stream.Messages) {
+		return false
+	}
+	for i, m := range stream.Messages {
+		if !inputMatches(m, messages[i]) {
+			return false
+		}
+	}
+	if stream.Any != nil {
+		found := false
+		for _, msg := range messages {
+			if inputMatches(*stream.Any, msg) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package stub
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientStreamAggregate(t *testing.T) {
+	defer clearStorage()
+
+	stubs := []string{
+		`{"service":"Upload","method":"Send","input":{"stream":{"count":0}},"output":{"data":{"v":"empty"}}}`,
+		`{"service":"Upload","method":"Send","input":{"stream":{"messages":[{"equals":{"id":"a"}},{},{"equals":{"id":"c"}}]}},"output":{"data":{"v":"indexed"}}}`,
+		`{"service":"Upload","method":"Send","input":{"stream":{"count":2,"any":{"contains":{"flag":true}}}},"output":{"data":{"v":"flagged pair"}}}`,
+		`{"service":"Upload","method":"Send","input":{"equals":{"id":"last"},"stream":{"count":3}},"output":{"data":{"v":"three ending in last"}}}`,
+		`{"service":"Upload","method":"Send","input":{"stream":{"min_count":5,"max_count":10}},"output":{"data":{"v":"batch"}}}`,
+		`{"service":"Upload","method":"Send","input":{"equals":{"id":"last"}},"output":{"data":{"v":"last"}}}`,
+	}
+	for _, payload := range stubs {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		assert.Equal(t, "Success add stub", wrt.Body.String())
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		expect  string
+	}{
+		{
+			name:    "empty stream",
+			payload: `{"service":"Upload","method":"Send","data":null,"stream":[]}`,
+			expect:  "empty",
+		},
+		{
+			name:    "per-index matchers",
+			payload: `{"service":"Upload","method":"Send","data":{"id":"c"},"stream":[{"id":"a"},{"id":"b"},{"id":"c"},{"id":"d"}]}`,
+			expect:  "indexed",
+		},
+		{
+			name:    "count and any",
+			payload: `{"service":"Upload","method":"Send","data":{"id":"y"},"stream":[{"id":"x","flag":true},{"id":"y"}]}`,
+			expect:  "flagged pair",
+		},
+		{
+			name:    "stream rules and last message",
+			payload: `{"service":"Upload","method":"Send","data":{"id":"last"},"stream":[{"id":"x"},{"id":"y"},{"id":"last"}]}`,
+			expect:  "three ending in last",
+		},
+		{
+			name:    "batch size",
+			payload: `{"service":"Upload","method":"Send","data":{"id":"last"},"stream":[{},{},{},{},{"id":"last"}]}`,
+			expect:  "batch",
+		},
+		{
+			name:    "last message only",
+			payload: `{"service":"Upload","method":"Send","data":{"id":"last"},"stream":[{"id":"last"}]}`,
+			expect:  "last",
+		},
+		{
+			name:    "stream rules ignored for unary lookups",
+			payload: `{"service":"Upload","method":"Send","data":{"id":"last"}}`,
+			expect:  "last",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrt := httptest.NewRecorder()
+			handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(tt.payload))))
+			assert.JSONEq(t, `{"data":{"v":"`+tt.expect+`"},"error":""}`, wrt.Body.String())
+		})
+	}
+}
+
+func Test_validateStreamInput(t *testing.T) {
+	one := 1
+	negative := -1
+	two := 2
+	tests := []struct {
+		name   string
+		stream *StreamInput
+		err    string
+	}{
+		{"no stream", nil, ""},
+		{"count", &StreamInput{Count: &one}, ""},
+		{"bounds", &StreamInput{MinCount: &one, MaxCount: &two}, ""},
+		{"empty", &StreamInput{}, "Input stream needs at least one of count, min_count, max_count, messages or any"},
+		{"negative count", &StreamInput{Count: &negative}, "Input stream count can't be negative"},
+		{"negative min", &StreamInput{MinCount: &negative}, "Input stream min_count can't be negative"},
+		{"count and bounds", &StreamInput{Count: &one, MaxCount: &two}, "Input stream count can't be combined with min_count or max_count"},
+		{"inverted bounds", &StreamInput{MinCount: &two, MaxCount: &one}, "Input stream min_count 2 is more than max_count 1, so can never match"},
+		{"too many matchers", &StreamInput{Count: &one, Messages: []Input{{}, {}}}, "Input stream has 2 message matchers but a count of 1, so can never match"},
+		{"too many matchers for max", &StreamInput{MaxCount: &one, Messages: []Input{{}, {}}}, "Input stream has 2 message matchers but a max_count of 1, so can never match"},
+		{"empty any", &StreamInput{Any: &Input{}}, "Input stream any needs an equals, contains, matches or wasm rule"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStreamInput(tt.stream)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBidiOpenLookup(t *testing.T) {
+	defer clearStorage()
+
+	stubs := []string{
+		`{"service":"Chat","method":"Talk","input":{"contains":{}},"output":{"data":{"v":"any message"}}}`,
+		`{"service":"Chat","method":"Talk","input":{"stream":{"count":0}},"output":{"data":{"v":"welcome"},"push":{"interval":"1s","messages":[{"v":"ping"}]}}}`,
+	}
+	for _, payload := range stubs {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		assert.Equal(t, "Success add stub", wrt.Body.String())
+	}
+
+	wrt := httptest.NewRecorder()
+	payload := `{"service":"Chat","method":"Talk","data":null,"stream":[],"optional":true}`
+	handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload))))
+	assert.JSONEq(t, `{"data":{"v":"welcome"},"error":"","push":{"interval":"1s","messages":[{"v":"ping"}]}}`, wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Chat","method":"Talk","data":{"v":"x"},"stream":[{"v":"x"}]}`
+	handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload))))
+	assert.JSONEq(t, `{"data":{"v":"any message"},"error":""}`, wrt.Body.String())
+
+	clearStorage()
+	wrt = httptest.NewRecorder()
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(stubs[0]))))
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Chat","method":"Talk","data":null,"stream":[],"optional":true}`
+	handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, http.StatusNotFound, wrt.Code)
+}
+
+func TestPushValidation(t *testing.T) {
+	tests := []struct {
+		push string
+		err  string
+	}{
+		{`{"messages":[{}]}`, "Output push requires an interval"},
+		{`{"interval":"-1s","messages":[{}]}`, `Output push interval "-1s" is not a valid positive duration, e.g. "500ms"`},
+		{`{"interval":"1s"}`, "Output push requires at least one message"},
+		{`{"interval":"1s","messages":[{}],"count":-1}`, "Output push count can't be negative"},
+	}
+	for _, tt := range tests {
+		wrt := httptest.NewRecorder()
+		payload := `{"service":"Chat","method":"Talk","input":{"equals":{}},"output":{"push":` + tt.push + `}}`
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		assert.Equal(t, tt.err, wrt.Body.String())
+	}
+}
+
+func TestRepeatValidation(t *testing.T) {
+	tests := []struct {
+		output string
+		err    string
+	}{
+		{`{"data":{"v":1},"repeat":{"interval":"1s","max_duration":"1m"}}`, "Success add stub"},
+		{`{"data":{"v":1},"repeat":{}}`, `Output repeat interval "" is not a valid positive duration, e.g. "1s"`},
+		{`{"data":{"v":1},"repeat":{"interval":"1s","max_duration":"soon"}}`, `Output repeat max_duration "soon" is not a valid duration, e.g. "1.5s"`},
+		{`{"error":"gone","repeat":{"interval":"1s"}}`, "Output repeat needs data or stream messages to repeat"},
+	}
+	for _, tt := range tests {
+		wrt := httptest.NewRecorder()
+		payload := `{"service":"Feed","method":"Watch","input":{"equals":{}},"output":` + tt.output + `}`
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		assert.Equal(t, tt.err, wrt.Body.String())
+	}
+	clearStorage()
+}
+
+func TestSendRateValidation(t *testing.T) {
+	tests := []struct {
+		output string
+		err    string
+	}{
+		{`{"stream":[{"v":1}],"send_rate":{"messages_per_second":0.5,"bytes_per_second":1024}}`, "Success add stub"},
+		{`{"stream":[{"v":1}],"send_rate":{}}`, "Output send_rate needs messages_per_second or bytes_per_second"},
+		{`{"stream":[{"v":1}],"send_rate":{"bytes_per_second":-1}}`, "Output send_rate can't be negative"},
+	}
+	for _, tt := range tests {
+		wrt := httptest.NewRecorder()
+		payload := `{"service":"Feed","method":"Watch","input":{"equals":{}},"output":` + tt.output + `}`
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		assert.Equal(t, tt.err, wrt.Body.String())
+	}
+	clearStorage()
+}
+
+func TestHalfCloseValidation(t *testing.T) {
+	tests := []struct {
+		output string
+		err    string
+	}{
+		{`{"data":{},"half_close":{"action":"finish_push","timeout":"10s"}}`, "Success add stub"},
+		{`{"data":{},"half_close":{"action":"wait","timeout":"1s"}}`, "Success add stub"},
+		{`{"data":{},"half_close":{"action":"linger"}}`, `Output half_close action "linger" must be one of close, finish_push, wait`},
+		{`{"data":{},"half_close":{"action":"wait"}}`, "Output half_close wait requires a timeout"},
+		{`{"data":{},"half_close":{"action":"close","timeout":"x"}}`, `Output half_close timeout "x" is not a valid duration, e.g. "1.5s"`},
+	}
+	for _, tt := range tests {
+		wrt := httptest.NewRecorder()
+		payload := `{"service":"Chat","method":"Talk","input":{"equals":{}},"output":` + tt.output + `}`
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		assert.Equal(t, tt.err, wrt.Body.String())
+	}
+	clearStorage()
+}
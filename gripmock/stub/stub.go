@@ -1,67 +1,453 @@
 package stub
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 	
 	"github.com/go-chi/chi"
 )
 
 type Options struct {
-	Port     string
-	BindAddr string
-	StubPath string
+	// port to serve the HTTP admin API on; "0" picks a free one, see
+	// ServerPorts
+	Port string
+	// hosts or IP addresses to bind to; empty, or an empty host, for every
+	// interface
+	BindAddrs []string
+	StubPath  string
+	// gRPC metadata key whose value selects the stub namespace used to
+	// serve each call, e.g. "x-tenant-id". Empty disables tenant routing.
+	TenantKey string
+	// effective gripmock configuration, served as JSON on /config
+	Config interface{}
+	// what to do with stub files and JSON stubs added on the admin API that
+	// don't match the stub schema, one of VALIDATION_OFF (the default),
+	// VALIDATION_WARN or VALIDATION_REJECT
+	StubValidation string
+	// render stub files as templates, with environment variables, before
+	// loading them, see template.go
+	StubTemplates bool
+	// save stubs added on the admin API to the -stub directory whenever
+	// they change, see persist.go
+	PersistStubs bool
+	// default overlap analysis for stubs added via /add, one of
+	// OVERLAP_OFF, OVERLAP_WARN or OVERLAP_REJECT
+	OverlapCheck string
+	// walkthrough page served on /demo by "gripmock demo", if set
+	DemoPage []byte
+	// directory of .wasm modules stubs can use as matchers and
+	// transformers (Optional)
+	WasmDir string
+	// port to serve the gRPC admin service on, on BindAddrs; "0" picks a
+	// free one (Optional)
+	GrpcPort string
+	// gRPC metadata key, and admin HTTP header, whose value is the test
+	// session of each call or admin request, e.g. "x-gripmock-session".
+	// Empty disables sessions.
+	SessionKey string
+	// gRPC metadata key whose value is the correlation ID of each call,
+	// e.g. "x-request-id", recorded with it and prefixed to log lines about
+	// it, see correlation.go. Empty disables it.
+	CorrelationKey string
+	// log each stub lookup's decoded request and response, masking the
+	// values of the fields and metadata keys named in Redact, see
+	// wirelog.go
+	WireLog bool
+	Redact  []string
+	// file to write an access log line to for each finished call, rotated
+	// when it would grow past AccessLogMaxSize bytes or has been open for
+	// AccessLogRotate, keeping AccessLogBackups rotated files, see
+	// accesslog.go. Zero is no limit. (Optional)
+	AccessLog        string
+	AccessLogMaxSize int64
+	AccessLogRotate  time.Duration
+	AccessLogBackups int
+	// log at most LogSample lines of each category, e.g. LOG_NO_MATCH,
+	// about each method per LogSampleInterval (DEFAULT_LOG_SAMPLE_INTERVAL
+	// if zero), with a summary of those dropped at the end of it, see
+	// logsample.go. Zero logs them all.
+	LogSample         int
+	LogSampleInterval time.Duration
+	// largest a tar.gz or zip archive of stubs, from /import, a URL or
+	// stdin, may decompress to; DEFAULT_IMPORT_MAX_SIZE if zero, see
+	// bulk.go
+	ImportMaxSize int64
+	// serve pprof profiles of gripmock under /debug/pprof/, see pprof.go
+	Pprof bool
+	// only scan the stub files at startup, and load each service's stubs
+	// when it's first called, see lazy.go
+	LazyStubs bool
+	// PEM certificate and key files to serve the HTTP and gRPC admin APIs
+	// with TLS (Optional)
+	TLSCert string
+	TLSKey  string
+	// carry out a SERVER_ action on the gRPC server, for POST /reload and
+	// /server/*. Returns once it's done, e.g. the reloaded server has
+	// started, or with the error that stopped it.
+	Control func(action string) error
+	// the gRPC server serves TLS, which /health/ready checks it with, see
+	// probes.go
+	GrpcTLS bool
+	// called with the addresses the gRPC server listens on each time it
+	// starts, see EVENT_LISTENING (Optional)
+	OnListening func(addresses []string)
+	// called with true each time the gRPC server reports it's serving, and
+	// false when it starts draining (Optional)
+	OnServing func(serving bool)
 }
 
 const DEFAULT_PORT = "4771"
 
-func RunStubServer(opt Options) {
+// The ports RunStubServer serves on. A port of 0 is given a free one on the
+// first of the BindAddrs, which the others then share.
+type ServerPorts struct {
+	Admin string
+	// empty without Options.GrpcPort
+	GrpcAdmin string
+}
+
+func RunStubServer(opt Options) ServerPorts {
 	if opt.Port == "" {
 		opt.Port = DEFAULT_PORT
 	}
-	addr := opt.BindAddr + ":" + opt.Port
+	if len(opt.BindAddrs) == 0 {
+		opt.BindAddrs = []string{""}
+	}
+	tenantKey = strings.ToLower(opt.TenantKey)
+	sessionKey = strings.ToLower(opt.SessionKey)
+	correlationKey = strings.ToLower(opt.CorrelationKey)
+	controlServer = opt.Control
+	onListening = opt.OnListening
+	onServing = opt.OnServing
+	grpcTLS = opt.GrpcTLS
+	overlapCheck = opt.OverlapCheck
+	stubValidation = opt.StubValidation
+	stubTemplates = opt.StubTemplates
+	wireLog = opt.WireLog
+	lazyStubLoading = opt.LazyStubs
+	importMaxSize = DEFAULT_IMPORT_MAX_SIZE
+	if opt.ImportMaxSize > 0 {
+		importMaxSize = opt.ImportMaxSize
+	}
+	setRedactNames(opt.Redact)
+	logSampling.configure(opt.LogSample, opt.LogSampleInterval)
+	if opt.LogSample > 0 {
+		go logSampling.summarize()
+	}
 	r := chi.NewRouter()
 	r.Post("/add", addStub)
 	r.Get("/", listStub)
 	r.Post("/find", handleFindStub)
+	r.Post("/preview", handlePreview)
 	r.Get("/clear", handleClearStub)
+	r.Post("/import", handleImport)
+	r.Post("/import/url", handleImportURL)
+	r.Post("/import/wiremock", handleImportWireMock)
+	r.Post("/save", handleSave)
+	r.Get("/export", handleExport)
+	r.Post("/reset", handleReset)
+	r.Post("/reset/stubs", handleResetStubs)
+	r.Post("/reset/journal", handleResetJournal)
+	r.Post("/reset/state", handleResetState)
+	r.Get("/stub/{id}", handleGetStub)
+	r.Put("/stub/{id}", handleUpdateStub)
+	r.Delete("/stub/{id}", handleDeleteStub)
+	r.Get("/health/services", listHealth)
+	r.Post("/health/services", setHealth)
+	r.Delete("/health/services", resetHealth)
+	r.Get("/health/live", handleLive)
+	r.Get("/health/ready", handleReady)
+	r.Get("/events", listEvents)
+	r.Post("/events", addEvent)
+	r.Get("/state", getState)
+	r.Post("/state/resume", resumeState)
+	r.Post("/reload", handleReload)
+	r.Post("/server/stop", handleServerControl(SERVER_STOP))
+	r.Post("/server/start", handleServerControl(SERVER_START))
+	r.Post("/server/restart", handleServerControl(SERVER_RESTART))
+	r.Post("/server/pause", handleServerControl(SERVER_PAUSE))
+	r.Post("/server/resume", handleServerControl(SERVER_RESUME))
+	r.Get("/journal", listJournal)
+	r.Get("/activity", streamActivity)
+	r.Get("/verify/counts", listCallCounts)
+	r.Post("/verify", handleVerify)
+	r.Get("/inflight", listInflight)
+	r.Post("/inflight", reportCall)
+	r.Get("/metrics", handleMetrics)
+	r.Get("/summary", listSummary)
+	r.Get("/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(opt.Config)
+	})
+	if opt.Pprof {
+		mountPprof(r)
+	}
+	if opt.DemoPage != nil {
+		r.Get("/demo", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(opt.DemoPage)
+		})
+	}
+
+	if opt.AccessLog != "" {
+		f, err := openRotatingFile(opt.AccessLog, opt.AccessLogMaxSize, opt.AccessLogRotate, opt.AccessLogBackups)
+		if err != nil {
+			log.Fatalf("Opening access log %s: %v", opt.AccessLog, err)
+		}
+		accessLog = f
+	}
+
+	if opt.WasmDir != "" {
+		if err := loadWasmModules(opt.WasmDir); err != nil {
+			log.Fatalf("Loading WASM modules from %s: %v", opt.WasmDir, err)
+		}
+	}
 
+	persistPath = findPersistPath(opt.StubPath)
+	if opt.PersistStubs && persistPath == "" {
+		log.Fatalf("Persisting stubs needs a -stub directory to save them to")
+	}
 	if opt.StubPath != "" {
-		readStubFromFile(opt.StubPath)
+		loadStubsAndSaved(opt.StubPath)
+	}
+	if opt.PersistStubs {
+		go persistStubs()
 	}
 
-	fmt.Println("Serving stub admin on http://" + addr)
-	go func() {
-		err := http.ListenAndServe(addr, r)
-		log.Fatal(err)
-	}()
+	for _, host := range opt.BindAddrs {
+		if opt.GrpcPort != "" {
+			opt.GrpcPort = serveGrpcAdmin(net.JoinHostPort(host, opt.GrpcPort), opt.TLSCert, opt.TLSKey)
+		}
+
+		// listening before returning, so the admin server is up once the
+		// gRPC server starts, e.g. in the same process in a standalone one
+		lis, err := net.Listen("tcp", net.JoinHostPort(host, opt.Port))
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, opt.Port, _ = net.SplitHostPort(lis.Addr().String())
+		addr := net.JoinHostPort(host, opt.Port)
+		if opt.TLSCert != "" {
+			fmt.Println("Serving stub admin on https://" + addr)
+			go func() {
+				err := http.ServeTLS(lis, r, opt.TLSCert, opt.TLSKey)
+				log.Fatal(err)
+			}()
+			continue
+		}
+		fmt.Println("Serving stub admin on http://" + addr)
+		go func() {
+			err := http.Serve(lis, r)
+			log.Fatal(err)
+		}()
+	}
+	return ServerPorts{Admin: opt.Port, GrpcAdmin: opt.GrpcPort}
 }
 
+// default overlap analysis mode for /add, see Options.OverlapCheck
+var overlapCheck string
+
 func responseError(err error, w http.ResponseWriter) {
 	w.WriteHeader(500)
 	w.Write([]byte(err.Error()))
 }
 
 type Stub struct {
-	Service string `json:"service"`
-	Method  string `json:"method"`
-	Input   Input  `json:"input"`
-	Output  Output `json:"output"`
+	// unique ID for the stub's admin endpoints, generated when the stub is
+	// added if it doesn't have one
+	ID        string `json:"id,omitempty"`
+	Service   string `json:"service"`
+	Method    string `json:"method"`
+	Namespace string `json:"namespace,omitempty"`
+	// test session the stub belongs to, see session.go; set from the
+	// admin request if not given
+	Session string `json:"session,omitempty"`
+	// stub file this one is based on, and fragments it takes in, resolved
+	// when a stub file is loaded, see include.go
+	Extends string   `json:"extends,omitempty"`
+	Include []string `json:"include,omitempty"`
+	Input   Input    `json:"input"`
+	Output  Output   `json:"output"`
 }
 
 type Input struct {
 	Equals   map[string]interface{} `json:"equals"`
 	Contains map[string]interface{} `json:"contains"`
 	Matches  map[string]interface{} `json:"matches"`
+	// rules on the whole sequence of messages received by a
+	// client-streaming or bidirectional method
+	Stream *StreamInput `json:"stream,omitempty"`
+	// rules on the time left before the call's deadline
+	Deadline *DeadlineInput `json:"deadline,omitempty"`
+	// matcher function from a module in the WASM dir
+	Wasm *WasmFunc `json:"wasm,omitempty"`
 }
 
 type Output struct {
 	Data  map[string]interface{} `json:"data"`
 	Error string                 `json:"error"`
+	// compression algorithm for the response message, "identity" or
+	// "gzip". Empty uses whatever the server would use by default.
+	Compression string `json:"compression,omitempty"`
+	// gRPC status code returned with Error, by number or name; UNKNOWN
+	// if unset
+	Code StatusCode `json:"code,omitempty"`
+	// attach a google.rpc.RetryInfo detail with this delay to the error,
+	// as a go duration string e.g. "1.5s"
+	RetryDelay string `json:"retry_delay,omitempty"`
+	// trailing metadata sent with the response status
+	Trailers map[string]string `json:"trailers,omitempty"`
+	// header metadata sent before the response message
+	Headers map[string]string `json:"headers,omitempty"`
+	// wait this long before sending the response message or status, as a
+	// go duration string e.g. "2s"
+	Delay string `json:"delay,omitempty"`
+	// wait until the call's deadline has passed before responding, so the
+	// client gets DEADLINE_EXCEEDED whatever its deadline is
+	ExceedDeadline bool `json:"exceed_deadline,omitempty"`
+	// send the response headers as soon as the stub matches, before the
+	// delay, rather than with the first message
+	EarlyHeaders bool `json:"early_headers,omitempty"`
+	// end a streaming call with just the status and trailers, sending no
+	// headers or messages
+	TrailersOnly bool `json:"trailers_only,omitempty"`
+	// shorthand for a RESOURCE_EXHAUSTED rate limit error
+	Throttle *Throttle `json:"throttle,omitempty"`
+	// messages a server-streaming or bidirectional method sends in order,
+	// before ending the stream with Error if one is set
+	Stream []map[string]interface{} `json:"stream,omitempty"`
+	// unsolicited messages a bidirectional method sends on a timer
+	Push *Push `json:"push,omitempty"`
+	// resend a server stream's messages on an interval
+	Repeat *Repeat `json:"repeat,omitempty"`
+	// slow down a streaming method's sends
+	SendRate *SendRate `json:"send_rate,omitempty"`
+	// what a bidirectional method does when the client half-closes
+	HalfClose *HalfClose `json:"half_close,omitempty"`
+	// function from a module in the WASM dir that builds the output from
+	// the call and this output
+	Transform *WasmFunc `json:"transform,omitempty"`
+	// Starlark script computing the response data from the call, see
+	// script.go
+	Script string `json:"script,omitempty"`
+	// base64 protobuf bytes of the response message to a method that isn't
+	// in the protos, see unknown.go
+	Raw string `json:"raw,omitempty"`
+}
+
+// Repeating server stream. The stream's messages (or its data message) are
+// sent at once, then again every Interval until the client cancels or
+// MaxDuration elapses, at which point the stream ends with the stub's error,
+// if any, or OK.
+type Repeat struct {
+	// time between repeats, as a go duration string e.g. "1s"
+	Interval string `json:"interval"`
+	// how long to keep repeating; empty or "0s" repeats until the client
+	// cancels
+	MaxDuration string `json:"max_duration,omitempty"`
+}
+
+// Send rate limit of a server-streaming or bidirectional method, to simulate
+// a slow producer or network so client backpressure handling and buffer
+// sizing can be exercised. Every limit that is set applies. On a
+// bidirectional stream the limit carries on to later replies and pushes
+// until another stub sets a new one.
+type SendRate struct {
+	MessagesPerSecond float64 `json:"messages_per_second,omitempty"`
+	// serialized message bytes
+	BytesPerSecond int `json:"bytes_per_second,omitempty"`
+}
+
+const (
+	// end the call with OK at once, stopping any push
+	HALF_CLOSE_CLOSE = "close"
+	// keep the call open until the running push has sent all its messages
+	HALF_CLOSE_FINISH_PUSH = "finish_push"
+	// keep the call open, with pushes still running, until the timeout
+	HALF_CLOSE_WAIT = "wait"
+)
+
+// Behaviour of a bidirectional stream once the client half-closes it. The
+// last matched stub that sets one applies.
+type HalfClose struct {
+	// HALF_CLOSE_CLOSE (the default without a half_close),
+	// HALF_CLOSE_FINISH_PUSH or HALF_CLOSE_WAIT
+	Action string `json:"action"`
+	// longest to keep the call open before ending it with OK, as a go
+	// duration string; required for HALF_CLOSE_WAIT
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Timed server pushes on a bidirectional stream. They start once the stub
+// matches and run until the stream ends, Count messages have been sent, or a
+// later matching stub starts pushes of its own.
+type Push struct {
+	// time between messages, as a go duration string e.g. "500ms"
+	Interval string `json:"interval"`
+	// messages to send, in turn, starting over after the last
+	Messages []map[string]interface{} `json:"messages"`
+	// total messages to send; 0 sends until the stream ends
+	Count int `json:"count,omitempty"`
+}
+
+// Rate limiting error shorthand. Expands to a RESOURCE_EXHAUSTED error with
+// a RetryInfo detail and x-ratelimit-* trailers describing the limit.
+type Throttle struct {
+	RetryDelay string `json:"retry_delay"`
+	Limit      *int   `json:"limit,omitempty"`
+	Remaining  *int   `json:"remaining,omitempty"`
+	Reset      string `json:"reset,omitempty"`
+}
+
+const (
+	CODE_MAX                = 16
+	CODE_RESOURCE_EXHAUSTED = 8
+)
+
+// Return a copy of the output with any throttle shorthand expanded into the
+// error, code, retry delay and trailers it stands for. Fields already set
+// explicitly on the output take precedence.
+func (o Output) resolve() Output {
+	if o.Throttle == nil {
+		return o
+	}
+	t := o.Throttle
+	o.Throttle = nil
+	if o.Code == 0 {
+		o.Code = CODE_RESOURCE_EXHAUSTED
+	}
+	if o.Error == "" {
+		o.Error = "rate limit exceeded"
+	}
+	if o.RetryDelay == "" {
+		o.RetryDelay = t.RetryDelay
+	}
+	trailers := map[string]string{}
+	if t.Limit != nil {
+		trailers["x-ratelimit-limit"] = strconv.Itoa(*t.Limit)
+	}
+	if t.Remaining != nil {
+		trailers["x-ratelimit-remaining"] = strconv.Itoa(*t.Remaining)
+	}
+	if t.Reset != "" {
+		trailers["x-ratelimit-reset"] = t.Reset
+	}
+	for k, v := range o.Trailers {
+		trailers[k] = v
+	}
+	if len(trailers) > 0 {
+		o.Trailers = trailers
+	}
+	return o
 }
 
 func addStub(w http.ResponseWriter, r *http.Request) {
@@ -71,10 +457,12 @@ func addStub(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stub := new(Stub)
-	err = json.Unmarshal(body, stub)
+	if !checkRequestSchema(w, r, body) {
+		return
+	}
+	stub, err := decodeStub(r, body)
 	if err != nil {
-		responseError(err, w)
+		decodeError(err, w)
 		return
 	}
 
@@ -83,18 +471,125 @@ func addStub(w http.ResponseWriter, r *http.Request) {
 		responseError(err, w)
 		return
 	}
+	if stub.Session == "" {
+		stub.Session = requestSession(r)
+	}
+
+	mode := r.URL.Query().Get("overlap")
+	if mode == "" {
+		mode = overlapCheck
+	}
+	switch mode {
+	case "", OVERLAP_OFF:
+	case OVERLAP_WARN, OVERLAP_REJECT:
+		if err := findOverlap(stub); err != nil {
+			if mode == OVERLAP_REJECT {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			log.Printf("Warning: %v", err)
+			w.Header().Set("X-Gripmock-Warning", strings.ReplaceAll(err.Error(), "\n", " "))
+		}
+	default:
+		responseError(fmt.Errorf("Unknown overlap mode \"%s\", must be one of off, warn, reject", mode), w)
+		return
+	}
 
 	err = storeStub(stub)
+	if errors.Is(err, errStubExists) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
 		responseError(err, w)
 		return
 	}
 
+	w.Header().Set("X-Gripmock-Stub-Id", stub.ID)
+	w.Header().Set("Location", "/stub/"+stub.ID)
 	w.Write([]byte("Success add stub"))
 }
 
+func handleGetStub(w http.ResponseWriter, r *http.Request) {
+	stub, err := getStub(chi.URLParam(r, "id"))
+	if err != nil {
+		stubIDError(err, w)
+		return
+	}
+	if writeStubProto(w, r, stub) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stub)
+}
+
+// Replace a stub, keeping its ID. The new stub's "id" may be left out.
+func handleUpdateStub(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responseError(err, w)
+		return
+	}
+	if !checkRequestSchema(w, r, body) {
+		return
+	}
+	stub, err := decodeStub(r, body)
+	if err != nil {
+		decodeError(err, w)
+		return
+	}
+	if stub.ID != "" && stub.ID != id {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Stub ID %s doesn't match the URL's %s", stub.ID, id)))
+		return
+	}
+	stub.ID = id
+	if err := validateStub(stub); err != nil {
+		responseError(err, w)
+		return
+	}
+	if stub.Session == "" {
+		stub.Session = requestSession(r)
+	}
+	if err := updateStub(stub); err != nil {
+		stubIDError(err, w)
+		return
+	}
+	w.Write([]byte("Success update stub"))
+}
+
+func handleDeleteStub(w http.ResponseWriter, r *http.Request) {
+	if err := deleteStub(chi.URLParam(r, "id")); err != nil {
+		stubIDError(err, w)
+		return
+	}
+	w.Write([]byte("Success delete stub"))
+}
+
+func stubIDError(err error, w http.ResponseWriter) {
+	if errors.Is(err, errStubNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	responseError(err, w)
+}
+
 func listStub(w http.ResponseWriter, r *http.Request) {
+	if responseEncoding(r) != ENCODING_JSON {
+		writeStubListProto(w, r, sessionStubs(requestSession(r)))
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
+	if session := requestSession(r); session != "" {
+		mx.Lock()
+		defer mx.Unlock()
+		json.NewEncoder(w).Encode(stubStorage.session(session).withHits())
+		return
+	}
 	json.NewEncoder(w).Encode(allStub())
 }
 
@@ -106,6 +601,10 @@ func validateStub(stub *Stub) error {
 	if stub.Method == "" {
 		return fmt.Errorf("Method name can't be emtpy")
 	}
+
+	if stub.Extends != "" || len(stub.Include) > 0 {
+		return fmt.Errorf("extends and include are only supported in stub files")
+	}
 	
 	// due to golang implementation
 	// method name must capital
@@ -118,15 +617,147 @@ func validateStub(stub *Stub) error {
 		break
 	case stub.Input.Matches != nil:
 		break
+	case stub.Input.Stream != nil:
+		break
+	case stub.Input.Wasm != nil:
+		break
+	case stub.Input.Deadline != nil:
+		break
 	default:
 		return fmt.Errorf("Input cannot be empty")
 	}
 
 	// TODO: validate all input case
 
-	if stub.Output.Error == "" && stub.Output.Data == nil {
+	if err := validateStreamInput(stub.Input.Stream); err != nil {
+		return err
+	}
+	if err := validateWasmFunc("Input", stub.Input.Wasm); err != nil {
+		return err
+	}
+	if err := validateDeadlineInput(stub.Input.Deadline); err != nil {
+		return err
+	}
+
+	return validateOutput(stub.Output)
+}
+
+func validateOutput(output Output) error {
+	if output.Error == "" && output.Data == nil && output.Code == 0 && output.Throttle == nil && len(output.Stream) == 0 && output.Push == nil && output.Transform == nil && output.Script == "" && output.Raw == "" && !output.TrailersOnly && !output.ExceedDeadline {
 		return fmt.Errorf("Output can't be empty")
 	}
+
+	if output.Data != nil && len(output.Stream) > 0 {
+		return fmt.Errorf("Output can't have both data and stream, list every message in stream")
+	}
+
+	if output.TrailersOnly {
+		switch {
+		case output.Data != nil, len(output.Stream) > 0, output.Push != nil, output.Repeat != nil, output.Script != "":
+			return fmt.Errorf("Output trailers_only sends no messages, so can't have data, stream, push, repeat or script")
+		case len(output.Headers) > 0, output.EarlyHeaders:
+			return fmt.Errorf("Output trailers_only sends no headers, so can't have headers or early_headers")
+		}
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(output.Raw); err != nil {
+		return fmt.Errorf("Output raw must be base64 protobuf bytes: %v", err)
+	}
+
+	if !output.Code.valid() {
+		return fmt.Errorf("Output code %d is not a valid gRPC status code", output.Code)
+	}
+	if err := validateDuration("retry_delay", output.RetryDelay); err != nil {
+		return err
+	}
+	if err := validateDuration("delay", output.Delay); err != nil {
+		return err
+	}
+	if t := output.Throttle; t != nil {
+		if t.RetryDelay == "" {
+			return fmt.Errorf("Output throttle requires a retry_delay")
+		}
+		if err := validateDuration("throttle retry_delay", t.RetryDelay); err != nil {
+			return err
+		}
+	}
+
+	if p := output.Push; p != nil {
+		if p.Interval == "" {
+			return fmt.Errorf("Output push requires an interval")
+		}
+		if d, err := time.ParseDuration(p.Interval); err != nil || d <= 0 {
+			return fmt.Errorf("Output push interval \"%s\" is not a valid positive duration, e.g. \"500ms\"", p.Interval)
+		}
+		if len(p.Messages) == 0 {
+			return fmt.Errorf("Output push requires at least one message")
+		}
+		if p.Count < 0 {
+			return fmt.Errorf("Output push count can't be negative")
+		}
+	}
+
+	if r := output.Repeat; r != nil {
+		if d, err := time.ParseDuration(r.Interval); err != nil || d <= 0 {
+			return fmt.Errorf("Output repeat interval \"%s\" is not a valid positive duration, e.g. \"1s\"", r.Interval)
+		}
+		if err := validateDuration("repeat max_duration", r.MaxDuration); err != nil {
+			return err
+		}
+		if output.Data == nil && len(output.Stream) == 0 {
+			return fmt.Errorf("Output repeat needs data or stream messages to repeat")
+		}
+	}
+
+	if r := output.SendRate; r != nil {
+		if r.MessagesPerSecond == 0 && r.BytesPerSecond == 0 {
+			return fmt.Errorf("Output send_rate needs messages_per_second or bytes_per_second")
+		}
+		if r.MessagesPerSecond < 0 || r.BytesPerSecond < 0 {
+			return fmt.Errorf("Output send_rate can't be negative")
+		}
+	}
+
+	if h := output.HalfClose; h != nil {
+		switch h.Action {
+		case HALF_CLOSE_CLOSE, HALF_CLOSE_FINISH_PUSH, HALF_CLOSE_WAIT:
+		default:
+			return fmt.Errorf("Output half_close action \"%s\" must be one of close, finish_push, wait", h.Action)
+		}
+		if err := validateDuration("half_close timeout", h.Timeout); err != nil {
+			return err
+		}
+		if d, _ := time.ParseDuration(h.Timeout); h.Action == HALF_CLOSE_WAIT && d <= 0 {
+			return fmt.Errorf("Output half_close wait requires a timeout")
+		}
+	}
+
+	if err := validateWasmFunc("Output transform", output.Transform); err != nil {
+		return err
+	}
+
+	if output.Script != "" && len(output.Stream) > 0 {
+		return fmt.Errorf("Output script computes data, it can't be used with stream")
+	}
+	if err := validateScript(output.Script); err != nil {
+		return err
+	}
+
+	switch output.Compression {
+	case "", "identity", "gzip":
+	default:
+		return fmt.Errorf("Unsupported output compression \"%s\", must be \"identity\" or \"gzip\"", output.Compression)
+	}
+	return nil
+}
+
+func validateDuration(field, value string) error {
+	if value == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(value); err != nil || d < 0 {
+		return fmt.Errorf("Output %s \"%s\" is not a valid duration, e.g. \"1.5s\"", field, value)
+	}
 	return nil
 }
 
@@ -134,6 +765,22 @@ type findStubPayload struct {
 	Service string                 `json:"service"`
 	Method  string                 `json:"method"`
 	Data    map[string]interface{} `json:"data"`
+	// incoming gRPC metadata of the call, multiple values joined by ", "
+	Headers map[string]string `json:"headers,omitempty"`
+	// every message received so far by a client-streaming or bidirectional
+	// method, in order. Data is the last of them.
+	Stream []map[string]interface{} `json:"stream,omitempty"`
+	// time left before the call's deadline when it was looked up, as a go
+	// duration string; empty if the call has no deadline
+	Deadline string `json:"deadline,omitempty"`
+	// ID of the call in its start and finish reports, see journal.go
+	CallID string `json:"call_id,omitempty"`
+	// the caller carries on without a stub if none matches, so a miss is
+	// answered with 404 Not Found and isn't logged
+	Optional bool `json:"optional,omitempty"`
+	// the method isn't in the gRPC server's protos, so Data only holds the
+	// request's raw bytes and catch-all stubs apply, see unknown.go
+	Unknown bool `json:"unknown,omitempty"`
 }
 
 func handleFindStub(w http.ResponseWriter, r *http.Request) {
@@ -143,14 +790,19 @@ func handleFindStub(w http.ResponseWriter, r *http.Request) {
 		responseError(err, w)
 		return
 	}
-	
-	// due to golang implementation
-	// method name must capital
-	stub.Method = strings.Title(stub.Method)
-	
-	output, err := findStub(stub)
+
+	_, output, matched, err := lookupStub(stub)
+	if !matched && stub.Optional {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
-		log.Println(err)
+		category := LOG_CALL_ERROR
+		if !matched {
+			category = LOG_NO_MATCH
+		}
+		logCallSampled(category, stub, "%v", err)
 		responseError(err, w)
 		return
 	}
@@ -159,7 +811,63 @@ func handleFindStub(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(output)
 }
 
+// Find the stub for a call, record the lookup and compute the output to
+// send, returning the matched stub's ID. matched is false, with the not
+// found error, if no stub matched; optional misses aren't recorded.
+func lookupStub(stub *findStubPayload) (id string, output Output, matched bool, err error) {
+	// due to golang implementation
+	// method name must capital
+	stub.Method = strings.Title(stub.Method)
+
+	find := findStub
+	if stub.Unknown {
+		find = findUnknownStub
+	}
+	match, err := find(stub)
+	if err != nil && stub.Optional {
+		return "", Output{}, false, err
+	}
+	history := callsFor(callSession(stub.Headers))
+	call := recordedCall{Service: stub.Service, Method: stub.Method, Data: stub.Data}
+	if err != nil {
+		history.record(call)
+		journal.lookup(stub, "")
+		activity.lookup(stub, "", err)
+		logWire(stub, "", Output{}, err)
+		return "", Output{}, false, err
+	}
+	call.StubID = match.ID
+	recordHit(match.ID)
+	history.record(call)
+	journal.lookup(stub, match.ID)
+	activity.lookup(stub, match.ID, nil)
+	output, err = computeOutput(stub, match.Output)
+	logWire(stub, match.ID, output, err)
+	return match.ID, output, true, err
+}
+
+// Run a matched stub's output transform and script on the call, returning
+// the output to send
+func computeOutput(call *findStubPayload, output Output) (Output, error) {
+	var err error
+	if output.Transform != nil {
+		output, err = wasmTransform(output.Transform, call, output)
+		if err != nil {
+			return Output{}, err
+		}
+	}
+
+	if output.Script != "" {
+		output, err = runScript(call, output)
+		if err != nil {
+			return Output{}, err
+		}
+	}
+
+	return output.resolve(), nil
+}
+
 func handleClearStub(w http.ResponseWriter, r *http.Request) {
-	clearStorage()
+	clearStubs(requestSession(r))
 	w.Write([]byte("OK"))
 }
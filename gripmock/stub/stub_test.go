@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/go-chi/chi"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,6 +24,7 @@ func TestStub(t *testing.T) {
 			name: "add simple stub",
 			mock: func() *http.Request {
 				payload := `{
+						"id": "simple",
 						"service": "Testing",
 						"method":"TestMethod",
 						"input":{
@@ -48,7 +50,7 @@ func TestStub(t *testing.T) {
 				return httptest.NewRequest("GET", "/", nil)
 			},
 			handler: listStub,
-			expect:  "{\"Testing\":{\"TestMethod\":[{\"Input\":{\"equals\":{\"Hola\":\"Mundo\"},\"contains\":null,\"matches\":null},\"Output\":{\"data\":{\"Hello\":\"World\"},\"error\":\"\"}}]}}\n",
+			expect:  "{\"Testing\":{\"TestMethod\":[{\"ID\":\"simple\",\"Input\":{\"equals\":{\"Hola\":\"Mundo\"},\"contains\":null,\"matches\":null},\"Output\":{\"data\":{\"Hello\":\"World\"},\"error\":\"\"}}]}}\n",
 		},
 		{
 			name: "find stub equals",
@@ -103,6 +105,7 @@ func TestStub(t *testing.T) {
 			name: "add stub contains",
 			mock: func() *http.Request {
 				payload := `{
+								"id": "contains",
 								"service": "Testing",
 								"method":"TestMethod",
 								"input":{
@@ -290,7 +293,9 @@ func TestStub(t *testing.T) {
 				return httptest.NewRequest("GET", "/find", bytes.NewReader([]byte(payload)))
 			},
 			handler: handleFindStub,
-			expect:  "Can't find stub \n\nService: Testing \n\nMethod: TestMethod \n\nInput\n\n{\n\tfield1: hello field1\n}\n\nClosest Match \n\ncontains:{\n\tfield1: hello field1\n\tfield3: hello field3\n}",
+			expect: "Can't find stub \n\nService: Testing \n\nMethod: TestMethod \n\nInput\n\n{\n\tfield1: hello field1\n}\n\nClosest Match \n\ncontains:{\n\tfield1: hello field1\n\tfield3: hello field3\n}" +
+				"\n\nNear misses\n\nstub contains, contains:\n\tfield3: missing, expected \"hello field3\"" +
+				"\n\nstub simple, equals:\n\tHola: missing, expected \"Mundo\"\n\tfield1: unexpected, got \"hello field1\"",
 		},
 		{
 			name: "error find stub equals",
@@ -299,7 +304,18 @@ func TestStub(t *testing.T) {
 				return httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload)))
 			},
 			handler: handleFindStub,
-			expect:  "Can't find stub \n\nService: Testing \n\nMethod: TestMethod \n\nInput\n\n{\n\tHola: Dunia\n}\n\nClosest Match \n\nequals:{\n\tHola: Mundo\n}",
+			expect: "Can't find stub \n\nService: Testing \n\nMethod: TestMethod \n\nInput\n\n{\n\tHola: Dunia\n}\n\nClosest Match \n\nequals:{\n\tHola: Mundo\n}" +
+				"\n\nNear misses\n\nstub simple, equals:\n\tHola: expected \"Mundo\", got \"Dunia\"" +
+				"\n\nstub contains, contains:\n\tfield1: missing, expected \"hello field1\"\n\tfield3: missing, expected \"hello field3\"",
+		},
+		{
+			name: "add stub unsupported compression",
+			mock: func() *http.Request {
+				payload := `{"service":"Testing","method":"TestMethod","input":{"equals":{"Hola":"Mundo"}},"output":{"data":{"Hello":"World"},"compression":"brotli"}}`
+				return httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload)))
+			},
+			handler: addStub,
+			expect:  `Unsupported output compression "brotli", must be "identity" or "gzip"`,
 		},
 	}
 
@@ -315,3 +331,226 @@ func TestStub(t *testing.T) {
 		})
 	}
 }
+
+func TestTenantRouting(t *testing.T) {
+	tenantKey = "x-tenant-id"
+	defer func() {
+		tenantKey = ""
+		clearStorage()
+	}()
+
+	add := func(payload string) {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		assert.Equal(t, "Success add stub", wrt.Body.String())
+	}
+	add(`{"service":"Tenanted","method":"Get","input":{"equals":{"id":"1"}},"output":{"data":{"owner":"shared"}}}`)
+	add(`{"service":"Tenanted","method":"Get","namespace":"acme","input":{"equals":{"id":"1"}},"output":{"data":{"owner":"acme"}}}`)
+
+	cases := []struct {
+		name    string
+		payload string
+		expect  string
+	}{
+		{
+			name:    "no tenant metadata uses default namespace",
+			payload: `{"service":"Tenanted","method":"Get","data":{"id":"1"}}`,
+			expect:  "{\"data\":{\"owner\":\"shared\"},\"error\":\"\"}\n",
+		},
+		{
+			name:    "tenant namespace takes precedence",
+			payload: `{"service":"Tenanted","method":"Get","data":{"id":"1"},"headers":{"x-tenant-id":"acme"}}`,
+			expect:  "{\"data\":{\"owner\":\"acme\"},\"error\":\"\"}\n",
+		},
+		{
+			name:    "unknown tenant falls back to default namespace",
+			payload: `{"service":"Tenanted","method":"Get","data":{"id":"1"},"headers":{"x-tenant-id":"globex"}}`,
+			expect:  "{\"data\":{\"owner\":\"shared\"},\"error\":\"\"}\n",
+		},
+	}
+	for _, v := range cases {
+		t.Run(v.name, func(t *testing.T) {
+			wrt := httptest.NewRecorder()
+			handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(v.payload))))
+			assert.Equal(t, v.expect, wrt.Body.String())
+		})
+	}
+}
+
+func TestThrottleOutput(t *testing.T) {
+	defer clearStorage()
+
+	wrt := httptest.NewRecorder()
+	payload := `{"service":"Throttled","method":"Get","input":{"equals":{"id":"1"}},"output":{"throttle":{"retry_delay":"2s","limit":10,"remaining":0,"reset":"30"},"trailers":{"x-ratelimit-reset":"45"}}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, "Success add stub", wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Throttled","method":"Get","data":{"id":"1"}}`
+	handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload))))
+	assert.JSONEq(t, `{
+		"data": null,
+		"error": "rate limit exceeded",
+		"code": 8,
+		"retry_delay": "2s",
+		"trailers": {"x-ratelimit-limit": "10", "x-ratelimit-remaining": "0", "x-ratelimit-reset": "45"}
+	}`, wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Throttled","method":"Get","input":{"equals":{"id":"2"}},"output":{"throttle":{"limit":10}}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, "Output throttle requires a retry_delay", wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Throttled","method":"Get","input":{"equals":{"id":"2"}},"output":{"error":"bad","code":17}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, "Output code 17 is not a valid gRPC status code", wrt.Body.String())
+}
+
+func TestStreamOutput(t *testing.T) {
+	defer clearStorage()
+
+	wrt := httptest.NewRecorder()
+	payload := `{"service":"Streamer","method":"List","input":{"equals":{"id":"1"}},"output":{"stream":[{"n":1},{"n":2}],"error":"connection reset","code":14}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, "Success add stub", wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Streamer","method":"List","data":{"id":"1"}}`
+	handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload))))
+	assert.JSONEq(t, `{
+		"data": null,
+		"error": "connection reset",
+		"code": 14,
+		"stream": [{"n": 1}, {"n": 2}]
+	}`, wrt.Body.String())
+}
+
+func TestStatusCodeNames(t *testing.T) {
+	defer clearStorage()
+
+	wrt := httptest.NewRecorder()
+	payload := `{"service":"Coded","method":"Get","input":{"equals":{"id":"1"}},"output":{"error":"no such thing","code":"NOT_FOUND"}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, "Success add stub", wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Coded","method":"Get","data":{"id":"1"}}`
+	handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload))))
+	assert.JSONEq(t, `{"data":null,"error":"no such thing","code":5}`, wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Coded","method":"Get","input":{"equals":{"id":"2"}},"output":{"error":"bad","code":"NOT_FOUNDD"}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, `Output code "NOT_FOUNDD" is not a valid gRPC status code name, must be one of OK, CANCELLED, UNKNOWN, INVALID_ARGUMENT, DEADLINE_EXCEEDED, NOT_FOUND, ALREADY_EXISTS, PERMISSION_DENIED, RESOURCE_EXHAUSTED, FAILED_PRECONDITION, ABORTED, OUT_OF_RANGE, UNIMPLEMENTED, INTERNAL, UNAVAILABLE, DATA_LOSS, UNAUTHENTICATED`, wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Coded","method":"Get","input":{"equals":{"id":"2"}},"output":{"error":"bad","code":1.5}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, "Output code 1.5 must be a gRPC status code number or name", wrt.Body.String())
+}
+
+func TestStreamOutputConflict(t *testing.T) {
+	defer clearStorage()
+
+	wrt := httptest.NewRecorder()
+	payload := `{"service":"Streamer","method":"List","input":{"equals":{"id":"1"}},"output":{"data":{"n":0},"stream":[{"n":1}]}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, "Output can't have both data and stream, list every message in stream", wrt.Body.String())
+}
+
+func TestDelayedOutput(t *testing.T) {
+	defer clearStorage()
+
+	wrt := httptest.NewRecorder()
+	payload := `{"service":"Slow","method":"Get","input":{"equals":{"id":"1"}},"output":{"data":{"n":1},"headers":{"x-region":"eu"},"delay":"2s","early_headers":true}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, "Success add stub", wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Slow","method":"Get","data":{"id":"1"}}`
+	handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload))))
+	assert.JSONEq(t, `{"data":{"n":1},"error":"","headers":{"x-region":"eu"},"delay":"2s","early_headers":true}`, wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Slow","method":"Get","input":{"equals":{"id":"2"}},"output":{"data":{},"delay":"soon"}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, `Output delay "soon" is not a valid duration, e.g. "1.5s"`, wrt.Body.String())
+}
+
+func TestTrailersOnlyOutput(t *testing.T) {
+	defer clearStorage()
+
+	wrt := httptest.NewRecorder()
+	payload := `{"service":"Streamer","method":"List","input":{"equals":{"id":"1"}},"output":{"trailers_only":true,"trailers":{"x-reason":"empty"}}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, "Success add stub", wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Streamer","method":"List","data":{"id":"1"}}`
+	handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload))))
+	assert.JSONEq(t, `{"data":null,"error":"","trailers":{"x-reason":"empty"},"trailers_only":true}`, wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Streamer","method":"List","input":{"equals":{"id":"2"}},"output":{"trailers_only":true,"stream":[{"n":1}]}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, "Output trailers_only sends no messages, so can't have data, stream, push, repeat or script", wrt.Body.String())
+
+	wrt = httptest.NewRecorder()
+	payload = `{"service":"Streamer","method":"List","input":{"equals":{"id":"2"}},"output":{"trailers_only":true,"headers":{"a":"b"}}}`
+	addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+	assert.Equal(t, "Output trailers_only sends no headers, so can't have headers or early_headers", wrt.Body.String())
+}
+
+func TestStubIDs(t *testing.T) {
+	defer clearStorage()
+
+	r := chi.NewRouter()
+	r.Post("/add", addStub)
+	r.Post("/find", handleFindStub)
+	r.Get("/stub/{id}", handleGetStub)
+	r.Put("/stub/{id}", handleUpdateStub)
+	r.Delete("/stub/{id}", handleDeleteStub)
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		wrt := httptest.NewRecorder()
+		r.ServeHTTP(wrt, httptest.NewRequest(method, path, bytes.NewReader([]byte(body))))
+		return wrt
+	}
+
+	wrt := call("POST", "/add", `{"service":"Ids","method":"Get","input":{"equals":{"k":"a"}},"output":{"data":{"v":"first"}}}`)
+	assert.Equal(t, "Success add stub", wrt.Body.String())
+	generated := wrt.Header().Get("X-Gripmock-Stub-Id")
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, generated)
+	assert.Equal(t, "/stub/"+generated, wrt.Header().Get("Location"))
+
+	wrt = call("POST", "/add", `{"id":"fixed","service":"Ids","method":"Get","input":{"contains":{}},"output":{"data":{"v":"second"}}}`)
+	assert.Equal(t, "fixed", wrt.Header().Get("X-Gripmock-Stub-Id"))
+	wrt = call("POST", "/add", `{"id":"fixed","service":"Ids","method":"Get","input":{"contains":{}},"output":{"data":{"v":"dup"}}}`)
+	assert.Equal(t, http.StatusConflict, wrt.Code)
+	assert.Equal(t, "A stub already exists with ID: fixed", wrt.Body.String())
+
+	wrt = call("GET", "/stub/fixed", "")
+	assert.JSONEq(t, `{"id":"fixed","service":"Ids","method":"Get","input":{"equals":null,"contains":{},"matches":null},"output":{"data":{"v":"second"},"error":""}}`, wrt.Body.String())
+
+	// replacing keeps the stub's place ahead of later ones
+	wrt = call("PUT", "/stub/"+generated, `{"service":"Ids","method":"Get","input":{"equals":{"k":"b"}},"output":{"data":{"v":"replaced"}}}`)
+	assert.Equal(t, "Success update stub", wrt.Body.String())
+	wrt = call("POST", "/find", `{"service":"Ids","method":"Get","data":{"k":"b"}}`)
+	assert.JSONEq(t, `{"data":{"v":"replaced"},"error":""}`, wrt.Body.String())
+
+	wrt = call("PUT", "/stub/fixed", `{"id":"other","service":"Ids","method":"Get","input":{"contains":{}},"output":{"data":{}}}`)
+	assert.Equal(t, http.StatusBadRequest, wrt.Code)
+
+	wrt = call("DELETE", "/stub/"+generated, "")
+	assert.Equal(t, "Success delete stub", wrt.Body.String())
+	wrt = call("POST", "/find", `{"service":"Ids","method":"Get","data":{"k":"b"}}`)
+	assert.JSONEq(t, `{"data":{"v":"second"},"error":""}`, wrt.Body.String())
+
+	for _, method := range []string{"GET", "DELETE"} {
+		wrt = call(method, "/stub/"+generated, "")
+		assert.Equal(t, http.StatusNotFound, wrt.Code)
+		assert.Equal(t, "Can't find stub with ID: "+generated, wrt.Body.String())
+	}
+	wrt = call("PUT", "/stub/missing", `{"service":"Ids","method":"Get","input":{"contains":{}},"output":{"data":{}}}`)
+	assert.Equal(t, http.StatusNotFound, wrt.Code)
+}
@@ -0,0 +1,213 @@
+package stub
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * Stub file discovery.
+ *
+ * -stub takes a comma separated list of directories, files and glob
+ * patterns. A directory is loaded with every file in its whole tree, so
+ * large suites can keep their stubs in per-service or per-feature
+ * subfolders. A pattern matches files by their slash separated path: *, ?
+ * and [...] work as in path.Match within one path segment, and a ** segment
+ * matches any number of directories, so "stubs/**" matches every file under
+ * stubs. Hidden files and directories, such as .git, are skipped either way.
+ *
+ * ReadStubFiles reads the stubs of the files found, resolved as they are
+ * when loaded, for clients that send them to an admin server instead.
+ */
+
+type StubFile struct {
+	// path on disk
+	Path string
+	// slash separated path below the directory it was found in, or the
+	// base of the pattern that matched it
+	Rel string
+}
+
+// Every stub file of a -stub list, in order and without repeats. URLs and
+// stdin aren't files, so are an error.
+func FindStubFiles(spec string) ([]StubFile, error) {
+	files := []StubFile{}
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if entry == STUB_STDIN {
+			return nil, fmt.Errorf("stubs from stdin aren't files, save them first")
+		}
+		if IsStubURL(entry) {
+			return nil, fmt.Errorf("stubs from %s aren't files, fetch them first", entry)
+		}
+		found, err := findStubFiles(entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range found {
+			if !seen[f.Path] {
+				seen[f.Path] = true
+				files = append(files, f)
+			}
+		}
+	}
+	return files, nil
+}
+
+// The stubs of the stub files of a -stub list, as -stub loads them, see
+// ReadStubs. Fragments are only read where they're included.
+func ReadStubFiles(spec string, templates bool) ([]*Stub, error) {
+	files, err := FindStubFiles(spec)
+	if err != nil {
+		return nil, err
+	}
+	stubs := []*Stub{}
+	for _, file := range files {
+		if isFragment(file.Rel) {
+			continue
+		}
+		byt, err := os.ReadFile(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		read, err := ReadStubs(file.Path, byt, templates)
+		if err != nil {
+			return nil, err
+		}
+		stubs = append(stubs, read...)
+	}
+	return stubs, nil
+}
+
+// The stubs of a stub document, rendered as a template if templates is set
+// and with its includes and extends resolved. name is the file it came
+// from, which includes are relative to.
+func ReadStubs(name string, byt []byte, templates bool) ([]*Stub, error) {
+	stubTemplates = templates
+	byt, err := renderStubFile(name, byt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	resolved, unresolved := resolveIncludes(name, byt)
+	if err := unresolved[-1]; err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	stubs, err := parseStubs(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for i := range stubs {
+		if err := unresolved[i]; err != nil {
+			return nil, fmt.Errorf("%s stub %d: %w", name, i, err)
+		}
+	}
+	return stubs, nil
+}
+
+// The stub files of one directory, file or pattern
+func findStubFiles(entry string) ([]StubFile, error) {
+	if !hasGlob(entry) {
+		info, err := os.Stat(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return []StubFile{{Path: entry, Rel: filepath.Base(entry)}}, nil
+		}
+		return walkStubFiles(entry, func(string) bool { return true })
+	}
+
+	pattern := filepath.ToSlash(entry)
+	segments := strings.Split(pattern, "/")
+	base := []string{}
+	for len(segments) > 1 && !hasGlob(segments[0]) {
+		base = append(base, segments[0])
+		segments = segments[1:]
+	}
+	for _, seg := range segments {
+		if _, err := path.Match(seg, ""); err != nil {
+			return nil, fmt.Errorf("invalid stub pattern %s: %w", entry, err)
+		}
+	}
+	dir := "."
+	if len(base) > 0 {
+		dir = filepath.FromSlash(strings.Join(base, "/"))
+		if dir == "" {
+			// the pattern is absolute
+			dir = "/"
+		}
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	found, err := walkStubFiles(dir, func(rel string) bool {
+		return matchGlob(segments, strings.Split(rel, "/"))
+	})
+	if err == nil && len(found) == 0 {
+		err = fmt.Errorf("no stub files match %s", entry)
+	}
+	return found, err
+}
+
+// The files in dir's tree whose slash separated paths below it match
+func walkStubFiles(dir string, match func(rel string) bool) ([]StubFile, error) {
+	found := []StubFile{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if match(rel) {
+			found = append(found, StubFile{Path: p, Rel: rel})
+		}
+		return nil
+	})
+	return found, err
+}
+
+func hasGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// Match path segments against pattern segments, where a ** segment matches
+// any number of path segments
+func matchGlob(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchGlob(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
@@ -0,0 +1,109 @@
+package stub
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindStubFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"users/get.json",
+		"users/v2/list.json",
+		"orders/create.json",
+		"orders/README.md",
+		"shared.json",
+		".git/config",
+		"users/.draft.json",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte("[]"), 0644))
+	}
+	rels := func(spec string) []string {
+		files, err := FindStubFiles(spec)
+		require.NoError(t, err, spec)
+		found := []string{}
+		for _, f := range files {
+			found = append(found, f.Rel)
+		}
+		return found
+	}
+
+	assert.Equal(t, []string{"orders/README.md", "orders/create.json", "shared.json", "users/get.json", "users/v2/list.json"}, rels(dir))
+	assert.Equal(t, []string{"shared.json"}, rels(filepath.Join(dir, "shared.json")))
+	assert.Equal(t, []string{"orders/create.json", "shared.json", "users/get.json", "users/v2/list.json"}, rels(dir+"/**/*.json"))
+	assert.Equal(t, []string{"get.json", "v2/list.json"}, rels(dir+"/users/**"))
+	assert.Equal(t, []string{"orders/create.json", "users/get.json"}, rels(dir+"/*/*.json"))
+	// repeats are left out
+	assert.Equal(t, []string{"list.json", "get.json"}, rels(dir+"/users/v2/list.json, "+dir+"/users/**"))
+
+	_, err := FindStubFiles(dir + "/*/*.yaml")
+	assert.EqualError(t, err, "no stub files match "+dir+"/*/*.yaml")
+	_, err = FindStubFiles(dir + "/[a-")
+	assert.True(t, err != nil && strings.HasPrefix(err.Error(), "invalid stub pattern"), err)
+	_, err = FindStubFiles(dir + "/missing")
+	assert.Error(t, err)
+
+	// every match is loaded
+	sm := stubMapping{}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "users/v2/list.json"),
+		[]byte(`{"service":"Users","method":"List","input":{"contains":{}},"output":{"data":{}}}`), 0644))
+	sm.readStubFromFile(dir + "/users/**")
+	assert.Len(t, sm["Users"]["List"], 1)
+}
+
+func Test_matchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"*.json", "a.json", true},
+		{"*.json", "x/a.json", false},
+		{"**", "x/y/a.json", true},
+		{"**/*.json", "a.json", true},
+		{"**/*.json", "x/y/a.json", true},
+		{"x/**/a.json", "x/a.json", true},
+		{"x/**/a.json", "x/y/z/a.json", true},
+		{"x/**/a.json", "y/a.json", false},
+		{"x/*/a.json", "x/y/z/a.json", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, matchGlob(strings.Split(tt.pattern, "/"), strings.Split(tt.path, "/")), tt.pattern+" "+tt.path)
+	}
+}
+
+func TestReadStubFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	write("_base/greeter.json", `{"service": "Greeter", "method": "SayHello", "output": {"data": {"message": "hi"}}}`)
+	write("hello.json", `[
+		{"extends": "_base/greeter.json", "input": {"equals": {"name": "bob"}}},
+		{"service": "Greeter", "method": "SayBye", "input": {"contains": {}}, "output": {"data": {"to": "${GRIPMOCK_TEST_NAME}"}}}
+	]`)
+	t.Setenv("GRIPMOCK_TEST_NAME", "eve")
+	defer func() { stubTemplates = false }()
+
+	stubs, err := ReadStubFiles(dir, true)
+	require.NoError(t, err)
+	require.Len(t, stubs, 2, "fragments aren't stubs")
+	assert.Equal(t, "SayHello", stubs[0].Method)
+	assert.Equal(t, "hi", stubs[0].Output.Data["message"])
+	assert.Equal(t, "eve", stubs[1].Output.Data["to"])
+
+	write("bad.json", `{"extends": "_base/missing.json"}`)
+	_, err = ReadStubFiles(filepath.Join(dir, "bad.json"), false)
+	assert.ErrorContains(t, err, "bad.json stub 0: ")
+	_, err = ReadStubs("stdin", []byte("{"), false)
+	assert.ErrorContains(t, err, "stdin: ")
+}
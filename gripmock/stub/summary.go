@@ -0,0 +1,157 @@
+package stub
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ * Latency and error summary.
+ *
+ * Stubs can inject delays and errors, and the generated server reports each
+ * call's status code and duration as it finishes (see inflight.go). The
+ * summary on /summary adds those up per method since startup or the last
+ * state reset: how many calls finished, latency percentiles, and how many
+ * ended with each status code. That's the ground truth of what the mock
+ * actually served, to check a client's retries, timeouts and error budget
+ * handling against.
+ *
+ * Percentiles are over the last SUMMARY_SAMPLES calls of each method, while
+ * the counts cover every call.
+ */
+
+// Durations kept per method for the latency percentiles
+const SUMMARY_SAMPLES = 10000
+
+type methodSummary struct {
+	// finished calls
+	Calls int `json:"calls"`
+	// calls that ended with a status other than OK, and their share of
+	// the calls
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	// calls by status code name
+	Codes   map[string]int  `json:"codes"`
+	Latency *latencySummary `json:"latency,omitempty"`
+}
+
+// go duration strings, like the journal's latencies
+type latencySummary struct {
+	Min  string `json:"min"`
+	Mean string `json:"mean"`
+	P50  string `json:"p50"`
+	P90  string `json:"p90"`
+	P95  string `json:"p95"`
+	P99  string `json:"p99"`
+	Max  string `json:"max"`
+	// calls the percentiles are over
+	Samples int `json:"samples"`
+}
+
+type methodOutcomes struct {
+	calls int
+	codes map[StatusCode]int
+	// ring of the last SUMMARY_SAMPLES durations, next is where the next
+	// one goes
+	samples []time.Duration
+	next    int
+}
+
+type callSummary struct {
+	mx      sync.Mutex
+	methods map[string]*methodOutcomes
+}
+
+var summary = &callSummary{methods: map[string]*methodOutcomes{}}
+
+// Count a finished call
+func (s *callSummary) add(c callReport) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	m, ok := s.methods[c.Method]
+	if !ok {
+		m = &methodOutcomes{codes: map[StatusCode]int{}}
+		s.methods[c.Method] = m
+	}
+	m.calls++
+	m.codes[c.Code]++
+	// reported by the gRPC server with time.Duration.String()
+	latency, err := time.ParseDuration(c.Latency)
+	if err != nil {
+		return
+	}
+	if len(m.samples) < SUMMARY_SAMPLES {
+		m.samples = append(m.samples, latency)
+	} else {
+		m.samples[m.next] = latency
+	}
+	m.next = (m.next + 1) % SUMMARY_SAMPLES
+}
+
+func (s *callSummary) reset() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.methods = map[string]*methodOutcomes{}
+}
+
+// Summarise the calls of each method, keyed by method
+func (s *callSummary) snapshot() map[string]methodSummary {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	snap := make(map[string]methodSummary, len(s.methods))
+	for method, m := range s.methods {
+		sum := methodSummary{Calls: m.calls, Codes: map[string]int{}}
+		for code, n := range m.codes {
+			sum.Codes[code.String()] = n
+			if code != 0 {
+				sum.Errors += n
+			}
+		}
+		if m.calls > 0 {
+			sum.ErrorRate = float64(sum.Errors) / float64(m.calls)
+		}
+		sum.Latency = summarizeLatency(m.samples)
+		snap[method] = sum
+	}
+	return snap
+}
+
+func summarizeLatency(samples []time.Duration) *latencySummary {
+	if len(samples) == 0 {
+		return nil
+	}
+	sorted := append([]time.Duration{}, samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	return &latencySummary{
+		Min:     sorted[0].String(),
+		Mean:    (total / time.Duration(len(sorted))).String(),
+		P50:     percentile(sorted, 50).String(),
+		P90:     percentile(sorted, 90).String(),
+		P95:     percentile(sorted, 95).String(),
+		P99:     percentile(sorted, 99).String(),
+		Max:     sorted[len(sorted)-1].String(),
+		Samples: len(sorted),
+	}
+}
+
+// The nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func listSummary(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary.snapshot())
+}
@@ -0,0 +1,73 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{}
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, percentile(sorted, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(sorted, 99))
+	assert.Equal(t, 100*time.Millisecond, percentile(sorted, 100))
+	assert.Equal(t, 7*time.Millisecond, percentile([]time.Duration{7 * time.Millisecond}, 50))
+}
+
+func TestSummary(t *testing.T) {
+	summary = &callSummary{methods: map[string]*methodOutcomes{}}
+	defer summary.reset()
+
+	report := func(code int, latency string) {
+		wrt := httptest.NewRecorder()
+		payload := fmt.Sprintf(`{"method":"/pkg.Svc/Get","type":"unary","delta":-1,"code":%d,"latency":%q}`, code, latency)
+		reportCall(wrt, httptest.NewRequest("POST", "/inflight", bytes.NewReader([]byte(payload))))
+		require.Equal(t, "OK", wrt.Body.String())
+	}
+	for i := 1; i <= 8; i++ {
+		report(0, fmt.Sprintf("%dms", i*10))
+	}
+	report(14, "1s")
+	report(14, "")
+
+	list := func() map[string]methodSummary {
+		wrt := httptest.NewRecorder()
+		listSummary(wrt, httptest.NewRequest("GET", "/summary", nil))
+		var sums map[string]methodSummary
+		require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &sums))
+		return sums
+	}
+	sum := list()["/pkg.Svc/Get"]
+	assert.Equal(t, 10, sum.Calls)
+	assert.Equal(t, 2, sum.Errors)
+	assert.Equal(t, 0.2, sum.ErrorRate)
+	assert.Equal(t, map[string]int{"OK": 8, "UNAVAILABLE": 2}, sum.Codes)
+	require.NotNil(t, sum.Latency)
+	assert.Equal(t, latencySummary{
+		Min: "10ms", Mean: "151.111111ms", P50: "50ms", P90: "1s", P95: "1s", P99: "1s", Max: "1s", Samples: 9,
+	}, *sum.Latency, "the call without a latency isn't in the percentiles")
+
+	resetState()
+	assert.Empty(t, list())
+}
+
+func TestSummarySamples(t *testing.T) {
+	s := &callSummary{methods: map[string]*methodOutcomes{}}
+	for i := 0; i < SUMMARY_SAMPLES+10; i++ {
+		s.add(callReport{Method: "/pkg.Svc/Get", Latency: "1ms"})
+	}
+	s.add(callReport{Method: "/pkg.Svc/Get", Latency: "5s"})
+	sum := s.snapshot()["/pkg.Svc/Get"]
+	assert.Equal(t, SUMMARY_SAMPLES+11, sum.Calls)
+	assert.Equal(t, SUMMARY_SAMPLES, sum.Latency.Samples)
+	assert.Equal(t, "5s", sum.Latency.Max)
+}
@@ -0,0 +1,123 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/*
+ * Stub file templates.
+ *
+ * With Options.StubTemplates, stub files are rendered before they're parsed,
+ * so environment specific values such as hostnames, IDs and dates can be
+ * put in when they're loaded instead of generating the files out of band.
+ * First ${NAME} is replaced by the environment variable NAME, or
+ * ${NAME:-default} by its default if NAME is unset or empty; $${ is a
+ * literal ${. Then the file is run as a Go text/template, with the
+ * functions in stubTemplateFuncs, e.g.
+ *
+ *   {"expires": "{{ (now.AddDate 0 0 7).Format "2006-01-02" }}"}
+ *
+ * Each file is rendered once, when it's loaded.
+ */
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// render stub files before parsing them, see Options.StubTemplates
+var stubTemplates bool
+
+var stubTemplateFuncs = template.FuncMap{
+	// the environment variable, or the default if it's unset or empty
+	"env": func(name string, def ...string) string {
+		if v := os.Getenv(name); v != "" || len(def) == 0 {
+			return v
+		}
+		return def[0]
+	},
+	"now": time.Now,
+	// a random UUID, as generated for stub IDs
+	"uuid": newStubID,
+	// the value as JSON, e.g. to quote a string that may need escaping
+	"json": func(v interface{}) (string, error) {
+		byt, err := json.Marshal(v)
+		return string(byt), err
+	},
+}
+
+// Render a stub file if templates are on
+func renderStubFile(path string, byt []byte) ([]byte, error) {
+	if !stubTemplates {
+		return byt, nil
+	}
+	expanded, err := expandEnv(byt)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(path).Funcs(stubTemplateFuncs).Parse(string(expanded))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Replace ${NAME} and ${NAME:-default} with environment variables. Every
+// variable that's unset, with no default, is reported; one that's set but
+// empty is replaced by nothing.
+func expandEnv(byt []byte) ([]byte, error) {
+	var out bytes.Buffer
+	missing := []string{}
+	for {
+		i := bytes.Index(byt, []byte("${"))
+		if i < 0 {
+			out.Write(byt)
+			break
+		}
+		if i > 0 && byt[i-1] == '$' {
+			// $${ is a literal ${
+			out.Write(byt[:i-1])
+			out.WriteString("${")
+			byt = byt[i+2:]
+			continue
+		}
+		end := bytes.IndexByte(byt[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated ${ at %q", truncate(string(byt[i:]), 20))
+		}
+		out.Write(byt[:i])
+		name, def, hasDefault := strings.Cut(string(byt[i+2:i+end]), ":-")
+		if !envName.MatchString(name) {
+			return nil, fmt.Errorf("invalid environment variable name %q", name)
+		}
+		v, set := os.LookupEnv(name)
+		switch {
+		case v != "":
+			out.WriteString(v)
+		case hasDefault:
+			out.WriteString(def)
+		case !set:
+			missing = append(missing, name)
+		}
+		byt = byt[i+end+1:]
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return out.Bytes(), nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,64 @@
+package stub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderStubFile(t *testing.T) {
+	defer func() { stubTemplates = false }()
+	t.Setenv("GRIPMOCK_TEST_HOST", "api.example.com")
+	t.Setenv("GRIPMOCK_TEST_EMPTY", "")
+
+	raw := []byte(`{"host":"${GRIPMOCK_TEST_HOST}"}`)
+	byt, err := renderStubFile("stubs.json", raw)
+	require.NoError(t, err)
+	assert.Equal(t, raw, byt, "left alone unless templates are on")
+
+	stubTemplates = true
+	for doc, want := range map[string]string{
+		`{"host":"${GRIPMOCK_TEST_HOST}"}`:                        `{"host":"api.example.com"}`,
+		`{"port":"${GRIPMOCK_TEST_PORT:-443}"}`:                   `{"port":"443"}`,
+		`{"empty":"${GRIPMOCK_TEST_EMPTY}"}`:                      `{"empty":""}`,
+		`{"empty":"${GRIPMOCK_TEST_EMPTY:-x}"}`:                   `{"empty":"x"}`,
+		`{"literal":"$${GRIPMOCK_TEST_HOST}"}`:                    `{"literal":"${GRIPMOCK_TEST_HOST}"}`,
+		`{"host":{{ env "GRIPMOCK_TEST_HOST" | json }}}`:          `{"host":"api.example.com"}`,
+		`{"region":"{{ env "GRIPMOCK_TEST_REGION" "eu-west" }}"}`: `{"region":"eu-west"}`,
+		`{"year":"{{ now.Year }}"}`:                               `{"year":"` + time.Now().Format("2006") + `"}`,
+	} {
+		byt, err := renderStubFile("stubs.json", []byte(doc))
+		require.NoError(t, err, doc)
+		assert.Equal(t, want, string(byt), doc)
+	}
+
+	byt, err = renderStubFile("stubs.json", []byte(`{"id":"{{ uuid }}"}`))
+	require.NoError(t, err)
+	assert.Regexp(t, `^\{"id":"[0-9a-f-]{36}"\}$`, string(byt))
+
+	_, err = renderStubFile("stubs.json", []byte(`{"a":"${GRIPMOCK_TEST_A}","b":"${GRIPMOCK_TEST_B}"}`))
+	assert.EqualError(t, err, "environment variables not set: GRIPMOCK_TEST_A, GRIPMOCK_TEST_B")
+	_, err = renderStubFile("stubs.json", []byte(`{"a":"${GRIPMOCK_TEST_A"}`))
+	assert.EqualError(t, err, `invalid environment variable name "GRIPMOCK_TEST_A\""`)
+	_, err = renderStubFile("stubs.json", []byte(`"${GRIPMOCK_TEST_A`))
+	assert.EqualError(t, err, `unterminated ${ at "${GRIPMOCK_TEST_A"`)
+	_, err = renderStubFile("stubs.json", []byte(`{"a":"{{ bogus }}"}`))
+	assert.ErrorContains(t, err, `template: stubs.json:1: function "bogus" not defined`)
+
+	// files are rendered when they're loaded
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello.json"), []byte(`{
+		"service": "Greeter", "method": "SayHello",
+		"input": {"equals": {"host": "${GRIPMOCK_TEST_HOST}"}},
+		"output": {"data": {"message": "hi from {{ env "GRIPMOCK_TEST_HOST" }}"}}
+	}`), 0644))
+	sm := stubMapping{}
+	sm.readStubFromFile(dir)
+	require.Len(t, sm["Greeter"]["SayHello"], 1)
+	assert.Equal(t, "api.example.com", sm["Greeter"]["SayHello"][0].Input.Equals["host"])
+	assert.Equal(t, "hi from api.example.com", sm["Greeter"]["SayHello"][0].Output.Data["message"])
+}
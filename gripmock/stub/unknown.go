@@ -0,0 +1,37 @@
+package stub
+
+/*
+ * Catch-all stubs for unknown methods.
+ *
+ * The gRPC server answers calls to methods that aren't in its protos
+ * instead of failing them at the transport level. It has no descriptor for
+ * them, so the request is only raw bytes, matched as {"raw": "<base64>"},
+ * and the response is the stub's raw output: base64 protobuf bytes of the
+ * response message, or nothing for an empty message. Errors, headers,
+ * trailers and delays work as for any stub.
+ *
+ * The call's service and method are looked up first, so a stub can name an
+ * unknown method, then catch-all stubs with method "*" for any method of
+ * the service, then with service and method "*" for any unknown call.
+ */
+
+// service or method of a catch-all stub
+const STUB_WILDCARD = "*"
+
+// Find the stub for a call to an unknown method: one for the method, or
+// else a catch-all one
+func findUnknownStub(call *findStubPayload) (*storage, error) {
+	match, err := findStub(call)
+	if err == nil {
+		return match, nil
+	}
+	for _, wild := range [][2]string{{call.Service, STUB_WILDCARD}, {STUB_WILDCARD, STUB_WILDCARD}} {
+		catchAll := *call
+		catchAll.Service, catchAll.Method = wild[0], wild[1]
+		if match, wildErr := findStub(&catchAll); wildErr == nil {
+			return match, nil
+		}
+	}
+	// the error for the method itself is the most helpful
+	return nil, err
+}
@@ -0,0 +1,39 @@
+package stub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindUnknownStub(t *testing.T) {
+	clearStorage()
+	defer clearStorage()
+	anyInput := Input{Contains: map[string]interface{}{}}
+	require.NoError(t, storeStub(&Stub{ID: "greeter", Service: "Greeter", Method: STUB_WILDCARD, Input: anyInput, Output: Output{Raw: "CgJoaQ=="}}))
+	require.NoError(t, storeStub(&Stub{ID: "any", Service: STUB_WILDCARD, Method: STUB_WILDCARD, Input: anyInput, Output: Output{Error: "gone", Code: 5}}))
+	require.NoError(t, storeStub(&Stub{ID: "named", Service: "Greeter", Method: "SayHowdy", Input: anyInput, Output: Output{Raw: "CgVob3dkeQ=="}}))
+
+	raw := map[string]interface{}{"raw": "CgNib2I="}
+	lookup := func(service, method string, unknown bool) (string, Output, error) {
+		id, output, _, err := lookupStub(&findStubPayload{Service: service, Method: method, Data: raw, Unknown: unknown})
+		return id, output, err
+	}
+	id, output, err := lookup("Greeter", "SayHi", true)
+	require.NoError(t, err)
+	assert.Equal(t, "greeter", id, "catch-all for the service")
+	assert.Equal(t, "CgJoaQ==", output.Raw)
+	id, _, err = lookup("Greeter", "SayHowdy", true)
+	require.NoError(t, err)
+	assert.Equal(t, "named", id, "a stub for the method itself comes first")
+	id, _, err = lookup("Orders", "List", true)
+	require.NoError(t, err)
+	assert.Equal(t, "any", id)
+
+	// calls to known methods don't fall back to catch-all stubs
+	_, _, err = lookup("Orders", "List", false)
+	assert.ErrorContains(t, err, "Can't find stub for Service: Orders")
+
+	assert.ErrorContains(t, validateOutput(Output{Raw: "not base64!"}), "Output raw must be base64")
+}
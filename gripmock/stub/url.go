@@ -0,0 +1,149 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+ * Stubs from URLs.
+ *
+ * -stub entries that are http:// or https:// URLs are fetched at startup,
+ * so CI jobs can pull a stub bundle from an artifact store instead of
+ * baking it into the image. The body is anything POST /import takes: stub
+ * JSON, or a tar, tar.gz or zip archive of stub files. Each is loaded as a
+ * stub file is, with the schema check, templates and includes, and its
+ * stubs count as loaded from -stub, so they aren't saved, see persist.go.
+ * POST /import/url does the same at runtime, with the URL in a JSON body:
+ *
+ *   {"url": "https://artifacts.example.com/stubs/orders.tar.gz"}
+ */
+
+// How long fetching a stub URL may take
+const STUB_FETCH_TIMEOUT = 30 * time.Second
+
+var stubFetchClient = &http.Client{Timeout: STUB_FETCH_TIMEOUT}
+
+// Whether a -stub entry is a URL rather than a path
+func IsStubURL(entry string) bool {
+	return strings.HasPrefix(entry, "http://") || strings.HasPrefix(entry, "https://")
+}
+
+// Fetch a stub document or archive
+func fetchStubURL(url string) ([]byte, error) {
+	resp, err := stubFetchClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Load the stubs from a -stub URL, skipping invalid ones as for a file
+func (sm *stubMapping) readStubURL(url string) {
+	body, err := fetchStubURL(url)
+	if err != nil {
+		log.Printf("Can't read stub from %s. %v\n", url, err)
+		return
+	}
+	files, archive, err := archiveStubFiles(body)
+	if err != nil {
+		log.Printf("Error when reading stubs from %s. %v. skipping...", url, err)
+		return
+	}
+	if !archive {
+		files = []stubFile{{url, body}}
+	} else {
+		for i := range files {
+			files[i].name = url + "#" + files[i].name
+		}
+	}
+
+	before := sm.ids()
+	for _, f := range files {
+		byt, err := renderStubFile(f.name, f.byt)
+		if err != nil {
+			log.Printf("Error when rendering %s. %v. skipping...", f.name, err)
+			continue
+		}
+		sm.loadStubs(f.name, byt)
+	}
+	// they came from -stub, so aren't saved, see persist.go
+	persistMx.Lock()
+	defer persistMx.Unlock()
+	for id := range sm.ids() {
+		if !before[id] {
+			loadedStubs[id] = true
+		}
+	}
+}
+
+// The IDs of the stubs in sm
+func (sm stubMapping) ids() map[string]bool {
+	mx.Lock()
+	defer mx.Unlock()
+	ids := map[string]bool{}
+	for _, methods := range sm {
+		for _, stubs := range methods {
+			for _, s := range stubs {
+				ids[s.ID] = true
+			}
+		}
+	}
+	return ids
+}
+
+// Store the stubs of an import read at startup, skipping invalid ones
+func (sm *stubMapping) storeImported(source string, stubs []importedStub) {
+	for _, s := range stubs {
+		if err := validateOutput(s.stub.Output); err != nil {
+			log.Printf("Invalid %s from %s. %v. skipping...", s.from, source, err)
+			continue
+		}
+		if err := sm.storeStub(s.stub); err != nil {
+			log.Printf("Can't store %s from %s. %v. skipping...", s.from, source, err)
+		}
+	}
+}
+
+// Import the stubs at a URL, as POST /import would with what it serves
+func handleImportURL(w http.ResponseWriter, r *http.Request) {
+	replace, ok := importReplace(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Invalid import request: %v", err)))
+		return
+	}
+	if !IsStubURL(req.URL) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Invalid url \"%s\", must be http:// or https://", req.URL)))
+		return
+	}
+	body, err := fetchStubURL(req.URL)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	stubs, err := parseImport(body)
+	if err != nil {
+		w.WriteHeader(importErrorStatus(err))
+		w.Write([]byte(fmt.Sprintf("%s: %v", req.URL, err)))
+		return
+	}
+	finishImport(w, r, stubs, replace, importResult{})
+}
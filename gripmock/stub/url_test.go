@@ -0,0 +1,112 @@
+package stub
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStubURL(t *testing.T) {
+	defer clearStorage()
+	clearStorage()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stubs.json":
+			w.Write([]byte(`[
+				{"id":"hello","service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{"message":"hi"}}},
+				{"service":"Greeter","method":"SayBye","input":{"contains":{}},"output":{"data":{}}}
+			]`))
+		case "/bad.json":
+			w.Write([]byte(`{"service":`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	sm := stubMapping{}
+	sm.readStubFromFile(srv.URL + "/stubs.json," + srv.URL + "/missing.json")
+	require.Len(t, sm["Greeter"]["SayHello"], 1)
+	assert.Equal(t, "hello", sm["Greeter"]["SayHello"][0].ID)
+	assert.Len(t, sm["Greeter"]["SayBye"], 1)
+
+	_, err := FindStubFiles(srv.URL + "/stubs.json")
+	assert.ErrorContains(t, err, "aren't files")
+
+	importURL := func(query, body string) *httptest.ResponseRecorder {
+		wrt := httptest.NewRecorder()
+		handleImportURL(wrt, httptest.NewRequest("POST", "/import/url"+query, bytes.NewReader([]byte(body))))
+		return wrt
+	}
+	wrt := importURL("", `{"url":"`+srv.URL+`/stubs.json"}`)
+	require.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+	assert.Contains(t, wrt.Body.String(), `"imported":2`)
+	assert.Len(t, listStubs(), 2)
+
+	wrt = importURL("", `{"url":"`+srv.URL+`/stubs.json"}`)
+	assert.Equal(t, http.StatusConflict, wrt.Code, "the IDs are in use")
+	wrt = importURL("?replace=true", `{"url":"`+srv.URL+`/stubs.json"}`)
+	assert.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+	assert.Len(t, listStubs(), 2)
+
+	wrt = importURL("", `{"url":"`+srv.URL+`/missing.json"}`)
+	assert.Equal(t, http.StatusBadGateway, wrt.Code)
+	assert.Equal(t, "fetching "+srv.URL+"/missing.json: 404 Not Found", wrt.Body.String())
+	wrt = importURL("", `{"url":"`+srv.URL+`/bad.json"}`)
+	assert.Equal(t, http.StatusBadRequest, wrt.Code)
+	wrt = importURL("", `{"url":"file:///etc/passwd"}`)
+	assert.Equal(t, http.StatusBadRequest, wrt.Code)
+	assert.Equal(t, `Invalid url "file:///etc/passwd", must be http:// or https://`, wrt.Body.String())
+}
+
+func TestStubURLLoadedAsFile(t *testing.T) {
+	defer clearStorage()
+	defer func() {
+		stubTemplates = false
+		stubValidation = ""
+		loadedStubs = map[string]bool{}
+	}()
+	stubTemplates = true
+	stubValidation = VALIDATION_REJECT
+	t.Setenv("GRIPMOCK_TEST_GREETING", "hi")
+
+	var tarball bytes.Buffer
+	gz := gzip.NewWriter(&tarball)
+	tw := tar.NewWriter(gz)
+	content := `{"id":"archived","service":"Greeter","method":"SayBye","input":{"contains":{}},"output":{"data":{}}}`
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "stubs/bye.json", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	tw.Write([]byte(content))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stubs.json":
+			w.Write([]byte(`[
+				{"id":"templated","service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{"message":"${GRIPMOCK_TEST_GREETING}"}}},
+				{"id":"misspelt","service":"Greeter","method":"SayHello","input":{"equal":{}},"output":{"data":{}}}
+			]`))
+		case "/stubs.tar.gz":
+			w.Write(tarball.Bytes())
+		}
+	}))
+	defer srv.Close()
+
+	clearStorage()
+	loadedStubs = map[string]bool{}
+	stubStorage.readStubFromFile(srv.URL + "/stubs.json," + srv.URL + "/stubs.tar.gz")
+	s, err := getStub("templated")
+	require.NoError(t, err)
+	assert.Equal(t, "hi", s.Output.Data["message"])
+	_, err = getStub("misspelt")
+	assert.Error(t, err, "rejected by the stub schema")
+	_, err = getStub("archived")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"templated": true, "archived": true}, loadedStubs, "not saved as runtime stubs")
+}
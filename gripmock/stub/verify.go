@@ -0,0 +1,282 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Call verification.
+ *
+ * Every stub lookup the gRPC server makes is counted, by method and by the
+ * stub that matched it, and kept in a bounded call log. Test frameworks can
+ * read the counts on /verify/counts, or POST expectations such as "SayHello
+ * was called exactly 3 times with a name matching ^bob" to /verify and get a
+ * pass/fail result for each.
+ *
+ * Unary, server-streaming and client-streaming calls are looked up once per
+ * call. Bidirectional streams are looked up once per message received, so
+ * count once per message. Lookups that carry on without a stub, like the
+ * one made when a bidirectional stream opens, aren't counted.
+ */
+
+// Number of calls kept in the log before the oldest are dropped. Counts are
+// kept regardless, but expectations on the input can only see logged calls.
+const CALL_LOG_SIZE = 10000
+
+type recordedCall struct {
+	Time    time.Time
+	Service string
+	Method  string
+	Data    map[string]interface{}
+	// ID of the matched stub, empty if none matched
+	StubID string
+}
+
+type methodCalls struct {
+	Calls int `json:"calls"`
+	// calls no stub matched
+	Unmatched int `json:"unmatched"`
+}
+
+type callHistory struct {
+	mx      sync.Mutex
+	log     []recordedCall
+	dropped int
+	// keyed by "<service>/<method>"
+	methods map[string]*methodCalls
+	// keyed by stub ID
+	stubs map[string]int
+}
+
+var calls = newCallHistory()
+
+func newCallHistory() *callHistory {
+	return &callHistory{methods: map[string]*methodCalls{}, stubs: map[string]int{}}
+}
+
+func (h *callHistory) record(c recordedCall) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if c.Time.IsZero() {
+		c.Time = time.Now()
+	}
+	h.log = append(h.log, c)
+	if len(h.log) > CALL_LOG_SIZE {
+		h.dropped += len(h.log) - CALL_LOG_SIZE
+		h.log = h.log[len(h.log)-CALL_LOG_SIZE:]
+	}
+	key := c.Service + "/" + c.Method
+	m, ok := h.methods[key]
+	if !ok {
+		m = &methodCalls{}
+		h.methods[key] = m
+	}
+	m.Calls++
+	if c.StubID == "" {
+		m.Unmatched++
+	} else {
+		h.stubs[c.StubID]++
+	}
+}
+
+type callCounts struct {
+	Methods map[string]methodCalls `json:"methods"`
+	Stubs   map[string]int         `json:"stubs"`
+}
+
+func (h *callHistory) counts() callCounts {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	counts := callCounts{Methods: map[string]methodCalls{}, Stubs: map[string]int{}}
+	for k, m := range h.methods {
+		counts.Methods[k] = *m
+	}
+	for k, n := range h.stubs {
+		counts.Stubs[k] = n
+	}
+	return counts
+}
+
+// Expected number of calls to a method, or to a stub. Every rule that is
+// set must hold; without any, at least one call is expected.
+type Expectation struct {
+	// method the calls were to; may be left out if StubID is set
+	Service string `json:"service,omitempty"`
+	Method  string `json:"method,omitempty"`
+	// only count calls that matched this stub
+	StubID string `json:"stub_id,omitempty"`
+	// only count calls with a message that matches these rules
+	Input *Input `json:"input,omitempty"`
+	// exact number of calls
+	Count *int `json:"count,omitempty"`
+	// bounds on the number of calls, inclusive
+	MinCount *int `json:"min_count,omitempty"`
+	MaxCount *int `json:"max_count,omitempty"`
+}
+
+type VerifyResult struct {
+	Pass     bool   `json:"pass"`
+	Actual   int    `json:"actual"`
+	Expected string `json:"expected"`
+	Message  string `json:"message"`
+	// calls were dropped from the log, so calls matching the input may
+	// have been missed
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+func validateExpectation(e *Expectation) error {
+	if e.StubID == "" && (e.Service == "" || e.Method == "") {
+		return fmt.Errorf("Expectation needs a service and method, or a stub_id")
+	}
+	if e.Count != nil && (e.MinCount != nil || e.MaxCount != nil) {
+		return fmt.Errorf("Expectation count can't be combined with min_count or max_count")
+	}
+	if e.Input != nil {
+		if e.Input.Stream != nil || e.Input.Deadline != nil {
+			return fmt.Errorf("Expectation input only supports equals, contains, matches and wasm rules")
+		}
+		if err := validateWasmFunc("Expectation input", e.Input.Wasm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Describe the number of calls expected
+func (e *Expectation) describe() string {
+	switch {
+	case e.Count != nil:
+		return fmt.Sprintf("exactly %d", *e.Count)
+	case e.MinCount != nil && e.MaxCount != nil:
+		return fmt.Sprintf("between %d and %d", *e.MinCount, *e.MaxCount)
+	case e.MinCount != nil:
+		return fmt.Sprintf("at least %d", *e.MinCount)
+	case e.MaxCount != nil:
+		return fmt.Sprintf("at most %d", *e.MaxCount)
+	}
+	return "at least 1"
+}
+
+func (e *Expectation) holds(n int) bool {
+	switch {
+	case e.Count != nil:
+		return n == *e.Count
+	case e.MinCount == nil && e.MaxCount == nil:
+		return n >= 1
+	}
+	return (e.MinCount == nil || n >= *e.MinCount) && (e.MaxCount == nil || n <= *e.MaxCount)
+}
+
+func (h *callHistory) verify(e *Expectation) VerifyResult {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	result := VerifyResult{Expected: e.describe()}
+	subject := e.Service + "/" + e.Method
+	if e.StubID != "" {
+		subject = "stub " + e.StubID
+	}
+	switch {
+	case e.Input == nil && e.Service == "":
+		result.Actual = h.stubs[e.StubID]
+	case e.Input == nil && e.StubID == "":
+		if m, ok := h.methods[e.Service+"/"+e.Method]; ok {
+			result.Actual = m.Calls
+		}
+	default:
+		for _, c := range h.log {
+			if e.Service != "" && (c.Service != e.Service || c.Method != e.Method) {
+				continue
+			}
+			if e.StubID != "" && c.StubID != e.StubID {
+				continue
+			}
+			if e.Input != nil && !inputMatches(*e.Input, c.Data) {
+				continue
+			}
+			result.Actual++
+		}
+		result.Incomplete = h.dropped > 0
+	}
+	if e.Input != nil {
+		subject += " with matching input"
+	}
+
+	result.Pass = e.holds(result.Actual)
+	times := "times"
+	if result.Actual == 1 {
+		times = "time"
+	}
+	verdict := "as expected"
+	if !result.Pass {
+		verdict = "but expected " + result.Expected
+	}
+	result.Message = fmt.Sprintf("%s was called %d %s, %s", subject, result.Actual, times, verdict)
+	return result
+}
+
+func listCallCounts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(callsFor(requestSession(r)).counts())
+}
+
+// Check one expectation, or a list of them. A list gets back an overall
+// result with the result for each expectation in order.
+func handleVerify(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responseError(err, w)
+		return
+	}
+	body = bytes.TrimSpace(body)
+	list := len(body) > 0 && body[0] == '['
+	expectations := []*Expectation{}
+	if list {
+		err = json.Unmarshal(body, &expectations)
+	} else {
+		e := new(Expectation)
+		err = json.Unmarshal(body, e)
+		expectations = append(expectations, e)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	history := callsFor(requestSession(r))
+	results := []VerifyResult{}
+	pass := true
+	for i, e := range expectations {
+		if err := validateExpectation(e); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			if list {
+				err = fmt.Errorf("expectation %d: %w", i, err)
+			}
+			w.Write([]byte(err.Error()))
+			return
+		}
+		// due to golang implementation, method names are capitalized
+		e.Method = strings.Title(e.Method)
+		result := history.verify(e)
+		pass = pass && result.Pass
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if list {
+		json.NewEncoder(w).Encode(struct {
+			Pass    bool           `json:"pass"`
+			Results []VerifyResult `json:"results"`
+		}{pass, results})
+		return
+	}
+	json.NewEncoder(w).Encode(results[0])
+}
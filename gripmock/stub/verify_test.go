@@ -0,0 +1,116 @@
+package stub
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	defer clearStorage()
+	calls = newCallHistory()
+	defer func() { calls = newCallHistory() }()
+
+	for _, payload := range []string{
+		`{"id":"bob","service":"Greeter","method":"SayHello","input":{"matches":{"name":"^bob"}},"output":{"data":{"message":"hi bob"}}}`,
+		`{"id":"anyone","service":"Greeter","method":"SayHello","input":{"contains":{}},"output":{"data":{"message":"hi"}}}`,
+	} {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		assert.Equal(t, "Success add stub", wrt.Body.String())
+	}
+	for _, payload := range []string{
+		`{"service":"Greeter","method":"sayHello","data":{"name":"bobby"}}`,
+		`{"service":"Greeter","method":"SayHello","data":{"name":"bob"}}`,
+		`{"service":"Greeter","method":"SayHello","data":{"name":"alice"}}`,
+		`{"service":"Greeter","method":"SayGoodbye","data":{"name":"alice"}}`,
+		// optional misses aren't calls
+		`{"service":"Greeter","method":"SayGoodbye","data":null,"stream":[],"optional":true}`,
+	} {
+		handleFindStub(httptest.NewRecorder(), httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload))))
+	}
+
+	wrt := httptest.NewRecorder()
+	listCallCounts(wrt, httptest.NewRequest("GET", "/verify/counts", nil))
+	assert.JSONEq(t, `{
+		"methods":{"Greeter/SayHello":{"calls":3,"unmatched":0},"Greeter/SayGoodbye":{"calls":1,"unmatched":1}},
+		"stubs":{"bob":2,"anyone":1}
+	}`, wrt.Body.String())
+
+	tests := []struct {
+		name   string
+		body   string
+		code   int
+		expect string
+	}{
+		{
+			name:   "method count",
+			body:   `{"service":"Greeter","method":"sayHello","count":3}`,
+			code:   http.StatusOK,
+			expect: `{"pass":true,"actual":3,"expected":"exactly 3","message":"Greeter/SayHello was called 3 times, as expected"}`,
+		},
+		{
+			name:   "matching input",
+			body:   `{"service":"Greeter","method":"SayHello","input":{"equals":{"name":"alice"}},"min_count":2}`,
+			code:   http.StatusOK,
+			expect: `{"pass":false,"actual":1,"expected":"at least 2","message":"Greeter/SayHello with matching input was called 1 time, but expected at least 2"}`,
+		},
+		{
+			name:   "stub",
+			body:   `{"stub_id":"bob","max_count":1}`,
+			code:   http.StatusOK,
+			expect: `{"pass":false,"actual":2,"expected":"at most 1","message":"stub bob was called 2 times, but expected at most 1"}`,
+		},
+		{
+			name: "list",
+			body: `[{"stub_id":"anyone"},{"service":"Greeter","method":"SayGoodbye","count":0}]`,
+			code: http.StatusOK,
+			expect: `{"pass":false,"results":[
+				{"pass":true,"actual":1,"expected":"at least 1","message":"stub anyone was called 1 time, as expected"},
+				{"pass":false,"actual":1,"expected":"exactly 0","message":"Greeter/SayGoodbye was called 1 time, but expected exactly 0"}
+			]}`,
+		},
+		{
+			name:   "no method",
+			body:   `[{"service":"Greeter","count":1}]`,
+			code:   http.StatusBadRequest,
+			expect: "expectation 0: Expectation needs a service and method, or a stub_id",
+		},
+		{
+			name:   "count and bounds",
+			body:   `{"stub_id":"bob","count":1,"min_count":1}`,
+			code:   http.StatusBadRequest,
+			expect: "Expectation count can't be combined with min_count or max_count",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrt := httptest.NewRecorder()
+			handleVerify(wrt, httptest.NewRequest("POST", "/verify", bytes.NewReader([]byte(tt.body))))
+			assert.Equal(t, tt.code, wrt.Code)
+			if tt.code == http.StatusOK {
+				assert.JSONEq(t, tt.expect, wrt.Body.String())
+			} else {
+				assert.Equal(t, tt.expect, wrt.Body.String())
+			}
+		})
+	}
+}
+
+func TestCallLogSize(t *testing.T) {
+	h := newCallHistory()
+	for i := 0; i < CALL_LOG_SIZE+5; i++ {
+		h.record(recordedCall{Service: "S", Method: "M", Data: map[string]interface{}{"i": float64(i)}})
+	}
+	assert.Len(t, h.log, CALL_LOG_SIZE)
+	assert.Equal(t, 5, h.dropped)
+
+	result := h.verify(&Expectation{Service: "S", Method: "M", Input: &Input{Equals: map[string]interface{}{"i": float64(0)}}})
+	assert.False(t, result.Pass)
+	assert.True(t, result.Incomplete)
+	// counts without an input rule are exact
+	assert.Equal(t, CALL_LOG_SIZE+5, h.verify(&Expectation{Service: "S", Method: "M"}).Actual)
+}
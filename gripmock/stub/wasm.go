@@ -0,0 +1,197 @@
+package stub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+/*
+ * WASM matcher and transformer extensions.
+ *
+ * Stubs can reference functions exported by WebAssembly modules loaded from
+ * the -wasm-dir, so custom matching and response logic can be written in any
+ * language that compiles to WASM. Each module is compiled once at startup and
+ * instantiated afresh for every call, in a sandbox with no filesystem,
+ * network, environment or clock access beyond what WASI provides by default,
+ * a memory limit and a time limit.
+ *
+ * The calling convention is the same for every function. The module exports
+ * its "memory" and an "alloc(size i32) i32" function; gripmock allocates room
+ * for a JSON argument, writes it there and calls the function with its
+ * (ptr i32, len i32):
+ *
+ *   - a matcher returns i32, non-zero to match. Its argument is the call:
+ *     {"service", "method", "data", "headers", "stream"}
+ *   - a transformer returns i64, the (ptr << 32 | len) of a JSON stub output
+ *     in its memory that replaces the stub's output. Its argument is
+ *     {"request": <the call>, "output": <the stub's output>}
+ */
+
+const (
+	// memory limit of a module instance, in 64KiB pages (16MiB)
+	WASM_MEMORY_LIMIT_PAGES = 256
+	// time limit of one matcher or transformer call
+	WASM_CALL_TIMEOUT = time.Second
+)
+
+// Reference to a function exported by a module in the WASM dir
+type WasmFunc struct {
+	// module file name, without the .wasm suffix
+	Module   string `json:"module"`
+	Function string `json:"function"`
+}
+
+var wasmRuntime wazero.Runtime
+
+// compiled modules by name
+var wasmModules = map[string]wazero.CompiledModule{}
+
+// Compile every .wasm module in dir
+func loadWasmModules(dir string) error {
+	ctx := context.Background()
+	wasmRuntime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(WASM_MEMORY_LIMIT_PAGES).
+		WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, wasmRuntime); err != nil {
+		return fmt.Errorf("instantiating WASI: %w", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		byt, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		compiled, err := wasmRuntime.CompileModule(ctx, byt)
+		if err != nil {
+			return fmt.Errorf("compiling %s: %w", file, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".wasm")
+		wasmModules[name] = compiled
+		log.Printf("Loaded WASM module %s from %s", name, file)
+	}
+	return nil
+}
+
+func validateWasmFunc(field string, fn *WasmFunc) error {
+	if fn == nil {
+		return nil
+	}
+	compiled, ok := wasmModules[fn.Module]
+	if !ok {
+		return fmt.Errorf("%s wasm module \"%s\" isn't loaded, see -wasm-dir", field, fn.Module)
+	}
+	if _, ok := compiled.ExportedFunctions()[fn.Function]; !ok {
+		return fmt.Errorf("%s wasm module \"%s\" doesn't export a function \"%s\"", field, fn.Module, fn.Function)
+	}
+	return nil
+}
+
+// Call fn in a new instance of its module with a JSON argument, returning
+// the function's raw results and the instance's memory.
+func callWasm(fn *WasmFunc, arg interface{}) ([]uint64, api.Memory, func(), error) {
+	compiled, ok := wasmModules[fn.Module]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("wasm module \"%s\" isn't loaded", fn.Module)
+	}
+	byt, err := json.Marshal(arg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), WASM_CALL_TIMEOUT)
+	mod, err := wasmRuntime.InstantiateModule(ctx, compiled,
+		wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		cancel()
+		return nil, nil, nil, fmt.Errorf("instantiating wasm module \"%s\": %w", fn.Module, err)
+	}
+	done := func() {
+		mod.Close(context.Background())
+		cancel()
+	}
+
+	alloc := mod.ExportedFunction("alloc")
+	if alloc == nil || mod.Memory() == nil {
+		done()
+		return nil, nil, nil, fmt.Errorf("wasm module \"%s\" must export memory and alloc", fn.Module)
+	}
+	ptr, err := alloc.Call(ctx, uint64(len(byt)))
+	if err != nil {
+		done()
+		return nil, nil, nil, fmt.Errorf("wasm module \"%s\" alloc: %w", fn.Module, err)
+	}
+	if !mod.Memory().Write(uint32(ptr[0]), byt) {
+		done()
+		return nil, nil, nil, fmt.Errorf("wasm module \"%s\" alloc returned an out of range pointer", fn.Module)
+	}
+
+	f := mod.ExportedFunction(fn.Function)
+	if f == nil {
+		done()
+		return nil, nil, nil, fmt.Errorf("wasm module \"%s\" doesn't export a function \"%s\"", fn.Module, fn.Function)
+	}
+	results, err := f.Call(ctx, ptr[0], uint64(len(byt)))
+	if err != nil {
+		done()
+		return nil, nil, nil, fmt.Errorf("wasm %s.%s: %w", fn.Module, fn.Function, err)
+	}
+	if len(results) != 1 {
+		done()
+		return nil, nil, nil, fmt.Errorf("wasm %s.%s must return one value", fn.Module, fn.Function)
+	}
+	return results, mod.Memory(), done, nil
+}
+
+// Run a matcher function on a call. Errors are logged and count as no match.
+func wasmMatch(fn *WasmFunc, call *findStubPayload) bool {
+	results, _, done, err := callWasm(fn, call)
+	if err != nil {
+		log.Printf("Error in wasm matcher: %v", err)
+		return false
+	}
+	defer done()
+	return uint32(results[0]) != 0
+}
+
+type wasmTransformArg struct {
+	Request *findStubPayload `json:"request"`
+	Output  Output           `json:"output"`
+}
+
+// Run a transformer function, returning the output it builds
+func wasmTransform(fn *WasmFunc, call *findStubPayload, output Output) (Output, error) {
+	output.Transform = nil
+	results, mem, done, err := callWasm(fn, wasmTransformArg{Request: call, Output: output})
+	if err != nil {
+		return Output{}, err
+	}
+	defer done()
+
+	ptr, size := uint32(results[0]>>32), uint32(results[0])
+	byt, ok := mem.Read(ptr, size)
+	if !ok {
+		return Output{}, fmt.Errorf("wasm %s.%s returned an out of range result", fn.Module, fn.Function)
+	}
+	transformed := Output{}
+	if err := json.Unmarshal(byt, &transformed); err != nil {
+		return Output{}, fmt.Errorf("wasm %s.%s returned an invalid output: %w", fn.Module, fn.Function, err)
+	}
+	if err := validateOutput(transformed); err != nil {
+		return Output{}, fmt.Errorf("wasm %s.%s returned an invalid output: %w", fn.Module, fn.Function, err)
+	}
+	return transformed, nil
+}
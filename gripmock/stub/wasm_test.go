@@ -0,0 +1,146 @@
+package stub
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uleb128(v uint64) []byte {
+	out := []byte{}
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			out = append(out, b|0x80)
+			continue
+		}
+		return append(out, b)
+	}
+}
+
+func sleb128(v int64) []byte {
+	out := []byte{}
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func wasmVec(items ...[]byte) []byte {
+	out := uleb128(uint64(len(items)))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+func wasmSection(id byte, content []byte) []byte {
+	return append(append([]byte{id}, uleb128(uint64(len(content)))...), content...)
+}
+
+func wasmName(name string) []byte {
+	return append(uleb128(uint64(len(name))), name...)
+}
+
+// Assemble a module following the gripmock calling convention, exporting the
+// matchers "always", "never" and "long" (argument longer than 100 bytes)
+// and a transformer returning the output transformed.
+func testWasmModule(transformed string) []byte {
+	const i32, i64 = 0x7f, 0x7e
+	const dataOffset = 16
+	funcType := func(params []byte, result byte) []byte {
+		return append(append([]byte{0x60}, wasmVec(bytesOf(params)...)...), 0x01, result)
+	}
+	body := func(code ...byte) []byte {
+		b := append([]byte{0x00}, code...)
+		return append(uleb128(uint64(len(b))), b...)
+	}
+	result := int64(dataOffset)<<32 | int64(len(transformed))
+
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	module = append(module, wasmSection(1, wasmVec(
+		funcType([]byte{i32}, i32),
+		funcType([]byte{i32, i32}, i32),
+		funcType([]byte{i32, i32}, i64),
+	))...)
+	module = append(module, wasmSection(3, wasmVec([]byte{0}, []byte{1}, []byte{1}, []byte{1}, []byte{2}))...)
+	module = append(module, wasmSection(5, wasmVec([]byte{0x00, 0x01}))...)
+	// mutable heap pointer for alloc, after the data
+	module = append(module, wasmSection(6, wasmVec(append([]byte{i32, 0x01, 0x41}, append(sleb128(1024), 0x0b)...)))...)
+	module = append(module, wasmSection(7, wasmVec(
+		append(wasmName("memory"), 0x02, 0x00),
+		append(wasmName("alloc"), 0x00, 0x00),
+		append(wasmName("always"), 0x00, 0x01),
+		append(wasmName("never"), 0x00, 0x02),
+		append(wasmName("long"), 0x00, 0x03),
+		append(wasmName("transform"), 0x00, 0x04),
+	))...)
+	module = append(module, wasmSection(10, wasmVec(
+		// alloc: return heap, heap += size
+		body(0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b),
+		body(0x41, 0x01, 0x0b),
+		body(0x41, 0x00, 0x0b),
+		// long: len > 100
+		body(append(append([]byte{0x20, 0x01, 0x41}, sleb128(100)...), 0x4b, 0x0b)...),
+		body(append(append([]byte{0x42}, sleb128(result)...), 0x0b)...),
+	))...)
+	module = append(module, wasmSection(11, wasmVec(
+		append([]byte{0x00, 0x41, dataOffset, 0x0b}, wasmName(transformed)...),
+	))...)
+	return module
+}
+
+func bytesOf(b []byte) [][]byte {
+	out := [][]byte{}
+	for _, c := range b {
+		out = append(out, []byte{c})
+	}
+	return out
+}
+
+func TestWasmExtensions(t *testing.T) {
+	defer clearStorage()
+
+	dir := t.TempDir()
+	module := testWasmModule(`{"data":{"v":"transformed"},"code":"ALREADY_EXISTS","error":"from wasm"}`)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ext.wasm"), module, 0644))
+	require.NoError(t, loadWasmModules(dir))
+
+	add := func(payload string) string {
+		wrt := httptest.NewRecorder()
+		addStub(wrt, httptest.NewRequest("POST", "/add", bytes.NewReader([]byte(payload))))
+		return wrt.Body.String()
+	}
+	find := func(payload string) string {
+		wrt := httptest.NewRecorder()
+		handleFindStub(wrt, httptest.NewRequest("POST", "/find", bytes.NewReader([]byte(payload))))
+		return wrt.Body.String()
+	}
+
+	assert.Equal(t, `Input wasm module "missing" isn't loaded, see -wasm-dir`,
+		add(`{"service":"W","method":"M","input":{"wasm":{"module":"missing","function":"always"}},"output":{"data":{}}}`))
+	assert.Equal(t, `Output transform wasm module "ext" doesn't export a function "missing"`,
+		add(`{"service":"W","method":"M","input":{"equals":{}},"output":{"transform":{"module":"ext","function":"missing"}}}`))
+
+	assert.Equal(t, "Success add stub",
+		add(`{"service":"W","method":"M","input":{"wasm":{"module":"ext","function":"never"}},"output":{"data":{"v":"never"}}}`))
+	assert.Equal(t, "Success add stub",
+		add(`{"service":"W","method":"M","input":{"wasm":{"module":"ext","function":"long"}},"output":{"data":{"v":"long"}}}`))
+	assert.Equal(t, "Success add stub",
+		add(`{"service":"W","method":"M","input":{"equals":{"id":"t"}},"output":{"transform":{"module":"ext","function":"transform"}}}`))
+
+	assert.JSONEq(t, `{"data":{"v":"long"},"error":""}`,
+		find(`{"service":"W","method":"M","data":{"id":"a long enough request to be over one hundred bytes of json"}}`))
+	assert.JSONEq(t, `{"data":{"v":"transformed"},"error":"from wasm","code":6}`,
+		find(`{"service":"W","method":"M","data":{"id":"t"}}`))
+}
@@ -0,0 +1,119 @@
+package stub
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+/*
+ * Wire log.
+ *
+ * With Options.WireLog, each stub lookup is logged as one JSON line
+ * prefixed "wire: ", with the call's method, metadata and decoded request
+ * messages, and the response the stub gave it: its messages, or its error
+ * and status code. It's a debugging aid, so it may end up in CI logs and
+ * other shared places; the values of fields and metadata keys named in
+ * Options.Redact are masked at any depth. Names match regardless of case
+ * and underscores, so "api_key" masks protojson's "apiKey" too.
+ */
+
+// What masked values are replaced with
+const REDACTED = "[REDACTED]"
+
+// log lookups; set from Options.WireLog
+var wireLog bool
+
+// normalised names of the fields and metadata keys to mask, see
+// redactName
+var redactNames = map[string]bool{}
+
+type wireLogEntry struct {
+	CallID        string      `json:"call_id,omitempty"`
+	CorrelationID string      `json:"correlation_id,omitempty"`
+	Service       string      `json:"service"`
+	Method        string      `json:"method"`
+	Headers       interface{} `json:"headers,omitempty"`
+	Request       interface{} `json:"request"`
+	// every message of a client stream
+	Stream   interface{} `json:"stream,omitempty"`
+	StubID   string      `json:"stub_id,omitempty"`
+	Response interface{} `json:"response,omitempty"`
+	// no stub matched, or its output couldn't be computed
+	Error string `json:"error,omitempty"`
+}
+
+func setRedactNames(names []string) {
+	redactNames = map[string]bool{}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			redactNames[redactName(name)] = true
+		}
+	}
+}
+
+func redactName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// Log a lookup, with the output of the stub it matched
+func logWire(call *findStubPayload, id string, output Output, err error) {
+	if !wireLog {
+		return
+	}
+	entry := wireLogEntry{
+		CallID:        call.CallID,
+		CorrelationID: correlationID(call.Headers),
+		Service:       call.Service,
+		Method:        call.Method,
+		Headers:       redacted(call.Headers),
+		Request:       redacted(call.Data),
+		StubID:        id,
+	}
+	if len(call.Stream) > 0 {
+		entry.Stream = redacted(call.Stream)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Response = redacted(output)
+	}
+	byt, err := json.Marshal(entry)
+	if err != nil {
+		logCall(call.Headers, "wire: logging %s/%s: %v", call.Service, call.Method, err)
+		return
+	}
+	log.Printf("wire: %s", byt)
+}
+
+// A copy of the value, as JSON, with the values of fields named in
+// redactNames masked
+func redacted(value interface{}) interface{} {
+	byt, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var generic interface{}
+	if err := json.Unmarshal(byt, &generic); err != nil {
+		return nil
+	}
+	return redact(generic)
+}
+
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if redactNames[redactName(key)] {
+				v[key] = REDACTED
+			} else {
+				v[key] = redact(field)
+			}
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = redact(elem)
+		}
+	}
+	return value
+}
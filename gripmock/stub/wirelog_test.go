@@ -0,0 +1,67 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedacted(t *testing.T) {
+	setRedactNames([]string{"password", "api_key", " "})
+	defer setRedactNames(nil)
+
+	in := map[string]interface{}{
+		"user":     "bob",
+		"Password": "hunter2",
+		"nested":   []interface{}{map[string]interface{}{"apiKey": "k", "id": 1}},
+	}
+	assert.Equal(t, map[string]interface{}{
+		"user":     "bob",
+		"Password": REDACTED,
+		"nested":   []interface{}{map[string]interface{}{"apiKey": REDACTED, "id": float64(1)}},
+	}, redacted(in))
+	assert.Equal(t, "hunter2", in["Password"], "the value itself isn't changed")
+}
+
+func TestLogWire(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+	setRedactNames([]string{"token"})
+	defer setRedactNames(nil)
+
+	call := &findStubPayload{
+		Service: "Greeter",
+		Method:  "SayHello",
+		Data:    map[string]interface{}{"name": "bob", "token": "t"},
+		Headers: map[string]string{"token": "t"},
+	}
+	logWire(call, "1", Output{Data: map[string]interface{}{"message": "hi"}}, nil)
+	assert.Empty(t, buf.String(), "off by default")
+
+	wireLog = true
+	defer func() { wireLog = false }()
+	logWire(call, "1", Output{Data: map[string]interface{}{"message": "hi"}}, nil)
+	logWire(call, "", Output{}, errors.New("no stub"))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[0], "wire: ")), &entry))
+	assert.Equal(t, map[string]interface{}{"name": "bob", "token": REDACTED}, entry["request"])
+	assert.Equal(t, map[string]interface{}{"token": REDACTED}, entry["headers"])
+	assert.Equal(t, "hi", entry["response"].(map[string]interface{})["data"].(map[string]interface{})["message"])
+	assert.Equal(t, "1", entry["stub_id"])
+
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "wire: ")), &entry))
+	assert.Equal(t, "no stub", entry["error"])
+}
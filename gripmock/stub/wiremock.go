@@ -0,0 +1,367 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+ * WireMock mapping import.
+ *
+ * Teams moving from HTTP mocks to gRPC can bring their WireMock mappings
+ * along: "gripmock wiremock" converts mapping files to a stub file, and
+ * POST /import/wiremock imports them directly. A mapping converts if its
+ * request is a POST to a gRPC method path, /package.Service/Method as the
+ * WireMock gRPC extension has it, with at most JSON body patterns, and its
+ * response is a JSON message or an error:
+ *
+ *   equalToJson               input.equals, or input.contains with
+ *                             ignoreExtraElements
+ *   no bodyPatterns           input.contains {}, matching any call
+ *   jsonBody, or a JSON body  output.data
+ *   non-2xx status            output.code, mapped as in google.rpc.Code,
+ *                             with the status message or body as the error
+ *   grpc-status-name and      output.code and output.error, as the
+ *   grpc-status-reason        WireMock gRPC extension sets them
+ *   headers                   output.headers
+ *   fixedDelayMilliseconds    output.delay
+ *
+ * Mappings that can't be converted, such as URL patterns or regex body
+ * matches, are skipped, and parts of a converted mapping that have no
+ * gRPC equivalent, such as request header matches, are dropped; both are
+ * reported.
+ */
+
+type wireMockMapping struct {
+	ID       string `json:"id"`
+	UUID     string `json:"uuid"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	Scenario string `json:"scenarioName"`
+	Request  struct {
+		Method          string                   `json:"method"`
+		URL             string                   `json:"url"`
+		URLPath         string                   `json:"urlPath"`
+		URLPattern      string                   `json:"urlPattern"`
+		URLPathPattern  string                   `json:"urlPathPattern"`
+		Headers         map[string]interface{}   `json:"headers"`
+		QueryParameters map[string]interface{}   `json:"queryParameters"`
+		Cookies         map[string]interface{}   `json:"cookies"`
+		BodyPatterns    []map[string]interface{} `json:"bodyPatterns"`
+	} `json:"request"`
+	Response struct {
+		Status                 int                    `json:"status"`
+		StatusMessage          string                 `json:"statusMessage"`
+		Body                   *string                `json:"body"`
+		JSONBody               interface{}            `json:"jsonBody"`
+		Base64Body             string                 `json:"base64Body"`
+		BodyFileName           string                 `json:"bodyFileName"`
+		Headers                map[string]interface{} `json:"headers"`
+		FixedDelayMilliseconds int                    `json:"fixedDelayMilliseconds"`
+		DelayDistribution      interface{}            `json:"delayDistribution"`
+		Fault                  string                 `json:"fault"`
+		Transformers           []string               `json:"transformers"`
+	} `json:"response"`
+}
+
+// The stubs converted from WireMock mappings, and what was left out
+type WireMockConversion struct {
+	Stubs []*Stub
+	// mappings that couldn't be converted, and why
+	Skipped []string
+	// parts of converted mappings that were left out
+	Dropped []string
+}
+
+var grpcMethodPath = regexp.MustCompile(`^/(?:[A-Za-z_][A-Za-z0-9_]*\.)*([A-Za-z_][A-Za-z0-9_]*)/([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// google.rpc.Code of an HTTP error status, per its HTTP mapping
+var httpStatusCodes = map[int]string{
+	400: "INVALID_ARGUMENT",
+	401: "UNAUTHENTICATED",
+	403: "PERMISSION_DENIED",
+	404: "NOT_FOUND",
+	409: "ALREADY_EXISTS",
+	429: "RESOURCE_EXHAUSTED",
+	499: "CANCELLED",
+	500: "INTERNAL",
+	501: "UNIMPLEMENTED",
+	503: "UNAVAILABLE",
+	504: "DEADLINE_EXCEEDED",
+}
+
+// HTTP headers that mean nothing as gRPC metadata
+var wireMockHTTPHeaders = map[string]bool{
+	"content-type":      true,
+	"content-length":    true,
+	"content-encoding":  true,
+	"transfer-encoding": true,
+	"connection":        true,
+}
+
+// Convert a WireMock mappings document, as the /__admin/mappings API gives
+// or a mappings file holds: {"mappings": [...]}, a single mapping, or an
+// array of them. from names it in the report.
+func ConvertWireMock(from string, byt []byte) (*WireMockConversion, error) {
+	mappings := []wireMockMapping{}
+	trimmed := bytes.TrimSpace(byt)
+	switch {
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		if err := json.Unmarshal(trimmed, &mappings); err != nil {
+			return nil, err
+		}
+	default:
+		var doc struct {
+			Mappings []wireMockMapping `json:"mappings"`
+		}
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, err
+		}
+		if doc.Mappings != nil {
+			mappings = doc.Mappings
+		} else {
+			var m wireMockMapping
+			if err := json.Unmarshal(trimmed, &m); err != nil {
+				return nil, err
+			}
+			mappings = append(mappings, m)
+		}
+	}
+
+	conv := &WireMockConversion{Stubs: []*Stub{}}
+	for i, m := range mappings {
+		name := fmt.Sprintf("mapping %d", i)
+		if from != "" {
+			name = from + " " + name
+		}
+		if m.Name != "" {
+			name += fmt.Sprintf(" (%s)", m.Name)
+		}
+		stub, dropped, err := m.convert()
+		if err != nil {
+			conv.Skipped = append(conv.Skipped, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		for _, d := range dropped {
+			conv.Dropped = append(conv.Dropped, fmt.Sprintf("%s: %s", name, d))
+		}
+		conv.Stubs = append(conv.Stubs, stub)
+	}
+	return conv, nil
+}
+
+// The stub for a mapping, and what of the mapping it leaves out
+func (m wireMockMapping) convert() (*Stub, []string, error) {
+	dropped := []string{}
+	req, resp := m.Request, m.Response
+
+	switch strings.ToUpper(req.Method) {
+	case "", "POST", "ANY":
+	default:
+		return nil, nil, fmt.Errorf("method %s, gRPC calls are POSTs", req.Method)
+	}
+	if req.URLPattern != "" || req.URLPathPattern != "" {
+		return nil, nil, fmt.Errorf("url patterns can't be converted, a stub is for a single method")
+	}
+	path := req.URLPath
+	if path == "" {
+		path = req.URL
+		if i := strings.IndexByte(path, '?'); i >= 0 {
+			path = path[:i]
+			dropped = append(dropped, "query string ignored")
+		}
+	}
+	match := grpcMethodPath.FindStringSubmatch(path)
+	if match == nil {
+		return nil, nil, fmt.Errorf("url \"%s\" isn't a gRPC method path, /package.Service/Method", path)
+	}
+	stub := &Stub{
+		ID:      m.ID,
+		Service: match[1],
+		Method:  match[2],
+	}
+	if stub.ID == "" {
+		stub.ID = m.UUID
+	}
+
+	if err := m.convertInput(&stub.Input); err != nil {
+		return nil, nil, err
+	}
+	if err := m.convertOutput(&stub.Output); err != nil {
+		return nil, nil, err
+	}
+
+	for _, part := range []struct {
+		name    string
+		present bool
+	}{
+		{"request headers", len(req.Headers) > 0},
+		{"query parameters", len(req.QueryParameters) > 0},
+		{"cookies", len(req.Cookies) > 0},
+		{"priority", m.Priority != 0},
+		{"scenario", m.Scenario != ""},
+		{"delayDistribution", resp.DelayDistribution != nil},
+		{"response transformers", len(resp.Transformers) > 0},
+	} {
+		if part.present {
+			dropped = append(dropped, part.name+" ignored")
+		}
+	}
+	return stub, dropped, nil
+}
+
+func (m wireMockMapping) convertInput(input *Input) error {
+	for _, pattern := range m.Request.BodyPatterns {
+		raw, ok := pattern["equalToJson"]
+		if !ok {
+			ops := []string{}
+			for op := range pattern {
+				ops = append(ops, op)
+			}
+			sort.Strings(ops)
+			return fmt.Errorf("body pattern %s can't be converted, only equalToJson can", strings.Join(ops, ", "))
+		}
+		if text, ok := raw.(string); ok {
+			if err := json.Unmarshal([]byte(text), &raw); err != nil {
+				return fmt.Errorf("equalToJson: %v", err)
+			}
+		}
+		fields, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("equalToJson must be a JSON object, a gRPC message")
+		}
+		if extra, _ := pattern["ignoreExtraElements"].(bool); extra {
+			input.Contains = mergeJSON(input.Contains, fields)
+		} else {
+			input.Equals = mergeJSON(input.Equals, fields)
+		}
+	}
+	if input.Equals != nil && input.Contains != nil {
+		// a stub matches on one of them, and equals is the stricter
+		input.Equals = mergeJSON(input.Contains, input.Equals)
+		input.Contains = nil
+	}
+	if input.Equals == nil && input.Contains == nil {
+		input.Contains = map[string]interface{}{}
+	}
+	return nil
+}
+
+func (m wireMockMapping) convertOutput(output *Output) error {
+	resp := m.Response
+	switch {
+	case resp.Fault != "":
+		return fmt.Errorf("fault %s can't be converted", resp.Fault)
+	case resp.BodyFileName != "":
+		return fmt.Errorf("bodyFileName can't be converted, inline the body as jsonBody")
+	case resp.Base64Body != "":
+		return fmt.Errorf("base64Body can't be converted, a gRPC response is a JSON message")
+	}
+
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	output.Headers = map[string]string{}
+	grpcStatus, grpcReason := "", ""
+	for name, v := range resp.Headers {
+		value := fmt.Sprint(v)
+		if values, ok := v.([]interface{}); ok && len(values) > 0 {
+			value = fmt.Sprint(values[0])
+		}
+		switch name = strings.ToLower(name); {
+		case name == "grpc-status-name":
+			grpcStatus = value
+		case name == "grpc-status-reason":
+			grpcReason = value
+		case !wireMockHTTPHeaders[name]:
+			output.Headers[name] = value
+		}
+	}
+	if len(output.Headers) == 0 {
+		output.Headers = nil
+	}
+	if resp.FixedDelayMilliseconds > 0 {
+		output.Delay = fmt.Sprintf("%dms", resp.FixedDelayMilliseconds)
+	}
+
+	var body interface{} = resp.JSONBody
+	if body == nil && resp.Body != nil && strings.TrimSpace(*resp.Body) != "" {
+		if err := json.Unmarshal([]byte(*resp.Body), &body); err != nil {
+			body = nil
+		}
+	}
+	data, isMessage := body.(map[string]interface{})
+
+	code := ""
+	switch {
+	case grpcStatus != "":
+		code = strings.ToUpper(grpcStatus)
+		output.Error = grpcReason
+	case status < 200 || status > 299:
+		if code = httpStatusCodes[status]; code == "" {
+			code = "UNKNOWN"
+		}
+		output.Error = resp.StatusMessage
+		if output.Error == "" && resp.Body != nil && !isMessage {
+			output.Error = strings.TrimSpace(*resp.Body)
+		}
+		if output.Error == "" {
+			output.Error = http.StatusText(status)
+		}
+	}
+	if code != "" && code != "OK" {
+		if err := output.Code.UnmarshalJSON([]byte(fmt.Sprintf("%q", code))); err != nil {
+			return err
+		}
+		if output.Error == "" {
+			output.Error = code
+		}
+		return nil
+	}
+
+	switch {
+	case isMessage:
+		output.Data = data
+	case body == nil && (resp.Body == nil || strings.TrimSpace(*resp.Body) == ""):
+		output.Data = map[string]interface{}{}
+	default:
+		return fmt.Errorf("response body isn't a JSON object, a gRPC message")
+	}
+	return nil
+}
+
+// Convert WireMock mappings in the body and import the stubs, as POST
+// /import would
+func handleImportWireMock(w http.ResponseWriter, r *http.Request) {
+	replace, ok := importReplace(w, r)
+	if !ok {
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responseError(err, w)
+		return
+	}
+	conv, err := ConvertWireMock("", body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Invalid WireMock mappings: %v", err)))
+		return
+	}
+	if len(conv.Stubs) == 0 && len(conv.Skipped) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("No mappings could be converted:\n" + strings.Join(conv.Skipped, "\n")))
+		return
+	}
+	finishImport(w, r, importedFrom("", conv.Stubs), replace, importResult{
+		Skipped: conv.Skipped,
+		Dropped: conv.Dropped,
+	})
+}
@@ -0,0 +1,131 @@
+package stub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertWireMock(t *testing.T) {
+	conv, err := ConvertWireMock("mappings.json", []byte(`{"mappings": [
+		{
+			"id": "8c5db8b0-2db4-4ad7-a99f-38c9b00da3f7",
+			"name": "hello bob",
+			"request": {
+				"method": "POST",
+				"url": "/helloworld.Greeter/SayHello",
+				"headers": {"x-api-key": {"equalTo": "secret"}},
+				"bodyPatterns": [{"equalToJson": "{\"name\": \"bob\"}"}]
+			},
+			"response": {
+				"status": 200,
+				"jsonBody": {"message": "Hello bob"},
+				"headers": {"Content-Type": "application/json", "X-Served-By": "wiremock"},
+				"fixedDelayMilliseconds": 250
+			}
+		},
+		{
+			"request": {
+				"urlPath": "/Greeter/SayHello",
+				"bodyPatterns": [{"equalToJson": {"name": "eve"}, "ignoreExtraElements": true}]
+			},
+			"response": {"status": 404, "statusMessage": "no such user"}
+		},
+		{
+			"request": {"method": "ANY", "url": "/helloworld.Greeter/SayBye"},
+			"response": {"status": 200, "body": "{\"message\": \"bye\"}", "headers": {"grpc-status-name": "unavailable", "grpc-status-reason": "down"}}
+		},
+		{
+			"request": {"method": "POST", "url": "/helloworld.Greeter/SayBye"},
+			"response": {"status": 200}
+		},
+		{"request": {"method": "GET", "url": "/users/1"}, "response": {"status": 200}},
+		{"request": {"urlPattern": "/helloworld.Greeter/.*"}, "response": {"status": 200}},
+		{"request": {"url": "/users/1"}, "response": {"status": 200}},
+		{"request": {"url": "/a.B/C", "bodyPatterns": [{"matchesJsonPath": "$.name"}]}, "response": {"status": 200}},
+		{"request": {"url": "/a.B/C"}, "response": {"status": 200, "body": "plain text"}},
+		{"request": {"url": "/a.B/C"}, "response": {"bodyFileName": "c.json"}},
+		{"request": {"url": "/a.B/C"}, "response": {"fault": "CONNECTION_RESET_BY_PEER"}}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, conv.Stubs, 4)
+
+	hello := conv.Stubs[0]
+	assert.Equal(t, "8c5db8b0-2db4-4ad7-a99f-38c9b00da3f7", hello.ID)
+	assert.Equal(t, "Greeter", hello.Service)
+	assert.Equal(t, "SayHello", hello.Method)
+	assert.Equal(t, map[string]interface{}{"name": "bob"}, hello.Input.Equals)
+	assert.Nil(t, hello.Input.Contains)
+	assert.Equal(t, map[string]interface{}{"message": "Hello bob"}, hello.Output.Data)
+	assert.Equal(t, map[string]string{"x-served-by": "wiremock"}, hello.Output.Headers)
+	assert.Equal(t, "250ms", hello.Output.Delay)
+	assert.NoError(t, validateStub(hello))
+
+	notFound := conv.Stubs[1]
+	assert.Equal(t, map[string]interface{}{"name": "eve"}, notFound.Input.Contains)
+	assert.Equal(t, "NOT_FOUND", notFound.Output.Code.String())
+	assert.Equal(t, "no such user", notFound.Output.Error)
+	assert.Nil(t, notFound.Output.Data)
+
+	unavailable := conv.Stubs[2]
+	assert.Equal(t, map[string]interface{}{}, unavailable.Input.Contains, "no body patterns match any call")
+	assert.Equal(t, "UNAVAILABLE", unavailable.Output.Code.String())
+	assert.Equal(t, "down", unavailable.Output.Error)
+
+	assert.Equal(t, map[string]interface{}{}, conv.Stubs[3].Output.Data)
+
+	assert.Equal(t, []string{
+		`mappings.json mapping 4: method GET, gRPC calls are POSTs`,
+		`mappings.json mapping 5: url patterns can't be converted, a stub is for a single method`,
+		`mappings.json mapping 6: url "/users/1" isn't a gRPC method path, /package.Service/Method`,
+		`mappings.json mapping 7: body pattern matchesJsonPath can't be converted, only equalToJson can`,
+		`mappings.json mapping 8: response body isn't a JSON object, a gRPC message`,
+		`mappings.json mapping 9: bodyFileName can't be converted, inline the body as jsonBody`,
+		`mappings.json mapping 10: fault CONNECTION_RESET_BY_PEER can't be converted`,
+	}, conv.Skipped)
+	assert.Equal(t, []string{`mappings.json mapping 0 (hello bob): request headers ignored`}, conv.Dropped)
+
+	// a single mapping, or an array of them
+	conv, err = ConvertWireMock("", []byte(`{"request": {"url": "/a.B/C"}, "response": {"jsonBody": {}}}`))
+	require.NoError(t, err)
+	assert.Len(t, conv.Stubs, 1)
+	conv, err = ConvertWireMock("", []byte(`[{"request": {"url": "/a.B/C"}, "response": {"jsonBody": {}}}, {"request": {"url": "/a.B/D"}, "response": {"jsonBody": {}}}]`))
+	require.NoError(t, err)
+	assert.Len(t, conv.Stubs, 2)
+	_, err = ConvertWireMock("", []byte(`{"mappings": `))
+	assert.Error(t, err)
+}
+
+func TestImportWireMock(t *testing.T) {
+	defer clearStorage()
+	clearStorage()
+
+	importWireMock := func(body string) *httptest.ResponseRecorder {
+		wrt := httptest.NewRecorder()
+		handleImportWireMock(wrt, httptest.NewRequest("POST", "/import/wiremock", bytes.NewReader([]byte(body))))
+		return wrt
+	}
+	wrt := importWireMock(`{"mappings": [
+		{"request": {"url": "/helloworld.Greeter/SayHello", "queryParameters": {"a": {"equalTo": "b"}}}, "response": {"jsonBody": {"message": "hi"}}},
+		{"request": {"url": "/users"}, "response": {"status": 200}}
+	]}`)
+	require.Equal(t, http.StatusOK, wrt.Code, wrt.Body.String())
+	var result importResult
+	require.NoError(t, json.Unmarshal(wrt.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.Imported)
+	assert.Equal(t, []string{`mapping 1: url "/users" isn't a gRPC method path, /package.Service/Method`}, result.Skipped)
+	assert.Equal(t, []string{`mapping 0: query parameters ignored`}, result.Dropped)
+	require.Len(t, listStubs(), 1)
+	assert.Equal(t, "Greeter", listStubs()[0].Service)
+
+	wrt = importWireMock(`{"mappings": [{"request": {"url": "/users"}, "response": {"status": 200}}]}`)
+	assert.Equal(t, http.StatusBadRequest, wrt.Code)
+	assert.Contains(t, wrt.Body.String(), "No mappings could be converted")
+	wrt = importWireMock(`not json`)
+	assert.Equal(t, http.StatusBadRequest, wrt.Code)
+}
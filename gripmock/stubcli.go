@@ -0,0 +1,405 @@
+package main
+
+/*
+ * "gripmock stub" manages the stubs of a running gripmock through its
+ * admin API, for scripts and people who'd otherwise write curl calls:
+ *
+ *   gripmock stub add stubs/orders.json stubs/users/
+ *   gripmock stub list -service Greeter
+ *   gripmock stub delete 8a1b2c3d
+ *   gripmock stub verify -service Greeter -method SayHello -count 2
+ *
+ * Every command takes -admin, the admin server's address, by default
+ * $GRIPMOCK_ADMIN or localhost:4771, and -session, to act in a test
+ * session, see the README.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ringerc/gripmock/stub"
+)
+
+const (
+	// default admin server for "gripmock stub"
+	STUB_CLI_ADMIN = "localhost:4771"
+	// environment variable overriding STUB_CLI_ADMIN
+	STUB_CLI_ADMIN_ENV = "GRIPMOCK_ADMIN"
+)
+
+var stubCommands = []struct {
+	name    string
+	summary string
+	run     func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+}{
+	{"add", "add stubs from files, directories, patterns or - for stdin", runStubAdd},
+	{"list", "list the stubs, with how often each matched", runStubList},
+	{"delete", "delete stubs by ID, or all of them with -all", runStubDelete},
+	{"verify", "check how often a method or stub was called", runStubVerify},
+}
+
+// Run "gripmock stub" with the arguments after it, and return the exit code
+func runStubCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		for _, c := range stubCommands {
+			if c.name == args[0] {
+				return c.run(args[1:], stdin, stdout, stderr)
+			}
+		}
+	}
+	fmt.Fprintln(stderr, "Usage: gripmock stub <command> [flags] ...")
+	fmt.Fprintln(stderr)
+	fmt.Fprintln(stderr, "Commands:")
+	for _, c := range stubCommands {
+		fmt.Fprintf(stderr, "  %-8s%s\n", c.name, c.summary)
+	}
+	return EXITCODE_ARGUMENTS_ERROR
+}
+
+// A client of the admin API
+type adminClient struct {
+	base    string
+	session string
+	client  *http.Client
+}
+
+// The flags for a "gripmock stub" command, with -admin and -session
+func stubFlags(name, usage string, stderr io.Writer) (*flag.FlagSet, *adminClient) {
+	flags := flag.NewFlagSet("gripmock stub "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	admin := STUB_CLI_ADMIN
+	if env := os.Getenv(STUB_CLI_ADMIN_ENV); env != "" {
+		admin = env
+	}
+	c := &adminClient{client: &http.Client{Timeout: 30 * time.Second}}
+	flags.StringVar(&c.base, "admin", admin, "admin server address, host:port or URL, default $"+STUB_CLI_ADMIN_ENV+" or "+STUB_CLI_ADMIN)
+	flags.StringVar(&c.session, "session", "", "test session to act in (Optional)")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: gripmock stub "+name+" "+usage)
+		flags.PrintDefaults()
+	}
+	return flags, c
+}
+
+// Make a request of the admin server, returning the body of a 2xx answer
+// and an error with the body of any other
+func (c *adminClient) do(method, path string, query url.Values, body []byte) ([]byte, error) {
+	base := c.base
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.session != "" {
+		query.Set("session", c.session)
+	}
+	u := strings.TrimSuffix(base, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	byt, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(byt)))
+	}
+	return byt, nil
+}
+
+func runStubAdd(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags, c := stubFlags("add", "[-replace] [-stub-templates] <stub files, directories, patterns or ->...", stderr)
+	replace := flags.Bool("replace", false, "replace the existing stubs, or the session's, instead of adding to them")
+	templates := flags.Bool("stub-templates", false, "render the stub files as Go templates, with ${ENV_VAR} substitution, as -stub-templates does")
+	if err := flags.Parse(args); err != nil {
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+
+	stubs := []*stub.Stub{}
+	for _, arg := range flags.Args() {
+		var read []*stub.Stub
+		var err error
+		if arg == stub.STUB_STDIN {
+			var byt []byte
+			if byt, err = io.ReadAll(stdin); err == nil {
+				read, err = stub.ReadStubs("stdin", byt, *templates)
+			}
+		} else {
+			read, err = stub.ReadStubFiles(arg, *templates)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXITCODE_ARGUMENTS_ERROR
+		}
+		stubs = append(stubs, read...)
+	}
+	body, err := json.Marshal(stubs)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXITCODE_OTHER_ERROR
+	}
+
+	// added in one go, so either all of them are or none
+	query := url.Values{}
+	if *replace {
+		query.Set("replace", "true")
+	}
+	byt, err := c.do(http.MethodPost, "/import", query, body)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXITCODE_OTHER_ERROR
+	}
+	var result struct {
+		Imported int      `json:"imported"`
+		IDs      []string `json:"ids"`
+	}
+	if err := json.Unmarshal(byt, &result); err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXITCODE_OTHER_ERROR
+	}
+	fmt.Fprintf(stdout, "Added %d stubs\n", result.Imported)
+	for _, id := range result.IDs {
+		fmt.Fprintln(stdout, id)
+	}
+	return 0
+}
+
+// A stub as GET / lists it
+type listedStub struct {
+	ID        string
+	Namespace string
+	Session   string
+	Hits      int64
+}
+
+func runStubList(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags, c := stubFlags("list", "[-service <service>] [-method <method>] [-json]", stderr)
+	service := flags.String("service", "", "only list the stubs of this service (Optional)")
+	method := flags.String("method", "", "only list the stubs of this method (Optional)")
+	asJSON := flags.Bool("json", false, "print the stubs as the admin API answers, instead of a table")
+	if err := flags.Parse(args); err != nil {
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+
+	byt, err := c.do(http.MethodGet, "/", nil, nil)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXITCODE_OTHER_ERROR
+	}
+	if *asJSON && *service == "" && *method == "" {
+		stdout.Write(byt)
+		return 0
+	}
+	listed := map[string]map[string][]json.RawMessage{}
+	if err := json.Unmarshal(byt, &listed); err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXITCODE_OTHER_ERROR
+	}
+	for s, methods := range listed {
+		for m := range methods {
+			if (*service != "" && s != *service) || (*method != "" && m != *method) {
+				delete(methods, m)
+			}
+		}
+		if len(methods) == 0 {
+			delete(listed, s)
+		}
+	}
+	if *asJSON {
+		json.NewEncoder(stdout).Encode(listed)
+		return 0
+	}
+
+	services := []string{}
+	for s := range listed {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSERVICE\tMETHOD\tHITS\tNAMESPACE\tSESSION")
+	for _, s := range services {
+		methods := []string{}
+		for m := range listed[s] {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		for _, m := range methods {
+			// in the order they're matched in
+			for _, raw := range listed[s][m] {
+				var l listedStub
+				if err := json.Unmarshal(raw, &l); err != nil {
+					fmt.Fprintln(stderr, err)
+					return EXITCODE_OTHER_ERROR
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", l.ID, s, m, l.Hits, l.Namespace, l.Session)
+			}
+		}
+	}
+	tw.Flush()
+	return 0
+}
+
+func runStubDelete(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags, c := stubFlags("delete", "<stub ID>... | -all", stderr)
+	all := flags.Bool("all", false, "delete every stub, or every stub of the -session")
+	if err := flags.Parse(args); err != nil {
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+	if *all == (flags.NArg() > 0) {
+		flags.Usage()
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+
+	if *all {
+		if _, err := c.do(http.MethodGet, "/clear", nil, nil); err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXITCODE_OTHER_ERROR
+		}
+		fmt.Fprintln(stdout, "Deleted every stub")
+		return 0
+	}
+	// carries on past an ID that can't be deleted, to delete the rest
+	code := 0
+	for _, id := range flags.Args() {
+		if _, err := c.do(http.MethodDelete, "/stub/"+url.PathEscape(id), nil, nil); err != nil {
+			fmt.Fprintln(stderr, err)
+			code = EXITCODE_OTHER_ERROR
+			continue
+		}
+		fmt.Fprintln(stdout, "Deleted "+id)
+	}
+	return code
+}
+
+func runStubVerify(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags, c := stubFlags("verify", "[-service <service> -method <method> | -stub <ID>] [-count N | -min N] [-max N] [-input <rules>] | <expectations file or ->", stderr)
+	e := stub.Expectation{}
+	flags.StringVar(&e.Service, "service", "", "service the calls were to")
+	flags.StringVar(&e.Method, "method", "", "method the calls were to")
+	flags.StringVar(&e.StubID, "stub", "", "only count calls that matched this stub")
+	input := flags.String("input", "", "only count calls whose message matches these rules, as a stub's input, e.g. '{\"contains\": {\"name\": \"bob\"}}' (Optional)")
+	count := flags.Int("count", -1, "exact number of calls expected")
+	minCount := flags.Int("min", -1, "least number of calls expected")
+	maxCount := flags.Int("max", -1, "most number of calls expected")
+	if err := flags.Parse(args); err != nil {
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+
+	var body []byte
+	switch {
+	case flags.NArg() == 1 && flags.NFlag() == countSet(flags, "admin", "session"):
+		// expectations as the admin API takes them, one or a list
+		var err error
+		if flags.Arg(0) == stub.STUB_STDIN {
+			body, err = io.ReadAll(stdin)
+		} else {
+			body, err = os.ReadFile(flags.Arg(0))
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXITCODE_ARGUMENTS_ERROR
+		}
+	case flags.NArg() == 0 && (e.StubID != "" || e.Service != "" || e.Method != ""):
+		if *input != "" {
+			e.Input = &stub.Input{}
+			if err := json.Unmarshal([]byte(*input), e.Input); err != nil {
+				fmt.Fprintf(stderr, "invalid -input: %v\n", err)
+				return EXITCODE_ARGUMENTS_ERROR
+			}
+		}
+		for _, bound := range []struct {
+			value *int
+			field **int
+		}{{count, &e.Count}, {minCount, &e.MinCount}, {maxCount, &e.MaxCount}} {
+			if *bound.value >= 0 {
+				*bound.field = bound.value
+			}
+		}
+		var err error
+		if body, err = json.Marshal(e); err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXITCODE_OTHER_ERROR
+		}
+	default:
+		flags.Usage()
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+
+	byt, err := c.do(http.MethodPost, "/verify", nil, body)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXITCODE_OTHER_ERROR
+	}
+	// a list of expectations is answered with a result for each
+	var results []stub.VerifyResult
+	var list struct {
+		Results []stub.VerifyResult `json:"results"`
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		err = json.Unmarshal(byt, &list)
+		results = list.Results
+	} else {
+		var result stub.VerifyResult
+		err = json.Unmarshal(byt, &result)
+		results = append(results, result)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXITCODE_OTHER_ERROR
+	}
+	code := 0
+	for _, r := range results {
+		verdict := "PASS"
+		if !r.Pass {
+			verdict = "FAIL"
+			code = EXITCODE_OTHER_ERROR
+		}
+		fmt.Fprintf(stdout, "%s: %s\n", verdict, r.Message)
+	}
+	return code
+}
+
+// How many of the named flags were set
+func countSet(flags *flag.FlagSet, names ...string) int {
+	n := 0
+	flags.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				n++
+			}
+		}
+	})
+	return n
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// An admin server answering each "METHOD path?query" with a canned body,
+// recording the requests and their bodies
+func fakeAdmin(t *testing.T, answers map[string]string) (string, *[]string) {
+	requests := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.RequestURI()
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, strings.TrimSpace(key+" "+string(body)))
+		answer, ok := answers[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("no stub"))
+			return
+		}
+		w.Write([]byte(answer))
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://"), &requests
+}
+
+func Test_runStubCommand(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.Equal(t, EXITCODE_ARGUMENTS_ERROR, runStubCommand([]string{"frob"}, nil, stdout, stderr))
+	assert.Contains(t, stderr.String(), "  verify  check how often a method or stub was called")
+}
+
+func Test_runStubAdd(t *testing.T) {
+	admin, requests := fakeAdmin(t, map[string]string{
+		"POST /import?replace=true&session=s1": `{"imported":2,"ids":["a","b"]}`,
+	})
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello.json"), []byte(`{"id":"a","service":"Greeter","method":"SayHello","input":{"equals":{"name":"bob"}},"output":{"data":{"message":"hi"}}}`), 0644))
+	stdin := strings.NewReader(`[{"id":"b","service":"Greeter","method":"SayBye","input":{"equals":{}},"output":{"data":{}}}]`)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, 0, runStubCommand([]string{"add", "-admin", admin, "-session", "s1", "-replace", dir, "-"}, stdin, stdout, stderr), stderr.String())
+	assert.Equal(t, "Added 2 stubs\na\nb\n", stdout.String())
+	require.Len(t, *requests, 1)
+	assert.Contains(t, (*requests)[0], `"id":"a"`)
+	assert.Contains(t, (*requests)[0], `"id":"b"`)
+
+	assert.Equal(t, EXITCODE_ARGUMENTS_ERROR, runStubCommand([]string{"add", "-admin", admin, filepath.Join(dir, "missing.json")}, nil, stdout, stderr))
+	assert.Len(t, *requests, 1)
+}
+
+func Test_runStubList(t *testing.T) {
+	admin, _ := fakeAdmin(t, map[string]string{
+		"GET /": `{
+			"Greeter": {
+				"SayHello": [{"ID": "b", "Hits": 3}, {"ID": "a", "Session": "s1"}],
+				"SayBye": [{"ID": "c", "Namespace": "team"}]
+			},
+			"Admin": {"Reset": [{"ID": "d"}]}
+		}`,
+	})
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, 0, runStubCommand([]string{"list", "-admin", admin}, nil, stdout, stderr), stderr.String())
+	assert.Equal(t, `ID  SERVICE  METHOD    HITS  NAMESPACE  SESSION
+d   Admin    Reset     0
+c   Greeter  SayBye    0     team
+b   Greeter  SayHello  3
+a   Greeter  SayHello  0                s1
+`, regexp.MustCompile(` +\n`).ReplaceAllString(stdout.String(), "\n"))
+
+	stdout.Reset()
+	require.Equal(t, 0, runStubCommand([]string{"list", "-admin", admin, "-method", "SayBye", "-json"}, nil, stdout, stderr), stderr.String())
+	assert.JSONEq(t, `{"Greeter": {"SayBye": [{"ID": "c", "Namespace": "team"}]}}`, stdout.String())
+}
+
+func Test_runStubDelete(t *testing.T) {
+	admin, requests := fakeAdmin(t, map[string]string{
+		"DELETE /stub/a": "OK",
+		"GET /clear":     "OK",
+	})
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.Equal(t, EXITCODE_OTHER_ERROR, runStubCommand([]string{"delete", "-admin", admin, "missing", "a"}, nil, stdout, stderr))
+	assert.Equal(t, "Deleted a\n", stdout.String())
+	assert.Contains(t, stderr.String(), "DELETE /stub/missing: 404 Not Found: no stub")
+	assert.Equal(t, []string{"DELETE /stub/missing", "DELETE /stub/a"}, *requests)
+
+	require.Equal(t, 0, runStubCommand([]string{"delete", "-admin", admin, "-all"}, nil, stdout, stderr))
+	assert.Equal(t, "GET /clear", (*requests)[2])
+
+	assert.Equal(t, EXITCODE_ARGUMENTS_ERROR, runStubCommand([]string{"delete", "-admin", admin, "-all", "a"}, nil, stdout, stderr))
+}
+
+func Test_runStubVerify(t *testing.T) {
+	admin, requests := fakeAdmin(t, map[string]string{
+		"POST /verify": `{"pass":false,"actual":1,"expected":"2","message":"Greeter/SayHello was called 1 times, expected 2"}`,
+	})
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.Equal(t, EXITCODE_OTHER_ERROR, runStubCommand([]string{"verify", "-admin", admin, "-service", "Greeter", "-method", "SayHello", "-count", "2", "-input", `{"equals":{"name":"bob"}}`}, nil, stdout, stderr), stderr.String())
+	assert.Equal(t, "FAIL: Greeter/SayHello was called 1 times, expected 2\n", stdout.String())
+	require.True(t, strings.HasPrefix((*requests)[0], "POST /verify "))
+	assert.JSONEq(t, `{"service":"Greeter","method":"SayHello","input":{"equals":{"name":"bob"},"contains":null,"matches":null},"count":2}`, strings.TrimPrefix((*requests)[0], "POST /verify "))
+
+	admin, _ = fakeAdmin(t, map[string]string{
+		"POST /verify": `{"pass":true,"results":[{"pass":true,"message":"a"},{"pass":true,"message":"b"}]}`,
+	})
+	stdout.Reset()
+	stdin := strings.NewReader(`[{"stub_id":"a","min_count":1},{"stub_id":"b","max_count":0}]`)
+	require.Equal(t, 0, runStubCommand([]string{"verify", "-admin", admin, "-"}, stdin, stdout, stderr), stderr.String())
+	assert.Equal(t, "PASS: a\nPASS: b\n", stdout.String())
+
+	assert.Equal(t, EXITCODE_ARGUMENTS_ERROR, runStubCommand([]string{"verify", "-admin", admin}, nil, stdout, stderr))
+}
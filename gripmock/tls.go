@@ -0,0 +1,87 @@
+package main
+
+/*
+ * TLS for the gRPC mock server.
+ *
+ * Many client stacks are hard-coded to dial with TLS. -tls-cert and
+ * -tls-key name a PEM certificate (chain) and private key for the gRPC
+ * server to serve TLS with instead of plaintext. They're checked when
+ * gripmock starts, then handed to the generated server as flags of the same
+ * names, so the server doesn't need rebuilding when they change, or
+ * loaded into the in-process server with -dynamic.
+ *
+ * The admin APIs, HTTP and gRPC, serve TLS with -admin-tls-cert and
+ * -admin-tls-key, or with -admin-tls, sharing the gRPC server's pair. The
+ * gRPC server then reports to the admin server over HTTPS on loopback;
+ * that's gripmock's own server, whose certificate needn't name localhost,
+ * so it isn't verified.
+ */
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type tlsConfig struct {
+	// PEM certificate and key files; TLS is off if they're empty
+	cert string
+	key  string
+}
+
+// Check the -tls-cert and -tls-key flags, which must be given together,
+// and load the key pair to report problems with it up front
+func newTLSConfig(cert, key string) (tlsConfig, error) {
+	if cert == "" && key == "" {
+		return tlsConfig{}, nil
+	}
+	if cert == "" || key == "" {
+		return tlsConfig{}, fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+	if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+		return tlsConfig{}, fmt.Errorf("loading TLS certificate and key: %w", err)
+	}
+	return tlsConfig{cert: cert, key: key}, nil
+}
+
+func (t tlsConfig) enabled() bool {
+	return t.cert != ""
+}
+
+// Flags for the generated server
+func (t tlsConfig) serverArgs() []string {
+	if !t.enabled() {
+		return []string{}
+	}
+	return []string{"-tls-cert=" + t.cert, "-tls-key=" + t.key}
+}
+
+// Options for the in-process server
+func (t tlsConfig) serverOptions() ([]grpc.ServerOption, error) {
+	if !t.enabled() {
+		return nil, nil
+	}
+	creds, err := credentials.NewServerTLSFromFile(t.cert, t.key)
+	if err != nil {
+		return nil, err
+	}
+	return []grpc.ServerOption{grpc.Creds(creds)}, nil
+}
+
+// URL of the admin server on loopback, for the gRPC server to report to
+func adminURL(port string, tls bool) string {
+	if tls {
+		return "https://localhost:" + port
+	}
+	return "http://localhost:" + port
+}
+
+// Client for gripmock's own admin server on loopback, over TLS
+func loopbackTLSClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+}
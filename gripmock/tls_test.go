@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Write a self-signed certificate for localhost and its key, returning their
+// paths
+func writeTestCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	cert, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	require.NoError(t, os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return cert, keyFile
+}
+
+func Test_newTLSConfig(t *testing.T) {
+	off, err := newTLSConfig("", "")
+	require.NoError(t, err)
+	assert.False(t, off.enabled())
+	assert.Equal(t, []string{}, off.serverArgs())
+	opts, err := off.serverOptions()
+	require.NoError(t, err)
+	assert.Empty(t, opts)
+
+	cert, key := writeTestCert(t)
+	on, err := newTLSConfig(cert, key)
+	require.NoError(t, err)
+	assert.True(t, on.enabled())
+	assert.Equal(t, []string{"-tls-cert=" + cert, "-tls-key=" + key}, on.serverArgs())
+	opts, err = on.serverOptions()
+	require.NoError(t, err)
+	assert.Len(t, opts, 1)
+
+	_, err = newTLSConfig(cert, "")
+	assert.EqualError(t, err, "-tls-cert and -tls-key must be given together")
+	_, err = newTLSConfig(key, cert)
+	assert.ErrorContains(t, err, "loading TLS certificate and key")
+	_, err = newTLSConfig(cert, filepath.Join(t.TempDir(), "missing.key"))
+	assert.Error(t, err)
+}
+
+func Test_loopbackTLSClient(t *testing.T) {
+	assert.Equal(t, "http://localhost:4771", adminURL("4771", false))
+	assert.Equal(t, "https://localhost:4771", adminURL("4771", true))
+
+	// a self-signed certificate that doesn't name the address
+	admin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer admin.Close()
+	resp, err := loopbackTLSClient().Post(admin.URL+"/events", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	_, err = http.DefaultClient.Post(admin.URL+"/events", "application/json", nil)
+	assert.Error(t, err, "not trusted otherwise")
+}
@@ -0,0 +1,114 @@
+package main
+
+/*
+ * Vendored dependencies for the generated module.
+ *
+ * Building the server runs go mod tidy, which downloads any of the server's
+ * dependencies that aren't in the module cache, and so fails in air-gapped
+ * CI. With -vendor, go mod vendor then copies them into the module's vendor
+ * dir and the server is built from there, so an exported module (see
+ * export.go) builds without a network.
+ *
+ * -vendor-from takes the go.mod, go.sum and vendor dir of such a module,
+ * prepared where the network was available, and uses them instead of
+ * resolving dependencies at all: nothing is downloaded. The server's
+ * dependencies come from the template rather than the protos, so one
+ * prepared module serves for any protos built with the same gripmock and
+ * template, but not with -go-replace, or -xds if it was prepared without.
+ */
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type vendorConfig struct {
+	// vendor the dependencies and build the server from them
+	enabled bool
+	// module dir to take go.mod, go.sum and vendor from, if set
+	from string
+}
+
+// Check the -vendor and -vendor-from flags
+func newVendorConfig(enabled bool, from string, goReplaces string) (vendorConfig, error) {
+	if from == "" {
+		return vendorConfig{enabled: enabled}, nil
+	}
+	if goReplaces != "" {
+		return vendorConfig{}, fmt.Errorf("-vendor-from can't be used with -go-replace, since the replacements aren't vendored")
+	}
+	abs, err := filepath.Abs(from)
+	if err != nil {
+		return vendorConfig{}, err
+	}
+	for _, name := range []string{"go.mod", "go.sum", filepath.Join("vendor", "modules.txt")} {
+		if _, err := os.Stat(filepath.Join(abs, name)); err != nil {
+			return vendorConfig{}, fmt.Errorf("-vendor-from must be a module built with -vendor: %w", err)
+		}
+	}
+	return vendorConfig{enabled: true, from: abs}, nil
+}
+
+// Extra go build arguments
+func (v vendorConfig) buildArgs() []string {
+	if !v.enabled {
+		return nil
+	}
+	return []string{"-mod=vendor"}
+}
+
+// Copy the prepared module's go.mod, go.sum and vendor dir into dir
+func (v vendorConfig) copyPrepared(dir string) error {
+	for _, name := range []string{"go.mod", "go.sum"} {
+		if err := copyFile(filepath.Join(v.from, name), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "vendor")); err != nil {
+		return err
+	}
+	src := filepath.Join(v.from, "vendor")
+	return filepath.WalkDir(src, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(dir, "vendor", rel)
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		return copyFile(file, dest)
+	})
+}
+
+// Remove a vendor dir left by an earlier build with -vendor, which go
+// would otherwise build from
+func removeStaleVendor(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "vendor", "modules.txt")); err != nil {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(dir, "vendor"))
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
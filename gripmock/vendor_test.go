@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVendorConfig(t *testing.T) {
+	v, err := newVendorConfig(false, "", "")
+	require.NoError(t, err)
+	assert.Empty(t, v.buildArgs())
+	v, err = newVendorConfig(true, "", "example.com/x=../x")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-mod=vendor"}, v.buildArgs())
+
+	prepared := t.TempDir()
+	_, err = newVendorConfig(false, prepared, "")
+	assert.ErrorContains(t, err, "must be a module built with -vendor")
+	write := func(dir, name, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	write(prepared, "go.mod", "module gripmock/generated\n")
+	write(prepared, "go.sum", "")
+	write(prepared, "vendor/modules.txt", "# google.golang.org/grpc v1.55.0\n")
+	write(prepared, "vendor/google.golang.org/grpc/server.go", "package grpc\n")
+	_, err = newVendorConfig(false, prepared, "example.com/x=../x")
+	assert.ErrorContains(t, err, "-go-replace")
+	v, err = newVendorConfig(false, prepared, "")
+	require.NoError(t, err)
+	assert.Equal(t, vendorConfig{enabled: true, from: prepared}, v, "-vendor-from implies -vendor")
+
+	output := t.TempDir()
+	write(output, "go.mod", "module gripmock/generated\n\nrequire example.com/old v1.0.0\n")
+	write(output, "vendor/example.com/old/old.go", "package old\n")
+	require.NoError(t, v.copyPrepared(output))
+	byt, err := os.ReadFile(filepath.Join(output, "go.mod"))
+	require.NoError(t, err)
+	assert.Equal(t, "module gripmock/generated\n", string(byt))
+	assert.FileExists(t, filepath.Join(output, "vendor/google.golang.org/grpc/server.go"))
+	assert.NoFileExists(t, filepath.Join(output, "vendor/example.com/old/old.go"), "the old vendor dir is replaced")
+
+	require.NoError(t, removeStaleVendor(output))
+	assert.NoDirExists(t, filepath.Join(output, "vendor"))
+	write(output, "vendor/notes.txt", "not go's")
+	require.NoError(t, removeStaleVendor(output))
+	assert.FileExists(t, filepath.Join(output, "vendor/notes.txt"), "only a vendor dir go wrote is removed")
+}
@@ -0,0 +1,78 @@
+package main
+
+/*
+ * Watching the protos and templates.
+ *
+ * With -watch, gripmock polls the server's inputs, hashed as for the build
+ * cache (the protos and everything they import, descriptor sets, the
+ * template dir and -server-files), and reloads the server when they change,
+ * as POST /reload would: it's generated and built again while the old one
+ * keeps serving, then swapped in. A change is only acted on once the inputs
+ * have stayed the same for a whole interval, so saving several files, or
+ * checking out a branch, rebuilds once. A change that doesn't build leaves
+ * the old server running until the next change.
+ *
+ * Polling needs no file notification support, so works the same on every
+ * platform and on mounted volumes, at the cost of reading the inputs every
+ * interval.
+ */
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ringerc/gripmock/stub"
+)
+
+// How often -watch checks the server's inputs
+const WATCH_INTERVAL = time.Second
+
+// Poll hash every interval until done is closed, calling reload once the
+// hash has changed and then stayed the same for an interval
+func watchInputs(hash func() (string, error), interval time.Duration, reload func() error, done <-chan struct{}) {
+	last, err := hash()
+	if err != nil {
+		log.V(LOG_DEBUG).Info("hashing watched inputs", "error", err.Error())
+	}
+	// hash seen last time, when it differed from last
+	changed := ""
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		h, err := hash()
+		if err != nil {
+			// e.g. a proto that's being replaced
+			log.V(LOG_DEBUG).Info("hashing watched inputs", "error", err.Error())
+			continue
+		}
+		switch {
+		case h == last:
+			changed = ""
+			continue
+		case h != changed:
+			log.V(LOG_VERBOSE).Info("Watched inputs changed, waiting for them to settle")
+			changed = h
+			continue
+		}
+
+		log.V(LOG_INFO).Info("Protos or templates changed, reloading")
+		err = reload()
+		if errors.Is(err, stub.ErrServerBusy) {
+			// try again next time
+			continue
+		}
+		// recorded once it's done, since a busy server puts it off
+		if err != nil {
+			stub.RecordEvent(stub.EVENT_RELOAD, map[string]string{"status": "failed", "error": err.Error(), "trigger": "watch"})
+			log.Error(err, "reloading after a change, waiting for the next one")
+		} else {
+			stub.RecordEvent(stub.EVENT_RELOAD, map[string]string{"status": "done", "trigger": "watch"})
+		}
+		last, changed = h, ""
+	}
+}
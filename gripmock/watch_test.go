@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ringerc/gripmock/stub"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_watchInputs(t *testing.T) {
+	initLogging(LOG_ERROR)
+	var mx sync.Mutex
+	// hashes returned in turn, the last one from then on
+	hashes := []string{"a", "a", "b", "c", "c", "c", "bad", "bad", "d", "d", "d"}
+	current := ""
+	hash := func() (string, error) {
+		mx.Lock()
+		defer mx.Unlock()
+		h := hashes[0]
+		current = h
+		if len(hashes) > 1 {
+			hashes = hashes[1:]
+		}
+		if h == "bad" {
+			return "", errors.New("unreadable")
+		}
+		return h, nil
+	}
+	reloads := make(chan string, 10)
+	busy := true
+	reload := func() error {
+		mx.Lock()
+		defer mx.Unlock()
+		reloads <- current
+		if busy {
+			busy = false
+			return stub.ErrServerBusy
+		}
+		return nil
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go watchInputs(hash, time.Millisecond, reload, done)
+
+	// "b" never settles; "c" is reloaded once it has, after a busy server
+	// puts it off; unreadable inputs are ignored, and "d" reloads again
+	for _, want := range []string{"c", "c", "d"} {
+		select {
+		case got := <-reloads:
+			assert.Equal(t, want, got)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload")
+		}
+	}
+	select {
+	case got := <-reloads:
+		t.Fatalf("unexpected reload at %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+/*
+ * "gripmock wiremock" converts WireMock mapping files to a gripmock stub
+ * file, for teams moving from HTTP mocks to gRPC. See stub/wiremock.go for
+ * what converts; mappings that don't, and parts of mappings that were left
+ * out, are reported on stderr.
+ *
+ *   gripmock wiremock -o stubs/orders.json wiremock/mappings
+ */
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ringerc/gripmock/stub"
+)
+
+// Run "gripmock wiremock" with the arguments after it, and return the exit
+// code
+func runWireMock(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("gripmock wiremock", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	outputFile := flags.String("o", "", "stub file to write, default stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: gripmock wiremock [-o stubs.json] <mapping files, directories or patterns>...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+
+	files, err := stub.FindStubFiles(strings.Join(flags.Args(), ","))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXITCODE_ARGUMENTS_ERROR
+	}
+	stubs := []*stub.Stub{}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Path, ".json") {
+			continue
+		}
+		byt, err := os.ReadFile(f.Path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXITCODE_OTHER_ERROR
+		}
+		conv, err := stub.ConvertWireMock(f.Path, byt)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", f.Path, err)
+			return EXITCODE_OTHER_ERROR
+		}
+		for _, s := range conv.Skipped {
+			fmt.Fprintf(stderr, "skipped %s\n", s)
+		}
+		for _, d := range conv.Dropped {
+			fmt.Fprintf(stderr, "dropped %s\n", d)
+		}
+		stubs = append(stubs, conv.Stubs...)
+	}
+	if len(stubs) == 0 {
+		fmt.Fprintln(stderr, "no mappings could be converted")
+		return EXITCODE_OTHER_ERROR
+	}
+
+	byt, err := json.MarshalIndent(stubs, "", "  ")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXITCODE_OTHER_ERROR
+	}
+	byt = append(byt, '\n')
+	if *outputFile == "" {
+		stdout.Write(byt)
+	} else if err := os.WriteFile(*outputFile, byt, 0644); err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXITCODE_OTHER_ERROR
+	}
+	fmt.Fprintf(stderr, "converted %d mappings\n", len(stubs))
+	return 0
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runWireMock(t *testing.T) {
+	dir := t.TempDir()
+	mappings := filepath.Join(dir, "mappings")
+	require.NoError(t, os.MkdirAll(mappings, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(mappings, "hello.json"), []byte(`{
+		"request": {"method": "POST", "url": "/helloworld.Greeter/SayHello", "bodyPatterns": [{"equalToJson": {"name": "bob"}}]},
+		"response": {"status": 200, "jsonBody": {"message": "Hello bob"}}
+	}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(mappings, "users.json"), []byte(`{
+		"request": {"method": "GET", "url": "/users/1"},
+		"response": {"status": 200}
+	}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(mappings, "README.md"), []byte("not a mapping"), 0644))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, 0, runWireMock([]string{mappings}, stdout, stderr), stderr.String())
+	var stubs []map[string]interface{}
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &stubs))
+	require.Len(t, stubs, 1)
+	assert.Equal(t, "Greeter", stubs[0]["service"])
+	assert.Contains(t, stderr.String(), "skipped "+filepath.Join(mappings, "users.json")+" mapping 0: method GET, gRPC calls are POSTs")
+	assert.Contains(t, stderr.String(), "converted 1 mappings")
+
+	out := filepath.Join(dir, "stubs.json")
+	stdout.Reset()
+	require.Equal(t, 0, runWireMock([]string{"-o", out, filepath.Join(mappings, "hello.json")}, stdout, stderr))
+	assert.Empty(t, stdout.String())
+	byt, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Contains(t, string(byt), `"message": "Hello bob"`)
+
+	assert.Equal(t, EXITCODE_OTHER_ERROR, runWireMock([]string{filepath.Join(mappings, "users.json")}, stdout, stderr))
+	assert.Equal(t, EXITCODE_ARGUMENTS_ERROR, runWireMock(nil, stdout, stderr))
+}
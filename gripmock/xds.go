@@ -0,0 +1,56 @@
+package main
+
+/*
+ * xDS (proxyless service mesh) serving.
+ *
+ * Proxyless gRPC clients find servers through an xDS control plane, and may
+ * get mTLS certificates from it rather than dialling plaintext or TLS. With
+ * -xds the generated server is built with grpc-go's xDS server and xDS
+ * server credentials, so the mesh can configure it like the production
+ * services clients target. Without security config from the control plane
+ * it falls back to TLS with -tls-cert and -tls-key, or plaintext.
+ *
+ * grpc-go reads the xDS bootstrap, naming the control plane and the
+ * server's node, from the GRPC_XDS_BOOTSTRAP file or the
+ * GRPC_XDS_BOOTSTRAP_CONFIG contents when it starts, so -xds-bootstrap sets
+ * GRPC_XDS_BOOTSTRAP for the generated server to inherit.
+ *
+ * The xDS packages are only built into the server with -xds, since they
+ * pull in the Envoy APIs and much else; -dynamic can't serve xDS.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	XDS_BOOTSTRAP_ENV        = "GRPC_XDS_BOOTSTRAP"
+	XDS_BOOTSTRAP_CONFIG_ENV = "GRPC_XDS_BOOTSTRAP_CONFIG"
+)
+
+// Check the -xds and -xds-bootstrap flags, and point the generated server
+// at the bootstrap
+func setupXDS(enabled bool, bootstrap string) error {
+	if !enabled {
+		if bootstrap != "" {
+			return fmt.Errorf("-xds-bootstrap needs -xds")
+		}
+		return nil
+	}
+	if bootstrap == "" {
+		if os.Getenv(XDS_BOOTSTRAP_ENV) == "" && os.Getenv(XDS_BOOTSTRAP_CONFIG_ENV) == "" {
+			return fmt.Errorf("-xds needs a bootstrap, with -xds-bootstrap or %s or %s in the environment", XDS_BOOTSTRAP_ENV, XDS_BOOTSTRAP_CONFIG_ENV)
+		}
+		return nil
+	}
+	abs, err := filepath.Abs(bootstrap)
+	if err != nil {
+		return err
+	}
+	if _, err := os.ReadFile(abs); err != nil {
+		return fmt.Errorf("reading xDS bootstrap: %w", err)
+	}
+	return os.Setenv(XDS_BOOTSTRAP_ENV, abs)
+}
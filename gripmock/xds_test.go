@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_setupXDS(t *testing.T) {
+	t.Setenv(XDS_BOOTSTRAP_ENV, "")
+	t.Setenv(XDS_BOOTSTRAP_CONFIG_ENV, "")
+	assert.NoError(t, setupXDS(false, ""))
+	assert.Error(t, setupXDS(false, "bootstrap.json"), "a bootstrap without -xds")
+	assert.Error(t, setupXDS(true, ""), "no bootstrap")
+	assert.Error(t, setupXDS(true, filepath.Join(t.TempDir(), "missing.json")))
+
+	dir := t.TempDir()
+	bootstrap := filepath.Join(dir, "bootstrap.json")
+	require.NoError(t, os.WriteFile(bootstrap, []byte(`{"xds_servers":[]}`), 0644))
+	require.NoError(t, setupXDS(true, bootstrap))
+	assert.Equal(t, bootstrap, os.Getenv(XDS_BOOTSTRAP_ENV), "for the generated server to inherit")
+
+	t.Setenv(XDS_BOOTSTRAP_ENV, "")
+	t.Setenv(XDS_BOOTSTRAP_CONFIG_ENV, `{"xds_servers":[]}`)
+	assert.NoError(t, setupXDS(true, ""), "a bootstrap in the environment")
+}
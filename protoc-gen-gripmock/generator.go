@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"strings"
 	"text/template"
 	"path"
+	"path/filepath"
 	_ "embed"
 
 	"google.golang.org/protobuf/compiler/protogen"
@@ -54,15 +56,46 @@ func main() {
 	}
 
 	params := make(map[string]string)
+	// "codec" may be repeated, once per codec, "only-service" and
+	// "exclude-method" once per service or method, "server-file" once per
+	// file and "grpc-address" once per host to bind to
+	codecs := []Codec{}
+	var onlyServices, excludeMethods, serverFiles, grpcHosts []string
 	for _, param := range strings.Split(request.GetParameter(), ",") {
-		split := strings.Split(param, "=")
+		split := strings.SplitN(param, "=", 2)
+		switch split[0] {
+		case "codec":
+			name, kind, _ := strings.Cut(split[1], ":")
+			codecs = append(codecs, Codec{Name: name, Kind: kind})
+			continue
+		case "only-service":
+			onlyServices = append(onlyServices, split[1])
+			continue
+		case "exclude-method":
+			excludeMethods = append(excludeMethods, split[1])
+			continue
+		case "server-file":
+			serverFiles = append(serverFiles, split[1])
+			continue
+		case "grpc-address":
+			grpcHosts = append(grpcHosts, split[1])
+			continue
+		}
 		params[split[0]] = split[1]
 	}
 
 	generateOptions := Options{
 		adminPort: params["admin-port"],
-		grpcAddr:  fmt.Sprintf("%s:%s", params["grpc-address"], params["grpc-port"]),
+		grpcAddrs: grpcAddresses(grpcHosts, params["grpc-port"]),
 		templateDir:  params["template-dir"],
+		serverDir: params["server-dir"],
+		codecs:    codecs,
+		onlyServices:   onlyServices,
+		excludeMethods: excludeMethods,
+		serverFiles:    serverFiles,
+		xds:            params["xds"] == "true",
+		noTracing:      params["tracing"] == "false",
+		stubPackage:    params["stub-package"],
 	}
 	fw := fileWriter{plugin:plugin}
 	err = generateServer(fw, protos, &generateOptions)
@@ -84,9 +117,26 @@ func main() {
 type generatorParam struct {
 	Services     []Service
 	Imports      map[string]string
+	// the first of GrpcAddrs, for templates serving on one address
 	GrpcAddr     string
+	GrpcAddrs    []string
 	AdminPort    string
 	PbPath       string
+	Codecs       []Codec
+	// serve with grpc-go's xDS server and credentials
+	XDS          bool
+	// build OpenTelemetry tracing into the server
+	Tracing      bool
+	// import path of gripmock's stub package, copied into the module, for
+	// a standalone server that serves the stub admin API itself
+	StubPackage  string
+}
+
+// Extra gRPC codec registered in the server under a content-subtype. Kind is
+// "json" (protojson) or "proto" (binary protobuf).
+type Codec struct {
+	Name string
+	Kind string
 }
 
 type Service struct {
@@ -96,6 +146,9 @@ type Service struct {
 	// proto file package (api)
 	GrpcService string
 	Methods []methodTemplate
+	// proto names of the methods left out of the server, which it answers
+	// as unknown methods
+	ExcludedMethods []string
 }
 
 type methodTemplate struct {
@@ -134,10 +187,40 @@ const (
 )
 
 type Options struct {
-	grpcAddr  string
+	grpcAddrs []string
 	adminPort string
 	format    bool
 	templateDir  string
+	// dir of the server's main package in the generated module, default
+	// "cmd"
+	serverDir string
+	codecs    []Codec
+	// services to generate, by name or full name, if not all of them
+	onlyServices []string
+	// methods not to generate, as "<service>/<method>"
+	excludeMethods []string
+	// Go files to add to the server's main package, e.g. to register
+	// interceptors, see the interceptors hook in server.tmpl
+	serverFiles []string
+	// build the server with xDS serving, which needs the xDS packages
+	xds bool
+	// leave OpenTelemetry tracing and its packages out of the server
+	noTracing bool
+	// import path of the stub package of a standalone server, if it is one
+	stubPackage string
+}
+
+// host:port addresses for the hosts to bind to, with brackets round IPv6
+// literals; no hosts binds every interface
+func grpcAddresses(hosts []string, port string) []string {
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	addrs := []string{}
+	for _, host := range hosts {
+		addrs = append(addrs, net.JoinHostPort(host, port))
+	}
+	return addrs
 }
 
 /*
@@ -146,7 +229,7 @@ type Options struct {
  */
 func readTemplateFile(templateDir string, filename string) ([]byte, error) {
 	// read the template file from the filesystem
-	filePath := path.Join(templateDir, filename)
+	filePath := filepath.Join(templateDir, filename)
 	log.Printf("Loading template %s...", filePath)
 	f, err := os.ReadFile(filePath)
 	if err != nil {
@@ -186,21 +269,37 @@ func readTemplate(templateDir string, filename string) ([]byte, error) {
  * each file to the output to be sent in the protobuf reply.
  */
 func generateServer(fw FileWriter, protos []*descriptorpb.FileDescriptorProto, opt *Options) error {
-	services := extractServices(protos)
-	imports := resolveImports(protos)
-
 	if opt == nil {
 		opt = &Options{}
 	}
 
+	services := extractServices(protos, opt)
+	if len(services) == 0 && len(opt.onlyServices) > 0 {
+		return fmt.Errorf("none of the services %v are in the protos", opt.onlyServices)
+	}
+	imports := resolveImports(protos)
+
 	templateParams := generatorParam{
 		Services:     services,
 		Imports:      imports,
-		GrpcAddr:     opt.grpcAddr,
+		GrpcAddrs:    opt.grpcAddrs,
 		AdminPort:    opt.adminPort,
+		Codecs:       opt.codecs,
+		XDS:          opt.xds,
+		Tracing:      !opt.noTracing,
+		StubPackage:  opt.stubPackage,
+	}
+
+	if len(templateParams.GrpcAddrs) == 0 {
+		templateParams.GrpcAddrs = []string{":"}
 	}
+	templateParams.GrpcAddr = templateParams.GrpcAddrs[0]
 
-	if err := generateFile(fw, opt, templateParams, "server.tmpl", "cmd/server.go", true); err != nil {
+	serverDir := opt.serverDir
+	if serverDir == "" {
+		serverDir = "cmd"
+	}
+	if err := generateFile(fw, opt, templateParams, "server.tmpl", path.Join(serverDir, "server.go"), true); err != nil {
 		return err
 	}
 
@@ -208,6 +307,16 @@ func generateServer(fw FileWriter, protos []*descriptorpb.FileDescriptorProto, o
 		return err
 	}
 
+	for _, file := range opt.serverFiles {
+		byt, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("reading server file: %v", err)
+		}
+		if err := fw.AddGeneratedFile(path.Join(serverDir, filepath.Base(file)), ".", byt); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -320,8 +429,9 @@ func getGoPackage(proto *descriptorpb.FileDescriptorProto) (alias string, goPack
 		return
 	}
 
-	// Aliases can't be keywords
-	if isKeyword(alias) {
+	// Aliases can't be keywords, or the names server.tmpl imports
+	// packages as, e.g. "status" for google/rpc/status.proto
+	if isKeyword(alias) || serverImportNames[alias] {
 		alias = fmt.Sprintf("%s_pb", alias)
 	}
 
@@ -338,20 +448,35 @@ func getGoPackage(proto *descriptorpb.FileDescriptorProto) (alias string, goPack
 	return
 }
 
-// change the structure also translate method type
-func extractServices(protos []*descriptorpb.FileDescriptorProto) []Service {
+// change the structure also translate method type, leaving out the
+// services and methods the options exclude
+func extractServices(protos []*descriptorpb.FileDescriptorProto, opt *Options) []Service {
 	svcTmp := []Service{}
+	matched := map[string]bool{}
 	for _, proto := range protos {
 		for _, svc := range proto.GetService() {
 			var s Service
 			s.Name = svc.GetName()
 			s.GrpcService = proto.GetPackage()
+			fullName := s.Name
+			if s.GrpcService != "" {
+				fullName = s.GrpcService + "." + s.Name
+			}
+			if len(opt.onlyServices) > 0 && !matchService(opt.onlyServices, fullName, "", matched) {
+				log.Printf("Leaving out service %s, it isn't in only-service", fullName)
+				continue
+			}
 			alias, _ := getGoPackage(proto)
 			if alias != "" {
 				s.Package = alias + "."
 			}
-			methods := make([]methodTemplate, len(svc.Method))
-			for j, method := range svc.Method {
+			methods := []methodTemplate{}
+			for _, method := range svc.Method {
+				if matchService(opt.excludeMethods, fullName, method.GetName(), matched) {
+					log.Printf("Leaving out excluded method %s/%s", fullName, method.GetName())
+					s.ExcludedMethods = append(s.ExcludedMethods, method.GetName())
+					continue
+				}
 				tipe := methodTypeStandard
 				if method.GetServerStreaming() && !method.GetClientStreaming() {
 					tipe = methodTypeServerStream
@@ -361,22 +486,44 @@ func extractServices(protos []*descriptorpb.FileDescriptorProto) []Service {
 					tipe = methodTypeBidirectional
 				}
 
-				methods[j] = methodTemplate{
+				methods = append(methods, methodTemplate{
 					Name:        strings.Title(*method.Name),
 					SvcPackage:  s.Package,
 					ServiceName: svc.GetName(),
 					Input:       getMessageType(protos, method.GetInputType()),
 					Output:      getMessageType(protos, method.GetOutputType()),
 					MethodType:  tipe,
-				}
+				})
 			}
 			s.Methods = methods
 			svcTmp = append(svcTmp, s)
 		}
 	}
+	for _, name := range append(opt.onlyServices, opt.excludeMethods...) {
+		if !matched[name] {
+			log.Printf("WARNING: %s matches nothing in the served protos", name)
+		}
+	}
 	return svcTmp
 }
 
+// Whether one of the names is the service with the full name, by its full
+// name or its name without the package, followed by "/<method>" if method
+// isn't empty. Names that match are recorded in matched.
+func matchService(names []string, fullName string, method string, matched map[string]bool) bool {
+	for _, name := range names {
+		service, m, _ := strings.Cut(name, "/")
+		if m != method {
+			continue
+		}
+		if service == fullName || service == fullName[strings.LastIndex(fullName, ".")+1:] {
+			matched[name] = true
+			return true
+		}
+	}
+	return false
+}
+
 func getMessageType(protos []*descriptorpb.FileDescriptorProto, tipe string) string {
 	split := strings.Split(tipe, ".")[1:]
 	targetPackage := strings.Join(split[:len(split)-1], ".")
@@ -399,6 +546,25 @@ func getMessageType(protos []*descriptorpb.FileDescriptorProto, tipe string) str
 	return targetType
 }
 
+// names server.tmpl imports packages as
+var serverImportNames = map[string]bool{
+	"atomic": true, "attribute": true, "autoprop": true, "bytes": true,
+	"codes": true, "context": true, "credentials": true, "durationpb": true,
+	"emptypb": true, "encoding": true, "errdetails": true, "flag": true,
+	"fmt": true, "gripmockstub": true, "tls": true,
+	"grpc": true, "health": true, "healthpb": true, "http": true, "io": true,
+	"ioutil": true, "json": true, "jsonpb": true, "keepalive": true,
+	"log": true, "metadata": true, "net": true, "os": true, "otel": true,
+	"otelgrpc": true, "otlptrace": true, "otlptracegrpc": true,
+	"otlptracehttp": true, "otlpzipkin": true, "peer": true, "protoreflect": true,
+	"protov2": true, "reflection": true, "reflectionv1alpha": true,
+	"resource": true, "semconv": true, "signal": true, "status": true,
+	"stdouttrace": true, "stdr": true, "strings": true, "sync": true,
+	"syscall": true, "time": true, "trace": true, "tracesdk": true,
+	// only with xds
+	"insecure": true, "xds": true, "xdscreds": true,
+}
+
 func isKeyword(word string) bool {
 	keywords := [...]string{
 		"break",